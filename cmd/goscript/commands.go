@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// newFlagSet creates the flag set of a command, which prints its usage
+// line and flags to stderr.
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s\n\nflags:\n", strings.TrimSpace("goscript "+name+" [flags] "+args))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args with fs, requiring at least min arguments after
+// the flags. It returns the exit code for invalid usage, or -1.
+func parseFlags(fs *flag.FlagSet, args []string, min int) int {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() < min {
+		fs.Usage()
+		return exitUsage
+	}
+	return -1
+}
+
+// limits are the execution limit flags of the commands that evaluate, and
+// the flag for strict property access.
+type limits struct {
+	maxSteps     int
+	maxDepth     int
+	maxArraySize int
+	strict       bool
+}
+
+func (l *limits) register(fs *flag.FlagSet) {
+	defaults := evaluator.NewExecutionContext(nil)
+	fs.IntVar(&l.maxSteps, "max-steps", defaults.MaxSteps, "maximum number of instructions to execute (0 for no limit)")
+	fs.IntVar(&l.maxDepth, "max-depth", defaults.MaxDepth, "maximum function call depth (0 for no limit)")
+	fs.IntVar(&l.maxArraySize, "max-array-size", defaults.MaxArraySize, "maximum number of array elements (0 for no limit)")
+	fs.BoolVar(&l.strict, "strict", false, "make reading a missing property, or a property of a value that is not a hash, an error")
+}
+
+func (l *limits) apply(ctx *evaluator.ExecutionContext) {
+	ctx.MaxSteps = l.maxSteps
+	ctx.MaxDepth = l.maxDepth
+	ctx.MaxArraySize = l.maxArraySize
+	ctx.Strict = l.strict
+}
+
+// reports are the flags of the commands that evaluate for profiling and
+// coverage reports.
+type reports struct {
+	profile  string
+	coverage string
+}
+
+func (r *reports) register(fs *flag.FlagSet) {
+	fs.StringVar(&r.profile, "profile", "", "write a pprof profile of the steps and time taken on each line to `file`")
+	fs.StringVar(&r.coverage, "coverage", "", "write an LCOV report of the lines and branches run to `file`")
+}
+
+// apply sets up the profile and coverage of ctx, locating templates by path.
+func (r *reports) apply(ctx *evaluator.ExecutionContext, path func(template string) string) {
+	if r.profile != "" {
+		ctx.Profile = evaluator.NewProfile()
+		ctx.Profile.Path = path
+	}
+	if r.coverage != "" {
+		ctx.Coverage = evaluator.NewCoverage()
+		ctx.Coverage.Path = path
+	}
+}
+
+// write writes the reports of an evaluation with ctx, which are written
+// even if it failed.
+func (r *reports) write(ctx *evaluator.ExecutionContext) error {
+	if ctx.Profile != nil {
+		if err := writeReport(r.profile, ctx.Profile.WritePprof); err != nil {
+			return err
+		}
+	}
+	if ctx.Coverage != nil {
+		if err := writeReport(r.coverage, ctx.Coverage.WriteLCOV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReport(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stringWriter adapts an io.Writer for use as an ExecutionContext's Logger.
+type stringWriter struct {
+	io.Writer
+}
+
+func (w stringWriter) WriteString(s string) (int, error) {
+	return io.WriteString(w.Writer, s)
+}
+
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", &inputError{err: err}
+	}
+	return string(data), nil
+}
+
+func runScript(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("run", "script", stderr)
+	var lim limits
+	lim.register(fs)
+	var vars variables
+	vars.register(fs)
+	var rep reports
+	rep.register(fs)
+	if code := parseFlags(fs, args, 1); code >= 0 {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	path := fs.Arg(0)
+
+	values, err := vars.load()
+	if err != nil {
+		return report(stderr, "goscript", err)
+	}
+
+	source, err := readFile(path)
+	if err != nil {
+		return report(stderr, "goscript", err)
+	}
+
+	program, err := parser.New(lexer.NewScript(source)).Parse()
+	if err != nil {
+		return report(stderr, path, err)
+	}
+
+	ctx := evaluator.NewExecutionContext(program)
+	ctx.Source = source
+	ctx.Logger = stringWriter{stdout}
+	lim.apply(ctx)
+	rep.apply(ctx, func(string) string { return path })
+	for name, value := range values {
+		obj, err := evaluator.ToObject(value)
+		if err != nil {
+			return report(stderr, "goscript", &inputError{err: fmt.Errorf("variable %q: %w", name, err)})
+		}
+		ctx.RootScope.SetLocal(name, obj)
+	}
+
+	result, err := evaluator.New().Evaluate(ctx)
+	if err := rep.write(ctx); err != nil {
+		fmt.Fprintf(stderr, "goscript: %v\n", err)
+		return exitRuntime
+	}
+	if err != nil {
+		return report(stderr, path, err)
+	}
+
+	if ret, ok := result.(*evaluator.ReturnValue); ok && ret.Value != evaluator.Null {
+		fmt.Fprintln(stdout, ret.Value.Debug())
+	}
+	return exitOK
+}
+
+func renderTemplate(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("render", "template", stderr)
+	var lim limits
+	lim.register(fs)
+	var vars variables
+	vars.register(fs)
+	var rep reports
+	rep.register(fs)
+	out := fs.String("o", "", "write the output to `file` instead of standard output")
+	escape := fs.String("escape", "none", "escaping of expression output: none or html")
+	if code := parseFlags(fs, args, 1); code >= 0 {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	path := fs.Arg(0)
+
+	e := evaluator.New()
+	switch *escape {
+	case "none":
+	case "html":
+		e.Escaping = evaluator.HTMLEscaping
+	default:
+		fmt.Fprintf(stderr, "goscript: invalid -escape %q: want none or html\n", *escape)
+		return exitUsage
+	}
+	e.Freeze()
+
+	values, err := vars.load()
+	if err != nil {
+		return report(stderr, "goscript", err)
+	}
+
+	source, err := readFile(path)
+	if err != nil {
+		return report(stderr, "goscript", err)
+	}
+
+	// The template can include and extend the templates next to it.
+	dir, file := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(file)
+	set := evaluator.NewTemplateSetWithLoader(e, evaluator.NewFSLoader(os.DirFS(dir), ext))
+
+	tmpl, err := set.Add(strings.TrimSuffix(file, ext), source)
+	if err != nil {
+		return report(stderr, path, err)
+	}
+
+	ctx, err := tmpl.NewExecutionContext(values)
+	if err != nil {
+		return report(stderr, "goscript", &inputError{err: err})
+	}
+	lim.apply(ctx)
+	rep.apply(ctx, func(template string) string {
+		return filepath.Join(dir, filepath.FromSlash(template)+ext)
+	})
+
+	output, err := tmpl.RenderContext(ctx)
+	if err := rep.write(ctx); err != nil {
+		fmt.Fprintf(stderr, "goscript: %v\n", err)
+		return exitRuntime
+	}
+	if err != nil {
+		return report(stderr, path, err)
+	}
+
+	if *out == "" {
+		_, err = io.WriteString(stdout, output)
+	} else {
+		err = os.WriteFile(*out, []byte(output), 0o666)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "goscript: %v\n", err)
+		return exitRuntime
+	}
+	return exitOK
+}
+
+func check(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("check", "file...", stderr)
+	template := fs.Bool("template", false, "check the files as templates rather than scripts")
+	if code := parseFlags(fs, args, 1); code >= 0 {
+		return code
+	}
+
+	code := exitOK
+	for _, path := range fs.Args() {
+		source, err := readFile(path)
+		if err != nil {
+			code = max(code, report(stderr, "goscript", err))
+			continue
+		}
+
+		l := lexer.NewScript(source)
+		if *template {
+			l = lexer.NewTemplate(source)
+		}
+		if _, err := parser.New(l).Parse(); err != nil {
+			code = max(code, report(stderr, path, err))
+		}
+	}
+	return code
+}
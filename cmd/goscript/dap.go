@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/dap"
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// launchArguments are the arguments of a launch request to the debug
+// adapter, which name the file to debug and its variables as the flags of
+// run and render do.
+type launchArguments struct {
+	Program string            `json:"program"`
+	Data    []string          `json:"data"`
+	Vars    map[string]string `json:"vars"`
+	Escape  string            `json:"escape"`
+}
+
+// serveDAP runs a debug adapter on stdin and stdout, for editors to start
+// as a subprocess.
+func serveDAP(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("dap", "", stderr)
+	if code := parseFlags(fs, args, 0); code >= 0 {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := dap.NewServer(launch).Serve(stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "goscript: %v\n", err)
+		return exitRuntime
+	}
+	return exitOK
+}
+
+// launch prepares the script or template named by a launch request. Files
+// ending in .gs are scripts, and any others templates.
+func launch(raw json.RawMessage) (*dap.Target, error) {
+	var args launchArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.Program == "" {
+		return nil, errors.New("launch: no program given")
+	}
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := variables{data: args.Data}
+	for name, value := range args.Vars {
+		vars.vars = append(vars.vars, [2]string{name, value})
+	}
+	values, err := vars.load()
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(path, ".gs") {
+		return launchScript(path, source, values)
+	}
+	return launchTemplate(path, source, values, args.Escape)
+}
+
+func launchScript(path, source string, values evaluator.Vars) (*dap.Target, error) {
+	program, err := parser.New(lexer.NewScript(source)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := evaluator.NewExecutionContext(program)
+	ctx.Source = source
+	for name, value := range values {
+		obj, err := evaluator.ToObject(value)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		ctx.RootScope.SetLocal(name, obj)
+	}
+
+	return &dap.Target{
+		Run: func(d evaluator.Debugger, output io.Writer) error {
+			ctx.Debugger = d
+			ctx.Logger = stringWriter{output}
+			result, err := evaluator.New().Evaluate(ctx)
+			if err != nil {
+				return err
+			}
+			if ret, ok := result.(*evaluator.ReturnValue); ok && ret.Value != evaluator.Null {
+				fmt.Fprintln(output, ret.Value.Debug())
+			}
+			return nil
+		},
+		Path: func(string) string { return path },
+	}, nil
+}
+
+func launchTemplate(path, source string, values evaluator.Vars, escape string) (*dap.Target, error) {
+	e := evaluator.New()
+	switch escape {
+	case "", "none":
+	case "html":
+		e.Escaping = evaluator.HTMLEscaping
+	default:
+		return nil, fmt.Errorf("invalid escape %q: want none or html", escape)
+	}
+	e.Freeze()
+
+	// As with render, the template can include and extend the templates
+	// next to it, which are named by their paths from its directory.
+	dir, file := filepath.Split(path)
+	ext := filepath.Ext(file)
+	set := evaluator.NewTemplateSetWithLoader(e, evaluator.NewFSLoader(os.DirFS(dir), ext))
+	tmpl, err := set.Add(strings.TrimSuffix(file, ext), source)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := tmpl.NewExecutionContext(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dap.Target{
+		Run: func(d evaluator.Debugger, output io.Writer) error {
+			ctx.Debugger = d
+			ctx.Logger = stringWriter{output}
+			return tmpl.RenderContextTo(ctx, output)
+		},
+		Path: func(template string) string {
+			return filepath.Join(dir, filepath.FromSlash(template)+ext)
+		},
+	}, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLaunch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"page.html":   "<h1>{% title %}</h1>\n{% include(\"footer\") %}",
+		"footer.html": "<p>{% owner %}</p>",
+		"sum.gs":      "log(\"summing\");\nreturn a + b;",
+		"data.json":   `{"owner": "ada"}`,
+	})
+
+	tests := []struct {
+		name   string
+		args   map[string]any
+		output string
+		path   string
+	}{
+		{
+			"template",
+			map[string]any{"program": filepath.Join(dir, "page.html"), "data": []string{filepath.Join(dir, "data.json")}, "vars": map[string]string{"title": "<Home>"}, "escape": "html"},
+			"<h1>&lt;Home&gt;</h1><p>ada</p>",
+			filepath.Join(dir, "footer.html"),
+		},
+		{
+			"script",
+			map[string]any{"program": filepath.Join(dir, "sum.gs"), "vars": map[string]string{"a": "1", "b": "2"}},
+			"summing\n12\n",
+			filepath.Join(dir, "sum.gs"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(tt.args)
+			target, err := launch(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err := target.Run(nil, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.output {
+				t.Fatalf("expected output %q, got %q", tt.output, out.String())
+			}
+			if path := target.Path("footer"); path != tt.path {
+				t.Fatalf("expected path %s, got %s", tt.path, path)
+			}
+		})
+	}
+
+	for _, args := range []string{`{}`, `{"program": "missing.gs"}`, `{"program": "` + filepath.ToSlash(filepath.Join(dir, "page.html")) + `", "escape": "js"}`} {
+		if _, err := launch(json.RawMessage(args)); err == nil {
+			t.Errorf("%s: expected an error", args)
+		}
+	}
+}
+
+func TestDAP(t *testing.T) {
+	input := frame(
+		`{"seq":1,"type":"request","command":"initialize","arguments":{"adapterID":"goscript"}}`,
+		`{"seq":2,"type":"request","command":"launch","arguments":{"program":"missing.gs"}}`,
+		`{"seq":3,"type":"request","command":"disconnect"}`,
+	)
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"dap"}, strings.NewReader(input), &out, &errOut)
+	if code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, errOut.String())
+	}
+	for _, s := range []string{`"supportsConfigurationDoneRequest":true`, `"event":"initialized"`, `"command":"launch","message":"open `, `"command":"disconnect"`} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in %q", s, out.String())
+		}
+	}
+
+	code, _, stderr := runCommand("dap", "file.gs")
+	if code != exitUsage || !strings.Contains(stderr, "usage: goscript dap [flags]") {
+		t.Fatalf("expected a usage error, got %d: %s", code, stderr)
+	}
+}
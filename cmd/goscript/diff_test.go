@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(from, to int) string {
+		var sb strings.Builder
+		for i := from; i <= to; i++ {
+			sb.WriteString("line " + string(rune('a'+i-1)) + "\n")
+		}
+		return sb.String()
+	}
+
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"empty to text", "", "a\n", "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"change", "a\nb\nc\n", "a\nx\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"no newline", "a", "a\n", "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+		{
+			"separate hunks",
+			lines(1, 20),
+			strings.Replace(strings.Replace(lines(1, 20), "line b\n", "", 1), "line s\n", "line S\n", 1),
+			"--- a\n+++ b\n" +
+				"@@ -1,5 +1,4 @@\n line a\n-line b\n line c\n line d\n line e\n" +
+				"@@ -16,5 +15,5 @@\n line p\n line q\n line r\n-line s\n+line S\n line t\n",
+		},
+		{
+			"joined hunks",
+			lines(1, 10),
+			strings.Replace(strings.Replace(lines(1, 10), "line b\n", "line B\n", 1), "line i\n", "line I\n", 1),
+			"--- a\n+++ b\n" +
+				"@@ -1,10 +1,10 @@\n line a\n-line b\n+line B\n line c\n line d\n line e\n line f\n line g\n line h\n-line i\n+line I\n line j\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", tt.a, tt.b); got != tt.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
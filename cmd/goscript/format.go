@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ironfang-ltd/go-script/formatter"
+)
+
+func formatFiles(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("fmt", "[file...]", stderr)
+	list := fs.Bool("l", false, "list the files whose formatting differs")
+	write := fs.Bool("w", false, "write the result to the file instead of standard output")
+	diff := fs.Bool("d", false, "print diffs instead of the formatted source")
+	template := fs.Bool("template", false, "format the files as templates rather than scripts")
+	if code := parseFlags(fs, args, 0); code >= 0 {
+		return code
+	}
+
+	f := &fileFormatter{
+		format: formatter.Script,
+		list:   *list,
+		write:  *write,
+		diff:   *diff,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	if *template {
+		f.format = formatter.Template
+	}
+
+	if fs.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(stderr, "goscript: cannot use -w with standard input")
+			return exitUsage
+		}
+		source, err := io.ReadAll(stdin)
+		if err != nil {
+			return report(stderr, "goscript", &inputError{err: err})
+		}
+		return f.file("<standard input>", string(source))
+	}
+
+	code := exitOK
+	for _, path := range fs.Args() {
+		source, err := readFile(path)
+		if err != nil {
+			code = max(code, report(stderr, "goscript", err))
+			continue
+		}
+		code = max(code, f.file(path, source))
+	}
+	return code
+}
+
+// fileFormatter formats files for the fmt command.
+type fileFormatter struct {
+	format func(string) (string, error)
+	list   bool
+	write  bool
+	diff   bool
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// file formats source, read from path, returning the exit code.
+func (f *fileFormatter) file(path, source string) int {
+	formatted, err := f.format(source)
+	if err != nil {
+		return report(f.stderr, path, err)
+	}
+
+	if !f.list && !f.write && !f.diff {
+		io.WriteString(f.stdout, formatted)
+		return exitOK
+	}
+	if formatted == source {
+		return exitOK
+	}
+
+	if f.list {
+		fmt.Fprintln(f.stdout, path)
+	}
+	if f.write {
+		info, err := os.Stat(path)
+		if err == nil {
+			err = os.WriteFile(path, []byte(formatted), info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintf(f.stderr, "goscript: %v\n", err)
+			return exitRuntime
+		}
+	}
+	if f.diff {
+		io.WriteString(f.stdout, unifiedDiff(path+".orig", path, source, formatted))
+	}
+	return exitOK
+}
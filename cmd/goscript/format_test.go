@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ugly.gs":   "let a=1;\nlog(a);",
+		"tidy.gs":   "let a = 1;\n",
+		"bad.gs":    "let = 1;",
+		"page.html": "<p>{%name%}</p>",
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"print", []string{"fmt", path("ugly.gs"), path("tidy.gs")}, exitOK, "let a = 1;\nlog(a);\nlet a = 1;\n", ""},
+		{"template", []string{"fmt", "-template", path("page.html")}, exitOK, "<p>{% name %}</p>", ""},
+		{"list", []string{"fmt", "-l", path("ugly.gs"), path("tidy.gs")}, exitOK, path("ugly.gs") + "\n", ""},
+		{"diff", []string{"fmt", "-d", path("ugly.gs"), path("tidy.gs")}, exitOK,
+			"--- " + path("ugly.gs") + ".orig\n+++ " + path("ugly.gs") + "\n@@ -1,2 +1,2 @@\n-let a=1;\n-log(a);\n\\ No newline at end of file\n+let a = 1;\n+log(a);\n", ""},
+		{"syntax error", []string{"fmt", path("bad.gs"), path("tidy.gs")}, exitSyntax, "let a = 1;\n", "bad.gs: error:"},
+		{"missing file", []string{"fmt", "-l", path("missing.gs")}, exitInput, "", "missing.gs"},
+		{"write stdin", []string{"fmt", "-w"}, exitUsage, "", "cannot use -w with standard input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCommand(tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Fatalf("expected output %q, got %q", tt.stdout, stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Fatalf("expected %q in %q", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+func TestFormatWrite(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"ugly.gs": "fn f(){return 1;}"})
+	path := filepath.Join(dir, "ugly.gs")
+
+	code, stdout, stderr := runCommand("fmt", "-w", "-l", path)
+	if code != exitOK || stdout != path+"\n" {
+		t.Fatalf("unexpected result %d %q: %s", code, stdout, stderr)
+	}
+	if written, _ := os.ReadFile(path); string(written) != "fn f() {\n    return 1;\n}\n" {
+		t.Fatalf("unexpected file contents %q", written)
+	}
+
+	code, stdout, _ = runCommand("fmt", "-l", path)
+	if code != exitOK || stdout != "" {
+		t.Fatalf("expected the file to be formatted, got %d %q", code, stdout)
+	}
+}
+
+func TestFormatStdin(t *testing.T) {
+	var out, errOut strings.Builder
+	code := run([]string{"fmt"}, strings.NewReader("x+=1;"), &out, &errOut)
+	if code != exitOK || out.String() != "x += 1;\n" {
+		t.Fatalf("unexpected result %d %q: %s", code, out.String(), errOut.String())
+	}
+}
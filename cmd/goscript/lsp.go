@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/lsp"
+)
+
+// serveLSP runs a language server on stdin and stdout, for editors to
+// start as a subprocess.
+func serveLSP(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("lsp", "", stderr)
+	if code := parseFlags(fs, args, 0); code >= 0 {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	e := evaluator.New()
+	e.Freeze()
+	if err := lsp.NewServer(e).Serve(stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "goscript: %v\n", err)
+		return exitRuntime
+	}
+	return exitOK
+}
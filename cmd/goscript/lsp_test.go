@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// frame frames JSON-RPC messages with Content-Length headers.
+func frame(messages ...string) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&sb, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	return sb.String()
+}
+
+func TestLSP(t *testing.T) {
+	input := frame(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.gs","languageId":"goscript","version":1,"text":"let = 1;"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"lsp"}, strings.NewReader(input), &out, &errOut)
+	if code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, errOut.String())
+	}
+	for _, s := range []string{`"serverInfo":{"name":"goscript"}`, `"method":"textDocument/publishDiagnostics"`, `"expected IDENTIFIER`, `{"jsonrpc":"2.0","id":2,"result":null}`} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected %q in %q", s, out.String())
+		}
+	}
+
+	code = run([]string{"lsp"}, strings.NewReader(frame(`{"jsonrpc":"2.0","method":"exit"}`)), &out, &errOut)
+	if code != exitRuntime || !strings.Contains(errOut.String(), "exit before shutdown") {
+		t.Fatalf("expected an error exiting without shutdown, got %d: %s", code, errOut.String())
+	}
+
+	code, _, stderr := runCommand("lsp", "file.gs")
+	if code != exitUsage || !strings.Contains(stderr, "usage: goscript lsp [flags]") {
+		t.Fatalf("expected a usage error, got %d: %s", code, stderr)
+	}
+}
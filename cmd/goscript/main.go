@@ -0,0 +1,136 @@
+// Command goscript runs scripts, renders templates, checks their syntax,
+// formats them, and serves editors with a language server and a debug
+// adapter.
+//
+// Usage:
+//
+//	goscript run [flags] script
+//	goscript render [flags] template
+//	goscript check [flags] file...
+//	goscript fmt [flags] [file...]
+//	goscript repl [flags]
+//	goscript lsp
+//	goscript dap
+//
+// run evaluates a script and prints the value it returns. render renders a
+// template to standard output, or to the file named by -o. Templates can
+// include and extend other templates in the same directory with the same
+// extension. check only lexes and parses its files, reporting syntax
+// errors with the source they occur in. fmt prints files in the canonical
+// layout of the formatter package, or with -l, -w or -d lists the files it
+// would change, rewrites them or prints the changes as diffs; without files
+// it formats standard input. repl starts an interactive session in which
+// variables and functions persist from one input to the next. lsp runs the
+// language server of the lsp package on standard input and output, for
+// editors to start, and dap the debug adapter of the dap package. The
+// launch requests of the debug adapter take the path of the file to debug
+// as "program", with "data", "vars" and "escape" standing in for the flags
+// of run and render, and "stopOnEntry" to stop before the first statement.
+//
+// Variables are given by -data files, in JSON or YAML, and -var name=value
+// flags, which set string variables and take precedence over data files.
+// run and render write a pprof profile of an evaluation with -profile, and
+// an LCOV report of its coverage with -coverage.
+//
+// The exit status is 0 on success, 1 if evaluation fails, 2 for invalid
+// usage, 3 if a file has a syntax error and 4 if an input file cannot be
+// read.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitRuntime = 1
+	exitUsage   = 2
+	exitSyntax  = 3
+	exitInput   = 4
+)
+
+const usage = `usage: goscript <command> [flags] <file>
+
+commands:
+  run      run a script and print the value it returns
+  render   render a template
+  check    check the syntax of scripts or templates
+  fmt      format scripts or templates
+  repl     start an interactive session
+  lsp      run a language server for editors
+  dap      run a debug adapter for editors
+
+Run "goscript <command> -h" for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the goscript command with args, returning its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "run":
+		return runScript(args[1:], stdout, stderr)
+	case "render":
+		return renderTemplate(args[1:], stdout, stderr)
+	case "check":
+		return check(args[1:], stdout, stderr)
+	case "fmt":
+		return formatFiles(args[1:], stdin, stdout, stderr)
+	case "repl":
+		return startREPL(args[1:], stdin, stdout, stderr)
+	case "lsp":
+		return serveLSP(args[1:], stdin, stdout, stderr)
+	case "dap":
+		return serveDAP(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "goscript: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// inputError is an error reading an input file.
+type inputError struct {
+	err error
+}
+
+func (e *inputError) Error() string {
+	return e.err.Error()
+}
+
+func (e *inputError) Unwrap() error {
+	return e.err
+}
+
+// report prints err for the file at path and returns the exit code for it.
+func report(stderr io.Writer, path string, err error) int {
+	fmt.Fprintf(stderr, "%s: %v\n", path, err)
+
+	var parseErr *parser.ParseError
+	var tokenErr *lexer.TokenError
+	var inputErr *inputError
+	switch {
+	case errors.As(err, &parseErr), errors.As(err, &tokenErr):
+		return exitSyntax
+	case errors.As(err, &inputErr):
+		return exitInput
+	default:
+		return exitRuntime
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files into a new temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runCommand(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(""), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"sum.gs":    `log("summing"); let total = 0; foreach (items as i) { total += i; } return name + ": " + total;`,
+		"loop.gs":   `while (true) { }`,
+		"user.gs":   "let user = {};\nreturn user.name;",
+		"div.gs":    "let a = 1;\nreturn a / 0;",
+		"data.yml":  "name: yaml\nitems:\n  - 1\n  - 2\n",
+		"data.json": `{"items": [3, 4]}`,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"data and vars", []string{"run", "-data", path("data.yml"), "-data", path("data.json"), "-var", "name=total", path("sum.gs")}, exitOK, "summing\ntotal: 7\n", ""},
+		{"yaml data", []string{"run", "-data", path("data.yml"), path("sum.gs")}, exitOK, "summing\nyaml: 3\n", ""},
+		{"step limit", []string{"run", "-max-steps", "50", path("loop.gs")}, exitRuntime, "", "execution limit exceeded: 50 steps"},
+		{"strict", []string{"run", "-strict", path("user.gs")}, exitRuntime, "", "user.gs: error: undefined property: name\n --> line 2, column 12"},
+		{"runtime error", []string{"run", path("div.gs")}, exitRuntime, "", "div.gs: error: division by zero\n --> line 2, column 10"},
+		{"missing script", []string{"run", path("missing.gs")}, exitInput, "", "missing.gs: no such file"},
+		{"bad data", []string{"run", "-data", path("sum.gs"), path("sum.gs")}, exitInput, "", `unsupported data file extension ".gs"`},
+		{"bad var", []string{"run", "-var", "novalue", path("sum.gs")}, exitUsage, "", "expected name=value"},
+		{"no script", []string{"run"}, exitUsage, "", "usage: goscript run [flags] script"},
+		{"unknown command", []string{"build"}, exitUsage, "", `unknown command "build"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCommand(tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Fatalf("expected output %q, got %q", tt.stdout, stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Fatalf("expected %q in %q", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"layout.html": `<title>{% block title { %}{% } %}</title>{% block body { %}{% } %}`,
+		"page.html":   `{% extends "layout"; %}{% block title { %}{% title %}{% } %}{% block body { foreach (items as i) { include("item", {"i": i}); } } %}`,
+		"item.html":   `<li>{% i %}</li>`,
+		"bad.html":    "<p>\n{% let = %}",
+		"data.json":   `{"title": "<Home>", "items": [1, 2]}`,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	code, stdout, stderr := runCommand("render", "-data", path("data.json"), "-escape", "html", path("page.html"))
+	if code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+	if stdout != "<title>&lt;Home&gt;</title><li>1</li><li>2</li>" {
+		t.Fatalf("unexpected output %q", stdout)
+	}
+
+	out := path("out.html")
+	code, stdout, stderr = runCommand("render", "-data", path("data.json"), "-var", "title=Hi", "-o", out, path("page.html"))
+	if code != exitOK || stdout != "" {
+		t.Fatalf("unexpected result %d %q: %s", code, stdout, stderr)
+	}
+	if written, _ := os.ReadFile(out); string(written) != "<title>Hi</title><li>1</li><li>2</li>" {
+		t.Fatalf("unexpected file contents %q", written)
+	}
+
+	code, _, stderr = runCommand("render", path("bad.html"))
+	if code != exitSyntax || !strings.Contains(stderr, "2 | {% let = %}") {
+		t.Fatalf("expected a syntax error with source context, got %d: %s", code, stderr)
+	}
+
+	code, _, stderr = runCommand("render", "-escape", "xml", path("page.html"))
+	if code != exitUsage || !strings.Contains(stderr, `invalid -escape "xml"`) {
+		t.Fatalf("expected a usage error, got %d: %s", code, stderr)
+	}
+}
+
+func TestReports(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"page.html": `{% foreach (items as i) { include("item", {"i": i}); } %}`,
+		"item.html": `{% if (i > 1) { %}<li>{% i %}</li>{% } %}`,
+		"div.gs":    "let a = 1;\nreturn a / 0;",
+		"data.json": `{"items": [1, 2]}`,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	code, stdout, stderr := runCommand("render", "-data", path("data.json"), "-profile", path("page.prof"), "-coverage", path("page.lcov"), path("page.html"))
+	if code != exitOK || stdout != "<li>2</li>" {
+		t.Fatalf("unexpected result %d %q: %s", code, stdout, stderr)
+	}
+	lcov, _ := os.ReadFile(path("page.lcov"))
+	for _, s := range []string{"SF:" + path("item.html") + "\nFNF:0\nFNH:0\nBRDA:1,0,0,1\nBRDA:1,0,1,1\n", "SF:" + path("page.html") + "\n"} {
+		if !strings.Contains(string(lcov), s) {
+			t.Fatalf("expected %q in %q", s, lcov)
+		}
+	}
+	if profile, _ := os.ReadFile(path("page.prof")); !bytes.HasPrefix(profile, []byte{0x1f, 0x8b}) {
+		t.Fatalf("expected a gzipped profile, got %q", profile)
+	}
+
+	// The reports are written even if the evaluation fails.
+	code, _, stderr = runCommand("run", "-coverage", path("div.lcov"), path("div.gs"))
+	if code != exitRuntime {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+	if lcov, _ := os.ReadFile(path("div.lcov")); !strings.Contains(string(lcov), "SF:"+path("div.gs")+"\nFNF:0\nFNH:0\nBRF:0\nBRH:0\nDA:1,1\nDA:2,1\n") {
+		t.Fatalf("unexpected coverage %q", lcov)
+	}
+
+	code, _, stderr = runCommand("run", "-profile", path("missing/div.prof"), path("div.gs"))
+	if code != exitRuntime || !strings.Contains(stderr, "no such file") {
+		t.Fatalf("expected an error writing the profile, got %d: %s", code, stderr)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ok.gs":     `let a = 1; return a;`,
+		"bad.gs":    "let a = 1;\nlet = 2;",
+		"lex.gs":    `let s = "open;`,
+		"page.html": `<p>{% title %}</p>`,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr []string
+	}{
+		{"valid", []string{"check", path("ok.gs")}, exitOK, nil},
+		{"template", []string{"check", "-template", path("page.html")}, exitOK, nil},
+		{"parse error", []string{"check", path("ok.gs"), path("bad.gs")}, exitSyntax, []string{"bad.gs: error:", "2 | let = 2;"}},
+		{"lex error", []string{"check", path("lex.gs")}, exitSyntax, []string{"unterminated string literal"}},
+		{"missing file", []string{"check", path("bad.gs"), path("missing.gs")}, exitInput, []string{"bad.gs: error:", "missing.gs"}},
+		{"no files", []string{"check"}, exitUsage, []string{"usage: goscript check [flags] file..."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCommand(tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, stderr)
+			}
+			if stdout != "" {
+				t.Fatalf("unexpected output %q", stdout)
+			}
+			for _, s := range tt.stderr {
+				if !strings.Contains(stderr, s) {
+					t.Fatalf("expected %q in %q", s, stderr)
+				}
+			}
+			if tt.stderr == nil && stderr != "" {
+				t.Fatalf("unexpected errors %q", stderr)
+			}
+		})
+	}
+}
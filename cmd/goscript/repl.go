@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// maxHistory is the number of entries kept in the history file.
+const maxHistory = 1000
+
+const replHelp = `Enter statements to evaluate them. Input continues over several lines
+until its braces, brackets and parentheses are balanced.
+
+commands:
+  :vars           list the variables in scope
+  :load file      evaluate a file in the current scope
+  :ast source     print the syntax tree of source
+  :template       switch to template mode, where input is rendered
+  :script         switch back to script mode
+  :history        list the input entered so far
+  :reset          clear all variables
+  :help           show this help
+  :quit           exit (or end the input)
+`
+
+// repl is an interactive session. Everything evaluated in it shares one
+// scope, so variables and functions persist from one input to the next.
+type repl struct {
+	e           *evaluator.Evaluator
+	scope       *evaluator.Scope
+	limits      limits
+	template    bool
+	history     []string
+	historyFile string
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+func startREPL(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("repl", "", stderr)
+	r := &repl{
+		e:      evaluator.New(),
+		scope:  evaluator.NewScope(),
+		stdout: stdout,
+		stderr: stderr,
+	}
+	r.limits.register(fs)
+	fs.StringVar(&r.historyFile, "history", defaultHistoryFile(), "keep the input history in `file` (empty for none)")
+	if code := parseFlags(fs, args, 0); code >= 0 {
+		return code
+	}
+
+	r.loadHistory()
+	r.run(stdin)
+	return exitOK
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".goscript_history")
+}
+
+func (r *repl) prompt(continued bool) {
+	switch {
+	case continued:
+		fmt.Fprint(r.stdout, "... ")
+	case r.template:
+		fmt.Fprint(r.stdout, "%>> ")
+	default:
+		fmt.Fprint(r.stdout, ">>> ")
+	}
+}
+
+func (r *repl) run(stdin io.Reader) {
+	scanner := bufio.NewScanner(stdin)
+	var input strings.Builder
+
+	for {
+		r.prompt(input.Len() > 0)
+		if !scanner.Scan() {
+			fmt.Fprintln(r.stdout)
+			return
+		}
+		line := scanner.Text()
+
+		if input.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, ":") {
+				r.addHistory(trimmed)
+				if !r.command(trimmed) {
+					return
+				}
+				continue
+			}
+		} else {
+			input.WriteString("\n")
+		}
+		input.WriteString(line)
+
+		source := input.String()
+		if !complete(source, r.template) {
+			continue
+		}
+		input.Reset()
+
+		r.addHistory(source)
+		r.eval(source)
+	}
+}
+
+// command runs a REPL command, reporting whether the session continues.
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":quit", ":q", ":exit":
+		return false
+	case ":help":
+		fmt.Fprint(r.stdout, replHelp)
+	case ":vars":
+		for _, name := range r.scope.Names() {
+			val, _ := r.scope.GetLocal(name)
+			fmt.Fprintf(r.stdout, "%s = %s\n", name, val.Debug())
+		}
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.stderr, "usage: :load file")
+			break
+		}
+		source, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(r.stderr, err)
+			break
+		}
+		r.eval(string(source))
+	case ":ast":
+		program, err := r.parse(arg)
+		if err != nil {
+			fmt.Fprintln(r.stderr, err)
+			break
+		}
+		fmt.Fprint(r.stdout, program.Debug())
+	case ":template":
+		r.template = true
+		fmt.Fprintln(r.stdout, "template mode")
+	case ":script":
+		r.template = false
+		fmt.Fprintln(r.stdout, "script mode")
+	case ":history":
+		for i, entry := range r.history {
+			fmt.Fprintf(r.stdout, "%4d  %s\n", i+1, strings.ReplaceAll(entry, "\n", "\n      "))
+		}
+	case ":reset":
+		r.scope = evaluator.NewScope()
+	default:
+		fmt.Fprintf(r.stderr, "unknown command %s; type :help for help\n", name)
+	}
+	return true
+}
+
+func (r *repl) parse(source string) (*parser.Program, error) {
+	l := lexer.NewScript(source)
+	if r.template {
+		l = lexer.NewTemplate(source)
+	}
+	return parser.New(l).Parse()
+}
+
+// eval evaluates source in the session's scope, printing the result in
+// script mode and the output in template mode.
+func (r *repl) eval(source string) {
+	program, err := r.parse(source)
+	if err != nil {
+		fmt.Fprintln(r.stderr, err)
+		return
+	}
+
+	ctx := evaluator.NewExecutionContextWithScope(program, r.scope)
+	ctx.Source = source
+	ctx.Logger = stringWriter{r.stdout}
+	r.limits.apply(ctx)
+
+	if r.template {
+		output, err := r.e.EvaluateString(ctx)
+		if err != nil {
+			fmt.Fprintln(r.stderr, err)
+			return
+		}
+		if output != "" && !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+		fmt.Fprint(r.stdout, output)
+		return
+	}
+
+	result, err := r.e.Evaluate(ctx)
+	if err != nil {
+		fmt.Fprintln(r.stderr, err)
+		return
+	}
+	if ret, ok := result.(*evaluator.ReturnValue); ok {
+		result = ret.Value
+	}
+	if result != nil && result != evaluator.Null {
+		fmt.Fprintln(r.stdout, result.Debug())
+	}
+}
+
+// complete reports whether source can be evaluated, or needs more lines to
+// close its braces, brackets, parentheses, script blocks, comments or
+// interpolated strings.
+func complete(source string, template bool) bool {
+	l := lexer.NewScript(source)
+	if template {
+		l = lexer.NewTemplate(source)
+	}
+
+	depth := 0
+	for {
+		token, err := l.Read()
+		if err != nil {
+			var tokenErr *lexer.TokenError
+			if !errors.As(err, &tokenErr) {
+				return true
+			}
+			return tokenErr.Message != "unterminated comment" && tokenErr.Message != "unterminated interpolated string"
+		}
+
+		switch token.Type {
+		case lexer.EndOfFile:
+			return depth <= 0
+		case lexer.LeftBrace, lexer.LeftBracket, lexer.LeftParen, lexer.ScriptStart:
+			depth++
+		case lexer.RightBrace, lexer.RightBracket, lexer.RightParen, lexer.ScriptEnd:
+			depth--
+		}
+	}
+}
+
+// loadHistory reads the history file, which holds one quoted entry per
+// line.
+func (r *repl) loadHistory() {
+	if r.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(r.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, err := strconv.Unquote(line); err == nil {
+			r.history = append(r.history, entry)
+		}
+	}
+}
+
+func (r *repl) addHistory(entry string) {
+	r.history = append(r.history, entry)
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+	if r.historyFile == "" {
+		return
+	}
+
+	var sb strings.Builder
+	for _, entry := range r.history {
+		sb.WriteString(strconv.Quote(entry))
+		sb.WriteString("\n")
+	}
+	if err := os.WriteFile(r.historyFile, []byte(sb.String()), 0o600); err != nil {
+		fmt.Fprintf(r.stderr, "history: %v\n", err)
+		r.historyFile = ""
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runREPL(t *testing.T, input string, args ...string) (stdout, stderr string) {
+	t.Helper()
+	var out, errOut strings.Builder
+	args = append([]string{"repl", "-history", ""}, args...)
+	if code := run(args, strings.NewReader(input), &out, &errOut); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, errOut.String())
+	}
+	return out.String(), errOut.String()
+}
+
+// outputs returns what was printed after each prompt.
+func outputs(stdout string) []string {
+	var results []string
+	for _, part := range strings.FieldsFunc(stdout, func(r rune) bool { return r == '\n' }) {
+		for _, prompt := range []string{">>> ", "... ", "%>> "} {
+			part = strings.ReplaceAll(part, prompt, "")
+		}
+		if part != "" {
+			results = append(results, part)
+		}
+	}
+	return results
+}
+
+func TestREPLPersistentScope(t *testing.T) {
+	stdout, stderr := runREPL(t, strings.Join([]string{
+		`let x = 20;`,
+		`fn double(n) {`,
+		`  return n * 2;`,
+		`}`,
+		`double(x) + 2;`,
+		`let items = [`,
+		`  1, 2`,
+		`];`,
+		`log(len(items));`,
+		`missing + 1;`,
+		`:vars`,
+	}, "\n"))
+
+	expected := []string{"20", "Function", "42", "Array", "2", "double = Function", "items = Array", "x = 20"}
+	if got := outputs(stdout); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	if !strings.Contains(stderr, "identifier not found: missing") {
+		t.Fatalf("expected an error for missing, got %q", stderr)
+	}
+	if strings.Count(stdout, "... ") != 4 {
+		t.Fatalf("expected four continuation prompts, got %q", stdout)
+	}
+}
+
+func TestREPLCommands(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.gs")
+	if err := os.WriteFile(lib, []byte(`fn greet(name) { return "hi " + name; }`), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := runREPL(t, strings.Join([]string{
+		`:load ` + lib,
+		`greet("bob");`,
+		`:ast 1 + 2 * 3;`,
+		`:template`,
+		`<p>{% greet("ann") %}</p>`,
+		`{% foreach ([1, 2] as i) { %}`,
+		`[{% i %}]{% } %}`,
+		`:script`,
+		`:reset`,
+		`:vars`,
+		`:nope`,
+		`:quit`,
+		`"not evaluated";`,
+	}, "\n"))
+
+	expected := []string{"Function", "hi bob", "1 + 2 * 3", "template mode", "<p>hi ann</p>", "[1]", "[2]", "script mode"}
+	if got := outputs(stdout); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	if !strings.Contains(stderr, "unknown command :nope") {
+		t.Fatalf("expected an unknown command error, got %q", stderr)
+	}
+}
+
+func TestREPLHistory(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history")
+
+	runREPL(t, "let a = 1;\nfn f() {\n  return a;\n}\n", "-history", history)
+	stdout, _ := runREPL(t, ":history\n", "-history", history)
+
+	expected := "   1  let a = 1;\n   2  fn f() {\n        return a;\n      }\n   3  :history\n"
+	if !strings.Contains(stdout, expected) {
+		t.Fatalf("expected history %q in %q", expected, stdout)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		source   string
+		template bool
+		expected bool
+	}{
+		{`let a = 1;`, false, true},
+		{`fn f() {`, false, false},
+		{`let a = [1, (2`, false, false},
+		{`/* comment`, false, false},
+		{`}`, false, true},
+		{`let s = "{";`, false, true},
+		{"let s = `line", false, false},
+		{"let s = `${f(", false, false},
+		{"let s = `{`;", false, true},
+		{`<p>{ text }</p>`, true, true},
+		{`{% if (x) { %}`, true, false},
+		{`{% if (x) { %}yes{% } %}`, true, true},
+		{`{% let a = 1;`, true, false},
+	}
+
+	for _, tt := range tests {
+		if got := complete(tt.source, tt.template); got != tt.expected {
+			t.Errorf("complete(%q, %v): expected %v, got %v", tt.source, tt.template, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+)
+
+// variables are the -data and -var flags of run and render.
+type variables struct {
+	data []string
+	vars [][2]string
+}
+
+func (v *variables) register(fs *flag.FlagSet) {
+	fs.Func("data", "load variables from a JSON or YAML `file` (repeatable)", func(path string) error {
+		v.data = append(v.data, path)
+		return nil
+	})
+	fs.Func("var", "set the string variable `name=value` (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return errors.New("expected name=value")
+		}
+		v.vars = append(v.vars, [2]string{name, value})
+		return nil
+	})
+}
+
+// load returns the variables set by the flags. Later data files override
+// earlier ones, and -var flags override them all.
+func (v *variables) load() (evaluator.Vars, error) {
+	vars := make(evaluator.Vars)
+
+	for _, path := range v.data {
+		values, err := loadDataFile(path)
+		if err != nil {
+			return nil, &inputError{err: err}
+		}
+		for name, value := range values {
+			vars[name] = value
+		}
+	}
+
+	for _, kv := range v.vars {
+		vars[kv[0]] = kv[1]
+	}
+
+	return vars, nil
+}
+
+// loadDataFile reads the variables in a JSON or YAML file, chosen by its
+// extension, whose top level must be an object. An empty file sets none.
+func loadDataFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		value, err = decodeJSON(data)
+	case ".yaml", ".yml":
+		value, err = decodeYAML(data)
+	default:
+		return nil, fmt.Errorf("%s: unsupported data file extension %q: want .json, .yaml or .yml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values, ok := value.(map[string]any)
+	if !ok && value != nil {
+		return nil, fmt.Errorf("%s: top level must be an object", path)
+	}
+	return values, nil
+}
+
+// decodeJSON decodes data, keeping whole numbers as integers.
+func decodeJSON(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var value any
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+	return numbers(value), nil
+}
+
+// numbers replaces the json.Numbers in value with ints or float64s.
+func numbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, elem := range v {
+			v[key] = numbers(elem)
+		}
+	case []any:
+		for i, elem := range v {
+			v[i] = numbers(elem)
+		}
+	}
+	return value
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeYAML decodes the subset of YAML needed for data files: block
+// mappings and sequences, scalars, and flow collections written as JSON.
+// Anchors, aliases, tags, block scalars and multiple documents are not
+// supported.
+func decodeYAML(data []byte) (any, error) {
+	d := &yamlDecoder{}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		number := i + 1
+		line = strings.TrimRight(line, " \t\r")
+		text := strings.TrimLeft(line, " ")
+		indent := len(line) - len(text)
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", number)
+		}
+
+		text = stripComment(text)
+		switch {
+		case text == "":
+			continue
+		case text == "---" && len(d.lines) == 0:
+			continue
+		case text == "---" || text == "...":
+			return nil, fmt.Errorf("line %d: multiple documents are not supported", number)
+		}
+
+		d.lines = append(d.lines, yamlLine{number: number, indent: indent, text: text})
+	}
+
+	if len(d.lines) == 0 {
+		return nil, nil
+	}
+
+	value, err := d.node(d.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos < len(d.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", d.lines[d.pos].number)
+	}
+	return value, nil
+}
+
+// yamlLine is a line holding something other than a comment, without its
+// indentation and any trailing comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlDecoder struct {
+	lines []yamlLine
+	pos   int
+}
+
+// node decodes the block starting at the current line, which is indented
+// by indent.
+func (d *yamlDecoder) node(indent int) (any, error) {
+	line := d.lines[d.pos]
+
+	if isSequenceItem(line.text) {
+		return d.sequence(indent)
+	}
+	if _, _, ok, err := splitKey(line); err != nil {
+		return nil, err
+	} else if ok {
+		return d.mapping(indent)
+	}
+
+	d.pos++
+	return scalar(line)
+}
+
+// child decodes the block nested under the line before the current one,
+// which is indented by indent, or returns nil if it has none.
+func (d *yamlDecoder) child(indent int) (any, error) {
+	if d.pos < len(d.lines) && d.lines[d.pos].indent > indent {
+		return d.node(d.lines[d.pos].indent)
+	}
+	return nil, nil
+}
+
+func (d *yamlDecoder) sequence(indent int) (any, error) {
+	items := []any{}
+
+	for d.pos < len(d.lines) && d.lines[d.pos].indent == indent && isSequenceItem(d.lines[d.pos].text) {
+		line := &d.lines[d.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		var item any
+		var err error
+		if rest == "" {
+			d.pos++
+			item, err = d.child(indent)
+		} else {
+			// The item continues as though it were a line of its own,
+			// indented to where it starts.
+			line.indent += len(line.text) - len(rest)
+			line.text = rest
+			item, err = d.node(line.indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (d *yamlDecoder) mapping(indent int) (any, error) {
+	m := make(map[string]any)
+
+	for d.pos < len(d.lines) && d.lines[d.pos].indent == indent {
+		line := d.lines[d.pos]
+		key, rest, ok, err := splitKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line.number)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		d.pos++
+
+		var value any
+		switch {
+		case rest != "":
+			value, err = scalar(yamlLine{number: line.number, text: rest})
+		case d.pos < len(d.lines) && d.lines[d.pos].indent == indent && isSequenceItem(d.lines[d.pos].text):
+			// A sequence can be indented as far as its key.
+			value, err = d.sequence(indent)
+		default:
+			value, err = d.child(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+
+	return m, nil
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a "key: value" line, reporting whether the line is one.
+func splitKey(line yamlLine) (key, rest string, ok bool, err error) {
+	text := line.text
+
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 {
+			return "", "", false, fmt.Errorf("line %d: unterminated string", line.number)
+		}
+		after := text[end+1:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		key, err := unquote(line.number, text[:end+1])
+		if err != nil {
+			return "", "", false, err
+		}
+		return key, strings.TrimSpace(after[1:]), true, nil
+	}
+
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+
+	if i := strings.Index(text, ": "); i >= 0 {
+		return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true, nil
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true, nil
+	}
+	return "", "", false, nil
+}
+
+// scalar decodes the value on line.
+func scalar(line yamlLine) (any, error) {
+	text := line.text
+
+	switch text[0] {
+	case '"', '\'':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("line %d: unexpected text after string", line.number)
+		}
+		return unquote(line.number, text)
+	case '[', '{':
+		value, err := decodeJSON([]byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: flow collections must be valid JSON: %w", line.number, err)
+		}
+		return value, nil
+	case '|', '>':
+		return nil, fmt.Errorf("line %d: block scalars are not supported", line.number)
+	case '&', '*', '!':
+		return nil, fmt.Errorf("line %d: anchors, aliases and tags are not supported", line.number)
+	}
+
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+
+	if isNumber(text) {
+		if i, err := strconv.Atoi(text); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f, nil
+		}
+	}
+
+	return text, nil
+}
+
+// isNumber reports whether text is written as a decimal number.
+func isNumber(text string) bool {
+	digits := false
+	for _, c := range text {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-':
+		default:
+			return false
+		}
+	}
+	return digits
+}
+
+// closingQuote returns the index of the quote closing the string that text
+// starts with, or -1 if it is unterminated.
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote:
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func unquote(number int, text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	s, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("line %d: invalid string %s", number, text)
+	}
+	return s, nil
+}
+
+// stripComment removes a trailing comment from text. A comment starts with
+// a # at the start of the text or after whitespace, outside any string.
+func stripComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return strings.TrimRight(text[:i], " \t")
+		case (c == '"' || c == '\'') && startsValue(text[:i]):
+			end := closingQuote(text[i:])
+			if end < 0 {
+				return text
+			}
+			i += end
+		}
+	}
+	return text
+}
+
+// startsValue reports whether a quote following prefix starts a string,
+// rather than being part of a plain scalar.
+func startsValue(prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	switch prefix[len(prefix)-1] {
+	case ' ', '[', '{', ',', ':':
+		return true
+	}
+	return false
+}
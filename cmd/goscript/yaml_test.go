@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+	}{
+		{
+			"scalars",
+			"s: hello world\ni: 42\nn: -7\nf: 2.5\nb: true\nz: null\nt: ~\nv: 1.10.2\n",
+			map[string]any{"s": "hello world", "i": 42, "n": -7, "f": 2.5, "b": true, "z": nil, "t": nil, "v": "1.10.2"},
+		},
+		{
+			"quoted",
+			"a: \"line\\nbreak # not a comment\"\nb: 'it''s'\n\"c d\": x\n",
+			map[string]any{"a": "line\nbreak # not a comment", "b": "it's", "c d": "x"},
+		},
+		{
+			"comments",
+			"---\n# heading\na: 1 # one\n\nb: don't # two\nc: x#y\n",
+			map[string]any{"a": 1, "b": "don't", "c": "x#y"},
+		},
+		{
+			"nested",
+			"site:\n  title: Home\n  meta:\n    lang: en\nempty:\n",
+			map[string]any{"site": map[string]any{"title": "Home", "meta": map[string]any{"lang": "en"}}, "empty": nil},
+		},
+		{
+			"sequences",
+			"tags:\n  - a\n  - b\nflat:\n- 1\n- 2\n",
+			map[string]any{"tags": []any{"a", "b"}, "flat": []any{1, 2}},
+		},
+		{
+			"sequence of mappings",
+			"items:\n  - name: Apple\n    price: 3\n  - name: Pear\n    tags:\n      - green\n  -\n    name: Plum\n",
+			map[string]any{"items": []any{
+				map[string]any{"name": "Apple", "price": 3},
+				map[string]any{"name": "Pear", "tags": []any{"green"}},
+				map[string]any{"name": "Plum"},
+			}},
+		},
+		{
+			"flow collections",
+			"list: [1, \"two\", 3.5]\nmap: {\"k\": [true]}\n",
+			map[string]any{"list": []any{1, "two", 3.5}, "map": map[string]any{"k": []any{true}}},
+		},
+		{"empty", "# nothing\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeYAML([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %#v, got %#v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		message string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "line 2: tabs cannot be used for indentation"},
+		{"bad indentation", "a:\n    b: 1\n  c: 2\n", "line 3: unexpected indentation"},
+		{"duplicate key", "a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"not a key", "a: 1\nb\n", "line 2: expected key: value"},
+		{"block scalar", "a: |\n  text\n", "line 1: block scalars are not supported"},
+		{"alias", "a: *ref\n", "line 1: anchors, aliases and tags are not supported"},
+		{"flow", "a: [b, c]\n", "line 1: flow collections must be valid JSON"},
+		{"documents", "a: 1\n---\nb: 2\n", "line 2: multiple documents are not supported"},
+		{"unterminated", "\"a: 1\n", "line 1: unterminated string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeYAML([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("expected error %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
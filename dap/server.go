@@ -0,0 +1,447 @@
+// Package dap implements a Debug Adapter Protocol server, so that editors
+// can debug scripts and templates: set breakpoints, step through
+// statements, and inspect and modify variables while a program is stopped.
+//
+// The server does not know how to start a program. A Launcher creates the
+// Target of a launch or attach request from its arguments, and the server
+// runs it with a debugger.Session as the Debugger of its execution context:
+//
+//	launch := func(args json.RawMessage) (*dap.Target, error) {
+//		return &dap.Target{
+//			Run: func(d evaluator.Debugger, output io.Writer) error {
+//				ctx.Debugger = d
+//				return tmpl.RenderContextTo(ctx, output)
+//			},
+//			Path: func(template string) string { return paths[template] },
+//		}, nil
+//	}
+//	err := dap.NewServer(launch).Serve(os.Stdin, os.Stdout)
+//
+// Launch and attach requests are handled alike, so a host can offer an
+// editor a render to attach to by starting it from its Launcher. A program
+// has a single thread, with ID 1.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/ironfang-ltd/go-script/debugger"
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/internal/wire"
+)
+
+// threadID is the ID of the one thread of a program.
+const threadID = 1
+
+var errNotStopped = errors.New("the program is not stopped")
+
+// Target is a program to debug.
+type Target struct {
+	// Run evaluates the program with d as the Debugger of its execution
+	// context, writing its output to output. The output is also an
+	// io.StringWriter, to be used as the Logger of the context.
+	Run func(d evaluator.Debugger, output io.Writer) error
+	// Path returns the path of the source file of a template, given its
+	// name or "" as a debugger.Session does. Breakpoints and stack frames
+	// are located by these paths. If Path is nil, the names are used.
+	Path func(template string) string
+}
+
+// Launcher creates the target of a launch or attach request from its
+// arguments.
+type Launcher func(arguments json.RawMessage) (*Target, error)
+
+// Server is a debug adapter for one program.
+type Server struct {
+	launch Launcher
+
+	// writeMu serializes the messages written to out, which come from the
+	// requests and from the program as it runs.
+	writeMu sync.Mutex
+	out     io.Writer
+	seq     int
+
+	mu          sync.Mutex
+	breakpoints map[string][]int
+	target      *Target
+	stopOnEntry bool
+	configured  bool
+	session     *debugger.Session
+	// finished is closed once the program has ended and its exit has been
+	// reported.
+	finished chan struct{}
+
+	// stop is where the program is stopped, and frames its call stack.
+	stop   *debugger.Stop
+	frames []evaluator.DebugFrame
+	// refs holds the scopes, arrays and hashes whose variables the client
+	// can ask for while the program is stopped, by index plus one.
+	refs []any
+}
+
+// NewServer creates a server that starts programs with launch.
+func NewServer(launch Launcher) *Server {
+	return &Server{
+		launch:      launch,
+		breakpoints: make(map[string][]int),
+	}
+}
+
+// Serve reads requests from r and writes responses and events to w until
+// the input ends or the client disconnects. A program still running then is
+// terminated.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.out = w
+	in := bufio.NewReader(r)
+	defer s.terminate()
+
+	for {
+		content, err := wire.ReadMessage(in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("dap: %w", err)
+		}
+
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			if err := s.reply(&msg, nil, fmt.Errorf("invalid message: %w", err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Type != "request" {
+			continue
+		}
+
+		body, err := s.handle(&msg)
+		if err := s.reply(&msg, body, err); err != nil {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+		if err := s.after(&msg); err != nil {
+			return err
+		}
+		if msg.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (s *Server) write(v any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.seq++
+	switch m := v.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	return wire.WriteMessage(s.out, v)
+}
+
+// reply writes the response to a request.
+func (s *Server) reply(msg *message, body any, err error) error {
+	res := &response{Type: "response", RequestSeq: msg.Seq, Command: msg.Command, Success: err == nil, Body: body}
+	if err != nil {
+		res.Message = err.Error()
+		res.Body = nil
+	}
+	return s.write(res)
+}
+
+func (s *Server) send(name string, body any) error {
+	return s.write(&event{Type: "event", Event: name, Body: body})
+}
+
+// handle answers a request.
+func (s *Server) handle(msg *message) (any, error) {
+	switch msg.Command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsSetVariable:              true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+
+	case "launch", "attach":
+		var args LaunchArguments
+		if len(msg.Arguments) > 0 {
+			if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+				return nil, err
+			}
+		}
+		target, err := s.launch(msg.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.target != nil {
+			return nil, errors.New("a program has already been launched")
+		}
+		s.target, s.stopOnEntry = target, args.StopOnEntry
+		return nil, nil
+
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(args), nil
+
+	case "setExceptionBreakpoints":
+		return nil, nil
+
+	case "configurationDone":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.configured = true
+		return nil, nil
+
+	case "threads":
+		return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "main"}}}, nil
+
+	case "stackTrace":
+		var args StackTraceArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.stackTrace(args)
+
+	case "scopes":
+		var args ScopesArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.scopes(args)
+
+	case "variables":
+		var args VariablesArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args)
+
+	case "setVariable":
+		var args SetVariableArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.setVariable(args)
+
+	case "evaluate":
+		var args EvaluateArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args)
+
+	case "continue", "next", "stepIn", "stepOut":
+		// The program goes on once the response is written, so that the
+		// response comes before the program's next stop.
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stop == nil {
+			return nil, errNotStopped
+		}
+		s.stop, s.frames, s.refs = nil, nil, nil
+		if msg.Command == "continue" {
+			return ContinueResponseBody{AllThreadsContinued: true}, nil
+		}
+		return nil, nil
+
+	case "pause":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.session != nil {
+			s.session.Pause()
+		}
+		return nil, nil
+
+	case "terminate":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.session != nil {
+			s.session.Terminate()
+		}
+		return nil, nil
+
+	case "disconnect":
+		s.terminate()
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unsupported command: %s", msg.Command)
+}
+
+// after does what follows the response to a request.
+func (s *Server) after(msg *message) error {
+	switch msg.Command {
+	case "initialize":
+		return s.send("initialized", nil)
+	case "launch", "attach", "configurationDone":
+		s.start()
+	case "continue":
+		return s.resume((*debugger.Session).Continue)
+	case "next":
+		return s.resume((*debugger.Session).StepOver)
+	case "stepIn":
+		return s.resume((*debugger.Session).StepInto)
+	case "stepOut":
+		return s.resume((*debugger.Session).StepOut)
+	}
+	return nil
+}
+
+func (s *Server) resume(step func(*debugger.Session) error) error {
+	s.mu.Lock()
+	session := s.session
+	s.mu.Unlock()
+
+	// The session may have been terminated since the program stopped.
+	if err := step(session); err != nil && !errors.Is(err, debugger.ErrNotPaused) {
+		return err
+	}
+	return nil
+}
+
+// start runs the program once it has been launched and the client has
+// finished configuring it.
+func (s *Server) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target == nil || !s.configured || s.session != nil {
+		return
+	}
+
+	target := s.target
+	session := debugger.NewSession(s.stopOnEntry)
+	session.Path = s.path
+	for path, lines := range s.breakpoints {
+		session.SetBreakpoints(path, lines)
+	}
+	s.session = session
+	s.finished = make(chan struct{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- target.Run(session, output{s})
+	}()
+	go s.watch(session, done)
+}
+
+// watch reports the stops of the program, and its end.
+func (s *Server) watch(session *debugger.Session, done <-chan error) {
+	defer close(s.finished)
+	for {
+		select {
+		case stop := <-session.Stops():
+			s.mu.Lock()
+			s.stop, s.frames, s.refs = stop, stop.Frames(), nil
+			s.mu.Unlock()
+			s.send("stopped", StoppedEventBody{Reason: string(stop.Reason), ThreadID: threadID, AllThreadsStopped: true})
+
+		case err := <-done:
+			s.mu.Lock()
+			s.stop, s.frames, s.refs = nil, nil, nil
+			s.mu.Unlock()
+
+			exitCode := 0
+			if err != nil {
+				exitCode = 1
+				if !errors.Is(err, debugger.ErrTerminated) {
+					s.send("output", OutputEventBody{Category: "stderr", Output: err.Error() + "\n"})
+				}
+			}
+			s.send("exited", ExitedEventBody{ExitCode: exitCode})
+			s.send("terminated", nil)
+			return
+		}
+	}
+}
+
+// terminate ends the program, if it is running, and waits for its end to
+// be reported.
+func (s *Server) terminate() {
+	s.mu.Lock()
+	session, finished := s.session, s.finished
+	s.mu.Unlock()
+
+	if session != nil {
+		session.Terminate()
+		<-finished
+	}
+}
+
+// path returns the path of the source of a template.
+func (s *Server) path(template string) string {
+	if s.target.Path == nil {
+		return template
+	}
+	return cleanPath(s.target.Path(template))
+}
+
+// cleanPath cleans a path for comparison, leaving the name of a program
+// with no source file empty.
+func cleanPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return filepath.Clean(path)
+}
+
+// output writes the output of the program as output events.
+type output struct {
+	s *Server
+}
+
+func (o output) Write(p []byte) (int, error) {
+	if err := o.s.send("output", OutputEventBody{Category: "stdout", Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (o output) WriteString(str string) (int, error) {
+	return o.Write([]byte(str))
+}
+
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) SetBreakpointsResponseBody {
+	lines := args.Lines
+	if args.Breakpoints != nil {
+		lines = nil
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+		}
+	}
+
+	path := cleanPath(args.Source.Path)
+
+	s.mu.Lock()
+	s.breakpoints[path] = lines
+	if s.session != nil {
+		s.session.SetBreakpoints(path, lines)
+	}
+	s.mu.Unlock()
+
+	// Any line can have a breakpoint, though only those with a statement
+	// on them are ever hit.
+	body := SetBreakpointsResponseBody{Breakpoints: []Breakpoint{}}
+	for _, line := range lines {
+		body.Breakpoints = append(body.Breakpoints, Breakpoint{Verified: true, Line: line})
+	}
+	return body
+}
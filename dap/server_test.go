@@ -0,0 +1,438 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/internal/wire"
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+const script = `let prices = [3, 4];
+let basket = {"owner": "ada", "count": 2};
+fn total(items) {
+  let sum = 0;
+  foreach (items as price) {
+    sum = sum + price;
+  }
+  return sum;
+}
+let result = total(prices);
+log("total " + result);
+`
+
+// launcher runs the script given by the "script" argument, as if it were
+// the file /src/basket.gs.
+func launcher(args json.RawMessage) (*Target, error) {
+	var launch struct {
+		Script string `json:"script"`
+	}
+	if err := json.Unmarshal(args, &launch); err != nil {
+		return nil, err
+	}
+	program, err := parser.New(lexer.NewScript(launch.Script)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Target{
+		Run: func(d evaluator.Debugger, output io.Writer) error {
+			ctx := evaluator.NewExecutionContext(program)
+			ctx.Source = launch.Script
+			ctx.Logger = output.(io.StringWriter)
+			ctx.Debugger = d
+			_, err := evaluator.New().Evaluate(ctx)
+			return err
+		},
+		Path: func(template string) string { return "/src/basket.gs" },
+	}, nil
+}
+
+// incoming is a response or event from the server.
+type incoming struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client talks to a server running in the test, over a pair of pipes.
+type client struct {
+	t       *testing.T
+	w       *io.PipeWriter
+	msgs    chan incoming
+	seq     int
+	pending []incoming
+	done    chan error
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, w: clientOut, msgs: make(chan incoming, 100), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(launcher).Serve(serverIn, serverOut)
+		serverOut.Close()
+		c.done <- err
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		defer close(c.msgs)
+		for {
+			content, err := wire.ReadMessage(r)
+			if err != nil {
+				return
+			}
+			var msg incoming
+			if err := json.Unmarshal(content, &msg); err != nil {
+				t.Error(err)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		clientOut.Close()
+		clientIn.Close()
+	})
+
+	c.request("initialize", map[string]any{"adapterID": "goscript"}, nil)
+	c.event("initialized", nil)
+	return c
+}
+
+// next returns the next message from the server.
+func (c *client) next() incoming {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("the server closed its output")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return incoming{}
+}
+
+// call sends a request and decodes its body into body, returning the error
+// message of a failed request. Events that arrive first are kept for event.
+func (c *client) call(command string, args, body any) string {
+	c.t.Helper()
+	c.seq++
+	if err := wire.WriteMessage(c.w, map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args}); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.next()
+		if msg.Type == "event" {
+			c.pending = append(c.pending, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq || msg.Command != command {
+			c.t.Fatalf("expected a response to %s %d, got one to %s %d", command, c.seq, msg.Command, msg.RequestSeq)
+		}
+		if !msg.Success {
+			return msg.Message
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return ""
+	}
+}
+
+// request sends a request that must succeed.
+func (c *client) request(command string, args, body any) {
+	c.t.Helper()
+	if message := c.call(command, args, body); message != "" {
+		c.t.Fatalf("%s failed: %s", command, message)
+	}
+}
+
+// event waits for the event called name, decoding its body into body. Other
+// events on the way are skipped.
+func (c *client) event(name string, body any) {
+	c.t.Helper()
+	for {
+		var msg incoming
+		if len(c.pending) > 0 {
+			msg, c.pending = c.pending[0], c.pending[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Type != "event" {
+			c.t.Fatalf("unexpected response to %s while waiting for %s", msg.Command, name)
+		}
+		if msg.Event != name {
+			continue
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+// launch starts the script with breakpoints on lines.
+func (c *client) launch(script string, stopOnEntry bool, lines ...int) {
+	c.t.Helper()
+	c.request("launch", map[string]any{"script": script, "stopOnEntry": stopOnEntry}, nil)
+	var bps []map[string]int
+	for _, line := range lines {
+		bps = append(bps, map[string]int{"line": line})
+	}
+	c.request("setBreakpoints", map[string]any{"source": map[string]string{"path": "/src/basket.gs"}, "breakpoints": bps}, nil)
+	c.request("configurationDone", nil, nil)
+}
+
+// stopped waits for the program to stop and returns its reason and the
+// line of the innermost frame.
+func (c *client) stopped() (string, int) {
+	c.t.Helper()
+	var stopped StoppedEventBody
+	c.event("stopped", &stopped)
+	var trace StackTraceResponseBody
+	c.request("stackTrace", map[string]any{"threadId": 1}, &trace)
+	return stopped.Reason, trace.StackFrames[0].Line
+}
+
+func (c *client) variables(ref int) map[string]Variable {
+	c.t.Helper()
+	var body VariablesResponseBody
+	c.request("variables", map[string]any{"variablesReference": ref}, &body)
+	vars := make(map[string]Variable)
+	for _, v := range body.Variables {
+		vars[v.Name] = v
+	}
+	return vars
+}
+
+func TestBreakpointsAndVariables(t *testing.T) {
+	c := newClient(t)
+	c.launch(script, false, 6)
+
+	var stopped StoppedEventBody
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" || stopped.ThreadID != 1 {
+		t.Fatalf("unexpected stop: %+v", stopped)
+	}
+
+	var threads ThreadsResponseBody
+	c.request("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != 1 {
+		t.Fatalf("unexpected threads: %+v", threads)
+	}
+
+	var trace StackTraceResponseBody
+	c.request("stackTrace", map[string]any{"threadId": 1}, &trace)
+	expected := []StackFrame{
+		{ID: 1, Name: "total", Source: &Source{Name: "basket.gs", Path: "/src/basket.gs"}, Line: 6, Column: 5},
+		{ID: 2, Name: "<main>", Source: &Source{Name: "basket.gs", Path: "/src/basket.gs"}, Line: 10, Column: 19},
+	}
+	if !reflect.DeepEqual(trace.StackFrames, expected) || trace.TotalFrames != 2 {
+		t.Fatalf("expected frames %+v, got %+v", expected, trace.StackFrames)
+	}
+
+	var scopes ScopesResponseBody
+	c.request("scopes", map[string]any{"frameId": 1}, &scopes)
+	var names []string
+	for _, scope := range scopes.Scopes {
+		names = append(names, scope.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"Locals", "Enclosing", "Globals"}) {
+		t.Fatalf("unexpected scopes: %v", names)
+	}
+
+	locals := c.variables(scopes.Scopes[0].VariablesReference)
+	if v := locals["price"]; v.Value != "3" || v.Type != "INTEGER" {
+		t.Fatalf("unexpected price: %+v", v)
+	}
+	function := c.variables(scopes.Scopes[1].VariablesReference)
+	if function["sum"].Value != "0" || function["items"].Value != "Array(2)" {
+		t.Fatalf("unexpected function variables: %+v", function)
+	}
+	globals := c.variables(scopes.Scopes[2].VariablesReference)
+	basket := globals["basket"]
+	if basket.Value != "Hash(2)" || basket.VariablesReference == 0 {
+		t.Fatalf("unexpected basket: %+v", basket)
+	}
+	pairs := c.variables(basket.VariablesReference)
+	if pairs[`"owner"`].Value != `"ada"` || pairs[`"count"`].Value != "2" {
+		t.Fatalf("unexpected basket pairs: %+v", pairs)
+	}
+
+	// The next price is doubled, and the sum starts from 100.
+	items := function["items"]
+	var set SetVariableResponseBody
+	c.request("setVariable", map[string]any{"variablesReference": items.VariablesReference, "name": "1", "value": "price * 2"}, &set)
+	if set.Value != "6" {
+		t.Fatalf("unexpected value set: %+v", set)
+	}
+	c.request("setVariable", map[string]any{"variablesReference": scopes.Scopes[1].VariablesReference, "name": "sum", "value": "100"}, &set)
+
+	var evaluated EvaluateResponseBody
+	c.request("evaluate", map[string]any{"expression": "sum + price", "frameId": 1, "context": "hover"}, &evaluated)
+	if evaluated.Result != "103" {
+		t.Fatalf("expected 103, got %+v", evaluated)
+	}
+	c.request("evaluate", map[string]any{"expression": `basket["owner"]`, "frameId": 2, "context": "repl"}, &evaluated)
+	if evaluated.Result != `"ada"` || evaluated.Type != "STRING" {
+		t.Fatalf(`expected "ada", got %+v`, evaluated)
+	}
+	if message := c.call("evaluate", map[string]any{"expression": "missing", "frameId": 1}, nil); message != "identifier not found: missing" {
+		t.Fatalf("unexpected evaluation error: %q", message)
+	}
+
+	// The breakpoint is hit again by the next iteration, then cleared.
+	var cont ContinueResponseBody
+	c.request("continue", map[string]any{"threadId": 1}, &cont)
+	if !cont.AllThreadsContinued {
+		t.Fatal("expected all threads to continue")
+	}
+	if reason, line := c.stopped(); reason != "breakpoint" || line != 6 {
+		t.Fatalf("expected the breakpoint at line 6, got %s at %d", reason, line)
+	}
+	c.request("setBreakpoints", map[string]any{"source": map[string]string{"path": "/src/basket.gs"}, "breakpoints": []any{}}, nil)
+	c.request("continue", map[string]any{"threadId": 1}, nil)
+
+	var output OutputEventBody
+	c.event("output", &output)
+	if output.Category != "stdout" || output.Output != "total 109" {
+		t.Fatalf("unexpected output: %+v", output)
+	}
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exited.ExitCode)
+	}
+	c.event("terminated", nil)
+
+	c.request("disconnect", nil, nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepping(t *testing.T) {
+	c := newClient(t)
+	c.launch(script, true)
+
+	steps := []struct {
+		command string
+		reason  string
+		line    int
+	}{
+		{"", "entry", 1},
+		{"next", "step", 2},
+		{"next", "step", 3},
+		{"next", "step", 10},
+		{"stepIn", "step", 4},
+		{"stepIn", "step", 5},
+		{"stepIn", "step", 6},
+		{"stepOut", "step", 11},
+	}
+	for _, step := range steps {
+		if step.command != "" {
+			c.request(step.command, map[string]any{"threadId": 1}, nil)
+		}
+		if reason, line := c.stopped(); reason != step.reason || line != step.line {
+			t.Fatalf("after %q: expected %s at line %d, got %s at line %d", step.command, step.reason, step.line, reason, line)
+		}
+	}
+
+	c.request("continue", map[string]any{"threadId": 1}, nil)
+	c.event("terminated", nil)
+}
+
+func TestPauseAndDisconnect(t *testing.T) {
+	c := newClient(t)
+	c.launch("let n = 0;\nwhile (n >= 0) {\n  n = n + 1;\n}", false)
+
+	c.request("pause", map[string]any{"threadId": 1}, nil)
+	if reason, _ := c.stopped(); reason != "pause" {
+		t.Fatalf("expected a pause, got %s", reason)
+	}
+
+	// Disconnecting terminates the program, which stops quietly.
+	c.request("disconnect", map[string]any{"terminateDebuggee": true}, nil)
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	c := newClient(t)
+	c.launch("let a = 1;\na / 0;", false)
+
+	var output OutputEventBody
+	c.event("output", &output)
+	if output.Category != "stderr" || !strings.Contains(output.Output, "division by zero") {
+		t.Fatalf("unexpected output: %+v", output)
+	}
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	if exited.ExitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exited.ExitCode)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	c := newClient(t)
+
+	tests := []struct {
+		command  string
+		args     any
+		expected string
+	}{
+		{"stackTrace", map[string]any{"threadId": 1}, "the program is not stopped"},
+		{"continue", map[string]any{"threadId": 1}, "the program is not stopped"},
+		{"evaluate", map[string]any{"expression": "1"}, "the program is not stopped"},
+		{"variables", map[string]any{"variablesReference": 1}, "the program is not stopped"},
+		{"restart", nil, "unsupported command: restart"},
+		{"launch", map[string]any{"script": "let = ;"}, "expected IDENTIFIER"},
+	}
+	for _, tt := range tests {
+		if message := c.call(tt.command, tt.args, nil); !strings.Contains(message, tt.expected) {
+			t.Errorf("%s: expected an error containing %q, got %q", tt.command, tt.expected, message)
+		}
+	}
+
+	c.request("launch", map[string]any{"script": "1;"}, nil)
+	if message := c.call("launch", map[string]any{"script": "1;"}, nil); message != "a program has already been launched" {
+		t.Errorf("unexpected error launching twice: %q", message)
+	}
+}
+
+func TestServeInvalidInput(t *testing.T) {
+	var out strings.Builder
+	err := NewServer(launcher).Serve(strings.NewReader("Content-Length: x\r\n\r\n"), &out)
+	if err == nil || !strings.HasPrefix(err.Error(), "dap: ") {
+		t.Fatalf("expected a framing error, got: %v", err)
+	}
+	if err := NewServer(launcher).Serve(strings.NewReader(""), &out); err != nil {
+		t.Fatalf("expected the end of the input to end serving, got: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package dap
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+)
+
+// stackTrace lists the frames of the stopped program, innermost first. A
+// frame's ID is its index plus one.
+func (s *Server) stackTrace(args StackTraceArguments) (StackTraceResponseBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil {
+		return StackTraceResponseBody{}, errNotStopped
+	}
+
+	body := StackTraceResponseBody{StackFrames: []StackFrame{}, TotalFrames: len(s.frames)}
+	for i, frame := range s.frames {
+		if i < args.StartFrame || args.Levels > 0 && i >= args.StartFrame+args.Levels {
+			continue
+		}
+		sf := StackFrame{ID: i + 1, Name: frame.Function, Line: frame.Line, Column: frame.Column}
+		if frame.Native {
+			sf.PresentationHint = "subtle"
+		} else if path := s.path(frame.Template); path != "" {
+			sf.Source = &Source{Name: filepath.Base(path), Path: path}
+		}
+		body.StackFrames = append(body.StackFrames, sf)
+	}
+	return body, nil
+}
+
+// frame returns the frame with id, or the innermost frame for 0.
+func (s *Server) frame(id int) (evaluator.DebugFrame, error) {
+	if s.stop == nil {
+		return evaluator.DebugFrame{}, errNotStopped
+	}
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(s.frames) {
+		return evaluator.DebugFrame{}, fmt.Errorf("no frame with ID %d", id)
+	}
+	return s.frames[id-1], nil
+}
+
+// scopes lists the scopes of a frame: its own variables, those of the
+// scopes enclosing it, and the globals of the program.
+func (s *Server) scopes(args ScopesArguments) (ScopesResponseBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frame, err := s.frame(args.FrameID)
+	if err != nil {
+		return ScopesResponseBody{}, err
+	}
+
+	body := ScopesResponseBody{Scopes: []Scope{}}
+	for scope := frame.Scope; scope != nil; scope = scope.Parent() {
+		name := "Enclosing"
+		switch {
+		case scope.Parent() == nil:
+			name = "Globals"
+		case scope == frame.Scope:
+			name = "Locals"
+		}
+		body.Scopes = append(body.Scopes, Scope{Name: name, VariablesReference: s.ref(scope)})
+	}
+	return body, nil
+}
+
+// ref returns the reference by which the client asks for the variables of
+// a scope, array or hash.
+func (s *Server) ref(container any) int {
+	s.refs = append(s.refs, container)
+	return len(s.refs)
+}
+
+func (s *Server) container(ref int) (any, error) {
+	if s.stop == nil {
+		return nil, errNotStopped
+	}
+	if ref < 1 || ref > len(s.refs) {
+		return nil, fmt.Errorf("no variables with reference %d", ref)
+	}
+	return s.refs[ref-1], nil
+}
+
+// variables lists the variables set in a scope, the elements of an array or
+// the pairs of a hash.
+func (s *Server) variables(args VariablesArguments) (VariablesResponseBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	container, err := s.container(args.VariablesReference)
+	if err != nil {
+		return VariablesResponseBody{}, err
+	}
+
+	body := VariablesResponseBody{Variables: []Variable{}}
+	add := func(name string, obj evaluator.Object) {
+		value, typ, ref := s.describe(obj)
+		body.Variables = append(body.Variables, Variable{Name: name, Value: value, Type: typ, VariablesReference: ref})
+	}
+
+	switch c := container.(type) {
+	case *evaluator.Scope:
+		for _, name := range c.Names() {
+			obj, _ := c.GetLocal(name)
+			add(name, obj)
+		}
+	case *evaluator.ArrayValue:
+		for i, obj := range c.Elements {
+			add(strconv.Itoa(i), obj)
+		}
+	case *evaluator.HashValue:
+		for _, pair := range c.OrderedPairs() {
+			add(keyName(pair.Key), pair.Value)
+		}
+	}
+	return body, nil
+}
+
+// describe returns how the client shows a value, its type, and the
+// reference of its elements if it has any.
+func (s *Server) describe(obj evaluator.Object) (string, string, int) {
+	if obj == nil {
+		obj = evaluator.Null
+	}
+	typ := string(obj.Type())
+	switch v := obj.(type) {
+	case *evaluator.StringValue:
+		return strconv.Quote(v.Value), typ, 0
+	case *evaluator.ArrayValue:
+		ref := 0
+		if len(v.Elements) > 0 {
+			ref = s.ref(v)
+		}
+		return fmt.Sprintf("Array(%d)", len(v.Elements)), typ, ref
+	case *evaluator.HashValue:
+		ref := 0
+		if len(v.Pairs) > 0 {
+			ref = s.ref(v)
+		}
+		return fmt.Sprintf("Hash(%d)", len(v.Pairs)), typ, ref
+	}
+	return obj.Debug(), typ, 0
+}
+
+// keyName returns the name of a hash pair: its key, quoted if it is a
+// string.
+func keyName(key evaluator.Object) string {
+	if str, ok := key.(*evaluator.StringValue); ok {
+		return strconv.Quote(str.Value)
+	}
+	return key.Debug()
+}
+
+// setVariable evaluates a new value for a variable, element or pair in
+// the scope of the innermost frame, and sets it.
+func (s *Server) setVariable(args SetVariableArguments) (SetVariableResponseBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	container, err := s.container(args.VariablesReference)
+	if err != nil {
+		return SetVariableResponseBody{}, err
+	}
+
+	scope := s.stop.Scope
+	if c, ok := container.(*evaluator.Scope); ok {
+		scope = c
+	}
+	obj, err := s.stop.Evaluate(scope, args.Value)
+	if err != nil {
+		return SetVariableResponseBody{}, evaluationError(err)
+	}
+
+	switch c := container.(type) {
+	case *evaluator.Scope:
+		if _, ok := c.GetLocal(args.Name); !ok {
+			return SetVariableResponseBody{}, fmt.Errorf("no variable %s", args.Name)
+		}
+		c.SetLocal(args.Name, obj)
+	case *evaluator.ArrayValue:
+		i, err := strconv.Atoi(args.Name)
+		if err != nil || i < 0 || i >= len(c.Elements) {
+			return SetVariableResponseBody{}, fmt.Errorf("no element %s", args.Name)
+		}
+		c.Elements[i] = obj
+	case *evaluator.HashValue:
+		found := false
+		for _, pair := range c.OrderedPairs() {
+			if keyName(pair.Key) == args.Name {
+				found = true
+				if err := c.Set(pair.Key, obj); err != nil {
+					return SetVariableResponseBody{}, err
+				}
+			}
+		}
+		if !found {
+			return SetVariableResponseBody{}, fmt.Errorf("no key %s", args.Name)
+		}
+	}
+
+	value, typ, ref := s.describe(obj)
+	return SetVariableResponseBody{Value: value, Type: typ, VariablesReference: ref}, nil
+}
+
+// evaluate runs an expression or statements in the scope of a frame.
+func (s *Server) evaluate(args EvaluateArguments) (EvaluateResponseBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frame, err := s.frame(args.FrameID)
+	if err != nil {
+		return EvaluateResponseBody{}, err
+	}
+	if frame.Scope == nil {
+		return EvaluateResponseBody{}, fmt.Errorf("cannot evaluate in native function %s", frame.Function)
+	}
+
+	obj, err := s.stop.Evaluate(frame.Scope, args.Expression)
+	if err != nil {
+		return EvaluateResponseBody{}, evaluationError(err)
+	}
+	value, typ, ref := s.describe(obj)
+	return EvaluateResponseBody{Result: value, Type: typ, VariablesReference: ref}, nil
+}
+
+// evaluationError drops the source snippet from the error of evaluating
+// what the client typed, which it already shows.
+func evaluationError(err error) error {
+	var rtErr *evaluator.RuntimeError
+	if errors.As(err, &rtErr) {
+		return errors.New(rtErr.Message)
+	}
+	return err
+}
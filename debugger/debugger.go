@@ -0,0 +1,275 @@
+// Package debugger implements a step debugger for scripts and templates on
+// top of the evaluator's Debugger hook.
+//
+// A Session is set as the Debugger of an execution context, which is then
+// evaluated on a goroutine of its own. Whenever the program stops, at a
+// breakpoint or after a step, the session sends a Stop on the channel
+// returned by Stops and waits to be told how to go on:
+//
+//	session := debugger.NewSession(false)
+//	session.SetBreakpoints("", []int{12})
+//	ctx.Debugger = session
+//	go func() { _, err := e.Evaluate(ctx); done <- err }()
+//
+//	for {
+//		select {
+//		case stop := <-session.Stops():
+//			fmt.Println(stop.Line, stop.Scope.Names())
+//			session.StepOver()
+//		case err := <-done:
+//			return err
+//		}
+//	}
+//
+// While stopped, the variables of the program can be read and assigned
+// through the scopes of the Stop, or with its Evaluate method.
+package debugger
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+)
+
+var (
+	// ErrTerminated is the error a program ends with when its session is
+	// terminated.
+	ErrTerminated = errors.New("debugger: terminated")
+
+	// ErrNotPaused is returned by Continue and the step methods while the
+	// program is running.
+	ErrNotPaused = errors.New("debugger: not paused")
+)
+
+// Reason says why a program stopped.
+type Reason string
+
+const (
+	// ReasonEntry is a stop at the first statement of a session that
+	// stops on entry.
+	ReasonEntry Reason = "entry"
+	// ReasonBreakpoint is a stop at a line with a breakpoint.
+	ReasonBreakpoint Reason = "breakpoint"
+	// ReasonStep is a stop after a step.
+	ReasonStep Reason = "step"
+	// ReasonPause is a stop asked for by Pause.
+	ReasonPause Reason = "pause"
+)
+
+// Stop is a program stopped before a statement. It is only valid until the
+// program is told to go on.
+type Stop struct {
+	Reason Reason
+	// Path is the name of the source the statement is in, as given by the
+	// session's Path function.
+	Path string
+	*evaluator.DebugState
+}
+
+// mode is what a running program is doing, from the last time it stopped.
+type mode int
+
+const (
+	modeContinue mode = iota
+	modeEntry
+	modeStepInto
+	modeStepOver
+	modeStepOut
+)
+
+// location is where a statement is in the source and call stack.
+type location struct {
+	path   string
+	line   int
+	column int
+	depth  int
+}
+
+// moved reports whether a program at l has gone on from the statement at
+// from to another line, to another call, or back round a loop.
+func (l location) moved(from location) bool {
+	return l.path != from.path || l.line != from.line || l.depth != from.depth || l.column <= from.column
+}
+
+// Session controls a program being debugged. Its methods may be called from
+// any goroutine.
+type Session struct {
+	// Path names the source of a template, which breakpoints and stops are
+	// given by. It is called with the name of the template, or "" for the
+	// program of the execution context when it did not come from a
+	// TemplateSet. By default, the name itself is used.
+	Path func(template string) string
+
+	mu          sync.Mutex
+	breakpoints map[string]map[int]bool
+	mode        mode
+	// from is where the program last stopped, and last where the last
+	// statement it ran was.
+	from   location
+	last   location
+	paused bool
+	// pause is set by Pause until the program next stops.
+	pause bool
+
+	stops     chan *Stop
+	resume    chan mode
+	done      chan struct{}
+	terminate sync.Once
+}
+
+// NewSession creates a session. If stopOnEntry is set, the program stops
+// before its first statement.
+func NewSession(stopOnEntry bool) *Session {
+	s := &Session{
+		breakpoints: make(map[string]map[int]bool),
+		stops:       make(chan *Stop),
+		resume:      make(chan mode, 1),
+		done:        make(chan struct{}),
+	}
+	if stopOnEntry {
+		s.mode = modeEntry
+	}
+	return s
+}
+
+// Stops returns the channel a Stop is sent on each time the program stops.
+// The program waits for the Stop to be received.
+func (s *Session) Stops() <-chan *Stop {
+	return s.stops
+}
+
+// SetBreakpoints replaces the breakpoints of the source at path with the
+// given lines.
+func (s *Session) SetBreakpoints(path string, lines []int) {
+	set := make(map[int]bool, len(lines))
+	for _, line := range lines {
+		set[line] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints[path] = set
+}
+
+// Continue runs a stopped program until the next breakpoint.
+func (s *Session) Continue() error {
+	return s.resumeWith(modeContinue)
+}
+
+// StepInto runs a stopped program to the next statement, in any function.
+func (s *Session) StepInto() error {
+	return s.resumeWith(modeStepInto)
+}
+
+// StepOver runs a stopped program to the next statement in the same
+// function, or in its caller once it returns, running calls through.
+func (s *Session) StepOver() error {
+	return s.resumeWith(modeStepOver)
+}
+
+// StepOut runs a stopped program until the function it stopped in returns.
+func (s *Session) StepOut() error {
+	return s.resumeWith(modeStepOut)
+}
+
+func (s *Session) resumeWith(m mode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.paused {
+		return ErrNotPaused
+	}
+	s.paused = false
+	// The buffer holds the one mode sent for each stop.
+	s.resume <- m
+	return nil
+}
+
+// Pause stops a running program before its next statement. It does nothing
+// to a stopped program.
+func (s *Session) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused {
+		s.pause = true
+	}
+}
+
+// Terminate ends the program with ErrTerminated before its next statement,
+// or at once if it is stopped.
+func (s *Session) Terminate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	s.terminate.Do(func() { close(s.done) })
+}
+
+// Statement implements evaluator.Debugger, stopping the program when a
+// breakpoint or step says to.
+func (s *Session) Statement(state *evaluator.DebugState) error {
+	select {
+	case <-s.done:
+		return ErrTerminated
+	default:
+	}
+
+	path := state.Template
+	if s.Path != nil {
+		path = s.Path(path)
+	}
+	at := location{path: path, line: state.Line, column: state.Column, depth: state.Depth}
+
+	s.mu.Lock()
+	reason, stop := s.stopAt(at)
+	s.last = at
+	if stop {
+		s.paused = true
+		s.pause = false
+	}
+	s.mu.Unlock()
+
+	if !stop {
+		return nil
+	}
+
+	select {
+	case s.stops <- &Stop{Reason: reason, Path: path, DebugState: state}:
+	case <-s.done:
+		return ErrTerminated
+	}
+
+	select {
+	case m := <-s.resume:
+		s.mu.Lock()
+		s.mode = m
+		s.from = at
+		s.mu.Unlock()
+		return nil
+	case <-s.done:
+		return ErrTerminated
+	}
+}
+
+// stopAt reports whether the program stops at a statement, and why.
+func (s *Session) stopAt(at location) (Reason, bool) {
+	switch {
+	case s.pause:
+		return ReasonPause, true
+	case s.mode == modeEntry:
+		return ReasonEntry, true
+	case s.breakpoints[at.path][at.line] && at.moved(s.last):
+		// Of the statements on a line, the first to run hits its breakpoint.
+		return ReasonBreakpoint, true
+	}
+
+	switch s.mode {
+	case modeStepInto:
+		return ReasonStep, at.moved(s.from)
+	case modeStepOver:
+		return ReasonStep, at.depth < s.from.depth || at.depth == s.from.depth && at.moved(s.from)
+	case modeStepOut:
+		return ReasonStep, at.depth < s.from.depth
+	}
+	return "", false
+}
@@ -0,0 +1,306 @@
+package debugger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// program is a script running under a session.
+type program struct {
+	session *Session
+	ctx     *evaluator.ExecutionContext
+	done    chan error
+	result  evaluator.Object
+}
+
+func start(t *testing.T, session *Session, input string, configure ...func(*evaluator.ExecutionContext)) *program {
+	t.Helper()
+	ast, err := parser.New(lexer.NewScript(input)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &program{session: session, ctx: evaluator.NewExecutionContext(ast), done: make(chan error, 1)}
+	p.ctx.Debugger = session
+	for _, f := range configure {
+		f(p.ctx)
+	}
+	go func() {
+		var err error
+		p.result, err = evaluator.New().Evaluate(p.ctx)
+		p.done <- err
+	}()
+	return p
+}
+
+// next waits for the program to stop.
+func (p *program) next(t *testing.T) *Stop {
+	t.Helper()
+	select {
+	case stop := <-p.session.Stops():
+		return stop
+	case err := <-p.done:
+		t.Fatalf("expected a stop, the program ended with: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a stop")
+	}
+	return nil
+}
+
+// finish waits for the program to end.
+func (p *program) finish(t *testing.T) error {
+	t.Helper()
+	select {
+	case stop := <-p.session.Stops():
+		t.Fatalf("expected the program to end, it stopped at line %d", stop.Line)
+	case err := <-p.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the program to end")
+	}
+	return nil
+}
+
+type stopAt struct {
+	Reason Reason
+	Line   int
+	Depth  int
+}
+
+const calls = `fn add(a, b) {
+  let sum = a + b;
+  return sum;
+}
+let total = 0;
+total = add(total, 1);
+total = add(total, 2);
+total;`
+
+func TestBreakpoints(t *testing.T) {
+	session := NewSession(false)
+	session.SetBreakpoints("", []int{3, 7})
+	p := start(t, session, calls)
+
+	var stops []stopAt
+	for range 3 {
+		stop := p.next(t)
+		stops = append(stops, stopAt{stop.Reason, stop.Line, stop.Depth})
+		if err := session.Continue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.finish(t); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []stopAt{
+		{ReasonBreakpoint, 3, 1},
+		{ReasonBreakpoint, 7, 0},
+		{ReasonBreakpoint, 3, 1},
+	}
+	if !reflect.DeepEqual(stops, expected) {
+		t.Fatalf("expected stops %v, got %v", expected, stops)
+	}
+}
+
+func TestBreakpointLoops(t *testing.T) {
+	session := NewSession(false)
+	session.SetBreakpoints("", []int{2})
+	p := start(t, session, "let n = 0;\nwhile (n < 3) { n = n + 1; let m = n; }\nn;")
+
+	for i := range 4 {
+		if stop := p.next(t); stop.Line != 2 {
+			t.Fatalf("stop %d: expected line 2, got %d", i, stop.Line)
+		}
+		if err := session.Continue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.finish(t); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     func(*Session) error
+		expected []stopAt
+	}{
+		{
+			"into",
+			(*Session).StepInto,
+			[]stopAt{
+				{ReasonEntry, 1, 0},
+				{ReasonStep, 5, 0},
+				{ReasonStep, 6, 0},
+				{ReasonStep, 2, 1},
+				{ReasonStep, 3, 1},
+				{ReasonStep, 7, 0},
+				{ReasonStep, 2, 1},
+				{ReasonStep, 3, 1},
+				{ReasonStep, 8, 0},
+			},
+		},
+		{
+			"over",
+			(*Session).StepOver,
+			[]stopAt{
+				{ReasonEntry, 1, 0},
+				{ReasonStep, 5, 0},
+				{ReasonStep, 6, 0},
+				{ReasonStep, 7, 0},
+				{ReasonStep, 8, 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := NewSession(true)
+			p := start(t, session, calls)
+
+			var stops []stopAt
+			for range tt.expected {
+				stop := p.next(t)
+				stops = append(stops, stopAt{stop.Reason, stop.Line, stop.Depth})
+				if err := tt.step(session); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := p.finish(t); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stops, tt.expected) {
+				t.Fatalf("expected stops %v, got %v", tt.expected, stops)
+			}
+		})
+	}
+}
+
+func TestStepOut(t *testing.T) {
+	session := NewSession(false)
+	session.SetBreakpoints("", []int{2})
+	p := start(t, session, calls)
+
+	if stop := p.next(t); stop.Line != 2 || stop.Depth != 1 {
+		t.Fatalf("expected a stop at line 2 in add, got line %d", stop.Line)
+	}
+	if err := session.StepOut(); err != nil {
+		t.Fatal(err)
+	}
+	if stop := p.next(t); stop.Reason != ReasonStep || stop.Line != 7 || stop.Depth != 0 {
+		t.Fatalf("expected a step to line 7, got %s at line %d", stop.Reason, stop.Line)
+	}
+	// The breakpoint in add is hit again on the way.
+	if err := session.StepOver(); err != nil {
+		t.Fatal(err)
+	}
+	if stop := p.next(t); stop.Reason != ReasonBreakpoint || stop.Line != 2 {
+		t.Fatalf("expected the breakpoint at line 2, got %s at line %d", stop.Reason, stop.Line)
+	}
+	if err := session.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.finish(t); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModifyVariables(t *testing.T) {
+	session := NewSession(false)
+	session.SetBreakpoints("", []int{3})
+	p := start(t, session, calls)
+
+	stop := p.next(t)
+	sum, _ := stop.Scope.GetLocal("sum")
+	if sum.Debug() != "1" {
+		t.Fatalf("expected sum 1, got %s", sum.Debug())
+	}
+	stop.Scope.SetLocal("sum", evaluator.NewIntegerValue(10))
+	session.SetBreakpoints("", nil)
+
+	if err := session.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.finish(t); err != nil {
+		t.Fatal(err)
+	}
+	if p.result.Debug() != "12" {
+		t.Fatalf("expected 12, got %s", p.result.Debug())
+	}
+}
+
+func TestPauseAndTerminate(t *testing.T) {
+	session := NewSession(false)
+	p := start(t, session, "let n = 0;\nwhile (true) {\n  n = n + 1;\n}", func(ctx *evaluator.ExecutionContext) {
+		ctx.MaxSteps = 0
+	})
+
+	session.Pause()
+	stop := p.next(t)
+	if stop.Reason != ReasonPause {
+		t.Fatalf("expected a pause, got %s", stop.Reason)
+	}
+
+	session.Terminate()
+	err := p.finish(t)
+	if !errors.Is(err, ErrTerminated) || !errors.Is(err, evaluator.ErrStopped) {
+		t.Fatalf("expected the program to be terminated, got: %v", err)
+	}
+	if err := session.Continue(); !errors.Is(err, ErrNotPaused) {
+		t.Fatalf("expected ErrNotPaused, got: %v", err)
+	}
+}
+
+func TestNotPaused(t *testing.T) {
+	session := NewSession(false)
+	for _, step := range []func() error{session.Continue, session.StepInto, session.StepOver, session.StepOut} {
+		if err := step(); !errors.Is(err, ErrNotPaused) {
+			t.Fatalf("expected ErrNotPaused, got: %v", err)
+		}
+	}
+}
+
+func TestTemplatePaths(t *testing.T) {
+	set := evaluator.NewTemplateSetWithLoader(evaluator.New(), evaluator.MapLoader{
+		"page":   "<h1>{% title %}</h1>\n{% include(\"footer\") %}",
+		"footer": "<footer>\n{% let year = 2024; %}\n</footer>",
+	})
+	tmpl, err := set.Get("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tmpl.NewExecutionContext(evaluator.Vars{"title": "Home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := NewSession(false)
+	session.Path = func(template string) string { return "templates/" + template + ".html" }
+	session.SetBreakpoints("templates/footer.html", []int{2})
+	ctx.Debugger = session
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := tmpl.RenderContext(ctx)
+		done <- err
+	}()
+
+	stop := <-session.Stops()
+	if stop.Path != "templates/footer.html" || stop.Line != 2 || stop.Template != "footer" {
+		t.Fatalf("expected a stop at templates/footer.html line 2, got %s line %d", stop.Path, stop.Line)
+	}
+	if err := session.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
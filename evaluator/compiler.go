@@ -0,0 +1,1394 @@
+package evaluator
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"weak"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+	"github.com/ironfang-ltd/go-script/resolver"
+)
+
+// opcode identifies a VM instruction. Every instruction takes one operand,
+// whose meaning depends on the opcode.
+type opcode uint8
+
+const (
+	opConstant       opcode = iota // push constants[arg]
+	opNull                         // push null
+	opTrue                         // push true
+	opFalse                        // push false
+	opPop                          // discard the top of the stack
+	opDup                          // push the top of the stack again
+	opGet                          // push the variable names[arg]
+	opGetLocal                     // push the local variable variables[arg]
+	opGetGlobal                    // push the global variable variables[arg]
+	opDefine                       // pop a value into a new local variable names[arg]
+	opDefineLocal                  // pop a value into slot arg of the current scope
+	opAssign                       // pop a value into the existing variable names[arg]
+	opAssignLocal                  // pop a value into the existing local variable variables[arg]
+	opAssignGlobal                 // pop a value into the existing global variable variables[arg]
+	opFunction                     // push a closure over functions[arg]
+	opAdd                          // pop two operands, push their sum
+	opSubtract                     // pop two operands, push their difference
+	opMultiply                     // pop two operands, push their product
+	opDivide                       // pop two operands, push their quotient
+	opModulo                       // pop two operands, push their remainder
+	opLess                         // pop two operands, push left < right
+	opGreater                      // pop two operands, push left > right
+	opLessEqual                    // pop two operands, push left <= right
+	opGreaterEqual                 // pop two operands, push left >= right
+	opEqual                        // pop two operands, push left == right
+	opNotEqual                     // pop two operands, push left != right
+	opInfix                        // pop two operands, apply the operator of the instruction's token
+	opNot                          // replace the top of the stack with its negation
+	opNegate                       // replace the top of the stack with its arithmetic negation
+	opRange                        // pop a start, an end and a step if arg has rangeStep, push a range of them
+	opIndex                        // pop an index and a value, push value[index]
+	opProperty                     // replace a hash on top of the stack with its property keys[arg]
+	opSafeProperty                 // like opProperty for ?., which reads null for a missing property even when strict
+	opMethod                       // push the method keys[arg] of the value on top of the stack under it, or else replace it with its property and push nil
+	opSafeMethod                   // like opMethod for ?., which reads null for a missing property even when strict
+	opPropertyTarget               // fail unless the top of the stack is a hash
+	opSetProperty                  // pop a value and a hash, set property keys[arg], push the value
+	opSetIndex                     // pop a value, an index and a target, set target[index], push the value
+	opArray                        // pop arg elements, push an array of them
+	opHash                         // pop arg key/value pairs, push a hash of them
+	opInterpolate                  // pop arg values, push a string joining them
+	opCall                         // pop arg arguments and a function, push the result of the call
+	opPipe                         // like opCall, for a function piped arg arguments, the first being the piped value
+	opMethodCall                   // like opCall, for a function pushed by opMethod, called with the value under it first unless that is nil
+	opJump                         // jump forward to arg
+	opJumpIfFalse                  // pop a condition, jump to arg if it is falsy
+	opAnd                          // jump to arg if the top of the stack is falsy, otherwise pop it
+	opOr                           // jump to arg if the top of the stack is truthy, otherwise pop it
+	opCoalesce                     // jump to arg unless the top of the stack is null, otherwise pop it
+	opSkipNull                     // jump to arg if the top of the stack is null, keeping it
+	opLoop                         // jump back to arg
+	opText                         // write the template text texts[arg]
+	opOutput                       // pop a value and write it as template output
+	opIterate                      // replace an iterable on top of the stack with an iterator over it
+	opNext                         // advance the iterator of loops[arg], or pop it and leave the loop
+	opEndIterate                   // pop an iterator, leaving its loop
+	opTry                          // install a handler that catches errors at arg
+	opEndTry                       // remove the innermost handler
+	opCatch                        // pop a caught error into the new scope of catches[arg]
+	opPushScope                    // enter a new scope with a slot for each of scopes[arg]
+	opPopScope                     // return to the parent scope
+	opThrow                        // pop a value and throw it
+	opExtends                      // pop a template name and extend that layout
+	opBlock                        // evaluate the named block blocks[arg]
+	opReturn                       // pop a value and return it from a return statement
+	opHalt                         // pop a value and finish with it
+	opStep                         // charge arg steps against MaxSteps
+)
+
+// The flags of an opRange instruction.
+const (
+	rangeExclusive = 1 << iota
+	rangeStep
+)
+
+// instruction is a single VM instruction.
+type instruction struct {
+	op  opcode
+	arg int32
+}
+
+// code is a compiled program, function body or named block. tokens holds
+// the source token of each instruction, which positions its errors, and
+// statements the instructions that start statements, for debuggers. A
+// function body runs in a scope with a slot for each of locals, and its
+// parameters go in the slots params. scopes holds the locals of the for
+// loops in it, which each run in a scope of their own.
+type code struct {
+	instructions []instruction
+	tokens       []lexer.Token
+	statements   []statementStart
+	constants    []Object
+	names        []string
+	variables    []variable
+	texts        []string
+	keys         []propertyKey
+	functions    []*functionCode
+	loops        []foreachLoop
+	catches      []catchBlock
+	scopes       [][]string
+	blocks       []*namedBlock
+	locals       []string
+	params       []int
+	maxStack     int
+}
+
+// statementStart marks the instruction at which a statement starts.
+type statementStart struct {
+	pc        int
+	statement parser.Statement
+}
+
+// statementAt returns the statement starting at the instruction pc, if
+// any.
+func (c *code) statementAt(pc int) (parser.Statement, bool) {
+	i, found := slices.BinarySearchFunc(c.statements, pc, func(s statementStart, pc int) int {
+		return s.pc - pc
+	})
+	if !found {
+		return nil, false
+	}
+	return c.statements[i].statement, true
+}
+
+// variable is a variable bound by the resolver. A local variable is in
+// slot of the scope depth levels up; a global one is looked up by name from
+// there.
+type variable struct {
+	name  string
+	depth int
+	slot  int
+}
+
+// propertyKey is the name of a property along with its precomputed hash
+// key.
+type propertyKey struct {
+	name *StringValue
+	hash HashKey
+}
+
+// functionCode is a compiled function literal. name is the name stack
+// traces use, which for an anonymous function assigned with let is the
+// variable's name. A named function is declared in slot of the scope
+// defining it, or by name if slot is -1.
+type functionCode struct {
+	name    string
+	literal *parser.FunctionLiteral
+	body    *code
+	slot    int
+}
+
+// foreachLoop describes the scope of an iteration of a foreach loop, the
+// slots of its variables and the address of the instruction following it.
+// index is -1 if the loop has no index variable.
+type foreachLoop struct {
+	locals   []string
+	variable int
+	index    int
+	exit     int
+}
+
+// catchBlock describes the scope of a catch block and the slot of its
+// parameter.
+type catchBlock struct {
+	locals    []string
+	parameter int
+}
+
+// namedBlock is a compiled named block statement.
+type namedBlock struct {
+	name string
+	body *code
+}
+
+type targetKind int
+
+const (
+	// targetStatement ends the current top-level statement.
+	targetStatement targetKind = iota
+	// targetHalt finishes the current function or named block with null.
+	targetHalt
+	// targetWhile is a while or for loop.
+	targetWhile
+	// targetForeach is a foreach loop, whose iterator is on the stack.
+	targetForeach
+)
+
+// jumpTarget is where break and continue statements go: the innermost loop,
+// or outside any loop the end of the statement, function or block.
+type jumpTarget struct {
+	kind       targetKind
+	depth      int
+	nesting    int
+	continueAt int
+	breaks     []int
+}
+
+type nestingKind int
+
+const (
+	nestingTry nestingKind = iota
+	nestingCatch
+)
+
+// compiler compiles one program, function body or named block to code.
+// depth tracks the height of the operand stack, so that break and continue
+// can discard whatever the enclosing expressions left on it.
+type compiler struct {
+	code     *code
+	res      *resolver.Resolution
+	template bool
+	depth    int
+	targets  []*jumpTarget
+	nesting  []nestingKind
+	// skips are the jumps of the null-safe accesses in the chain being
+	// compiled, to its end.
+	skips []int
+	// stepAt is the opStep that steps are charged to, or -1 once a jump
+	// or jump target ends the code it runs before.
+	stepAt int
+
+	integers table[int]
+	decimals table[float64]
+	strings  table[string]
+	names    table[string]
+	texts    table[string]
+	keys     table[string]
+}
+
+// table maps the values a compiler has added to the code, such as its
+// constants, to their indexes there. A small table is searched directly,
+// and only indexed by a map once it grows, since most code adds few values
+// and compiling it should cost little next to running it.
+type table[K comparable] struct {
+	entries []tableEntry[K]
+	index   map[K]int
+}
+
+type tableEntry[K comparable] struct {
+	value K
+	index int
+}
+
+// smallTable is the number of values up to which a table is searched.
+const smallTable = 8
+
+func (t *table[K]) get(v K) (int, bool) {
+	if t.index != nil {
+		i, ok := t.index[v]
+		return i, ok
+	}
+	for _, e := range t.entries {
+		if e.value == v {
+			return e.index, true
+		}
+	}
+	return 0, false
+}
+
+// set maps v to index i, and returns i.
+func (t *table[K]) set(v K, i int) int {
+	if t.index != nil {
+		t.index[v] = i
+		return i
+	}
+	t.entries = append(t.entries, tableEntry[K]{v, i})
+	if len(t.entries) > smallTable {
+		t.index = make(map[K]int, len(t.entries))
+		for _, e := range t.entries {
+			t.index[e.value] = e.index
+		}
+		t.entries = nil
+	}
+	return i
+}
+
+func newCompiler(res *resolver.Resolution, template bool) *compiler {
+	return &compiler{
+		code: &code{
+			instructions: make([]instruction, 0, 16),
+			tokens:       make([]lexer.Token, 0, 16),
+		},
+		res:      res,
+		template: template,
+		stepAt:   -1,
+	}
+}
+
+// compileProgram compiles program. In template mode, the values of
+// expression statements are written to the output.
+func compileProgram(program *parser.Program, template bool) (*code, error) {
+	c := newCompiler(resolver.Bind(program), template)
+
+	c.code.statements = make([]statementStart, 0, len(program.Statements))
+
+	// Each top-level statement in turn is the target of the break and
+	// continue statements outside any loop in it.
+	target := &jumpTarget{kind: targetStatement}
+	c.targets = []*jumpTarget{target}
+
+	last := len(program.Statements) - 1
+	for i, statement := range program.Statements {
+		keep := !template && i == last
+		target.breaks = target.breaks[:0]
+
+		if err := c.statement(statement, keep); err != nil {
+			return nil, err
+		}
+
+		if len(target.breaks) > 0 && keep {
+			skip := c.emitJump(opJump, lexer.Token{})
+			c.patchJumps(target.breaks)
+			c.depth--
+			c.emit(opNull, 0, lexer.Token{})
+			c.patchJump(skip)
+			continue
+		}
+		c.patchJumps(target.breaks)
+	}
+
+	if last < 0 || template {
+		c.emit(opNull, 0, lexer.Token{})
+	}
+	c.emit(opHalt, 0, lexer.Token{})
+
+	return c.code, nil
+}
+
+// compileFunction compiles the body of fl, which finishes with the value
+// of its last statement unless it returns first.
+func compileFunction(fl *parser.FunctionLiteral, res *resolver.Resolution) (*code, error) {
+	c := newCompiler(res, false)
+	c.targets = []*jumpTarget{{kind: targetHalt}}
+	c.code.locals = res.Function(fl).Names
+
+	for _, param := range fl.Parameters {
+		b, _ := res.Binding(param)
+		c.code.params = append(c.code.params, b.Slot)
+	}
+
+	c.step(fl.Body.Token)
+	if err := c.block(fl.Body, true); err != nil {
+		return nil, err
+	}
+	c.emit(opHalt, 0, fl.Body.Token)
+
+	return c.code, nil
+}
+
+// compileFunctionValue compiles a function that was not created from a
+// compiled program, and so has no resolution.
+func compileFunctionValue(f *FunctionValue) (*code, error) {
+	fl := &parser.FunctionLiteral{Parameters: f.Parameters, Body: f.Body}
+	return compileFunction(fl, resolver.ResolveFunction(fl))
+}
+
+// compileNamedBlock compiles the body of a named block, which may be
+// evaluated by a layout other than the template defining it.
+func compileNamedBlock(body *parser.BlockStatement, template bool, res *resolver.Resolution) (*code, error) {
+	c := newCompiler(res, template)
+	c.targets = []*jumpTarget{{kind: targetHalt}}
+
+	if err := c.block(body, false); err != nil {
+		return nil, err
+	}
+	c.emit(opNull, 0, body.Token)
+	c.emit(opHalt, 0, body.Token)
+
+	return c.code, nil
+}
+
+func (c *compiler) emit(op opcode, arg int, token lexer.Token) int {
+	c.code.instructions = append(c.code.instructions, instruction{op: op, arg: int32(arg)})
+	c.code.tokens = append(c.code.tokens, token)
+
+	switch op {
+	case opJump, opJumpIfFalse, opAnd, opOr, opCoalesce, opSkipNull, opNext, opLoop:
+		c.stepAt = -1
+	}
+
+	c.depth += stackEffect(op, arg)
+	if c.depth > c.code.maxStack {
+		c.code.maxStack = c.depth
+	}
+
+	return len(c.code.instructions) - 1
+}
+
+// stackEffect returns how far op changes the height of the operand stack
+// when execution continues with the next instruction.
+func stackEffect(op opcode, arg int) int {
+	switch op {
+	case opConstant, opNull, opTrue, opFalse, opDup, opGet, opGetLocal, opGetGlobal, opFunction, opMethod, opSafeMethod:
+		return 1
+	case opPop, opDefine, opDefineLocal, opAssign, opAssignLocal, opAssignGlobal, opAdd, opSubtract, opMultiply, opDivide, opModulo,
+		opLess, opGreater, opLessEqual, opGreaterEqual, opEqual, opNotEqual, opInfix,
+		opIndex, opSetProperty, opJumpIfFalse, opAnd, opOr, opCoalesce, opOutput,
+		opEndIterate, opCatch, opThrow, opExtends, opReturn, opHalt:
+		return -1
+	case opSetIndex:
+		return -2
+	case opRange:
+		if arg&rangeStep != 0 {
+			return -2
+		}
+		return -1
+	case opArray:
+		return 1 - arg
+	case opHash:
+		return 1 - 2*arg
+	case opInterpolate:
+		return 1 - arg
+	case opCall, opPipe:
+		return -arg
+	case opMethodCall:
+		return -arg - 1
+	default:
+		return 0
+	}
+}
+
+// step charges a step for the statement or expression at token. Every
+// statement and expression evaluated is a step, as is every block of an if
+// expression and function body. The steps of a statement, up to the first
+// jump or jump target in it, are charged together by one opStep ahead of
+// its instructions, positioned at the first of them.
+func (c *compiler) step(token lexer.Token) {
+	if c.stepAt < 0 {
+		c.stepAt = c.emit(opStep, 0, token)
+	}
+	c.code.instructions[c.stepAt].arg++
+}
+
+// stepNode charges a step for node.
+func (c *compiler) stepNode(node Node) {
+	token, _ := nodeToken(node)
+	c.step(token)
+}
+
+// here returns the address of the next instruction as a jump target. Steps
+// charged after it go to an opStep of their own, so that jumping there
+// charges them but not those before it.
+func (c *compiler) here() int {
+	c.stepAt = -1
+	return len(c.code.instructions)
+}
+
+func (c *compiler) emitJump(op opcode, token lexer.Token) int {
+	return c.emit(op, 0, token)
+}
+
+// patchJump points the jump at pos to the next instruction.
+func (c *compiler) patchJump(pos int) {
+	c.code.instructions[pos].arg = int32(c.here())
+}
+
+func (c *compiler) patchJumps(positions []int) {
+	for _, pos := range positions {
+		c.patchJump(pos)
+	}
+}
+
+func (c *compiler) integer(v int) int {
+	if i, ok := c.integers.get(v); ok {
+		return i
+	}
+	c.code.constants = append(c.code.constants, &IntegerValue{Value: v})
+	return c.integers.set(v, len(c.code.constants)-1)
+}
+
+func (c *compiler) decimal(v float64) int {
+	if i, ok := c.decimals.get(v); ok {
+		return i
+	}
+	c.code.constants = append(c.code.constants, &DecimalValue{Value: v})
+	return c.decimals.set(v, len(c.code.constants)-1)
+}
+
+func (c *compiler) string(v string) int {
+	if i, ok := c.strings.get(v); ok {
+		return i
+	}
+	c.code.constants = append(c.code.constants, &StringValue{Value: v})
+	return c.strings.set(v, len(c.code.constants)-1)
+}
+
+func (c *compiler) name(v string) int {
+	if i, ok := c.names.get(v); ok {
+		return i
+	}
+	c.code.names = append(c.code.names, v)
+	return c.names.set(v, len(c.code.names)-1)
+}
+
+func (c *compiler) text(v string) int {
+	if i, ok := c.texts.get(v); ok {
+		return i
+	}
+	c.code.texts = append(c.code.texts, v)
+	return c.texts.set(v, len(c.code.texts)-1)
+}
+
+func (c *compiler) key(v string) int {
+	if i, ok := c.keys.get(v); ok {
+		return i
+	}
+	name := &StringValue{Value: v}
+	c.code.keys = append(c.code.keys, propertyKey{name: name, hash: name.HashKey()})
+	return c.keys.set(v, len(c.code.keys)-1)
+}
+
+// variable emits the instruction reading or assigning the variable id,
+// depending on how the resolver bound it: by name, local or global.
+func (c *compiler) variable(id *parser.Identifier, byName, local, global opcode, token lexer.Token) {
+	b, ok := c.res.Binding(id)
+	switch {
+	case !ok || b.Slot < 0 && b.Depth == 0:
+		c.emit(byName, c.name(id.Value), token)
+	case b.Slot < 0:
+		c.code.variables = append(c.code.variables, variable{name: id.Value, depth: b.Depth, slot: -1})
+		c.emit(global, len(c.code.variables)-1, token)
+	default:
+		c.code.variables = append(c.code.variables, variable{name: id.Value, depth: b.Depth, slot: b.Slot})
+		c.emit(local, len(c.code.variables)-1, token)
+	}
+}
+
+// block compiles the statements of a block. If keep is set, the value of
+// the last statement is left on the stack.
+func (c *compiler) block(block *parser.BlockStatement, keep bool) error {
+	if len(block.Statements) == 0 {
+		if keep {
+			c.emit(opNull, 0, block.Token)
+		}
+		return nil
+	}
+
+	last := len(block.Statements) - 1
+	for i, statement := range block.Statements {
+		if err := c.statement(statement, keep && i == last); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statement compiles a statement. If keep is set, the value of the
+// statement is left on the stack.
+func (c *compiler) statement(statement parser.Statement, keep bool) error {
+	// A block is not a statement of its own to a debugger. Of statements
+	// starting at the same instruction the last is kept, since any before
+	// it compiled to nothing and never run.
+	if _, ok := statement.(*parser.BlockStatement); !ok {
+		start := statementStart{pc: c.here(), statement: statement}
+		if n := len(c.code.statements); n > 0 && c.code.statements[n-1].pc == start.pc {
+			c.code.statements[n-1] = start
+		} else {
+			c.code.statements = append(c.code.statements, start)
+		}
+	}
+	c.stepNode(statement)
+
+	switch n := statement.(type) {
+	case *parser.PrintStatement:
+		c.emit(opText, c.text(n.Value), n.Token)
+		c.keepNull(keep, n.Token)
+	case *parser.LetStatement:
+		if err := c.value(n.Value, n.Name.Value); err != nil {
+			return err
+		}
+		if keep {
+			c.emit(opDup, 0, n.Token)
+		}
+		if b, ok := c.res.Binding(n.Name); ok && b.Slot >= 0 {
+			c.emit(opDefineLocal, b.Slot, n.Token)
+		} else {
+			c.emit(opDefine, c.name(n.Name.Value), n.Token)
+		}
+	case *parser.ReturnStatement:
+		if err := c.value(n.Value, ""); err != nil {
+			return err
+		}
+		c.emit(opReturn, 0, n.Token)
+		c.unreachable(keep)
+	case *parser.ThrowStatement:
+		if err := c.value(n.Value, ""); err != nil {
+			return err
+		}
+		c.emit(opThrow, 0, n.Token)
+		c.unreachable(keep)
+	case *parser.ExpressionStatement:
+		return c.expressionStatement(n, keep)
+	case *parser.BreakStatement:
+		c.jumpOut(false, n.Token)
+		c.unreachable(keep)
+	case *parser.ContinueStatement:
+		c.jumpOut(true, n.Token)
+		c.unreachable(keep)
+	case *parser.BlockStatement:
+		return c.block(n, keep)
+	case *parser.ExtendsStatement:
+		if err := c.expression(n.Template); err != nil {
+			return err
+		}
+		c.emit(opExtends, 0, n.Token)
+		c.keepNull(keep, n.Token)
+	case *parser.NamedBlockStatement:
+		body, err := compileNamedBlock(n.Body, c.template, c.res)
+		if err != nil {
+			return err
+		}
+		c.code.blocks = append(c.code.blocks, &namedBlock{name: n.Name.Value, body: body})
+		c.emit(opBlock, len(c.code.blocks)-1, n.Token)
+		c.keepNull(keep, n.Token)
+	case *parser.TryStatement:
+		return c.tryStatement(n, keep)
+	case *parser.ForeachExpression:
+		// A foreach loop is parsed as a statement of its own, which has
+		// been charged its step, rather than as an expression statement.
+		return c.foreachExpression(n, keep)
+	default:
+		return c.bareExpression(n, keep)
+	}
+
+	return nil
+}
+
+// keepNull leaves null on the stack as the value of a statement that has
+// none.
+func (c *compiler) keepNull(keep bool, token lexer.Token) {
+	if keep {
+		c.emit(opNull, 0, token)
+	}
+}
+
+// unreachable accounts for the value a statement that never completes
+// would have left on the stack, keeping the tracked height consistent for
+// the code that follows it.
+func (c *compiler) unreachable(keep bool) {
+	if keep {
+		c.depth++
+	}
+}
+
+// value compiles the value of a let, return or throw statement, which is
+// never written as template output. An anonymous function literal is named
+// after name, if given.
+func (c *compiler) value(value parser.Expression, name string) error {
+	template := c.template
+	c.template = false
+	defer func() { c.template = template }()
+
+	if fl, ok := value.(*parser.FunctionLiteral); ok && fl.Identifier == nil && name != "" {
+		c.step(fl.Token)
+		return c.function(fl, name)
+	}
+	return c.expression(value)
+}
+
+func (c *compiler) expressionStatement(es *parser.ExpressionStatement, keep bool) error {
+	if assign, ok := es.Expression.(*parser.AssignmentExpression); ok {
+		c.step(assign.Token)
+		return c.assignment(assign, keep)
+	}
+
+	if !c.template {
+		return c.bareExpression(es.Expression, keep)
+	}
+
+	if err := c.expression(es.Expression); err != nil {
+		return err
+	}
+
+	token, _ := nodeToken(es.Expression)
+	c.emit(opOutput, 0, token)
+	c.keepNull(keep, token)
+
+	return nil
+}
+
+// bareExpression compiles an expression used as a statement, whose value
+// is never written as template output.
+func (c *compiler) bareExpression(expression parser.Expression, keep bool) error {
+	// Loops and conditionals used as statements need not produce a value
+	// at all.
+	switch n := expression.(type) {
+	case *parser.IfExpression:
+		c.step(n.Token)
+		return c.ifExpression(n, keep)
+	case *parser.WhileExpression:
+		c.step(n.Token)
+		return c.whileExpression(n, keep)
+	case *parser.ForExpression:
+		c.step(n.Token)
+		return c.forExpression(n, keep)
+	case *parser.ForeachExpression:
+		c.step(n.Token)
+		return c.foreachExpression(n, keep)
+	}
+
+	if err := c.expression(expression); err != nil {
+		return err
+	}
+
+	if !keep {
+		c.emit(opPop, 0, lexer.Token{})
+	}
+	return nil
+}
+
+// jumpOut compiles a break or continue statement. Outside of a loop, both
+// end the current top-level statement, function or named block.
+func (c *compiler) jumpOut(isContinue bool, token lexer.Token) {
+	depth := c.depth
+	defer func() { c.depth = depth }()
+
+	target := c.targets[len(c.targets)-1]
+	if target.kind == targetHalt {
+		c.emit(opNull, 0, token)
+		c.emit(opHalt, 0, token)
+		return
+	}
+
+	for i := len(c.nesting) - 1; i >= target.nesting; i-- {
+		switch c.nesting[i] {
+		case nestingTry:
+			c.emit(opEndTry, 0, token)
+		case nestingCatch:
+			c.emit(opPopScope, 0, token)
+		}
+	}
+
+	for c.depth > target.depth {
+		c.emit(opPop, 0, token)
+	}
+
+	switch {
+	case isContinue && target.kind != targetStatement:
+		c.emit(opLoop, target.continueAt, token)
+	case target.kind == targetForeach:
+		c.emit(opEndIterate, 0, token)
+		target.breaks = append(target.breaks, c.emitJump(opJump, token))
+	default:
+		target.breaks = append(target.breaks, c.emitJump(opJump, token))
+	}
+}
+
+func (c *compiler) expression(expression parser.Expression) error {
+	switch expression.(type) {
+	case *parser.CallExpression, *parser.IndexExpression, *parser.PropertyExpression:
+		return c.chain(expression)
+	}
+	c.stepNode(expression)
+
+	switch n := expression.(type) {
+	case *parser.IntegerLiteral:
+		c.emit(opConstant, c.integer(n.Value), n.Token)
+	case *parser.FloatLiteral:
+		c.emit(opConstant, c.decimal(n.Value), n.Token)
+	case *parser.StringLiteral:
+		c.emit(opConstant, c.string(n.Value), n.Token)
+	case *parser.InterpolatedString:
+		count := 0
+		for i, s := range n.Strings {
+			if s != "" {
+				c.emit(opConstant, c.string(s), n.Token)
+				count++
+			}
+			if i < len(n.Expressions) {
+				if err := c.expression(n.Expressions[i]); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+		c.emit(opInterpolate, count, n.Token)
+	case *parser.BooleanLiteral:
+		if n.Value {
+			c.emit(opTrue, 0, n.Token)
+		} else {
+			c.emit(opFalse, 0, n.Token)
+		}
+	case *parser.NullLiteral:
+		c.emit(opNull, 0, n.Token)
+	case *parser.Identifier:
+		c.variable(n, opGet, opGetLocal, opGetGlobal, n.Token)
+	case *parser.PrefixExpression:
+		if err := c.expression(n.Right); err != nil {
+			return err
+		}
+		switch n.Operator {
+		case "!":
+			c.emit(opNot, 0, n.Token)
+		case "-":
+			c.emit(opNegate, 0, n.Token)
+		default:
+			return newError(ErrUnknownOperator, "unknown operator: %s", n.Operator)
+		}
+	case *parser.InfixExpression:
+		return c.infixExpression(n)
+	case *parser.IfExpression:
+		return c.ifExpression(n, true)
+	case *parser.WhileExpression:
+		return c.whileExpression(n, true)
+	case *parser.ForExpression:
+		return c.forExpression(n, true)
+	case *parser.RangeExpression:
+		return c.rangeExpression(n)
+	case *parser.ForeachExpression:
+		return c.foreachExpression(n, true)
+	case *parser.FunctionLiteral:
+		return c.function(n, "")
+	case *parser.PipeExpression:
+		return c.pipeExpression(n)
+	case *parser.ArrayLiteral:
+		for _, element := range n.Elements {
+			if err := c.expression(element); err != nil {
+				return err
+			}
+		}
+		c.emit(opArray, len(n.Elements), n.Token)
+	case *parser.HashLiteral:
+		for _, pair := range n.Pairs {
+			if err := c.expression(pair.Key); err != nil {
+				return err
+			}
+			if err := c.expression(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(opHash, len(n.Pairs), n.Token)
+	case *parser.AssignmentExpression:
+		return c.assignment(n, true)
+	default:
+		return fmt.Errorf("unknown node type: %T", n)
+	}
+
+	return nil
+}
+
+var infixOpcodes = map[string]opcode{
+	"+":  opAdd,
+	"-":  opSubtract,
+	"*":  opMultiply,
+	"/":  opDivide,
+	"%":  opModulo,
+	"<":  opLess,
+	">":  opGreater,
+	"<=": opLessEqual,
+	">=": opGreaterEqual,
+	"==": opEqual,
+	"!=": opNotEqual,
+}
+
+func (c *compiler) infixExpression(ie *parser.InfixExpression) error {
+	if err := c.expression(ie.Left); err != nil {
+		return err
+	}
+
+	// &&, || and ?? only evaluate their right operand when the left one
+	// does not decide the result.
+	var shortCircuit opcode
+	switch ie.Token.Source {
+	case "&&":
+		shortCircuit = opAnd
+	case "||":
+		shortCircuit = opOr
+	case "??":
+		shortCircuit = opCoalesce
+	}
+	if shortCircuit != 0 {
+		end := c.emitJump(shortCircuit, ie.Token)
+		if err := c.expression(ie.Right); err != nil {
+			return err
+		}
+		c.patchJump(end)
+		return nil
+	}
+
+	if err := c.expression(ie.Right); err != nil {
+		return err
+	}
+
+	op, ok := infixOpcodes[ie.Token.Source]
+	if !ok {
+		op = opInfix
+	}
+	c.emit(op, 0, ie.Token)
+
+	return nil
+}
+
+// chain compiles a call, index or property access that ends a chain of
+// them. A null-safe access in the chain skips the rest of it when its left
+// side is null, so that a?.b.c is null rather than an error when a is.
+func (c *compiler) chain(expression parser.Expression) error {
+	skips := c.skips
+	c.skips = nil
+	err := c.link(expression)
+	c.patchJumps(c.skips)
+	c.skips = skips
+	return err
+}
+
+// link compiles a link of the chain being compiled, after the links before
+// it.
+func (c *compiler) link(expression parser.Expression) error {
+	switch n := expression.(type) {
+	case *parser.CallExpression:
+		c.step(n.Token)
+		if pe, ok := n.Function.(*parser.PropertyExpression); ok {
+			return c.methodCall(pe, n)
+		}
+		if err := c.link(n.Function); err != nil {
+			return err
+		}
+		for _, arg := range n.Args {
+			if err := c.expression(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(opCall, len(n.Args), n.Token)
+	case *parser.IndexExpression:
+		c.step(n.Token)
+		if err := c.link(n.Left); err != nil {
+			return err
+		}
+		if n.Optional {
+			c.skips = append(c.skips, c.emitJump(opSkipNull, n.Token))
+		}
+		if err := c.expression(n.Index); err != nil {
+			return err
+		}
+		c.emit(opIndex, 0, n.Token)
+	case *parser.PropertyExpression:
+		property, ok := n.Property.(*parser.Identifier)
+		if !ok {
+			return fmt.Errorf("unsupported property: %T", n.Property)
+		}
+		c.step(n.Token)
+		if err := c.link(n.Left); err != nil {
+			return err
+		}
+		op := opProperty
+		if n.Optional {
+			c.skips = append(c.skips, c.emitJump(opSkipNull, n.Token))
+			op = opSafeProperty
+		}
+		c.emit(op, c.key(property.Value), n.Token)
+	default:
+		return c.expression(expression)
+	}
+
+	return nil
+}
+
+// methodCall compiles a call of the property pe, which calls a method of
+// the value pe is read from, with the value as its first argument, unless
+// the value is a hash with that property. Only a call looks for methods, so
+// that reading h.keys without calling it reads its property.
+func (c *compiler) methodCall(pe *parser.PropertyExpression, call *parser.CallExpression) error {
+	property, ok := pe.Property.(*parser.Identifier)
+	if !ok {
+		return fmt.Errorf("unsupported property: %T", pe.Property)
+	}
+	c.step(pe.Token)
+	if err := c.link(pe.Left); err != nil {
+		return err
+	}
+	op := opMethod
+	if pe.Optional {
+		c.skips = append(c.skips, c.emitJump(opSkipNull, pe.Token))
+		op = opSafeMethod
+	}
+	c.emit(op, c.key(property.Value), pe.Token)
+	for _, arg := range call.Args {
+		if err := c.expression(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(opMethodCall, len(call.Args), call.Token)
+
+	return nil
+}
+
+// pipeExpression compiles x | f(a) as the call f(x, a).
+func (c *compiler) pipeExpression(pe *parser.PipeExpression) error {
+	function, args := pe.Filter, []parser.Expression(nil)
+	if call, ok := pe.Filter.(*parser.CallExpression); ok {
+		function, args = call.Function, call.Args
+	}
+
+	if err := c.expression(function); err != nil {
+		return err
+	}
+	if err := c.expression(pe.Left); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := c.expression(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(opPipe, len(args)+1, pe.Token)
+
+	return nil
+}
+
+// ifExpression compiles an if expression. If keep is set, the value of the
+// branch taken, or null, is left on the stack.
+func (c *compiler) ifExpression(ie *parser.IfExpression, keep bool) error {
+	// An optimized program leaves only the taken branch of an if expression
+	// with a constant condition, under a condition of true.
+	if b, ok := ie.Condition.(*parser.BooleanLiteral); ok && b.Value && ie.Alternative == nil {
+		c.step(b.Token)
+		c.step(ie.Consequence.Token)
+		return c.block(ie.Consequence, keep)
+	}
+
+	if err := c.expression(ie.Condition); err != nil {
+		return err
+	}
+
+	alternative := c.emitJump(opJumpIfFalse, ie.Token)
+	c.step(ie.Consequence.Token)
+	if err := c.block(ie.Consequence, keep); err != nil {
+		return err
+	}
+
+	if ie.Alternative == nil && !keep {
+		c.patchJump(alternative)
+		return nil
+	}
+
+	end := c.emitJump(opJump, ie.Token)
+	c.patchJump(alternative)
+	if keep {
+		c.depth--
+	}
+
+	if ie.Alternative != nil {
+		c.step(ie.Alternative.Token)
+		if err := c.block(ie.Alternative, keep); err != nil {
+			return err
+		}
+	} else {
+		c.emit(opNull, 0, ie.Token)
+	}
+	c.patchJump(end)
+
+	return nil
+}
+
+func (c *compiler) whileExpression(we *parser.WhileExpression, keep bool) error {
+	start := c.here()
+	// A statement that starts with the loop starts again with each pass
+	// round it, after the steps of the statement itself.
+	if n := len(c.code.statements); n > 0 && c.code.statements[n-1].pc == start-1 && c.code.instructions[start-1].op == opStep {
+		c.code.statements[n-1].pc = start
+	}
+	target := &jumpTarget{kind: targetWhile, depth: c.depth, nesting: len(c.nesting), continueAt: start}
+
+	if err := c.expression(we.Condition); err != nil {
+		return err
+	}
+	exit := c.emitJump(opJumpIfFalse, we.Token)
+
+	c.targets = append(c.targets, target)
+	if err := c.block(we.Body, false); err != nil {
+		return err
+	}
+	c.targets = c.targets[:len(c.targets)-1]
+
+	c.emit(opLoop, start, we.Token)
+	c.patchJump(exit)
+	c.patchJumps(target.breaks)
+	c.keepNull(keep, we.Token)
+
+	return nil
+}
+
+// forExpression compiles a for loop to run in a scope of its own, with its
+// update first so that continue can jump to it:
+//
+//	init; jump cond; update; cond; jump-if-false exit; body; loop update
+func (c *compiler) forExpression(fe *parser.ForExpression, keep bool) error {
+	c.code.scopes = append(c.code.scopes, c.res.For(fe).Names)
+	c.emit(opPushScope, len(c.code.scopes)-1, fe.Token)
+
+	switch init := fe.Init.(type) {
+	case *parser.LetStatement:
+		if err := c.statement(init, false); err != nil {
+			return err
+		}
+	case *parser.ExpressionStatement:
+		if err := c.forClause(init.Expression); err != nil {
+			return err
+		}
+	}
+
+	start := c.here()
+	if fe.Update != nil {
+		skip := c.emitJump(opJump, fe.Token)
+		start = c.here()
+		if err := c.forClause(fe.Update); err != nil {
+			return err
+		}
+		c.patchJump(skip)
+	}
+	target := &jumpTarget{kind: targetWhile, depth: c.depth, nesting: len(c.nesting), continueAt: start}
+
+	exit := -1
+	if fe.Condition != nil {
+		if err := c.expression(fe.Condition); err != nil {
+			return err
+		}
+		exit = c.emitJump(opJumpIfFalse, fe.Token)
+	}
+
+	c.targets = append(c.targets, target)
+	if err := c.block(fe.Body, false); err != nil {
+		return err
+	}
+	c.targets = c.targets[:len(c.targets)-1]
+
+	c.emit(opLoop, start, fe.Token)
+	if exit >= 0 {
+		c.patchJump(exit)
+	}
+	c.patchJumps(target.breaks)
+	c.emit(opPopScope, 0, fe.Token)
+	c.keepNull(keep, fe.Token)
+
+	return nil
+}
+
+// forClause compiles the initialization or update of a for loop, whose
+// value is discarded even in templates.
+func (c *compiler) forClause(expression parser.Expression) error {
+	if assign, ok := expression.(*parser.AssignmentExpression); ok {
+		c.step(assign.Token)
+		return c.assignment(assign, false)
+	}
+	return c.bareExpression(expression, false)
+}
+
+func (c *compiler) rangeExpression(re *parser.RangeExpression) error {
+	if err := c.expression(re.Start); err != nil {
+		return err
+	}
+	if err := c.expression(re.End); err != nil {
+		return err
+	}
+
+	flags := 0
+	if re.Exclusive {
+		flags |= rangeExclusive
+	}
+	if re.Step != nil {
+		if err := c.expression(re.Step); err != nil {
+			return err
+		}
+		flags |= rangeStep
+	}
+	c.emit(opRange, flags, re.Token)
+
+	return nil
+}
+
+func (c *compiler) foreachExpression(fe *parser.ForeachExpression, keep bool) error {
+	if err := c.expression(fe.Iterable); err != nil {
+		return err
+	}
+	c.emit(opIterate, 0, fe.Token)
+
+	loop := foreachLoop{locals: c.res.Loop(fe).Names, index: -1}
+	b, _ := c.res.Binding(fe.Variable)
+	loop.variable = b.Slot
+	if fe.Index != nil {
+		b, _ := c.res.Binding(fe.Index)
+		loop.index = b.Slot
+	}
+	c.code.loops = append(c.code.loops, loop)
+	index := len(c.code.loops) - 1
+
+	next := c.emit(opNext, index, fe.Token)
+	target := &jumpTarget{kind: targetForeach, depth: c.depth, nesting: len(c.nesting), continueAt: next}
+
+	c.targets = append(c.targets, target)
+	if err := c.block(fe.Body, false); err != nil {
+		return err
+	}
+	c.targets = c.targets[:len(c.targets)-1]
+
+	c.emit(opLoop, next, fe.Token)
+	c.code.loops[index].exit = c.here()
+	c.patchJumps(target.breaks)
+	c.depth--
+	c.keepNull(keep, fe.Token)
+
+	return nil
+}
+
+func (c *compiler) tryStatement(ts *parser.TryStatement, keep bool) error {
+	handler := c.emitJump(opTry, ts.Token)
+
+	c.nesting = append(c.nesting, nestingTry)
+	if err := c.block(ts.Body, keep); err != nil {
+		return err
+	}
+	c.nesting = c.nesting[:len(c.nesting)-1]
+
+	c.emit(opEndTry, 0, ts.Token)
+	end := c.emitJump(opJump, ts.Token)
+
+	// The handler resumes here with the caught error pushed in place of
+	// whatever the body left on the stack.
+	c.patchJump(handler)
+	if !keep {
+		c.depth++
+	}
+	b, _ := c.res.Binding(ts.Parameter)
+	c.code.catches = append(c.code.catches, catchBlock{locals: c.res.Catch(ts).Names, parameter: b.Slot})
+	c.emit(opCatch, len(c.code.catches)-1, ts.Parameter.Token)
+
+	c.nesting = append(c.nesting, nestingCatch)
+	if err := c.block(ts.Catch, keep); err != nil {
+		return err
+	}
+	c.nesting = c.nesting[:len(c.nesting)-1]
+
+	c.emit(opPopScope, 0, ts.Token)
+	c.patchJump(end)
+
+	return nil
+}
+
+// function compiles a function literal, naming an anonymous one name.
+func (c *compiler) function(fl *parser.FunctionLiteral, name string) error {
+	body, err := compileFunction(fl, c.res)
+	if err != nil {
+		return err
+	}
+
+	slot := -1
+	if fl.Identifier != nil {
+		name = fl.Identifier.Value
+		if b, ok := c.res.Binding(fl.Identifier); ok {
+			slot = b.Slot
+		}
+	}
+
+	c.code.functions = append(c.code.functions, &functionCode{name: name, literal: fl, body: body, slot: slot})
+	c.emit(opFunction, len(c.code.functions)-1, fl.Token)
+
+	return nil
+}
+
+// assignment compiles an assignment. If keep is set, the assigned value is
+// left on the stack.
+func (c *compiler) assignment(assign *parser.AssignmentExpression, keep bool) error {
+	template := c.template
+	c.template = false
+	defer func() { c.template = template }()
+
+	switch left := assign.Left.(type) {
+	case *parser.Identifier:
+		if err := c.expression(assign.Right); err != nil {
+			return err
+		}
+		if keep {
+			c.emit(opDup, 0, assign.Token)
+		}
+		c.variable(left, opAssign, opAssignLocal, opAssignGlobal, assign.Token)
+		return nil
+	case *parser.PropertyExpression:
+		property, ok := left.Property.(*parser.Identifier)
+		if !ok {
+			return fmt.Errorf("unsupported property: %T", left.Property)
+		}
+		if err := c.expression(left.Left); err != nil {
+			return err
+		}
+		c.emit(opPropertyTarget, 0, assign.Token)
+		if err := c.expression(assign.Right); err != nil {
+			return err
+		}
+		c.emit(opSetProperty, c.key(property.Value), assign.Token)
+	case *parser.IndexExpression:
+		if err := c.expression(left.Left); err != nil {
+			return err
+		}
+		if err := c.expression(left.Index); err != nil {
+			return err
+		}
+		if err := c.expression(assign.Right); err != nil {
+			return err
+		}
+		c.emit(opSetIndex, 0, assign.Token)
+	default:
+		return fmt.Errorf("unknown expression type in assignment: %T", assign.Left)
+	}
+
+	if !keep {
+		c.emit(opPop, 0, assign.Token)
+	}
+	return nil
+}
+
+// programCache holds the code compiled for each program that has been run
+// from an ExecutionContext, other than a Template's, which keeps its own.
+// It refers to programs weakly, dropping their code once they are
+// garbage collected. Code is looked up by the program alone, so a program
+// that is modified after it first runs keeps running its old code.
+type programCache struct {
+	mu       sync.Mutex
+	programs map[weak.Pointer[parser.Program]]*compiledProgram
+}
+
+type compiledProgram struct {
+	script   *code
+	template *code
+}
+
+// programs is shared by every Evaluator, since compiled code depends only on
+// the program and not on registered functions or execution limits.
+var programs programCache
+
+// compile returns the code for program, compiling it on first use.
+func compile(program *parser.Program, template bool) (*code, error) {
+	key := weak.Make(program)
+	cache := &programs
+
+	cache.mu.Lock()
+	entry, ok := cache.programs[key]
+	if !ok {
+		if cache.programs == nil {
+			cache.programs = make(map[weak.Pointer[parser.Program]]*compiledProgram)
+		}
+		entry = &compiledProgram{}
+		cache.programs[key] = entry
+		runtime.AddCleanup(program, cache.remove, key)
+	}
+	compiled := entry.script
+	if template {
+		compiled = entry.template
+	}
+	cache.mu.Unlock()
+
+	if compiled != nil {
+		return compiled, nil
+	}
+
+	compiled, err := compileProgram(program, template)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	if template {
+		entry.template = compiled
+	} else {
+		entry.script = compiled
+	}
+	cache.mu.Unlock()
+
+	return compiled, nil
+}
+
+func (c *programCache) remove(key weak.Pointer[parser.Program]) {
+	c.mu.Lock()
+	delete(c.programs, key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,196 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+func newScriptContext(t *testing.T, input string) *ExecutionContext {
+	t.Helper()
+	l := lexer.NewScript(input)
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewExecutionContext(program)
+}
+
+func TestContextDefaultsToBackground(t *testing.T) {
+	ctx := newScriptContext(t, `return 1;`)
+	if ctx.Context != context.Background() {
+		t.Fatal("expected Context to default to context.Background()")
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	ctx := newScriptContext(t, `let x = 1; return x;`)
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.Context = cancelCtx
+
+	_, err := New().Evaluate(ctx)
+
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("expected *CancelledError, got: %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error to wrap context.Canceled, got: %v", err)
+	}
+	if cancelled.Error() != "execution cancelled: context canceled" {
+		t.Fatalf("unexpected message: %q", cancelled.Error())
+	}
+}
+
+func TestContextCancelCause(t *testing.T) {
+	ctx := newScriptContext(t, `return 1;`)
+	cause := errors.New("tenant suspended")
+	cancelCtx, cancel := context.WithCancelCause(context.Background())
+	cancel(cause)
+	ctx.Context = cancelCtx
+
+	_, err := New().Evaluate(ctx)
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected error to wrap the cancellation cause, got: %v", err)
+	}
+}
+
+func TestContextDeadlineStopsInfiniteLoop(t *testing.T) {
+	ctx := newScriptContext(t, `while (true) { }`)
+	ctx.MaxSteps = 0
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ctx.Context = deadlineCtx
+
+	_, err := New().Evaluate(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := newScriptContext(t, `while (true) { }`)
+	ctx.MaxSteps = 0
+	ctx.Timeout = 20 * time.Millisecond
+
+	start := time.Now()
+	_, err := New().Evaluate(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("evaluation took too long to stop: %s", elapsed)
+	}
+	if ctx.Context != context.Background() {
+		t.Fatal("expected Context to be restored after evaluation")
+	}
+}
+
+func TestTimeoutTemplate(t *testing.T) {
+	ctx := newTemplateContext(t, `before{% while (true) { } %}after`)
+	ctx.MaxSteps = 0
+	ctx.Timeout = 20 * time.Millisecond
+
+	var sb strings.Builder
+	err := New().EvaluateTo(ctx, &sb)
+
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("expected *CancelledError, got: %v", err)
+	}
+	if sb.String() != "before" {
+		t.Fatalf("unexpected output: %q", sb.String())
+	}
+}
+
+func TestTimeoutNotReached(t *testing.T) {
+	ctx := newScriptContext(t, `return 1 + 2;`)
+	ctx.Timeout = time.Minute
+
+	result, err := New().Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapReturn(t, result).(*IntegerValue).Value != 3 {
+		t.Fatalf("expected 3, got %s", result.Debug())
+	}
+}
+
+func TestTimeoutSlowFunction(t *testing.T) {
+	ctx := newScriptContext(t, `slow(); return 1;`)
+	ctx.Timeout = 20 * time.Millisecond
+
+	e := New()
+	e.RegisterFunction("slow", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		select {
+		case <-ctx.Context.Done():
+			return nil, ctx.Context.Err()
+		case <-time.After(5 * time.Second):
+			return Null, nil
+		}
+	})
+
+	_, err := e.Evaluate(ctx)
+
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("expected *CancelledError, got: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestFunctionReadsContext(t *testing.T) {
+	type tenantKey struct{}
+
+	ctx := newScriptContext(t, `return tenant();`)
+	ctx.Context = context.WithValue(context.Background(), tenantKey{}, "acme")
+
+	e := New()
+	e.RegisterFunction("tenant", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return NewStringValue(ctx.Context.Value(tenantKey{}).(string)), nil
+	})
+
+	result, err := e.Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapReturn(t, result).(*StringValue).Value != "acme" {
+		t.Fatalf("expected 'acme', got %s", result.Debug())
+	}
+}
+
+func TestNilContext(t *testing.T) {
+	ctx := newScriptContext(t, `return 1;`)
+	ctx.Context = nil
+
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateRenderCancelled(t *testing.T) {
+	tmpl, err := Compile(`Hello, {% name %}!`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tmpl.NewExecutionContext(Vars{"name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.Context = cancelCtx
+
+	if _, err := tmpl.RenderContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+}
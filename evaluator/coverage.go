@@ -0,0 +1,326 @@
+package evaluator
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Coverage records which statements and branches of templates and scripts
+// run, when set on the ExecutionContext evaluating them. One coverage can be
+// shared by any number of evaluations, including concurrent ones, and adds
+// up all of them.
+//
+// A template or script is only known to a coverage once it has run, or
+// been added with AddTemplate, so that a report can show the lines of a
+// template that never ran at all.
+type Coverage struct {
+	// Path returns the path of the source file of a template, given its
+	// name or "" for a program that is not a named template, for the
+	// reports of WriteLCOV. If Path is nil, the names are used.
+	Path func(template string) string
+
+	mu    sync.Mutex
+	codes map[*code]*codeHits
+}
+
+// NewCoverage creates an empty coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{codes: make(map[*code]*codeHits)}
+}
+
+// codeHits counts the runs of each instruction of a compiled program,
+// function or block, and the times each one jumped. program is the code of
+// the program it is part of, and template the name of the program.
+type codeHits struct {
+	template string
+	program  *code
+	runs     []int
+	jumps    []int
+}
+
+func newCodeHits(c, program *code, template string) *codeHits {
+	return &codeHits{
+		template: template,
+		program:  program,
+		runs:     make([]int, len(c.instructions)),
+		jumps:    make([]int, len(c.instructions)),
+	}
+}
+
+// eachCode calls fn for c and the code of every function and block in it.
+func eachCode(c *code, fn func(*code)) {
+	fn(c)
+	for _, f := range c.functions {
+		eachCode(f.body, fn)
+	}
+	for _, b := range c.blocks {
+		eachCode(b.body, fn)
+	}
+}
+
+// cover prepares to record the coverage of the program compiled to c,
+// which is the template being evaluated.
+func (ctx *ExecutionContext) cover(program *code) {
+	if ctx.coverage == nil {
+		return
+	}
+	eachCode(program, func(c *code) {
+		if _, ok := ctx.coverage[c]; !ok {
+			ctx.coverage[c] = newCodeHits(c, program, ctx.template)
+		}
+	})
+}
+
+// AddTemplate adds t to the coverage, so that it is reported even if it
+// never runs.
+func (cov *Coverage) AddTemplate(t *Template) error {
+	program := t.code
+
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	eachCode(program, func(c *code) {
+		if _, ok := cov.codes[c]; !ok {
+			cov.codes[c] = newCodeHits(c, program, t.Name)
+		}
+	})
+	return nil
+}
+
+// add adds the hits of an evaluation that has finished.
+func (cov *Coverage) add(hits map[*code]*codeHits) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+
+	for c, h := range hits {
+		total, ok := cov.codes[c]
+		if !ok {
+			cov.codes[c] = h
+			continue
+		}
+		for pc := range h.runs {
+			total.runs[pc] += h.runs[pc]
+			total.jumps[pc] += h.jumps[pc]
+		}
+	}
+}
+
+// FileCoverage is the coverage of a template, or of the programs that are
+// not named templates.
+type FileCoverage struct {
+	Template  string
+	Lines     []LineCoverage
+	Branches  []BranchCoverage
+	Functions []FunctionCoverage
+}
+
+// LineCoverage counts the runs of the statements starting on a line, which
+// are those of the statement run the most.
+type LineCoverage struct {
+	Line int
+	Hits int
+}
+
+// BranchCoverage counts the ways a branch went: an if, while, for or
+// foreach, a &&, || or ?? operator, or a null-safe ?. or ?[ ] access. Taken
+// counts the times the branch of an if, the body of a loop, the right
+// operand of an operator or the rest of a chain ran, and NotTaken the times
+// it was skipped: the else branches of an if, the ends of a loop and the
+// short circuits of an operator or null-safe access.
+type BranchCoverage struct {
+	Line     int
+	Column   int
+	Taken    int
+	NotTaken int
+}
+
+// FunctionCoverage counts the calls of a function.
+type FunctionCoverage struct {
+	Name   string
+	Line   int
+	Column int
+	Hits   int
+}
+
+// Files returns the coverage of each template, sorted by name.
+func (cov *Coverage) Files() []FileCoverage {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+
+	type file struct {
+		// lines holds the hits of each line by the program they are in,
+		// since a template compiled more than once is more than one.
+		lines     map[*code]map[int]int
+		branches  map[[2]int]*BranchCoverage
+		functions map[[2]int]*FunctionCoverage
+	}
+	files := make(map[string]*file)
+
+	for c, h := range cov.codes {
+		f, ok := files[h.template]
+		if !ok {
+			f = &file{
+				lines:     make(map[*code]map[int]int),
+				branches:  make(map[[2]int]*BranchCoverage),
+				functions: make(map[[2]int]*FunctionCoverage),
+			}
+			files[h.template] = f
+		}
+
+		lines, ok := f.lines[h.program]
+		if !ok {
+			lines = make(map[int]int)
+			f.lines[h.program] = lines
+		}
+		for _, s := range c.statements {
+			if line := startToken(s.statement).Line; line > 0 {
+				lines[line] = max(lines[line], h.runs[s.pc])
+			}
+		}
+
+		for pc, in := range c.instructions {
+			switch in.op {
+			case opJumpIfFalse, opAnd, opOr, opCoalesce, opSkipNull, opNext:
+			default:
+				continue
+			}
+			token := c.tokens[pc]
+			key := [2]int{token.Line, token.Column}
+			b, ok := f.branches[key]
+			if !ok {
+				b = &BranchCoverage{Line: token.Line, Column: token.Column}
+				f.branches[key] = b
+			}
+			b.Taken += h.runs[pc] - h.jumps[pc]
+			b.NotTaken += h.jumps[pc]
+		}
+
+		for _, fn := range c.functions {
+			token := fn.literal.Token
+			key := [2]int{token.Line, token.Column}
+			fc, ok := f.functions[key]
+			if !ok {
+				name := fn.name
+				if name == "" {
+					name = anonymousFunction
+				}
+				fc = &FunctionCoverage{Name: name, Line: token.Line, Column: token.Column}
+				f.functions[key] = fc
+			}
+			if hits, ok := cov.codes[fn.body]; ok {
+				fc.Hits += hits.runs[0]
+			}
+		}
+	}
+
+	result := make([]FileCoverage, 0, len(files))
+	for name, f := range files {
+		fc := FileCoverage{Template: name}
+		lines := make(map[int]int)
+		for _, program := range f.lines {
+			for line, hits := range program {
+				lines[line] += hits
+			}
+		}
+		for line, hits := range lines {
+			fc.Lines = append(fc.Lines, LineCoverage{Line: line, Hits: hits})
+		}
+		slices.SortFunc(fc.Lines, func(a, b LineCoverage) int {
+			return cmp.Compare(a.Line, b.Line)
+		})
+		for _, b := range f.branches {
+			fc.Branches = append(fc.Branches, *b)
+		}
+		slices.SortFunc(fc.Branches, func(a, b BranchCoverage) int {
+			return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+		})
+		for _, fn := range f.functions {
+			fc.Functions = append(fc.Functions, *fn)
+		}
+		slices.SortFunc(fc.Functions, func(a, b FunctionCoverage) int {
+			return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+		})
+		result = append(result, fc)
+	}
+	slices.SortFunc(result, func(a, b FileCoverage) int {
+		return cmp.Compare(a.Template, b.Template)
+	})
+	return result
+}
+
+// WriteLCOV writes the coverage to w in the LCOV tracefile format, for
+// genhtml and the coverage tools of editors and CI services. Each branch
+// is a block of its own, with its branch and the one skipping it as
+// branches 0 and 1.
+func (cov *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range cov.Files() {
+		path := f.Template
+		if cov.Path != nil {
+			path = cov.Path(f.Template)
+		}
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", path)
+
+		names := lcovFunctionNames(f.Functions)
+		hit := 0
+		for i, fn := range f.Functions {
+			fmt.Fprintf(bw, "FN:%d,%s\n", fn.Line, names[i])
+		}
+		for i, fn := range f.Functions {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.Hits, names[i])
+			if fn.Hits > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(f.Functions), hit)
+
+		hit = 0
+		for i, b := range f.Branches {
+			for branch, taken := range []int{b.Taken, b.NotTaken} {
+				count := "-"
+				if b.Taken+b.NotTaken > 0 {
+					count = fmt.Sprint(taken)
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", b.Line, i, branch, count)
+				if taken > 0 {
+					hit++
+				}
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", 2*len(f.Branches), hit)
+
+		hit = 0
+		for _, l := range f.Lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", l.Line, l.Hits)
+			if l.Hits > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(f.Lines), hit)
+	}
+
+	return bw.Flush()
+}
+
+// lcovFunctionNames names functions uniquely within their file, adding
+// their positions to the names of anonymous functions and of those sharing
+// a name.
+func lcovFunctionNames(functions []FunctionCoverage) []string {
+	count := make(map[string]int)
+	for _, fn := range functions {
+		count[fn.Name]++
+	}
+
+	names := make([]string, len(functions))
+	for i, fn := range functions {
+		names[i] = fn.Name
+		if fn.Name == anonymousFunction || count[fn.Name] > 1 {
+			names[i] = fmt.Sprintf("%s:%d:%d", fn.Name, fn.Line, fn.Column)
+		}
+	}
+	return names
+}
@@ -0,0 +1,141 @@
+package evaluator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCoverageScript(t *testing.T) {
+	input := "fn label(n) {\n  if (n > 1 && n < 3) {\n    return \"two\";\n  } else {\n    return \"other\";\n  }\n}\nfn unused() {\n  return 1;\n}\nlet total = 0;\nforeach ([1, 2] as x) {\n  total += x;\n  label(x);\n}\nlet name = total ?? \"none\";"
+	ctx := newScriptContext(t, input)
+	ctx.Coverage = NewCoverage()
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []FileCoverage{{
+		Lines: []LineCoverage{
+			{Line: 1, Hits: 1}, {Line: 2, Hits: 2}, {Line: 3, Hits: 1}, {Line: 5, Hits: 1},
+			{Line: 8, Hits: 1}, {Line: 9, Hits: 0}, {Line: 11, Hits: 1}, {Line: 12, Hits: 1},
+			{Line: 13, Hits: 2}, {Line: 14, Hits: 2}, {Line: 16, Hits: 1},
+		},
+		Branches: []BranchCoverage{
+			{Line: 2, Column: 3, Taken: 1, NotTaken: 1},
+			{Line: 2, Column: 13, Taken: 1, NotTaken: 1},
+			{Line: 12, Column: 1, Taken: 2, NotTaken: 1},
+			{Line: 16, Column: 18, Taken: 0, NotTaken: 1},
+		},
+		Functions: []FunctionCoverage{
+			{Name: "label", Line: 1, Column: 1, Hits: 2},
+			{Name: "unused", Line: 8, Column: 1, Hits: 0},
+		},
+	}}
+	if files := ctx.Coverage.Files(); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected coverage %+v, got %+v", expected, files)
+	}
+}
+
+func TestCoverageTemplates(t *testing.T) {
+	set := NewTemplateSet(New())
+	if _, err := set.Add("layout", "<main>\n{% block body { %}{% } %}\n</main>"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := set.Add("page", "{% extends \"layout\"; %}\n{% block body { %}\n{% if (admin) { %}<p>admin</p>{% } %}\n{% } %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := set.Add("unused", "{% fn f() { return 1; } %}\n<p>{% f() %}</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cov := NewCoverage()
+	if err := cov.AddTemplate(unused); err != nil {
+		t.Fatal(err)
+	}
+	for _, admin := range []bool{false, false} {
+		ctx, err := page.NewExecutionContext(Vars{"admin": admin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.Coverage = cov
+		if _, err := page.RenderContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []FileCoverage{
+		{
+			Template: "layout",
+			Lines:    []LineCoverage{{Line: 1, Hits: 2}, {Line: 2, Hits: 2}},
+		},
+		{
+			Template: "page",
+			Lines:    []LineCoverage{{Line: 1, Hits: 2}, {Line: 2, Hits: 2}, {Line: 3, Hits: 2}},
+			Branches: []BranchCoverage{{Line: 3, Column: 4, Taken: 0, NotTaken: 2}},
+		},
+		{
+			Template:  "unused",
+			Lines:     []LineCoverage{{Line: 1, Hits: 0}, {Line: 2, Hits: 0}},
+			Functions: []FunctionCoverage{{Name: "f", Line: 1, Column: 4, Hits: 0}},
+		},
+	}
+	if files := cov.Files(); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected coverage %+v, got %+v", expected, files)
+	}
+}
+
+func TestCoverageLCOV(t *testing.T) {
+	input := "{% let double = fn(x) { return x * 2; }; %}\n{% fn twice(x) { return double(x) + double(x); } %}\n{% if (n > 1 || n < -1) { twice(n); } %}"
+	ctx := newTemplateContext(t, input, Vars{"n": 2})
+	ctx.name = "page"
+	ctx.Coverage = NewCoverage()
+	ctx.Coverage.Path = func(template string) string { return "templates/" + template + ".html" }
+	if _, err := New().EvaluateString(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var lcov strings.Builder
+	if err := ctx.Coverage.WriteLCOV(&lcov); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `TN:
+SF:templates/page.html
+FN:1,double
+FN:2,twice
+FNDA:2,double
+FNDA:1,twice
+FNF:2
+FNH:2
+BRDA:3,0,0,1
+BRDA:3,0,1,0
+BRDA:3,1,0,0
+BRDA:3,1,1,1
+BRF:4
+BRH:2
+DA:1,2
+DA:2,1
+DA:3,1
+LF:3
+LH:3
+end_of_record
+`
+	if lcov.String() != expected {
+		t.Fatalf("expected LCOV:\n%s\ngot:\n%s", expected, lcov.String())
+	}
+}
+
+func TestCoverageLCOVFunctionNames(t *testing.T) {
+	functions := []FunctionCoverage{
+		{Name: "<anonymous>", Line: 1, Column: 5},
+		{Name: "helper", Line: 2, Column: 3},
+		{Name: "helper", Line: 5, Column: 3},
+		{Name: "main", Line: 8, Column: 1},
+	}
+	expected := []string{"<anonymous>:1:5", "helper:2:3", "helper:5:3", "main"}
+	if names := lcovFunctionNames(functions); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected names %q, got %q", expected, names)
+	}
+}
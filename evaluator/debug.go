@@ -0,0 +1,156 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// ErrStopped classifies the errors a Debugger returns to end an evaluation.
+// Like the limits, they cannot be caught by try/catch.
+var ErrStopped = errors.New("stopped by debugger")
+
+// Debugger is called by the evaluator before each statement it runs, when
+// set on an ExecutionContext. Statement runs on the goroutine evaluating the
+// program, which waits for it to return: a debugger pauses the program by
+// not returning until it is told to go on, and may inspect and modify the
+// variables of the program meanwhile. Returning an error ends the
+// evaluation with that error, wrapped with ErrStopped.
+//
+// The time a program spends paused counts towards Timeout, so a context
+// being debugged should usually have none.
+type Debugger interface {
+	Statement(state *DebugState) error
+}
+
+// DebugState describes the statement about to run. It and the scopes it
+// refers to are only valid until Statement returns.
+type DebugState struct {
+	Statement parser.Statement
+	// Line and Column locate the start of Statement, and Template is the
+	// name of the template it is in, if it came from a TemplateSet.
+	Line     int
+	Column   int
+	Template string
+	// Scope holds the variables visible to Statement, innermost first
+	// through its parents.
+	Scope *Scope
+	// Depth is the number of function calls and includes in progress, which
+	// is the number of entries in Frames after the first.
+	Depth int
+
+	e   *Evaluator
+	ctx *ExecutionContext
+}
+
+// DebugFrame is a frame of the call stack of a paused program, with the
+// variables in scope at the position it has reached. Native frames have no
+// Scope.
+type DebugFrame struct {
+	StackFrame
+	Scope *Scope
+}
+
+// statementHook calls the debugger of ctx for the statement starting at the
+// instruction pc of c, if one does.
+func (e *Evaluator) statementHook(ctx *ExecutionContext, c *code, pc int, scope *Scope) error {
+	statement, ok := c.statementAt(pc)
+	if !ok {
+		return nil
+	}
+
+	token := startToken(statement)
+	err := ctx.Debugger.Statement(&DebugState{
+		Statement: statement,
+		Line:      token.Line,
+		Column:    token.Column,
+		Template:  ctx.template,
+		Scope:     scope,
+		Depth:     len(ctx.frames),
+		e:         e,
+		ctx:       ctx,
+	})
+	if err != nil {
+		return runtimeError(ctx, token, fmt.Errorf("%w: %w", ErrStopped, err))
+	}
+	return nil
+}
+
+// startToken returns the first token of node, where nodeToken gives the
+// operator of infix, pipe, range, call, index and property expressions.
+func startToken(node Node) lexer.Token {
+	switch n := node.(type) {
+	case *parser.ExpressionStatement:
+		return startToken(n.Expression)
+	case *parser.InfixExpression:
+		return startToken(n.Left)
+	case *parser.PipeExpression:
+		return startToken(n.Left)
+	case *parser.RangeExpression:
+		return startToken(n.Start)
+	case *parser.AssignmentExpression:
+		return startToken(n.Left)
+	case *parser.CallExpression:
+		return startToken(n.Function)
+	case *parser.IndexExpression:
+		return startToken(n.Left)
+	case *parser.PropertyExpression:
+		return startToken(n.Left)
+	}
+	token, _ := nodeToken(node)
+	return token
+}
+
+// Frames returns the call stack of the paused program, innermost frame
+// first, ending with the <main> frame of the template or script being
+// evaluated.
+func (s *DebugState) Frames() []DebugFrame {
+	trace := s.ctx.stackTrace(s.Line, s.Column)
+
+	frames := make([]DebugFrame, len(trace))
+	for i, frame := range trace {
+		frames[i].StackFrame = frame
+		switch {
+		case frame.Native:
+		case i == 0:
+			frames[i].Scope = s.Scope
+		default:
+			// Each call records the scope of its caller.
+			frames[i].Scope = s.ctx.frames[len(s.ctx.frames)-i].scope
+		}
+	}
+	return frames
+}
+
+// Evaluate runs a script in scope, which is usually Scope or the scope of
+// one of Frames, and returns its value. The script can read and assign the
+// variables of the paused program and call its functions, under the limits
+// of the program's execution context but without its debugger.
+func (s *DebugState) Evaluate(scope *Scope, script string) (Object, error) {
+	program, err := parser.New(lexer.NewScript(script)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := NewExecutionContextWithScope(program, scope)
+	ctx.Source = script
+	ctx.Logger = s.ctx.Logger
+	ctx.Templates = s.ctx.Templates
+	ctx.Escaping = s.ctx.Escaping
+	ctx.MaxSteps = s.ctx.MaxSteps
+	ctx.MaxDepth = s.ctx.MaxDepth
+	ctx.MaxArraySize = s.ctx.MaxArraySize
+	ctx.MaxOutputBytes = s.ctx.MaxOutputBytes
+	ctx.MaxMemory = s.ctx.MaxMemory
+	ctx.Strict = s.ctx.Strict
+	ctx.Context = context.Background()
+
+	result, err := s.e.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapReturnValue(result), nil
+}
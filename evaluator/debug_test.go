@@ -0,0 +1,255 @@
+package evaluator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// debugFunc adapts a function to the Debugger interface.
+type debugFunc func(state *DebugState) error
+
+func (f debugFunc) Statement(state *DebugState) error {
+	return f(state)
+}
+
+func expectDebug(t *testing.T, obj Object, expected string) {
+	t.Helper()
+	if obj == nil || obj.Debug() != expected {
+		t.Fatalf("expected %s, got %v", expected, obj)
+	}
+}
+
+type debugStop struct {
+	Line     int
+	Column   int
+	Template string
+	Depth    int
+}
+
+func TestDebuggerStatements(t *testing.T) {
+	input := "fn add(a, b) {\n  return a + b;\n}\nlet total = 0;\nforeach ([1, 2] as x) {\n  total = add(total, x);\n}\ntotal;"
+	ctx := newScriptContext(t, input)
+
+	var stops []debugStop
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		stops = append(stops, debugStop{state.Line, state.Column, state.Template, state.Depth})
+		return nil
+	})
+
+	result, err := New().Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDebug(t, result, "3")
+
+	expected := []debugStop{
+		{Line: 1, Column: 1},
+		{Line: 4, Column: 1},
+		{Line: 5, Column: 1},
+		{Line: 6, Column: 3},
+		{Line: 2, Column: 3, Depth: 1},
+		{Line: 6, Column: 3},
+		{Line: 2, Column: 3, Depth: 1},
+		{Line: 8, Column: 1},
+	}
+	if !reflect.DeepEqual(stops, expected) {
+		t.Fatalf("expected stops %v, got %v", expected, stops)
+	}
+}
+
+func TestDebuggerFrames(t *testing.T) {
+	input := "let greeting = \"hi\";\nfn inner(y) {\n  return y;\n}\nfn outer(x) {\n  let z = x * 2;\n  return inner(z);\n}\nouter(1);"
+	ctx := newScriptContext(t, input)
+
+	var frames []DebugFrame
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		if state.Line == 3 {
+			frames = state.Frames()
+		}
+		return nil
+	})
+
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var trace []StackFrame
+	for _, frame := range frames {
+		trace = append(trace, frame.StackFrame)
+	}
+	expected := []StackFrame{
+		{Function: "inner", Line: 3, Column: 3},
+		{Function: "outer", Line: 7, Column: 15},
+		{Function: "<main>", Line: 9, Column: 6},
+	}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Fatalf("expected frames %v, got %v", expected, trace)
+	}
+
+	lookup := func(scope *Scope, name string) Object {
+		t.Helper()
+		val, ok := scope.GetLocal(name)
+		if !ok {
+			t.Fatalf("expected %s in scope %v", name, scope.Names())
+		}
+		return val
+	}
+	expectDebug(t, lookup(frames[0].Scope, "y"), "2")
+	expectDebug(t, lookup(frames[1].Scope, "z"), "2")
+	expectDebug(t, lookup(frames[2].Scope, "greeting"), "hi")
+	if frames[0].Scope.Parent() == nil {
+		t.Fatal("expected the function scope to have a parent")
+	}
+}
+
+func TestDebuggerNativeFrames(t *testing.T) {
+	ctx := newScriptContext(t, "fn double(x) {\n  return x * 2;\n}\nmap([1], double);")
+
+	var frames []DebugFrame
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		if state.Line == 2 {
+			frames = state.Frames()
+		}
+		return nil
+	})
+
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 3 || !frames[1].Native || frames[1].Scope != nil {
+		t.Fatalf("expected a native map frame without a scope, got %v", frames)
+	}
+	if frames[2].Scope != ctx.RootScope {
+		t.Fatal("expected <main> to run in the root scope")
+	}
+}
+
+func TestDebuggerEvaluate(t *testing.T) {
+	ctx := newScriptContext(t, "fn f(a) {\n  let b = a + 1;\n  return b;\n}\nf(1);")
+
+	var inspected Object
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		if state.Line != 3 {
+			return nil
+		}
+		var err error
+		if inspected, err = state.Evaluate(state.Scope, "a + b"); err != nil {
+			return err
+		}
+		_, err = state.Evaluate(state.Scope, "b = 10;")
+		return err
+	})
+
+	result, err := New().Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDebug(t, inspected, "3")
+	expectDebug(t, unwrapReturnValue(result), "10")
+}
+
+func TestDebuggerEvaluateErrors(t *testing.T) {
+	ctx := newScriptContext(t, "let a = 1;")
+
+	var parseErr, evalErr error
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		_, parseErr = state.Evaluate(state.Scope, "a +")
+		_, evalErr = state.Evaluate(state.Scope, "missing")
+		return nil
+	})
+
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if parseErr == nil {
+		t.Fatal("expected a parse error")
+	}
+	if !errors.Is(evalErr, ErrUndefinedVariable) {
+		t.Fatalf("expected an undefined variable error, got: %v", evalErr)
+	}
+}
+
+func TestDebuggerStop(t *testing.T) {
+	ctx := newScriptContext(t, "let a = 1;\ntry {\n  a = 2;\n} catch (e) {\n  a = 3;\n}\na;")
+	ctx.Source = "let a = 1;\ntry {\n  a = 2;\n} catch (e) {\n  a = 3;\n}\na;"
+
+	stop := errors.New("terminated")
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		if state.Line == 3 {
+			return stop
+		}
+		return nil
+	})
+
+	_, err := New().Evaluate(ctx)
+	if !errors.Is(err, ErrStopped) || !errors.Is(err, stop) {
+		t.Fatalf("expected the debugger's error, got: %v", err)
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Line != 3 || rtErr.Column != 3 {
+		t.Fatalf("expected an error at line 3, column 3, got: %v", err)
+	}
+	if val, _ := ctx.RootScope.Get("a"); val.Debug() != "1" {
+		t.Fatalf("expected the catch block not to run, a is %s", val.Debug())
+	}
+}
+
+func TestDebuggerTemplates(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"page": "<ul>{% foreach (items as item) { %}\n{% include(\"item\", {\"item\": item}) %}{% } %}</ul>",
+		"item": "<li>{% item %}</li>",
+	})
+	tmpl, err := set.Get("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tmpl.NewExecutionContext(Vars{"items": []any{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stops []debugStop
+	var includeFrames []DebugFrame
+	ctx.Debugger = debugFunc(func(state *DebugState) error {
+		stops = append(stops, debugStop{state.Line, state.Column, state.Template, state.Depth})
+		if state.Template == "item" && includeFrames == nil {
+			includeFrames = state.Frames()
+		}
+		return nil
+	})
+
+	output, err := tmpl.RenderContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<ul><li>a</li></ul>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+
+	expected := []debugStop{
+		{Line: 1, Column: 1, Template: "page"},
+		{Line: 1, Column: 8, Template: "page"},
+		{Line: 1, Column: 36, Template: "page"},
+		{Line: 2, Column: 4, Template: "page"},
+		{Line: 1, Column: 1, Template: "item", Depth: 2},
+		{Line: 1, Column: 8, Template: "item", Depth: 2},
+		{Line: 1, Column: 15, Template: "item", Depth: 2},
+		{Line: 2, Column: 45, Template: "page"},
+	}
+	if !reflect.DeepEqual(stops, expected) {
+		t.Fatalf("expected stops %v, got %v", expected, stops)
+	}
+
+	var functions []string
+	for _, frame := range includeFrames {
+		functions = append(functions, frame.Function)
+	}
+	if !reflect.DeepEqual(functions, []string{"<main>", "include", "<main>"}) {
+		t.Fatalf("unexpected include frames: %v", functions)
+	}
+	if includeFrames[2].Template != "page" || includeFrames[2].Line != 2 {
+		t.Fatalf("expected the include to be called from page line 2, got %v", includeFrames[2])
+	}
+}
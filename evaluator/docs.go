@@ -0,0 +1,34 @@
+package evaluator
+
+// builtinDocs documents the built-in functions defined by New.
+var builtinDocs = map[string]FunctionDoc{
+	"log":        {Signature: "log(val, ...)", Description: "Writes values to the logger, one per line."},
+	"print":      {Signature: "print(val, ...)", Description: "Writes values to the template output."},
+	"raw":        {Signature: "raw(val)", Description: "Marks a value as safe from auto-escaping."},
+	"include":    {Signature: "include(name, vars?)", Description: "Renders a template from the template set, with the variables of the hash vars."},
+	"append":     {Signature: "append(arr, val)", Description: "Appends an element to an array, modifying it."},
+	"len":        {Signature: "len(val)", Description: "Returns the length of a string, array or hash."},
+	"split":      {Signature: "split(str, delim)", Description: "Splits a string into an array at each delimiter."},
+	"trim":       {Signature: "trim(str)", Description: "Removes leading and trailing whitespace from a string."},
+	"toUpper":    {Signature: "toUpper(str)", Description: "Converts a string to uppercase."},
+	"toLower":    {Signature: "toLower(str)", Description: "Converts a string to lowercase."},
+	"contains":   {Signature: "contains(str, sub)", Description: "Reports whether a string contains a substring."},
+	"startsWith": {Signature: "startsWith(str, prefix)", Description: "Reports whether a string begins with a prefix."},
+	"endsWith":   {Signature: "endsWith(str, suffix)", Description: "Reports whether a string ends with a suffix."},
+	"indexOf":    {Signature: "indexOf(str, sub)", Description: "Returns the position of the first occurrence of a substring, or -1 if there is none."},
+	"replace":    {Signature: "replace(str, old, new)", Description: "Replaces all occurrences of old in a string with new."},
+	"substring":  {Signature: "substring(str, start, end?)", Description: "Returns the part of a string from start to end, exclusive, or to the end of the string."},
+	"keys":       {Signature: "keys(hash)", Description: "Returns the keys of a hash, in insertion order."},
+	"values":     {Signature: "values(hash)", Description: "Returns the values of a hash, in insertion order."},
+	"type":       {Signature: "type(val)", Description: "Returns the type name of a value, such as \"INTEGER\"."},
+	"toString":   {Signature: "toString(val)", Description: "Converts a value to a string."},
+	"parseInt":   {Signature: "parseInt(str)", Description: "Parses a string as an integer."},
+	"parseFloat": {Signature: "parseFloat(str)", Description: "Parses a string as a decimal."},
+	"join":       {Signature: "join(arr, sep)", Description: "Joins the elements of an array into a string, separated by sep."},
+	"map":        {Signature: "map(arr, fn)", Description: "Returns an array of the results of calling fn with each element."},
+	"filter":     {Signature: "filter(arr, fn)", Description: "Returns an array of the elements for which fn returns a truthy value."},
+	"floor":      {Signature: "floor(num)", Description: "Rounds a number down to an integer."},
+	"ceil":       {Signature: "ceil(num)", Description: "Rounds a number up to an integer."},
+	"round":      {Signature: "round(num)", Description: "Rounds a number to the nearest integer."},
+	"abs":        {Signature: "abs(num)", Description: "Returns the absolute value of a number."},
+}
@@ -1,29 +1,80 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// Sentinel errors classifying evaluation failures. Errors returned by the
+// evaluator match them with errors.Is:
+//
+//	if errors.Is(err, evaluator.ErrStepLimit) { ... }
+var (
+	ErrStepLimit         = errors.New("execution limit exceeded")
+	ErrDepthLimit        = errors.New("maximum call depth exceeded")
+	ErrArrayLimit        = errors.New("maximum array size exceeded")
+	ErrOutputLimit       = errors.New("output limit exceeded")
+	ErrMemoryLimit       = errors.New("memory limit exceeded")
+	ErrCancelled         = errors.New("execution cancelled")
+	ErrUndefinedVariable = errors.New("undefined variable")
+	ErrUndefinedProperty = errors.New("undefined property")
+	ErrTypeMismatch      = errors.New("type mismatch")
+	ErrUnknownOperator   = errors.New("unknown operator")
+	ErrDivisionByZero    = errors.New("division by zero")
+	ErrIntegerOverflow   = errors.New("integer overflow")
+	ErrIndexOutOfRange   = errors.New("index out of range")
+	ErrArgumentCount     = errors.New("wrong number of arguments")
+	ErrNotCallable       = errors.New("not a function")
+	ErrTemplateNotFound  = errors.New("template not found")
+	ErrTemplateCycle     = errors.New("template cycle detected")
+	ErrThrown            = errors.New("uncaught exception")
 )
 
+// RuntimeError is an evaluation error located in the source. Every error
+// returned by Evaluate, EvaluateString and EvaluateTo is a *RuntimeError,
+// wrapping the underlying error in Err. Errors raised inside a function call
+// carry the script call stack in Stack, innermost frame first.
 type RuntimeError struct {
 	Message string
 	Source  string
 	Line    int
 	Column  int
+	Stack   []StackFrame
+	Err     error
 }
 
+// maxPrintedFrames is the number of stack frames Error prints before eliding
+// the middle of the trace.
+const maxPrintedFrames = 20
+
 func (e *RuntimeError) Error() string {
 	var sb strings.Builder
 
+	e.writeSnippet(&sb)
+	e.writeStack(&sb)
+
+	return sb.String()
+}
+
+func (e *RuntimeError) writeSnippet(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf("error: %s\n", e.Message))
-	sb.WriteString(fmt.Sprintf(" --> line %d, column %d\n", e.Line, e.Column))
+	sb.WriteString(fmt.Sprintf(" --> line %d, column %d", e.Line, e.Column))
+
+	if e.Source == "" {
+		return
+	}
 
 	lines := strings.Split(e.Source, "\n")
 
 	// Bounds check
 	if e.Line < 1 || e.Line > len(lines) {
-		return sb.String()
+		return
 	}
+	sb.WriteString("\n")
 
 	line := lines[e.Line-1]
 
@@ -39,8 +90,35 @@ func (e *RuntimeError) Error() string {
 
 	col := e.Column - 1 + (3 * tabs)
 	sb.WriteString(fmt.Sprintf("%s | %s^", padding, strings.Repeat(" ", col)))
+}
 
-	return sb.String()
+func (e *RuntimeError) writeStack(sb *strings.Builder) {
+	if len(e.Stack) == 0 {
+		return
+	}
+
+	sb.WriteString("\nstack trace:")
+
+	frames := e.Stack
+	elided := 0
+	if len(frames) > maxPrintedFrames {
+		elided = len(frames) - maxPrintedFrames
+	}
+
+	for i, frame := range frames {
+		if elided > 0 && i == maxPrintedFrames/2 {
+			sb.WriteString(fmt.Sprintf("\n    ... %d more frames", elided))
+		}
+		if elided > 0 && i >= maxPrintedFrames/2 && i < maxPrintedFrames/2+elided {
+			continue
+		}
+		sb.WriteString("\n    ")
+		sb.WriteString(frame.String())
+	}
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
 }
 
 func NewRuntimeError(message, source string, line, column int) *RuntimeError {
@@ -51,3 +129,152 @@ func NewRuntimeError(message, source string, line, column int) *RuntimeError {
 		Column:  column,
 	}
 }
+
+// runtimeError locates err at token in the source being evaluated.
+func runtimeError(ctx *ExecutionContext, token lexer.Token, err error) *RuntimeError {
+	return &RuntimeError{
+		Message: err.Error(),
+		Source:  ctx.source,
+		Line:    token.Line,
+		Column:  token.Column,
+		Err:     err,
+	}
+}
+
+// locateAt wraps err in a *RuntimeError positioned at token, unless it has
+// already been located by a nested function call or template.
+func locateAt(ctx *ExecutionContext, err error, token lexer.Token) error {
+	var rtErr *RuntimeError
+	if errors.As(err, &rtErr) {
+		return err
+	}
+
+	return runtimeError(ctx, token, err)
+}
+
+// nodeToken returns the token that positions node in the source.
+func nodeToken(node Node) (lexer.Token, bool) {
+	switch n := node.(type) {
+	case *parser.PrintStatement:
+		return n.Token, true
+	case *parser.LetStatement:
+		return n.Token, true
+	case *parser.ReturnStatement:
+		return n.Token, true
+	case *parser.BreakStatement:
+		return n.Token, true
+	case *parser.ContinueStatement:
+		return n.Token, true
+	case *parser.BlockStatement:
+		return n.Token, true
+	case *parser.ExtendsStatement:
+		return n.Token, true
+	case *parser.NamedBlockStatement:
+		return n.Token, true
+	case *parser.TryStatement:
+		return n.Token, true
+	case *parser.ThrowStatement:
+		return n.Token, true
+	case *parser.ExpressionStatement:
+		return nodeToken(n.Expression)
+	case *parser.AssignmentExpression:
+		return n.Token, true
+	case *parser.Identifier:
+		return n.Token, true
+	case *parser.PropertyExpression:
+		return n.Token, true
+	case *parser.CallExpression:
+		return n.Token, true
+	case *parser.InfixExpression:
+		return n.Token, true
+	case *parser.PipeExpression:
+		return n.Token, true
+	case *parser.PrefixExpression:
+		return n.Token, true
+	case *parser.IndexExpression:
+		return n.Token, true
+	case *parser.IfExpression:
+		return n.Token, true
+	case *parser.ForeachExpression:
+		return n.Token, true
+	case *parser.WhileExpression:
+		return n.Token, true
+	case *parser.ForExpression:
+		return n.Token, true
+	case *parser.RangeExpression:
+		return n.Token, true
+	case *parser.IntegerLiteral:
+		return n.Token, true
+	case *parser.FloatLiteral:
+		return n.Token, true
+	case *parser.StringLiteral:
+		return n.Token, true
+	case *parser.InterpolatedString:
+		return n.Token, true
+	case *parser.BooleanLiteral:
+		return n.Token, true
+	case *parser.NullLiteral:
+		return n.Token, true
+	case *parser.FunctionLiteral:
+		return n.Token, true
+	case *parser.ArrayLiteral:
+		return n.Token, true
+	case *parser.HashLiteral:
+		return n.Token, true
+	default:
+		return lexer.Token{}, false
+	}
+}
+
+// kindError is an error message classified by one of the sentinel errors.
+type kindError struct {
+	kind    error
+	message string
+}
+
+func newError(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, message: fmt.Sprintf(format, args...)}
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// CancelledError is returned when evaluation stops because the execution
+// context's Context was cancelled or its deadline, including one set by
+// Timeout, passed. Cause is the context's cancellation cause, so
+// errors.Is(err, context.DeadlineExceeded) and errors.Is(err,
+// context.Canceled) work as expected.
+type CancelledError struct {
+	Cause error
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("execution cancelled: %v", e.Cause)
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *CancelledError) Is(target error) bool {
+	return target == ErrCancelled
+}
+
+// MemoryLimitError is returned when an evaluation allocates more than the
+// execution context's MaxMemory budget.
+type MemoryLimitError struct {
+	Limit int
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("memory limit exceeded: %d bytes", e.Limit)
+}
+
+func (e *MemoryLimitError) Is(target error) bool {
+	return target == ErrMemoryLimit
+}
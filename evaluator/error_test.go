@@ -0,0 +1,200 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		setup  func(ctx *ExecutionContext)
+		kind   error
+		line   int
+		column int
+	}{
+		{"step limit", "let x = 0;\nwhile (true) { x = x + 1; }", func(ctx *ExecutionContext) { ctx.MaxSteps = 50 }, ErrStepLimit, 2, 0},
+		{"depth limit", "fn f() { return f(); }\nf();", func(ctx *ExecutionContext) { ctx.MaxDepth = 10 }, ErrDepthLimit, 1, 18},
+		{"array limit", "let a = [];\nwhile (true) { append(a, 1); }", func(ctx *ExecutionContext) { ctx.MaxArraySize = 5 }, ErrArrayLimit, 2, 22},
+		{"array literal limit", "let a = [1, 2, 3];", func(ctx *ExecutionContext) { ctx.MaxArraySize = 2 }, ErrArrayLimit, 1, 9},
+		{"memory limit", "let s = \"ab\";\nwhile (true) { s = s + s; }", func(ctx *ExecutionContext) { ctx.MaxMemory = 1000 }, ErrMemoryLimit, 2, 22},
+		{"undefined variable", "let x = 1;\nreturn y;", nil, ErrUndefinedVariable, 2, 8},
+		{"undefined assignment", "y = 1;", nil, ErrUndefinedVariable, 1, 3},
+		{"type mismatch", "let x = true - 1;", nil, ErrTypeMismatch, 1, 14},
+		{"builtin type mismatch", "len(1);", nil, ErrTypeMismatch, 1, 4},
+		{"unknown operator", "let x = \"a\" - \"b\";", nil, ErrUnknownOperator, 1, 13},
+		{"division by zero", "let x = 1;\nlet y = x / 0;", nil, ErrDivisionByZero, 2, 11},
+		{"interpolated expression", "let x = 0;\nlet s = `total:\n  ${len(\"ab\")} ${10 / x}`;", nil, ErrDivisionByZero, 3, 21},
+		{"integer overflow", "let x = 9223372036854775807 + 1;", nil, ErrIntegerOverflow, 1, 29},
+		{"index out of range", "let a = [1];\na[5] = 2;", nil, ErrIndexOutOfRange, 2, 6},
+		{"argument count", "fn f(a) { return a; }\nf(1, 2);", nil, ErrArgumentCount, 2, 2},
+		{"builtin argument count", "split(\"a\");", nil, ErrArgumentCount, 1, 6},
+		{"not callable", "let x = 1;\nx();", nil, ErrNotCallable, 2, 2},
+		{"strict undefined property", "let user = {\"address\": {}};\nreturn user.adress.city;", func(ctx *ExecutionContext) { ctx.Strict = true }, ErrUndefinedProperty, 2, 12},
+		{"strict property of non-hash", "let user = {\"address\": 1};\nreturn user?.address.city;", func(ctx *ExecutionContext) { ctx.Strict = true }, ErrTypeMismatch, 2, 21},
+		{"pipe argument count", "let s = \"a\";\nlet t = s | split;", nil, ErrArgumentCount, 2, 11},
+		{"pipe not callable", "let s = \"a\";\nlet t = s | s;", nil, ErrNotCallable, 2, 11},
+		{"range bound", "let n = \"3\";\nforeach (0..n as i) { }", nil, ErrTypeMismatch, 2, 11},
+		{"for step limit", "let n = 0;\nfor (;;) { n += 1; }", func(ctx *ExecutionContext) { ctx.MaxSteps = 50 }, ErrStepLimit, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newScriptContext(t, tt.input)
+			ctx.Source = tt.input
+			if tt.setup != nil {
+				tt.setup(ctx)
+			}
+
+			_, err := New().Evaluate(ctx)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected errors.Is(err, %v), got: %v", tt.kind, err)
+			}
+
+			var rtErr *RuntimeError
+			if !errors.As(err, &rtErr) {
+				t.Fatalf("expected *RuntimeError, got %T", err)
+			}
+			if rtErr.Line != tt.line {
+				t.Fatalf("expected line %d, got %d", tt.line, rtErr.Line)
+			}
+			if tt.column != 0 && rtErr.Column != tt.column {
+				t.Fatalf("expected column %d, got %d", tt.column, rtErr.Column)
+			}
+			if rtErr.Source != tt.input {
+				t.Fatal("expected error to carry the source")
+			}
+		})
+	}
+}
+
+func TestErrorKindsAreDistinct(t *testing.T) {
+	_, err := RunScript(`return 1 / 0;`)
+	if !errors.Is(err, ErrDivisionByZero) {
+		t.Fatalf("expected ErrDivisionByZero, got: %v", err)
+	}
+	for _, other := range []error{ErrStepLimit, ErrTypeMismatch, ErrUndefinedVariable, ErrIntegerOverflow} {
+		if errors.Is(err, other) {
+			t.Fatalf("did not expect error to match %v", other)
+		}
+	}
+}
+
+func TestErrorPositionWithoutSource(t *testing.T) {
+	ctx := newScriptContext(t, "let x = 1;\nlet s = \"\" + missing;")
+	ctx.Source = ""
+
+	_, err := New().Evaluate(ctx)
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got %T", err)
+	}
+	if rtErr.Line != 2 || rtErr.Column != 14 {
+		t.Fatalf("expected line 2, column 14, got line %d, column %d", rtErr.Line, rtErr.Column)
+	}
+	if rtErr.Message != "identifier not found: missing" {
+		t.Fatalf("unexpected message: %q", rtErr.Message)
+	}
+}
+
+func TestErrorOutputLimit(t *testing.T) {
+	ctx := newTemplateContext(t, "{% foreach (items as i) { %}\n0123456789{% } %}", Vars{"items": make([]any, 10)})
+	ctx.MaxOutputBytes = 25
+
+	_, err := New().EvaluateString(ctx)
+	if !errors.Is(err, ErrOutputLimit) {
+		t.Fatalf("expected ErrOutputLimit, got: %v", err)
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Line != 1 {
+		t.Fatalf("expected error located at the template text, got: %v", err)
+	}
+}
+
+func TestErrorCancelled(t *testing.T) {
+	ctx := newScriptContext(t, `return 1;`)
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.Context = cancelCtx
+
+	_, err := New().Evaluate(ctx)
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("expected ErrCancelled, got: %v", err)
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got %T", err)
+	}
+}
+
+func TestErrorMemoryLimitAs(t *testing.T) {
+	ctx := newScriptContext(t, `let s = "ab"; while (true) { s = s + s; }`)
+	ctx.MaxMemory = 1000
+
+	_, err := New().Evaluate(ctx)
+	var memErr *MemoryLimitError
+	if !errors.As(err, &memErr) || memErr.Limit != 1000 {
+		t.Fatalf("expected *MemoryLimitError with limit 1000, got: %v", err)
+	}
+}
+
+func TestErrorCustomFunction(t *testing.T) {
+	errTenant := errors.New("tenant not found")
+
+	ctx := newScriptContext(t, "let x = 1;\nlookup(x);")
+	e := New()
+	e.RegisterFunction("lookup", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return nil, errTenant
+	})
+
+	_, err := e.Evaluate(ctx)
+	if !errors.Is(err, errTenant) {
+		t.Fatalf("expected custom error to be wrapped, got: %v", err)
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Line != 2 {
+		t.Fatalf("expected error located at the call, got: %v", err)
+	}
+}
+
+func TestErrorIncludedTemplate(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"page":    "<h1>\n{% include(\"partial\") %}",
+		"partial": "<p>\n\n{% missing %}</p>",
+	})
+
+	_, err := set.Render("page")
+	if !errors.Is(err, ErrUndefinedVariable) {
+		t.Fatalf("expected ErrUndefinedVariable, got: %v", err)
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got %T", err)
+	}
+	if rtErr.Line != 3 || rtErr.Source != "<p>\n\n{% missing %}</p>" {
+		t.Fatalf("expected error located in the included template, got line %d in %q", rtErr.Line, rtErr.Source)
+	}
+}
+
+func TestErrorTemplateKinds(t *testing.T) {
+	_, err := NewTemplateSetWithLoader(New(), MapLoader{"a": `{% include("b") %}`}).Render("a")
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Fatalf("expected ErrTemplateNotFound, got: %v", err)
+	}
+
+	_, err = NewTemplateSetWithLoader(New(), MapLoader{"a": "\n{% extends \"a\"; %}"}).Render("a")
+	if !errors.Is(err, ErrTemplateCycle) {
+		t.Fatalf("expected ErrTemplateCycle, got: %v", err)
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Line != 2 {
+		t.Fatalf("expected error located at the extends statement, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "template cycle detected: a -> a") {
+		t.Fatalf("unexpected message: %v", err)
+	}
+}
@@ -0,0 +1,677 @@
+package evaluator
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Escaping selects how template expression output is escaped.
+type Escaping int
+
+const (
+	// NoEscaping writes expression output unchanged.
+	NoEscaping Escaping = iota
+	// HTMLEscaping escapes expression output according to the HTML context
+	// (element body, attribute, URL, script or style) established by the
+	// surrounding template text, in the manner of html/template.
+	HTMLEscaping
+)
+
+// filteredValue replaces output that cannot be made safe in its context.
+const filteredValue = "ZgotmplZ"
+
+type htmlState int
+
+const (
+	stateText htmlState = iota
+	stateTagName
+	stateTag
+	stateAttrName
+	stateAfterName
+	stateBeforeValue
+	stateAttr
+	stateRawText
+	stateComment
+)
+
+type attrKind int
+
+const (
+	attrNormal attrKind = iota
+	attrURL
+	attrScript
+	attrStyle
+)
+
+type urlPart int
+
+const (
+	urlStart urlPart = iota
+	urlPath
+	urlQuery
+)
+
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"src":        true,
+	"xlink:href": true,
+}
+
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+// htmlContext tracks where in an HTML document the template output
+// currently is, so that expression output can be escaped to match.
+type htmlContext struct {
+	state   htmlState
+	tag     string
+	endTag  bool
+	attr    string
+	kind    attrKind
+	delim   byte
+	url     urlPart
+	element string
+	js      jsContext
+}
+
+// jsContext tracks where in a script the output currently is: in code, or
+// in a string, template literal, regular expression or comment.
+type jsContext struct {
+	// quote is the quote of the string or template literal the output is
+	// in, or '/' in a regular expression literal.
+	quote byte
+	// comment is '/' in a line comment and '*' in a block comment.
+	comment byte
+	// escaped is set after a backslash in a string or regular expression.
+	escaped bool
+	// class is set in a character class of a regular expression, where a
+	// slash does not end it.
+	class bool
+	// slash is set after a slash in code, until the next character shows
+	// whether it starts a comment, a regular expression or is a division.
+	slash bool
+	// star is set after an asterisk in a block comment.
+	star bool
+	// dollar is set after a dollar sign in a template literal.
+	dollar bool
+	// division is set when a slash in code would be a division, after an
+	// operand, rather than start a regular expression.
+	division bool
+	// word is the identifier or keyword just read in code.
+	word string
+	// substitutions holds, for each ${ substitution the output is in, the
+	// depth of the braces opened in it.
+	substitutions []int
+}
+
+// jsRegexpKeywords are the keywords after which a slash starts a regular
+// expression rather than being a division.
+var jsRegexpKeywords = map[string]bool{
+	"await":      true,
+	"case":       true,
+	"delete":     true,
+	"do":         true,
+	"else":       true,
+	"in":         true,
+	"instanceof": true,
+	"new":        true,
+	"return":     true,
+	"throw":      true,
+	"typeof":     true,
+	"void":       true,
+	"yield":      true,
+}
+
+// advance feeds literal template text through the state machine.
+func (c *htmlContext) advance(s string) {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+
+		switch c.state {
+		case stateText:
+			if ch != '<' {
+				continue
+			}
+			if strings.HasPrefix(s[i:], "<!--") {
+				c.state = stateComment
+				i += 3
+				continue
+			}
+			if i+1 < len(s) && isASCIILetter(s[i+1]) {
+				c.state = stateTagName
+				c.tag = ""
+				c.endTag = false
+				continue
+			}
+			if i+2 < len(s) && s[i+1] == '/' && isASCIILetter(s[i+2]) {
+				c.state = stateTagName
+				c.tag = ""
+				c.endTag = true
+				i++
+			}
+		case stateTagName:
+			if isASCIILetter(ch) || isASCIIDigit(ch) || ch == '-' {
+				c.tag += string(toASCIILower(ch))
+				continue
+			}
+			c.state = stateTag
+			i--
+		case stateTag:
+			switch {
+			case ch == '>':
+				c.closeTag()
+			case isHTMLSpace(ch) || ch == '/':
+			default:
+				c.state = stateAttrName
+				c.attr = string(toASCIILower(ch))
+			}
+		case stateAttrName:
+			switch {
+			case ch == '=':
+				c.state = stateBeforeValue
+			case ch == '>':
+				c.closeTag()
+			case isHTMLSpace(ch) || ch == '/':
+				c.state = stateAfterName
+			default:
+				c.attr += string(toASCIILower(ch))
+			}
+		case stateAfterName:
+			switch {
+			case ch == '=':
+				c.state = stateBeforeValue
+			case ch == '>':
+				c.closeTag()
+			case isHTMLSpace(ch) || ch == '/':
+			default:
+				c.state = stateAttrName
+				c.attr = string(toASCIILower(ch))
+			}
+		case stateBeforeValue:
+			switch {
+			case isHTMLSpace(ch):
+			case ch == '>':
+				c.closeTag()
+			case ch == '"' || ch == '\'':
+				c.enterAttr(ch)
+			default:
+				c.enterAttr(0)
+				i--
+			}
+		case stateAttr:
+			if (c.delim != 0 && ch == c.delim) || (c.delim == 0 && isHTMLSpace(ch)) {
+				c.state = stateTag
+				continue
+			}
+			if c.delim == 0 && ch == '>' {
+				c.closeTag()
+				continue
+			}
+			switch c.kind {
+			case attrURL:
+				if ch == '?' || ch == '#' {
+					c.url = urlQuery
+				} else if c.url == urlStart {
+					c.url = urlPath
+				}
+			case attrScript:
+				c.advanceJS(ch)
+			}
+		case stateRawText:
+			if ch == '<' && i+1 < len(s) && s[i+1] == '/' &&
+				strings.HasPrefix(strings.ToLower(s[i+2:]), c.element) {
+				c.state = stateTagName
+				c.tag = ""
+				c.endTag = true
+				c.js = jsContext{}
+				i++
+				continue
+			}
+			if c.element == "script" {
+				c.advanceJS(ch)
+			}
+		case stateComment:
+			if strings.HasPrefix(s[i:], "-->") {
+				c.state = stateText
+				i += 2
+			}
+		}
+	}
+}
+
+// advanceJS feeds a character of script through the JS state machine.
+func (c *htmlContext) advanceJS(ch byte) {
+	js := &c.js
+
+	switch {
+	case js.comment == '/':
+		if ch == '\n' || ch == '\r' {
+			js.comment = 0
+		}
+		return
+	case js.comment == '*':
+		if js.star && ch == '/' {
+			js.comment = 0
+		}
+		js.star = ch == '*'
+		return
+	case js.escaped:
+		js.escaped = false
+		return
+	case js.quote == '/':
+		switch {
+		case ch == '\\':
+			js.escaped = true
+		case ch == '[':
+			js.class = true
+		case ch == ']':
+			js.class = false
+		case ch == '/' && !js.class, ch == '\n', ch == '\r':
+			js.quote = 0
+			js.division = true
+		}
+		return
+	case js.quote == '`':
+		switch {
+		case ch == '\\':
+			js.escaped = true
+		case ch == '`':
+			js.quote = 0
+			js.division = true
+		case ch == '{' && js.dollar:
+			js.quote = 0
+			js.division = false
+			js.substitutions = append(js.substitutions, 0)
+		}
+		js.dollar = ch == '$'
+		return
+	case js.quote != 0:
+		switch ch {
+		case '\\':
+			js.escaped = true
+		case js.quote:
+			js.quote = 0
+			js.division = true
+		}
+		return
+	}
+
+	if js.slash {
+		js.slash = false
+		switch {
+		case ch == '/':
+			js.comment = '/'
+			return
+		case ch == '*':
+			js.comment = '*'
+			js.star = false
+			return
+		case !js.division:
+			js.quote = '/'
+			js.class = false
+			c.advanceJS(ch)
+			return
+		}
+		js.division = false
+	}
+
+	if isJSIdentChar(ch) {
+		js.word += string(ch)
+		js.division = true
+		return
+	}
+	word := js.word
+	js.word = ""
+
+	switch {
+	case ch == '/':
+		if jsRegexpKeywords[word] {
+			js.division = false
+		}
+		js.slash = true
+	case ch == '"' || ch == '\'' || ch == '`':
+		js.quote = ch
+		js.dollar = false
+	case ch == '{':
+		if n := len(js.substitutions); n > 0 {
+			js.substitutions[n-1]++
+		}
+		js.division = false
+	case ch == '}':
+		if n := len(js.substitutions); n > 0 {
+			if js.substitutions[n-1] == 0 {
+				js.substitutions = js.substitutions[:n-1]
+				js.quote = '`'
+				js.dollar = false
+				return
+			}
+			js.substitutions[n-1]--
+		}
+		js.division = false
+	case ch == ')' || ch == ']':
+		js.division = true
+	case isHTMLSpace(ch):
+		if jsRegexpKeywords[word] {
+			js.division = false
+		}
+	default:
+		js.division = false
+	}
+}
+
+// output records that a value has been written at the current position,
+// resolving a pending slash first: a value after it is a division's
+// operand or the start of a regular expression.
+func (js *jsContext) output() {
+	if js.slash {
+		js.slash = false
+		if !js.division {
+			js.quote = '/'
+			js.class = false
+		}
+	}
+	if js.quote == 0 && js.comment == 0 {
+		js.word = ""
+		js.division = true
+	}
+}
+
+func (c *htmlContext) enterAttr(delim byte) {
+	c.state = stateAttr
+	c.delim = delim
+	c.url = urlStart
+	c.js = jsContext{}
+
+	switch {
+	case strings.HasPrefix(c.attr, "on"):
+		c.kind = attrScript
+	case c.attr == "style":
+		c.kind = attrStyle
+	case urlAttributes[c.attr]:
+		c.kind = attrURL
+	default:
+		c.kind = attrNormal
+	}
+}
+
+func (c *htmlContext) closeTag() {
+	if !c.endTag && rawTextElements[c.tag] {
+		c.state = stateRawText
+		c.element = c.tag
+		c.js = jsContext{}
+		return
+	}
+	c.state = stateText
+}
+
+// escape converts an output value to text that is safe in the current context.
+func (c *htmlContext) escape(obj Object) string {
+	switch c.state {
+	case stateText, stateComment:
+		return htmlEscape(obj.Debug())
+	case stateTagName, stateTag, stateAttrName, stateAfterName:
+		return attrNameFilter(obj.Debug())
+	case stateBeforeValue:
+		c.enterAttr(0)
+		return c.escapeAttr(obj)
+	case stateAttr:
+		return c.escapeAttr(obj)
+	case stateRawText:
+		switch c.element {
+		case "script":
+			return c.escapeJS(obj)
+		case "style":
+			return cssFilter(obj.Debug())
+		default:
+			return htmlEscape(obj.Debug())
+		}
+	default:
+		return htmlEscape(obj.Debug())
+	}
+}
+
+func (c *htmlContext) escapeAttr(obj Object) string {
+	var s string
+
+	switch c.kind {
+	case attrURL:
+		s = c.escapeURL(obj.Debug())
+	case attrScript:
+		s = c.escapeJS(obj)
+	case attrStyle:
+		s = cssFilter(obj.Debug())
+	default:
+		s = obj.Debug()
+	}
+
+	if c.delim == 0 {
+		return htmlUnquotedEscape(s)
+	}
+	return htmlEscape(s)
+}
+
+func (c *htmlContext) escapeURL(s string) string {
+	switch c.url {
+	case urlStart:
+		c.url = urlPath
+		if !isSafeURL(s) {
+			return "#" + filteredValue
+		}
+		return urlNormalize(s)
+	case urlPath:
+		return urlNormalize(s)
+	default:
+		return url.QueryEscape(s)
+	}
+}
+
+// escapeJS escapes obj as a JS value in code, and as the contents of a
+// string in a string, regular expression or comment. The latter escapes
+// quotes, slashes and line breaks, so that it cannot end any of them.
+func (c *htmlContext) escapeJS(obj Object) string {
+	c.js.output()
+	if c.js.quote != 0 || c.js.comment != 0 {
+		return jsStringEscape(obj.Debug())
+	}
+	return jsValue(obj)
+}
+
+func isSafeURL(s string) bool {
+	end := strings.IndexAny(s, ":/?#")
+	if end == -1 || s[end] != ':' {
+		return true
+	}
+	switch strings.ToLower(s[:end]) {
+	case "http", "https", "mailto", "tel":
+		return true
+	}
+	return false
+}
+
+// urlNormalize percent-encodes bytes that are not valid in a URL while
+// leaving reserved characters and existing escapes intact.
+func urlNormalize(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case isASCIILetter(ch), isASCIIDigit(ch):
+			sb.WriteByte(ch)
+		case strings.IndexByte("-._~:/?#[]@!$&'()*+,;=%", ch) != -1:
+			if ch == '\'' || ch == '(' || ch == ')' {
+				fmt.Fprintf(&sb, "%%%02X", ch)
+				continue
+			}
+			sb.WriteByte(ch)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", ch)
+		}
+	}
+	return sb.String()
+}
+
+var htmlReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&#34;",
+	"'", "&#39;",
+	"\x00", "\uFFFD",
+)
+
+func htmlEscape(s string) string {
+	return htmlReplacer.Replace(s)
+}
+
+var htmlUnquotedReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&#34;",
+	"'", "&#39;",
+	"`", "&#96;",
+	"=", "&#61;",
+	" ", "&#32;",
+	"\t", "&#9;",
+	"\n", "&#10;",
+	"\r", "&#13;",
+	"\f", "&#12;",
+	"\x00", "\uFFFD",
+)
+
+func htmlUnquotedEscape(s string) string {
+	return htmlUnquotedReplacer.Replace(s)
+}
+
+func attrNameFilter(s string) string {
+	if s == "" {
+		return filteredValue
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !isASCIILetter(ch) && !isASCIIDigit(ch) && ch != '-' && ch != '_' {
+			return filteredValue
+		}
+	}
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "on") || lower == "style" || urlAttributes[lower] {
+		return filteredValue
+	}
+	return s
+}
+
+func cssFilter(s string) string {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if isASCIILetter(ch) || isASCIIDigit(ch) {
+			continue
+		}
+		if strings.IndexByte(" #%,-._", ch) == -1 {
+			return filteredValue
+		}
+	}
+	if strings.Contains(strings.ToLower(s), "expression") {
+		return filteredValue
+	}
+	return s
+}
+
+func jsStringEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '"', '\'', '`', '<', '>', '&', '=', '+', '/', ' ', ' ':
+			fmt.Fprintf(&sb, `\u%04X`, r)
+		case utf8.RuneError:
+			sb.WriteString(`\uFFFD`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+				continue
+			}
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// jsValue renders an object as a JS expression, in the same way
+// html/template encodes values outside of string literals.
+func jsValue(obj Object) string {
+	switch v := obj.(type) {
+	case *NullValue:
+		return "null"
+	case *BooleanValue, *IntegerValue, *DecimalValue:
+		return " " + v.Debug() + " "
+	case *ArrayValue:
+		var sb strings.Builder
+		sb.WriteString("[")
+		for i, el := range v.Elements {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(jsValue(el))
+		}
+		sb.WriteString("]")
+		return sb.String()
+	case *HashValue:
+		var sb strings.Builder
+		sb.WriteString("{")
+		for i, pair := range v.OrderedPairs() {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(`"` + jsStringEscape(pair.Key.Debug()) + `":`)
+			sb.WriteString(jsValue(pair.Value))
+		}
+		sb.WriteString("}")
+		return sb.String()
+	default:
+		return `"` + jsStringEscape(obj.Debug()) + `"`
+	}
+}
+
+func isASCIILetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isJSIdentChar(ch byte) bool {
+	return isASCIILetter(ch) || isASCIIDigit(ch) || ch == '_' || ch == '$' || ch >= 0x80
+}
+
+func isASCIIDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHTMLSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
+
+func toASCIILower(ch byte) byte {
+	if ch >= 'A' && ch <= 'Z' {
+		return ch + ('a' - 'A')
+	}
+	return ch
+}
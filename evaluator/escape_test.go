@@ -0,0 +1,152 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+func evalEscapedTemplate(t *testing.T, input string, vars Vars) string {
+	t.Helper()
+	e := New()
+	e.Escaping = HTMLEscaping
+	output, err := e.RunTemplate(input, vars)
+	if err != nil {
+		t.Fatalf("RunTemplate error: %v", err)
+	}
+	return output
+}
+
+func TestEscapingDisabledByDefault(t *testing.T) {
+	output, err := RunTemplate(`<p>{% v %}</p>`, Vars{"v": "<b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<p><b></p>" {
+		t.Fatalf("expected unescaped output, got %q", output)
+	}
+}
+
+func TestEscapingContexts(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    any
+		expected string
+	}{
+		{"text", `<p>{% v %}</p>`, `<script>alert("x")</script>`, `<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>`},
+		{"quoted attribute", `<div title="{% v %}">`, `a" onclick="x`, `<div title="a&#34; onclick=&#34;x">`},
+		{"single quoted attribute", `<div title='{% v %}'>`, `it's`, `<div title='it&#39;s'>`},
+		{"unquoted attribute", `<div title={% v %}>`, `a b`, `<div title=a&#32;b>`},
+		{"url scheme", `<a href="{% v %}">`, `javascript:alert(1)`, `<a href="#ZgotmplZ">`},
+		{"url safe", `<a href="{% v %}">`, `https://example.com/a b`, `<a href="https://example.com/a%20b">`},
+		{"url relative", `<a href="{% v %}">`, `/users/1`, `<a href="/users/1">`},
+		{"url query", `<a href="/search?q={% v %}">`, `a&b=c d`, `<a href="/search?q=a%26b%3Dc+d">`},
+		{"script value", `<script>var x = {% v %};</script>`, `</script>`, `<script>var x = "\u003C\u002Fscript\u003E";</script>`},
+		{"script string", `<script>var x = "{% v %}";</script>`, `a"b`, `<script>var x = "a\u0022b";</script>`},
+		{"script integer", `<script>var x = {% v %};</script>`, 42, `<script>var x =  42 ;</script>`},
+		{"event handler", `<button onclick="go({% v %})">`, `x"`, `<button onclick="go(&#34;x\u0022&#34;)">`},
+		{"script after line comment", "<script>// don't\nvar a = {% v %};</script>", `alert(document.cookie)`, "<script>// don't\nvar a = \"alert(document.cookie)\";</script>"},
+		{"script after block comment", `<script>/* it's */ var a = {% v %};</script>`, `alert(document.cookie)`, `<script>/* it's */ var a = "alert(document.cookie)";</script>`},
+		{"event handler after comment", `<button onclick="/* ' */ f({% v %})">`, `alert(document.cookie)`, `<button onclick="/* ' */ f(&#34;alert(document.cookie)&#34;)">`},
+		{"script in line comment", `<script>// {% v %}</script>`, "\nalert(1)", `<script>// \nalert(1)</script>`},
+		{"script after regexp", `<script>var r = /'[/]/; var a = {% v %};</script>`, `x`, `<script>var r = /'[/]/; var a = "x";</script>`},
+		{"script after returned regexp", `<script>function f() { return /'/; } var a = {% v %};</script>`, `x`, `<script>function f() { return /'/; } var a = "x";</script>`},
+		{"script after division", `<script>var a = b / 2 + {% v %};</script>`, `x`, `<script>var a = b / 2 + "x";</script>`},
+		{"script in regexp", `<script>var r = /{% v %}/;</script>`, `/;alert(1)`, `<script>var r = /\u002F;alert(1)/;</script>`},
+		{"script template substitution", "<script>var s = `${ {a: 1}.a }'`; var a = {% v %};</script>", `x`, "<script>var s = `${ {a: 1}.a }'`; var a = \"x\";</script>"},
+		{"script in template literal", "<script>var s = `a${b}{% v %}`;</script>", "`+alert(1)", "<script>var s = `a${b}\\u0060\\u002Balert(1)`;</script>"},
+		{"style attribute", `<p style="color: {% v %}">`, `red; background: url(x)`, `<p style="color: ZgotmplZ">`},
+		{"textarea", `<textarea>{% v %}</textarea>`, `</textarea>`, `<textarea>&lt;/textarea&gt;</textarea>`},
+		{"after element", `<script>var a = 1;</script><p>{% v %}</p>`, `<i>`, `<script>var a = 1;</script><p>&lt;i&gt;</p>`},
+		{"comment", `<!-- {% v %} -->`, `-->`, `<!-- --&gt; -->`},
+		{"attribute name", `<div {% v %}="x">`, `onclick`, `<div ZgotmplZ="x">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := evalEscapedTemplate(t, tt.input, Vars{"v": tt.value})
+			if output != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestEscapingAcrossLoop(t *testing.T) {
+	input := `<ul>{% foreach (items as item) { %}<li><a href="/item/{% item %}">{% item %}</a></li>{% } %}</ul>`
+	output := evalEscapedTemplate(t, input, Vars{"items": []any{"a&b", "<c>"}})
+	expected := `<ul><li><a href="/item/a&amp;b">a&amp;b</a></li><li><a href="/item/%3Cc%3E">&lt;c&gt;</a></li></ul>`
+	if output != expected {
+		t.Fatalf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestEscapingPrintBuiltin(t *testing.T) {
+	output := evalEscapedTemplate(t, `<p>{% print(v, "&"); %}</p>`, Vars{"v": "<b>"})
+	if output != "<p>&lt;b&gt;&amp;</p>" {
+		t.Fatalf("expected escaped print output, got %q", output)
+	}
+}
+
+func TestEscapingRawBuiltin(t *testing.T) {
+	output := evalEscapedTemplate(t, `<p>{% raw(v) %}</p>`, Vars{"v": "<b>bold</b>"})
+	if output != "<p><b>bold</b></p>" {
+		t.Fatalf("expected raw output, got %q", output)
+	}
+}
+
+func TestEscapingSafeStringVar(t *testing.T) {
+	output := evalEscapedTemplate(t, `<p>{% v %}</p>`, Vars{"v": NewSafeStringValue("<br>")})
+	if output != "<p><br></p>" {
+		t.Fatalf("expected safe string output, got %q", output)
+	}
+}
+
+func TestEscapingSafeStringConcatenationIsUnsafe(t *testing.T) {
+	output := evalEscapedTemplate(t, `<p>{% raw("<b>") + v %}</p>`, Vars{"v": "<i>"})
+	if output != "<p>&lt;b&gt;&lt;i&gt;</p>" {
+		t.Fatalf("expected concatenation to be escaped, got %q", output)
+	}
+}
+
+func TestEscapingOnExecutionContext(t *testing.T) {
+	input := `<p>{% "<b>" %}</p>`
+	l := lexer.NewTemplate(input)
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewExecutionContext(program)
+	ctx.Escaping = HTMLEscaping
+	output, err := New().EvaluateString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<p>&lt;b&gt;</p>" {
+		t.Fatalf("expected escaped output, got %q", output)
+	}
+}
+
+func TestRawBuiltinBadArgs(t *testing.T) {
+	err := evalScriptError(t, `raw();`)
+	if err == nil || !strings.Contains(err.Error(), "raw: expected 1 argument") {
+		t.Fatalf("expected argument count error, got: %v", err)
+	}
+}
+
+func TestSafeStringValueDebugAndType(t *testing.T) {
+	s := NewSafeStringValue("<b>")
+	if s.Debug() != "<b>" {
+		t.Fatalf("expected '<b>', got %q", s.Debug())
+	}
+	if s.Type() != SafeStringObject {
+		t.Fatalf("expected SafeStringObject, got %s", s.Type())
+	}
+	if s.HashKey() != NewStringValue("<b>").HashKey() {
+		t.Fatal("expected safe string to hash like the equivalent string")
+	}
+}
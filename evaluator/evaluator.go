@@ -1,15 +1,23 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"math"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/ironfang-ltd/go-script/lexer"
 	"github.com/ironfang-ltd/go-script/parser"
+	"github.com/ironfang-ltd/go-script/resolver"
 )
 
 var (
@@ -20,16 +28,50 @@ type Node interface {
 	Debug() string
 }
 
+// Evaluator runs programs with a registry of built-in and registered
+// functions. An evaluator can be used from multiple goroutines at once, each
+// evaluating with an ExecutionContext of its own. Fields such as Escaping
+// must be set before the evaluator is shared.
 type Evaluator struct {
-	functions map[string]*BuiltInFunction
+	// functions and methods are replaced rather than modified by
+	// RegisterFunction and RegisterMethod, so that they can be read without
+	// locking.
+	functions atomic.Pointer[functionTable]
+	methods   atomic.Pointer[methodTable]
+	mu        sync.Mutex
+	frozen    bool
+	// Escaping is applied to the execution contexts created by RunTemplate.
+	Escaping Escaping
 }
 
+type functionTable map[string]*BuiltInFunction
+
+// define adds the built-in function name, documented by builtinDocs, which
+// takes from least to most arguments, with a most of -1 for no most.
+func (t functionTable) define(name string, least, most int, fn Function) {
+	doc := builtinDocs[name]
+	doc.Name = name
+	t[name] = &BuiltInFunction{Name: name, Fn: fn, Doc: doc, MinArgs: least, MaxArgs: most}
+}
+
+// builtinFunctions are the built-in functions every evaluator starts with.
+// Evaluators share them, since registering a function replaces rather than
+// modifies the table.
+var builtinFunctions = newBuiltinFunctions()
+
 func New() *Evaluator {
-	e := &Evaluator{
-		functions: make(map[string]*BuiltInFunction),
-	}
+	e := &Evaluator{}
+	e.functions.Store(&builtinFunctions)
+	e.methods.Store(&builtinMethodTable)
+	return e
+}
 
-	e.RegisterFunction("log", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+// newBuiltinFunctions defines the built-in functions. Those that call back
+// into the evaluator, such as map, use the one running the evaluation.
+func newBuiltinFunctions() functionTable {
+	functions := make(functionTable)
+
+	functions.define("log", 1, -1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		for _, arg := range args {
 			_, _ = ctx.Logger.WriteString(arg.Debug())
 			_, _ = ctx.Logger.WriteString("\n")
@@ -37,26 +79,46 @@ func New() *Evaluator {
 		return Null, nil
 	})
 
-	e.RegisterFunction("print", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("print", 1, -1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		for _, arg := range args {
-			ctx.output.WriteString(arg.Debug())
+			if err := ctx.writeValue(arg); err != nil {
+				return nil, err
+			}
 		}
 		return Null, nil
 	})
 
-	e.RegisterFunction("append", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("raw", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		if len(args) != 1 {
+			return nil, newError(ErrArgumentCount, "raw: expected 1 argument, got %d", len(args))
+		}
+		if safe, ok := args[0].(*SafeStringValue); ok {
+			return safe, nil
+		}
+		return &SafeStringValue{Value: args[0].Debug()}, nil
+	})
+
+	functions.define("include", 1, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return ctx.evaluator.evaluateInclude(ctx, args)
+	})
+
+	functions.define("append", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 
 		if len(args) != 2 {
-			return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "expected 2 arguments, got %d", len(args))
 		}
 
 		arrValue, ok := args[0].(*ArrayValue)
 		if !ok {
-			return nil, fmt.Errorf("expected array, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "expected array, got %s", args[0].Type())
 		}
 
 		if ctx.MaxArraySize > 0 && len(arrValue.Elements) >= ctx.MaxArraySize {
-			return nil, fmt.Errorf("maximum array size exceeded: %d", ctx.MaxArraySize)
+			return nil, newError(ErrArrayLimit, "maximum array size exceeded: %d", ctx.MaxArraySize)
+		}
+
+		if err := ctx.allocate(valueSize); err != nil {
+			return nil, err
 		}
 
 		arrValue.Elements = append(arrValue.Elements, args[1])
@@ -64,9 +126,9 @@ func New() *Evaluator {
 		return arrValue, nil
 	})
 
-	e.RegisterFunction("len", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("len", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("len: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "len: expected 1 argument, got %d", len(args))
 		}
 		switch v := args[0].(type) {
 		case *StringValue:
@@ -76,21 +138,31 @@ func New() *Evaluator {
 		case *HashValue:
 			return &IntegerValue{Value: len(v.Pairs)}, nil
 		default:
-			return nil, fmt.Errorf("len: unsupported type %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "len: unsupported type %s", args[0].Type())
 		}
 	})
 
-	e.RegisterFunction("split", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("split", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("split: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "split: expected 2 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("split: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "split: first argument must be a string, got %s", args[0].Type())
 		}
 		delim, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("split: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "split: second argument must be a string, got %s", args[1].Type())
+		}
+		count := len(str.Value) + 1
+		if delim.Value != "" {
+			count = strings.Count(str.Value, delim.Value) + 1
+		}
+		if err := ctx.allocArray(count); err != nil {
+			return nil, err
+		}
+		if err := ctx.allocStrings(count, len(str.Value)); err != nil {
+			return nil, err
 		}
 		parts := strings.Split(str.Value, delim.Value)
 		elements := make([]Object, len(parts))
@@ -100,129 +172,147 @@ func New() *Evaluator {
 		return &ArrayValue{Elements: elements}, nil
 	})
 
-	e.RegisterFunction("trim", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("trim", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("trim: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "trim: expected 1 argument, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("trim: argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "trim: argument must be a string, got %s", args[0].Type())
+		}
+		if err := ctx.allocString(len(str.Value)); err != nil {
+			return nil, err
 		}
 		return &StringValue{Value: strings.TrimSpace(str.Value)}, nil
 	})
 
-	e.RegisterFunction("toUpper", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("toUpper", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("toUpper: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "toUpper: expected 1 argument, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("toUpper: argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "toUpper: argument must be a string, got %s", args[0].Type())
+		}
+		if err := ctx.allocString(len(str.Value)); err != nil {
+			return nil, err
 		}
 		return &StringValue{Value: strings.ToUpper(str.Value)}, nil
 	})
 
-	e.RegisterFunction("toLower", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("toLower", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("toLower: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "toLower: expected 1 argument, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("toLower: argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "toLower: argument must be a string, got %s", args[0].Type())
+		}
+		if err := ctx.allocString(len(str.Value)); err != nil {
+			return nil, err
 		}
 		return &StringValue{Value: strings.ToLower(str.Value)}, nil
 	})
 
-	e.RegisterFunction("contains", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("contains", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("contains: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "contains: expected 2 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("contains: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "contains: first argument must be a string, got %s", args[0].Type())
 		}
 		substr, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("contains: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "contains: second argument must be a string, got %s", args[1].Type())
 		}
 		return &BooleanValue{Value: strings.Contains(str.Value, substr.Value)}, nil
 	})
 
-	e.RegisterFunction("startsWith", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("startsWith", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("startsWith: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "startsWith: expected 2 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("startsWith: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "startsWith: first argument must be a string, got %s", args[0].Type())
 		}
 		prefix, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("startsWith: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "startsWith: second argument must be a string, got %s", args[1].Type())
 		}
 		return &BooleanValue{Value: strings.HasPrefix(str.Value, prefix.Value)}, nil
 	})
 
-	e.RegisterFunction("endsWith", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("endsWith", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("endsWith: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "endsWith: expected 2 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("endsWith: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "endsWith: first argument must be a string, got %s", args[0].Type())
 		}
 		suffix, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("endsWith: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "endsWith: second argument must be a string, got %s", args[1].Type())
 		}
 		return &BooleanValue{Value: strings.HasSuffix(str.Value, suffix.Value)}, nil
 	})
 
-	e.RegisterFunction("indexOf", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("indexOf", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("indexOf: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "indexOf: expected 2 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("indexOf: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "indexOf: first argument must be a string, got %s", args[0].Type())
 		}
 		substr, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("indexOf: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "indexOf: second argument must be a string, got %s", args[1].Type())
 		}
 		return &IntegerValue{Value: strings.Index(str.Value, substr.Value)}, nil
 	})
 
-	e.RegisterFunction("replace", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("replace", 3, 3, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 3 {
-			return nil, fmt.Errorf("replace: expected 3 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "replace: expected 3 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("replace: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "replace: first argument must be a string, got %s", args[0].Type())
 		}
 		old, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("replace: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "replace: second argument must be a string, got %s", args[1].Type())
 		}
 		newStr, ok := args[2].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("replace: third argument must be a string, got %s", args[2].Type())
+			return nil, newError(ErrTypeMismatch, "replace: third argument must be a string, got %s", args[2].Type())
+		}
+		size := len(str.Value)
+		if old.Value == "" {
+			size += (utf8.RuneCountInString(str.Value) + 1) * len(newStr.Value)
+		} else {
+			size += strings.Count(str.Value, old.Value) * (len(newStr.Value) - len(old.Value))
+		}
+		if err := ctx.allocString(size); err != nil {
+			return nil, err
 		}
 		return &StringValue{Value: strings.ReplaceAll(str.Value, old.Value, newStr.Value)}, nil
 	})
 
-	e.RegisterFunction("substring", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("substring", 2, 3, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) < 2 || len(args) > 3 {
-			return nil, fmt.Errorf("substring: expected 2 or 3 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "substring: expected 2 or 3 arguments, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("substring: first argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "substring: first argument must be a string, got %s", args[0].Type())
 		}
 		start, ok := args[1].(*IntegerValue)
 		if !ok {
-			return nil, fmt.Errorf("substring: second argument must be an integer, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "substring: second argument must be an integer, got %s", args[1].Type())
 		}
 		s := start.Value
 		if s < 0 {
@@ -235,7 +325,7 @@ func New() *Evaluator {
 		if len(args) == 3 {
 			endVal, ok := args[2].(*IntegerValue)
 			if !ok {
-				return nil, fmt.Errorf("substring: third argument must be an integer, got %s", args[2].Type())
+				return nil, newError(ErrTypeMismatch, "substring: third argument must be an integer, got %s", args[2].Type())
 			}
 			end = endVal.Value
 			if end < s {
@@ -245,16 +335,22 @@ func New() *Evaluator {
 				end = len(str.Value)
 			}
 		}
+		if err := ctx.allocString(end - s); err != nil {
+			return nil, err
+		}
 		return &StringValue{Value: str.Value[s:end]}, nil
 	})
 
-	e.RegisterFunction("keys", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("keys", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("keys: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "keys: expected 1 argument, got %d", len(args))
 		}
 		hash, ok := args[0].(*HashValue)
 		if !ok {
-			return nil, fmt.Errorf("keys: argument must be a hash, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "keys: argument must be a hash, got %s", args[0].Type())
+		}
+		if err := ctx.allocArray(len(hash.Pairs)); err != nil {
+			return nil, err
 		}
 		ordered := hash.OrderedPairs()
 		elements := make([]Object, 0, len(ordered))
@@ -264,13 +360,16 @@ func New() *Evaluator {
 		return &ArrayValue{Elements: elements}, nil
 	})
 
-	e.RegisterFunction("values", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("values", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("values: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "values: expected 1 argument, got %d", len(args))
 		}
 		hash, ok := args[0].(*HashValue)
 		if !ok {
-			return nil, fmt.Errorf("values: argument must be a hash, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "values: argument must be a hash, got %s", args[0].Type())
+		}
+		if err := ctx.allocArray(len(hash.Pairs)); err != nil {
+			return nil, err
 		}
 		ordered := hash.OrderedPairs()
 		elements := make([]Object, 0, len(ordered))
@@ -280,27 +379,34 @@ func New() *Evaluator {
 		return &ArrayValue{Elements: elements}, nil
 	})
 
-	e.RegisterFunction("type", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("type", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("type: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "type: expected 1 argument, got %d", len(args))
+		}
+		if err := ctx.allocString(len(args[0].Type())); err != nil {
+			return nil, err
 		}
 		return &StringValue{Value: string(args[0].Type())}, nil
 	})
 
-	e.RegisterFunction("toString", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("toString", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("toString: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "toString: expected 1 argument, got %d", len(args))
+		}
+		str := args[0].Debug()
+		if err := ctx.allocString(len(str)); err != nil {
+			return nil, err
 		}
-		return &StringValue{Value: args[0].Debug()}, nil
+		return &StringValue{Value: str}, nil
 	})
 
-	e.RegisterFunction("parseInt", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("parseInt", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("parseInt: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "parseInt: expected 1 argument, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("parseInt: argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "parseInt: argument must be a string, got %s", args[0].Type())
 		}
 		val, err := strconv.ParseInt(str.Value, 10, 64)
 		if err != nil {
@@ -309,13 +415,13 @@ func New() *Evaluator {
 		return &IntegerValue{Value: int(val)}, nil
 	})
 
-	e.RegisterFunction("parseFloat", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("parseFloat", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("parseFloat: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "parseFloat: expected 1 argument, got %d", len(args))
 		}
 		str, ok := args[0].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("parseFloat: argument must be a string, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "parseFloat: argument must be a string, got %s", args[0].Type())
 		}
 		val, err := strconv.ParseFloat(str.Value, 64)
 		if err != nil {
@@ -324,36 +430,47 @@ func New() *Evaluator {
 		return &DecimalValue{Value: val}, nil
 	})
 
-	e.RegisterFunction("join", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("join", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("join: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "join: expected 2 arguments, got %d", len(args))
 		}
 		arr, ok := args[0].(*ArrayValue)
 		if !ok {
-			return nil, fmt.Errorf("join: first argument must be an array, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "join: first argument must be an array, got %s", args[0].Type())
 		}
 		sep, ok := args[1].(*StringValue)
 		if !ok {
-			return nil, fmt.Errorf("join: second argument must be a string, got %s", args[1].Type())
+			return nil, newError(ErrTypeMismatch, "join: second argument must be a string, got %s", args[1].Type())
 		}
 		parts := make([]string, len(arr.Elements))
+		size := 0
 		for i, el := range arr.Elements {
 			parts[i] = el.Debug()
+			size += len(parts[i])
+		}
+		if len(parts) > 1 {
+			size += (len(parts) - 1) * len(sep.Value)
+		}
+		if err := ctx.allocString(size); err != nil {
+			return nil, err
 		}
 		return &StringValue{Value: strings.Join(parts, sep.Value)}, nil
 	})
 
-	e.RegisterFunction("map", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("map", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("map: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "map: expected 2 arguments, got %d", len(args))
 		}
 		arr, ok := args[0].(*ArrayValue)
 		if !ok {
-			return nil, fmt.Errorf("map: first argument must be an array, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "map: first argument must be an array, got %s", args[0].Type())
+		}
+		if err := ctx.allocArray(len(arr.Elements)); err != nil {
+			return nil, err
 		}
 		result := make([]Object, len(arr.Elements))
 		for i, el := range arr.Elements {
-			val, err := e.applyFunction(ctx, scope, args[1], []Object{el})
+			val, err := ctx.evaluator.applyFunction(ctx, scope, ctx.callSite(), args[1], []Object{el})
 			if err != nil {
 				return nil, err
 			}
@@ -362,30 +479,36 @@ func New() *Evaluator {
 		return &ArrayValue{Elements: result}, nil
 	})
 
-	e.RegisterFunction("filter", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("filter", 2, 2, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("filter: expected 2 arguments, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "filter: expected 2 arguments, got %d", len(args))
 		}
 		arr, ok := args[0].(*ArrayValue)
 		if !ok {
-			return nil, fmt.Errorf("filter: first argument must be an array, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "filter: first argument must be an array, got %s", args[0].Type())
+		}
+		if err := ctx.allocArray(0); err != nil {
+			return nil, err
 		}
 		var result []Object
 		for _, el := range arr.Elements {
-			val, err := e.applyFunction(ctx, scope, args[1], []Object{el})
+			val, err := ctx.evaluator.applyFunction(ctx, scope, ctx.callSite(), args[1], []Object{el})
 			if err != nil {
 				return nil, err
 			}
 			if isTruthy(val) {
+				if err := ctx.allocate(valueSize); err != nil {
+					return nil, err
+				}
 				result = append(result, el)
 			}
 		}
 		return &ArrayValue{Elements: result}, nil
 	})
 
-	e.RegisterFunction("floor", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("floor", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("floor: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "floor: expected 1 argument, got %d", len(args))
 		}
 		switch v := args[0].(type) {
 		case *IntegerValue:
@@ -393,13 +516,13 @@ func New() *Evaluator {
 		case *DecimalValue:
 			return &IntegerValue{Value: int(math.Floor(v.Value))}, nil
 		default:
-			return nil, fmt.Errorf("floor: argument must be a number, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "floor: argument must be a number, got %s", args[0].Type())
 		}
 	})
 
-	e.RegisterFunction("ceil", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("ceil", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("ceil: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "ceil: expected 1 argument, got %d", len(args))
 		}
 		switch v := args[0].(type) {
 		case *IntegerValue:
@@ -407,13 +530,13 @@ func New() *Evaluator {
 		case *DecimalValue:
 			return &IntegerValue{Value: int(math.Ceil(v.Value))}, nil
 		default:
-			return nil, fmt.Errorf("ceil: argument must be a number, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "ceil: argument must be a number, got %s", args[0].Type())
 		}
 	})
 
-	e.RegisterFunction("round", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("round", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("round: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "round: expected 1 argument, got %d", len(args))
 		}
 		switch v := args[0].(type) {
 		case *IntegerValue:
@@ -421,13 +544,13 @@ func New() *Evaluator {
 		case *DecimalValue:
 			return &IntegerValue{Value: int(math.Round(v.Value))}, nil
 		default:
-			return nil, fmt.Errorf("round: argument must be a number, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "round: argument must be a number, got %s", args[0].Type())
 		}
 	})
 
-	e.RegisterFunction("abs", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	functions.define("abs", 1, 1, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("abs: expected 1 argument, got %d", len(args))
+			return nil, newError(ErrArgumentCount, "abs: expected 1 argument, got %d", len(args))
 		}
 		switch v := args[0].(type) {
 		case *IntegerValue:
@@ -438,43 +561,171 @@ func New() *Evaluator {
 		case *DecimalValue:
 			return &DecimalValue{Value: math.Abs(v.Value)}, nil
 		default:
-			return nil, fmt.Errorf("abs: argument must be a number, got %s", args[0].Type())
+			return nil, newError(ErrTypeMismatch, "abs: argument must be a number, got %s", args[0].Type())
 		}
 	})
 
-	return e
+	return functions
 }
 
+// RegisterFunction makes fn callable from scripts as name, replacing any
+// function already registered under that name. It is safe to call while the
+// evaluator is in use, with evaluations already running seeing either the
+// old or the new registry, but panics once the evaluator has been frozen.
 func (e *Evaluator) RegisterFunction(name string, fn Function) {
-	e.functions[name] = &BuiltInFunction{Fn: fn}
+	e.RegisterFunctionWithDoc(FunctionDoc{Name: name}, fn)
+}
+
+// RegisterFunctionWithDoc is like RegisterFunction, registering fn as
+// doc.Name with documentation for editors, such as the signature and
+// description the language server shows on hover.
+func (e *Evaluator) RegisterFunctionWithDoc(doc FunctionDoc, fn Function) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.frozen {
+		panic(fmt.Sprintf("evaluator: RegisterFunction(%q) called on a frozen Evaluator", doc.Name))
+	}
+
+	functions := maps.Clone(*e.functions.Load())
+	functions[doc.Name] = newFunction(doc, fn)
+	e.functions.Store(&functions)
+}
+
+// Freeze seals the function and method registries once setup is complete.
+// Any later call to RegisterFunction or RegisterMethod panics, so a frozen
+// evaluator's functions are fixed for every goroutine that shares it.
+func (e *Evaluator) Freeze() {
+	e.mu.Lock()
+	e.frozen = true
+	e.mu.Unlock()
+}
+
+// Frozen reports whether Freeze has been called.
+func (e *Evaluator) Frozen() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.frozen
+}
+
+// function returns the built-in or registered function called name.
+func (e *Evaluator) function(name string) (*BuiltInFunction, bool) {
+	fn, ok := (*e.functions.Load())[name]
+	return fn, ok
 }
 
+// Functions documents the built-in and registered functions, sorted by
+// name.
+func (e *Evaluator) Functions() []FunctionDoc {
+	registered := *e.functions.Load()
+	docs := make([]FunctionDoc, 0, len(registered))
+	for _, fn := range registered {
+		docs = append(docs, fn.Doc)
+	}
+	slices.SortFunc(docs, func(a, b FunctionDoc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return docs
+}
+
+// Check reports the undefined variables and invalid assignments in program
+// without running it. globals names the variables the host will set, such
+// as the keys of the Vars passed to RunScript; built-in and registered
+// functions are always known.
+func (e *Evaluator) Check(program *parser.Program, globals ...string) []*resolver.Diagnostic {
+	registered := *e.functions.Load()
+	functions := make([]string, 0, len(registered))
+	for name := range registered {
+		functions = append(functions, name)
+	}
+
+	res := resolver.Resolve(program, resolver.Globals{Variables: globals, Functions: functions})
+	return res.Diagnostics
+}
+
+// ExecutionContext configures a single evaluation: the program to run, the
+// variables it starts with and the limits it runs under. Everything that
+// changes while the program runs is kept apart, and reset at the start of
+// each evaluation, so the configuration is never modified by evaluating it.
+// A context must not be used by more than one evaluation at a time, but the
+// program it runs can be shared by any number of contexts.
 type ExecutionContext struct {
-	Program      *parser.Program
-	RootScope    *Scope
-	Logger       io.StringWriter
-	Metadata     map[string]any
-	Source       string
-	MaxSteps     int
-	MaxDepth     int
-	MaxArraySize int
-	steps        int
-	depth        int
-	output       *strings.Builder
-	templateMode bool
+	Program        *parser.Program
+	RootScope      *Scope
+	Logger         io.StringWriter
+	Metadata       map[string]any
+	Source         string
+	Context        context.Context
+	Timeout        time.Duration
+	MaxSteps       int
+	MaxDepth       int
+	MaxArraySize   int
+	MaxOutputBytes int
+	MaxMemory      int
+	Escaping       Escaping
+	Templates      *TemplateSet
+	Debugger       Debugger
+	Profile        *Profile
+	Coverage       *Coverage
+
+	// Strict makes reading a missing property with . an error, as well
+	// as reading a property of anything but a hash. The null-safe ?. still
+	// reads null for a missing property or a null left side.
+	Strict bool
+
+	// name is the name of the template being rendered, if it came from a
+	// TemplateSet.
+	name string
+
+	// tmpl is the template that created the context, if any. Its code is
+	// run as long as Program is still the template's own.
+	tmpl *Template
+
+	renderState
+}
+
+// renderState is the state of an evaluation in progress.
+type renderState struct {
+	// program, source and template follow includes and layouts, starting
+	// from the context's own Program, Source and template name.
+	program  *parser.Program
+	source   string
+	template string
+
+	// code is program compiled in template mode, if it came from a
+	// Template. Otherwise program is compiled when it is run.
+	code *code
+
+	// evaluator is the evaluator running the evaluation.
+	evaluator *Evaluator
+
+	steps       int
+	depth       int
+	done        <-chan struct{}
+	output      io.Writer
+	outputBytes int
+	memory      int
+	html        htmlContext
+
+	// profiler and coverage record the evaluation for Profile and
+	// Coverage, which they are added to once it finishes.
+	profiler *profiler
+	coverage map[*code]*codeHits
+
+	stack []Object
+	sp    int
+
+	frames []callFrame
+
+	templateStack []string
+	extends       string
+	extendsToken  lexer.Token
+	layoutOutput  io.Writer
+	blocks        map[string]*blockOverride
 }
 
 func NewExecutionContext(program *parser.Program) *ExecutionContext {
-	return &ExecutionContext{
-		Program:      program,
-		RootScope:    NewScope(),
-		Logger:       os.Stdout,
-		Metadata:     make(map[string]any),
-		MaxSteps:     100_000,
-		MaxDepth:     256,
-		MaxArraySize: 10_000,
-		output:       &strings.Builder{},
-	}
+	return NewExecutionContextWithScope(program, NewScope())
 }
 
 func NewExecutionContextWithScope(program *parser.Program, rootScope *Scope) *ExecutionContext {
@@ -483,479 +734,214 @@ func NewExecutionContextWithScope(program *parser.Program, rootScope *Scope) *Ex
 		RootScope:    rootScope,
 		Logger:       os.Stdout,
 		Metadata:     make(map[string]any),
+		Context:      context.Background(),
 		MaxSteps:     100_000,
 		MaxDepth:     256,
 		MaxArraySize: 10_000,
-		output:       &strings.Builder{},
 	}
 }
 
-func runtimeError(ctx *ExecutionContext, token lexer.Token, message string) error {
-	if ctx.Source != "" {
-		return NewRuntimeError(message, ctx.Source, token.Line, token.Column)
+// start prepares ctx for an evaluation by e, starting from a fresh render
+// state.
+// If Timeout is set, Context is replaced for the duration of the evaluation
+// by one with that deadline. The returned function must be called once the
+// evaluation finishes.
+func (ctx *ExecutionContext) start(e *Evaluator) func() {
+	ctx.renderState = renderState{
+		evaluator: e,
+		program:   ctx.Program,
+		source:    ctx.Source,
+		template:  ctx.name,
+		output:    io.Discard,
+	}
+	if ctx.tmpl != nil && ctx.tmpl.Program == ctx.Program {
+		ctx.code = ctx.tmpl.code
+	}
+	if ctx.name != "" {
+		ctx.templateStack = []string{ctx.name}
 	}
-	return fmt.Errorf("%s", message)
-}
-
-func (e *Evaluator) Evaluate(ctx *ExecutionContext) (Object, error) {
-
-	var result Object = Null
 
-	for _, statement := range ctx.Program.Statements {
-		evalResult, err := e.evaluateNode(ctx, statement, ctx.RootScope)
-		if err != nil {
-			return nil, err
+	finish := func() {}
+	if ctx.Profile != nil || ctx.Coverage != nil {
+		if ctx.Profile != nil {
+			ctx.profiler = &profiler{}
 		}
-
-		if _, ok := evalResult.(*ReturnValue); ok {
-			return evalResult, nil
+		if ctx.Coverage != nil {
+			ctx.coverage = make(map[*code]*codeHits)
 		}
-
-		result = evalResult
+		finish = ctx.finish
 	}
 
-	return result, nil
-}
-
-func (e *Evaluator) EvaluateString(ctx *ExecutionContext) (string, error) {
+	if ctx.Context == nil {
+		ctx.Context = context.Background()
+	}
 
-	ctx.templateMode = true
+	if ctx.Timeout <= 0 {
+		ctx.done = ctx.Context.Done()
+		return finish
+	}
 
-	for _, statement := range ctx.Program.Statements {
-		evalResult, err := e.evaluateNode(ctx, statement, ctx.RootScope)
-		if err != nil {
-			return "", err
-		}
+	parent := ctx.Context
+	timeoutCtx, cancel := context.WithTimeout(parent, ctx.Timeout)
+	ctx.Context = timeoutCtx
+	ctx.done = timeoutCtx.Done()
 
-		if _, ok := evalResult.(*ReturnValue); ok {
-			return "", nil
-		}
+	return func() {
+		cancel()
+		ctx.Context = parent
+		ctx.done = parent.Done()
+		finish()
 	}
-
-	return ctx.output.String(), nil
 }
 
-func shouldWriteTemplateOutput(obj Object) bool {
-	if obj == nil {
-		return false
+// finish adds what was recorded of an evaluation that has finished to
+// Profile and Coverage.
+func (ctx *ExecutionContext) finish() {
+	if ctx.profiler != nil {
+		ctx.Profile.add(ctx.profiler)
+		ctx.profiler = nil
 	}
-	switch obj.Type() {
-	case NullObject, ReturnValueObject, FunctionObject, BreakSignalObject, ContinueSignalObject:
-		return false
+	if ctx.coverage != nil {
+		ctx.Coverage.add(ctx.coverage)
+		ctx.coverage = nil
 	}
-	return true
 }
 
-func (e *Evaluator) evaluateNode(ctx *ExecutionContext, node Node, scope *Scope) (Object, error) {
-	if ctx.MaxSteps > 0 {
-		ctx.steps++
-		if ctx.steps > ctx.MaxSteps {
-			return nil, fmt.Errorf("execution limit exceeded: %d steps", ctx.MaxSteps)
-		}
+// checkCancelled returns a *CancelledError once Context is cancelled or its
+// deadline has passed.
+func (ctx *ExecutionContext) checkCancelled() error {
+	if ctx.done == nil {
+		return nil
 	}
 
-	switch n := node.(type) {
-	case *parser.PrintStatement:
-		return e.evaluatePrintStatement(ctx, n)
-	case *parser.BlockStatement:
-		return e.evaluateBlockStatement(ctx, n, scope)
-	case *parser.LetStatement:
-		return e.evaluateLetStatement(ctx, n, scope)
-	case *parser.AssignmentExpression:
-		return e.evaluateAssignmentExpression(ctx, n, scope)
-	case *parser.ReturnStatement:
-		return e.evaluateReturnStatement(ctx, n, scope)
-	case *parser.ExpressionStatement:
-		result, err := e.evaluateNode(ctx, n.Expression, scope)
-		if err != nil {
-			return nil, err
-		}
-		if ctx.templateMode {
-			if _, ok := n.Expression.(*parser.AssignmentExpression); !ok {
-				if shouldWriteTemplateOutput(result) {
-					ctx.output.WriteString(result.Debug())
-				}
-			}
-			return Null, nil
-		}
-		return result, nil
-	case *parser.ForeachExpression:
-		return e.evaluateForEach(ctx, n, scope)
-	case *parser.IntegerLiteral:
-		return &IntegerValue{Value: n.Value}, nil
-	case *parser.FloatLiteral:
-		return &DecimalValue{Value: n.Value}, nil
-	case *parser.BooleanLiteral:
-		return &BooleanValue{Value: n.Value}, nil
-	case *parser.StringLiteral:
-		return &StringValue{Value: n.Value}, nil
-	case *parser.PrefixExpression:
-		right, err := e.evaluateNode(ctx, n.Right, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		return e.evaluatePrefixExpression(n.Operator, right)
-	case *parser.NullLiteral:
-		return Null, nil
-	case *parser.WhileExpression:
-		return e.evaluateWhileExpression(ctx, n, scope)
-	case *parser.BreakStatement:
-		return &BreakSignal{}, nil
-	case *parser.ContinueStatement:
-		return &ContinueSignal{}, nil
-	case *parser.InfixExpression:
-		// Short-circuit for &&, ||, ??
-		switch n.Token.Source {
-		case "&&":
-			left, err := e.evaluateNode(ctx, n.Left, scope)
-			if err != nil {
-				return nil, err
-			}
-			if !isTruthy(left) {
-				return left, nil
-			}
-			return e.evaluateNode(ctx, n.Right, scope)
-		case "||":
-			left, err := e.evaluateNode(ctx, n.Left, scope)
-			if err != nil {
-				return nil, err
-			}
-			if isTruthy(left) {
-				return left, nil
-			}
-			return e.evaluateNode(ctx, n.Right, scope)
-		case "??":
-			left, err := e.evaluateNode(ctx, n.Left, scope)
-			if err != nil {
-				return nil, err
-			}
-			if _, isNull := left.(*NullValue); !isNull {
-				return left, nil
-			}
-			return e.evaluateNode(ctx, n.Right, scope)
-		}
-
-		left, err := e.evaluateNode(ctx, n.Left, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		right, err := e.evaluateNode(ctx, n.Right, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		return e.evaluateInfixExpression(ctx, n.Token, left, right)
-	case *parser.IfExpression:
-		return e.evaluateIfExpression(ctx, n, scope)
-	case *parser.Identifier:
-		return e.evaluateIdentifier(ctx, n, scope)
-	case *parser.FunctionLiteral:
-		return e.evaluateFunctionLiteral(n, scope)
-	case *parser.CallExpression:
-		return e.evaluateCallExpression(ctx, n, scope)
-	case *parser.ArrayLiteral:
-		return e.evaluateArrayLiteral(ctx, n, scope)
-	case *parser.IndexExpression:
-
-		left, err := e.evaluateNode(ctx, n.Left, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		index, err := e.evaluateNode(ctx, n.Index, scope)
-		if err != nil {
-			return nil, err
-		}
-		return e.evaluateIndexExpression(left, index)
-	case *parser.HashLiteral:
-		return e.evaluateHashLiteral(ctx, n, scope)
-	case *parser.PropertyExpression:
-		_, _, v, err := e.evaluatePropertyExpression(ctx, n, scope)
-		return v, err
-
+	select {
+	case <-ctx.done:
+		return &CancelledError{Cause: context.Cause(ctx.Context)}
 	default:
-		return nil, fmt.Errorf("unknown node type: %T", n)
+		return nil
 	}
 }
 
-func (e *Evaluator) evaluatePrintStatement(ctx *ExecutionContext, print *parser.PrintStatement) (Object, error) {
-	ctx.output.WriteString(print.Value)
-	return Null, nil
-}
-
-func (e *Evaluator) evaluateForEach(ctx *ExecutionContext, foreach *parser.ForeachExpression, scope *Scope) (Object, error) {
-	iterable, err := e.evaluateNode(ctx, foreach.Iterable, scope)
-	if err != nil {
-		return nil, err
+// write writes s to the output, enforcing MaxOutputBytes. Errors from the
+// underlying writer stop evaluation.
+func (ctx *ExecutionContext) write(s string) error {
+	if ctx.MaxOutputBytes > 0 && ctx.outputBytes+len(s) > ctx.MaxOutputBytes {
+		return newError(ErrOutputLimit, "output limit exceeded: %d bytes", ctx.MaxOutputBytes)
 	}
+	ctx.outputBytes += len(s)
 
-	switch i := iterable.(type) {
-	case *ArrayValue:
-		return e.evaluateArrayForEach(ctx, foreach, i, scope)
-	case *HashValue:
-		return e.evaluateHashForEach(ctx, foreach, i, scope)
-	default:
-		return Null, nil
+	if err := ctx.allocate(len(s)); err != nil {
+		return err
 	}
-}
-
-func (e *Evaluator) evaluateArrayForEach(ctx *ExecutionContext, foreach *parser.ForeachExpression, array *ArrayValue, scope *Scope) (Object, error) {
-	for i, el := range array.Elements {
-		extendedScope := NewChildScope(scope)
-		extendedScope.SetLocal(foreach.Variable.Value, el)
-		if foreach.Index != nil {
-			extendedScope.SetLocal(foreach.Index.Value, &IntegerValue{Value: i})
-		}
-
-		result, err := e.evaluateBlockStatement(ctx, foreach.Body, extendedScope)
-		if err != nil {
-			return nil, err
-		}
 
-		if _, ok := result.(*BreakSignal); ok {
-			break
-		}
-		if _, ok := result.(*ContinueSignal); ok {
-			continue
-		}
-		if _, ok := result.(*ReturnValue); ok {
-			return result, nil
-		}
+	if ctx.output == nil {
+		return nil
 	}
-
-	return Null, nil
+	_, err := io.WriteString(ctx.output, s)
+	return err
 }
 
-func (e *Evaluator) evaluateHashForEach(ctx *ExecutionContext, foreach *parser.ForeachExpression, hash *HashValue, scope *Scope) (Object, error) {
-	for _, pair := range hash.OrderedPairs() {
-		extendedScope := NewChildScope(scope)
-		extendedScope.SetLocal(foreach.Variable.Value, pair.Value)
-		if foreach.Index != nil {
-			extendedScope.SetLocal(foreach.Index.Value, pair.Key)
-		}
-
-		result, err := e.evaluateBlockStatement(ctx, foreach.Body, extendedScope)
-		if err != nil {
-			return nil, err
-		}
-
-		if _, ok := result.(*BreakSignal); ok {
-			break
-		}
-		if _, ok := result.(*ContinueSignal); ok {
-			continue
-		}
-		if _, ok := result.(*ReturnValue); ok {
-			return result, nil
-		}
+// writeText writes literal template text to the output.
+func (ctx *ExecutionContext) writeText(s string) error {
+	if ctx.Escaping == HTMLEscaping {
+		ctx.html.advance(s)
 	}
-
-	return Null, nil
+	return ctx.write(s)
 }
 
-func (e *Evaluator) evaluateWhileExpression(ctx *ExecutionContext, we *parser.WhileExpression, scope *Scope) (Object, error) {
-	for {
-		condition, err := e.evaluateNode(ctx, we.Condition, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		if !isTruthy(condition) {
-			break
-		}
-
-		result, err := e.evaluateBlockStatement(ctx, we.Body, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		if _, ok := result.(*BreakSignal); ok {
-			break
-		}
-		if _, ok := result.(*ContinueSignal); ok {
-			continue
-		}
-		if _, ok := result.(*ReturnValue); ok {
-			return result, nil
-		}
+// writeValue writes an evaluated object to the output, escaping it for the
+// current output context unless it is a SafeStringValue.
+func (ctx *ExecutionContext) writeValue(obj Object) error {
+	if safe, ok := obj.(*SafeStringValue); ok {
+		return ctx.write(safe.Value)
 	}
-
-	return Null, nil
-}
-
-func (e *Evaluator) evaluateBlockStatement(ctx *ExecutionContext, block *parser.BlockStatement, scope *Scope) (Object, error) {
-	var result Object
-
-	for _, statement := range block.Statements {
-		evalResult, err := e.evaluateNode(ctx, statement, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		// Propagate signals immediately
-		switch evalResult.(type) {
-		case *ReturnValue, *BreakSignal, *ContinueSignal:
-			return evalResult, nil
-		}
-
-		result = evalResult
+	if ctx.Escaping == HTMLEscaping {
+		return ctx.write(ctx.html.escape(obj))
 	}
-
-	return result, nil
+	return ctx.write(obj.Debug())
 }
 
-func (e *Evaluator) evaluateLetStatement(ctx *ExecutionContext, let *parser.LetStatement, scope *Scope) (Object, error) {
-	prevTM := ctx.templateMode
-	ctx.templateMode = false
-	val, err := e.evaluateNode(ctx, let.Value, scope)
-	ctx.templateMode = prevTM
+func (e *Evaluator) Evaluate(ctx *ExecutionContext) (Object, error) {
+	compiled, err := compile(ctx.Program, false)
 	if err != nil {
 		return nil, err
 	}
-
-	scope.SetLocal(let.Name.Value, val)
-
-	return val, nil
+	return e.evaluate(ctx, compiled)
 }
 
-func (e *Evaluator) evaluateAssignmentExpression(ctx *ExecutionContext, assign *parser.AssignmentExpression, scope *Scope) (Object, error) {
-
-	prevTM := ctx.templateMode
-	ctx.templateMode = false
-	defer func() { ctx.templateMode = prevTM }()
-
-	if ident, ok := assign.Left.(*parser.Identifier); ok {
+// evaluate runs compiled, the code of ctx.Program in script mode.
+func (e *Evaluator) evaluate(ctx *ExecutionContext, compiled *code) (Object, error) {
+	defer ctx.start(e)()
 
-		right, err := e.evaluateNode(ctx, assign.Right, scope)
-		if err != nil {
-			return nil, err
-		}
+	ctx.cover(compiled)
 
-		assigned := scope.Assign(ident.Value, right)
-		if !assigned {
-			return nil, runtimeError(ctx, assign.Token, fmt.Sprintf("identifier not found in scope: %s", ident.Value))
-		}
-		return right, nil
+	result, returned, err := e.run(ctx, compiled, ctx.RootScope)
+	if err != nil {
+		return nil, err
 	}
 
-	if propExpr, ok := assign.Left.(*parser.PropertyExpression); ok {
-		parent, idx, _, err := e.evaluatePropertyExpression(ctx, propExpr, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		if idx == Null || parent == Null {
-			return nil, fmt.Errorf("cannot assign to property: left side evaluated to null")
-		}
-
-		hashValue, ok := parent.(*HashValue)
-		if !ok {
-			return nil, fmt.Errorf("left side of property expression must be a hash, got %T", parent)
-		}
-
-		right, err := e.evaluateNode(ctx, assign.Right, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		if err := hashValue.Set(idx, right); err != nil {
-			return nil, err
-		}
-
-		return right, nil
+	if returned {
+		return &ReturnValue{Value: result}, nil
 	}
 
-	if indexExpr, ok := assign.Left.(*parser.IndexExpression); ok {
-
-		left, err := e.evaluateNode(ctx, indexExpr.Left, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		index, err := e.evaluateNode(ctx, indexExpr.Index, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		right, err := e.evaluateNode(ctx, assign.Right, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		if left == Null || index == Null {
-			return nil, fmt.Errorf("index expression left side or index evaluated to null")
-		}
-
-		if arrayValue, ok := left.(*ArrayValue); ok {
+	return result, nil
+}
 
-			if i, ok := index.(*IntegerValue); ok {
-				if i.Value < 0 || i.Value >= len(arrayValue.Elements) {
-					return nil, fmt.Errorf("index out of bounds: %d", i.Value)
-				}
-				arrayValue.Elements[i.Value] = right
-			} else {
-				return nil, fmt.Errorf("index must be an integer, got %T", index)
-			}
+// EvaluateString evaluates ctx.Program as a template and returns the
+// rendered output.
+func (e *Evaluator) EvaluateString(ctx *ExecutionContext) (string, error) {
+	var sb strings.Builder
 
-		} else if hashValue, ok := left.(*HashValue); ok {
-			if err := hashValue.Set(index, right); err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, fmt.Errorf("left side of index expression must be an array or hash, got %T", left)
-		}
+	evalResult, err := e.evaluateTo(ctx, &sb)
+	if err != nil {
+		return "", err
+	}
 
-		return right, nil
+	if _, ok := evalResult.(*ReturnValue); ok {
+		return "", nil
 	}
 
-	return nil, fmt.Errorf("unknown expression type in assignment: %T", assign.Left)
+	return sb.String(), nil
 }
 
-func (e *Evaluator) evaluateReturnStatement(ctx *ExecutionContext, ret *parser.ReturnStatement, scope *Scope) (Object, error) {
-	prevTM := ctx.templateMode
-	ctx.templateMode = false
-	val, err := e.evaluateNode(ctx, ret.Value, scope)
-	ctx.templateMode = prevTM
-	if err != nil {
-		return nil, err
-	}
-
-	return &ReturnValue{Value: val}, nil
+// EvaluateTo evaluates ctx.Program as a template, streaming output to w as it
+// is produced. If evaluation fails, w will already hold the output written
+// before the error.
+func (e *Evaluator) EvaluateTo(ctx *ExecutionContext, w io.Writer) error {
+	_, err := e.evaluateTo(ctx, w)
+	return err
 }
 
-func (e *Evaluator) evaluatePrefixExpression(operator string, right Object) (Object, error) {
-	switch operator {
-	case "!":
-		return e.evaluateBangOperatorExpression(right)
-	case "-":
-		return e.evaluateMinusPrefixOperatorExpression(right)
-	default:
-		return nil, fmt.Errorf("unknown operator: %s", operator)
-	}
+func (e *Evaluator) evaluateTo(ctx *ExecutionContext, w io.Writer) (Object, error) {
+	defer ctx.start(e)()
+
+	ctx.output = w
+
+	return e.evaluateTemplateProgram(ctx, ctx.RootScope)
 }
 
-func (e *Evaluator) evaluateBangOperatorExpression(right Object) (Object, error) {
-	switch r := right.(type) {
-	case *BooleanValue:
-		return &BooleanValue{Value: !r.Value}, nil
-	default:
-		return &BooleanValue{Value: false}, nil
+func shouldWriteTemplateOutput(obj Object) bool {
+	if obj == nil {
+		return false
 	}
+	switch obj.Type() {
+	case NullObject, ReturnValueObject, FunctionObject, BreakSignalObject, ContinueSignalObject:
+		return false
+	}
+	return true
 }
 
 func (e *Evaluator) evaluateMinusPrefixOperatorExpression(right Object) (Object, error) {
 	switch r := right.(type) {
 	case *IntegerValue:
 		if r.Value == math.MinInt {
-			return nil, fmt.Errorf("integer overflow")
+			return nil, newError(ErrIntegerOverflow, "integer overflow")
 		}
 		return &IntegerValue{Value: -r.Value}, nil
 	case *DecimalValue:
 		return &DecimalValue{Value: -r.Value}, nil
 	default:
-		return nil, fmt.Errorf("unknown operator: -%T", r)
+		return nil, newError(ErrUnknownOperator, "unknown operator: -%T", r)
 	}
 }
 
@@ -996,17 +982,25 @@ func (e *Evaluator) evaluateInfixExpression(ctx *ExecutionContext, token lexer.T
 
 	if s1, ok := left.(*StringValue); ok {
 		if s2, ok := right.(*StringValue); ok {
-			return e.evaluateStringInfixExpression(operator, s1, s2)
+			return e.evaluateStringInfixExpression(ctx, operator, s1, s2)
 		}
 	}
 
 	// String auto-coercion: "str" + other → "str" + other.Debug()
 	if operator == "+" {
 		if s, ok := left.(*StringValue); ok {
-			return &StringValue{Value: s.Value + right.Debug()}, nil
+			r := right.Debug()
+			if err := ctx.allocString(len(s.Value) + len(r)); err != nil {
+				return nil, err
+			}
+			return &StringValue{Value: s.Value + r}, nil
 		}
 		if s, ok := right.(*StringValue); ok {
-			return &StringValue{Value: left.Debug() + s.Value}, nil
+			l := left.Debug()
+			if err := ctx.allocString(len(l) + len(s.Value)); err != nil {
+				return nil, err
+			}
+			return &StringValue{Value: l + s.Value}, nil
 		}
 	}
 
@@ -1019,10 +1013,10 @@ func (e *Evaluator) evaluateInfixExpression(ctx *ExecutionContext, token lexer.T
 	}
 
 	if left.Type() != right.Type() {
-		return nil, runtimeError(ctx, token, fmt.Sprintf("type mismatch: %s %s %s", left.Type(), operator, right.Type()))
+		return nil, runtimeError(ctx, token, newError(ErrTypeMismatch, "type mismatch: %s %s %s", left.Type(), operator, right.Type()))
 	}
 
-	return nil, runtimeError(ctx, token, fmt.Sprintf("unknown operator: %s %s %s", left.Type(), operator, right.Type()))
+	return nil, runtimeError(ctx, token, newError(ErrUnknownOperator, "unknown operator: %s %s %s", left.Type(), operator, right.Type()))
 }
 
 func (e *Evaluator) evaluateIntegerInfixExpression(ctx *ExecutionContext, token lexer.Token, l, r *IntegerValue) (Object, error) {
@@ -1032,29 +1026,29 @@ func (e *Evaluator) evaluateIntegerInfixExpression(ctx *ExecutionContext, token
 	case "+":
 		result := l.Value + r.Value
 		if (r.Value > 0 && result < l.Value) || (r.Value < 0 && result > l.Value) {
-			return nil, runtimeError(ctx, token, "integer overflow")
+			return nil, runtimeError(ctx, token, newError(ErrIntegerOverflow, "integer overflow"))
 		}
 		return &IntegerValue{Value: result}, nil
 	case "-":
 		result := l.Value - r.Value
 		if (r.Value > 0 && result > l.Value) || (r.Value < 0 && result < l.Value) {
-			return nil, runtimeError(ctx, token, "integer overflow")
+			return nil, runtimeError(ctx, token, newError(ErrIntegerOverflow, "integer overflow"))
 		}
 		return &IntegerValue{Value: result}, nil
 	case "*":
 		result := l.Value * r.Value
 		if l.Value != 0 && r.Value != 0 && result/l.Value != r.Value {
-			return nil, runtimeError(ctx, token, "integer overflow")
+			return nil, runtimeError(ctx, token, newError(ErrIntegerOverflow, "integer overflow"))
 		}
 		return &IntegerValue{Value: result}, nil
 	case "/":
 		if r.Value == 0 {
-			return nil, runtimeError(ctx, token, "division by zero")
+			return nil, runtimeError(ctx, token, newError(ErrDivisionByZero, "division by zero"))
 		}
 		return &IntegerValue{Value: l.Value / r.Value}, nil
 	case "%":
 		if r.Value == 0 {
-			return nil, runtimeError(ctx, token, "division by zero")
+			return nil, runtimeError(ctx, token, newError(ErrDivisionByZero, "division by zero"))
 		}
 		return &IntegerValue{Value: l.Value % r.Value}, nil
 	case "<":
@@ -1070,7 +1064,7 @@ func (e *Evaluator) evaluateIntegerInfixExpression(ctx *ExecutionContext, token
 	case "!=":
 		return &BooleanValue{Value: l.Value != r.Value}, nil
 	default:
-		return nil, runtimeError(ctx, token, fmt.Sprintf("unknown operator: %s", operator))
+		return nil, runtimeError(ctx, token, newError(ErrUnknownOperator, "unknown operator: %s", operator))
 	}
 }
 
@@ -1086,12 +1080,12 @@ func (e *Evaluator) evaluateDecimalInfixExpression(ctx *ExecutionContext, token
 		return &DecimalValue{Value: l.Value * r.Value}, nil
 	case "/":
 		if r.Value == 0 {
-			return nil, runtimeError(ctx, token, "division by zero")
+			return nil, runtimeError(ctx, token, newError(ErrDivisionByZero, "division by zero"))
 		}
 		return &DecimalValue{Value: l.Value / r.Value}, nil
 	case "%":
 		if r.Value == 0 {
-			return nil, runtimeError(ctx, token, "division by zero")
+			return nil, runtimeError(ctx, token, newError(ErrDivisionByZero, "division by zero"))
 		}
 		return &DecimalValue{Value: math.Mod(l.Value, r.Value)}, nil
 	case "<":
@@ -1107,7 +1101,7 @@ func (e *Evaluator) evaluateDecimalInfixExpression(ctx *ExecutionContext, token
 	case "!=":
 		return &BooleanValue{Value: l.Value != r.Value}, nil
 	default:
-		return nil, runtimeError(ctx, token, fmt.Sprintf("unknown operator: %s", operator))
+		return nil, runtimeError(ctx, token, newError(ErrUnknownOperator, "unknown operator: %s", operator))
 	}
 }
 
@@ -1118,160 +1112,90 @@ func (e *Evaluator) evaluateBooleanInfixExpression(operator string, l, r *Boolea
 	case "!=":
 		return &BooleanValue{Value: l.Value != r.Value}, nil
 	default:
-		return nil, fmt.Errorf("unknown operator: %s", operator)
+		return nil, newError(ErrUnknownOperator, "unknown operator: %s", operator)
 	}
 }
 
-func (e *Evaluator) evaluateStringInfixExpression(operator string, l, r *StringValue) (Object, error) {
+func (e *Evaluator) evaluateStringInfixExpression(ctx *ExecutionContext, operator string, l, r *StringValue) (Object, error) {
 
 	switch operator {
 	case "+":
+		if err := ctx.allocString(len(l.Value) + len(r.Value)); err != nil {
+			return nil, err
+		}
 		return &StringValue{Value: l.Value + r.Value}, nil
 	case "==":
 		return &BooleanValue{Value: l.Value == r.Value}, nil
 	case "!=":
 		return &BooleanValue{Value: l.Value != r.Value}, nil
 	default:
-		return nil, fmt.Errorf("unknown operator: %s", operator)
-	}
-}
-
-func (e *Evaluator) evaluateIfExpression(ctx *ExecutionContext, ie *parser.IfExpression, scope *Scope) (Object, error) {
-	condition, err := e.evaluateNode(ctx, ie.Condition, scope)
-	if err != nil {
-		return nil, err
-	}
-
-	if isTruthy(condition) {
-		return e.evaluateNode(ctx, ie.Consequence, scope)
-	}
-
-	if ie.Alternative != nil {
-		return e.evaluateNode(ctx, ie.Alternative, scope)
-	}
-
-	return Null, nil
-}
-
-func (e *Evaluator) evaluateIdentifier(ctx *ExecutionContext, ident *parser.Identifier, scope *Scope) (Object, error) {
-	if val, ok := scope.Get(ident.Value); ok {
-		return val, nil
-	}
-
-	if builtin, ok := e.functions[ident.Value]; ok {
-		return builtin, nil
-	}
-
-	return nil, runtimeError(ctx, ident.Token, fmt.Sprintf("identifier not found: %s", ident.Value))
-}
-
-func (e *Evaluator) evaluateFunctionLiteral(fl *parser.FunctionLiteral, scope *Scope) (Object, error) {
-
-	fv := &FunctionValue{Parameters: fl.Parameters, Body: fl.Body, Scope: scope}
-
-	if fl.Identifier != nil {
-
-		if _, ok := scope.GetLocal(fl.Identifier.Value); ok {
-			return nil, fmt.Errorf("identifier already defined in local scope: %s", fl.Identifier.Value)
-		}
-
-		scope.SetLocal(fl.Identifier.Value, fv)
-	}
-
-	return fv, nil
-}
-
-func (e *Evaluator) evaluateCallExpression(ctx *ExecutionContext, ce *parser.CallExpression, scope *Scope) (Object, error) {
-	function, err := e.evaluateNode(ctx, ce.Function, scope)
-	if err != nil {
-		return nil, err
+		return nil, newError(ErrUnknownOperator, "unknown operator: %s", operator)
 	}
-
-	args, err := e.evaluateExpressions(ctx, ce.Args, scope)
-	if err != nil {
-		return nil, err
-	}
-
-	return e.applyFunction(ctx, scope, function, args)
 }
 
-func (e *Evaluator) evaluateExpressions(ctx *ExecutionContext, exps []parser.Expression, scope *Scope) ([]Object, error) {
-	var result []Object
-
-	for _, exp := range exps {
-		evaluated, err := e.evaluateNode(ctx, exp, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		result = append(result, evaluated)
-	}
-
-	return result, nil
-}
-
-func (e *Evaluator) applyFunction(ctx *ExecutionContext, scope *Scope, fn Object, args []Object) (Object, error) {
+// applyFunction calls fn with args. token is the call site, recorded on the
+// call stack for stack traces.
+func (e *Evaluator) applyFunction(ctx *ExecutionContext, scope *Scope, token lexer.Token, fn Object, args []Object) (Object, error) {
 	switch f := fn.(type) {
 	case *FunctionValue:
 		if len(args) != len(f.Parameters) {
-			return nil, fmt.Errorf("wrong number of arguments: expected %d, got %d", len(f.Parameters), len(args))
+			return nil, newError(ErrArgumentCount, "wrong number of arguments: expected %d, got %d", len(f.Parameters), len(args))
 		}
 
 		if ctx.MaxDepth > 0 {
 			ctx.depth++
 			if ctx.depth > ctx.MaxDepth {
-				return nil, fmt.Errorf("maximum call depth exceeded: %d", ctx.MaxDepth)
+				return nil, newError(ErrDepthLimit, "maximum call depth exceeded: %d", ctx.MaxDepth)
 			}
 			defer func() { ctx.depth-- }()
 		}
 
-		prevTM := ctx.templateMode
-		ctx.templateMode = false
-		extendedScope := e.extendFunctionScope(f, args)
-		evaluated, err := e.evaluateNode(ctx, f.Body, extendedScope)
-		ctx.templateMode = prevTM
-		if err != nil {
-			return nil, err
+		if f.code == nil {
+			body, err := compileFunctionValue(f)
+			if err != nil {
+				return nil, err
+			}
+			f.code = body
 		}
 
-		// Unwrap return values and discard break/continue signals that leaked
-		// out of loops within the function body
-		switch evaluated.(type) {
-		case *BreakSignal, *ContinueSignal:
-			return Null, nil
+		ctx.pushFrame(f, scope, token)
+		defer ctx.popFrame()
+
+		result, _, err := e.run(ctx, f.code, e.extendFunctionScope(f, args))
+		if err != nil {
+			return nil, ctx.traceError(err, token)
 		}
 
-		return unwrapReturnValue(evaluated), nil
+		return result, nil
 	case *BuiltInFunction:
-		return f.Fn(ctx, scope, args...)
+		ctx.pushFrame(f, scope, token)
+		defer ctx.popFrame()
+
+		result, err := f.Fn(ctx, scope, args...)
+		// A function that gave up because Context ended reports the
+		// cancellation rather than its own error.
+		if cerr := ctx.checkCancelled(); cerr != nil {
+			return nil, ctx.traceError(cerr, token)
+		}
+		if err != nil {
+			return nil, ctx.traceError(err, token)
+		}
+		return result, nil
 	default:
-		return nil, fmt.Errorf("not a function: %T", fn)
+		return nil, newError(ErrNotCallable, "not a function: %T", fn)
 	}
 }
 
 func (e *Evaluator) extendFunctionScope(f *FunctionValue, args []Object) *Scope {
-	extended := NewChildScope(f.Scope)
+	extended := newSlotScope(f.Scope, f.code.locals)
 
-	for i, param := range f.Parameters {
-		extended.SetLocal(param.Value, args[i])
+	for i, slot := range f.code.params {
+		extended.slots[slot] = args[i]
 	}
 
 	return extended
 }
 
-func (e *Evaluator) evaluateArrayLiteral(ctx *ExecutionContext, al *parser.ArrayLiteral, scope *Scope) (Object, error) {
-	if ctx.MaxArraySize > 0 && len(al.Elements) > ctx.MaxArraySize {
-		return nil, fmt.Errorf("maximum array size exceeded: %d", ctx.MaxArraySize)
-	}
-
-	elements, err := e.evaluateExpressions(ctx, al.Elements, scope)
-	if err != nil {
-		return nil, err
-	}
-
-	return &ArrayValue{Elements: elements}, nil
-}
-
 func (e *Evaluator) evaluateIndexExpression(left, index Object) (Object, error) {
 
 	if a, ok := left.(*ArrayValue); ok {
@@ -1284,7 +1208,7 @@ func (e *Evaluator) evaluateIndexExpression(left, index Object) (Object, error)
 		return e.evaluateHashIndexExpression(h, index)
 	}
 
-	return nil, fmt.Errorf("index operator not supported: %T", left)
+	return nil, newError(ErrTypeMismatch, "index operator not supported: %T", left)
 }
 
 func (e *Evaluator) evaluateArrayIndexExpression(array *ArrayValue, index *IntegerValue) (Object, error) {
@@ -1305,106 +1229,6 @@ func (e *Evaluator) evaluateHashIndexExpression(hash *HashValue, index Object) (
 	return Null, nil
 }
 
-func (e *Evaluator) evaluateHashLiteral(ctx *ExecutionContext, hl *parser.HashLiteral, scope *Scope) (Object, error) {
-	hash := NewHashValue()
-
-	for _, pair := range hl.Pairs {
-		key, err := e.evaluateNode(ctx, pair.Key, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		value, err := e.evaluateNode(ctx, pair.Value, scope)
-		if err != nil {
-			return nil, err
-		}
-
-		if err := hash.Set(key, value); err != nil {
-			return nil, err
-		}
-	}
-
-	return hash, nil
-}
-
-func (e *Evaluator) evaluatePropertyExpression(ctx *ExecutionContext, pe *parser.PropertyExpression, scope *Scope) (Object, Object, Object, error) {
-
-	left, err := e.evaluateNode(ctx, pe.Left, scope)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	if _, ok := left.(*HashValue); !ok {
-		return Null, Null, Null, nil
-	}
-
-	right := pe.Property
-
-	for {
-		switch r := right.(type) {
-		case *parser.PropertyExpression:
-
-			if ident, ok := r.Left.(*parser.Identifier); ok {
-				leftValue, err := e.evaluateIndexExpression(left, &StringValue{Value: ident.Value})
-				if err != nil {
-					return nil, nil, nil, err
-				}
-
-				leftHash, ok := leftValue.(*HashValue)
-				if !ok {
-					return Null, Null, Null, nil
-				}
-
-				left = leftHash
-				right = r.Property
-				break
-			}
-
-			if indexIdent, ok := r.Left.(*parser.IndexExpression); ok {
-				indexValue, err := e.evaluateNode(ctx, indexIdent.Index, scope)
-				if err != nil {
-					return nil, nil, nil, err
-				}
-
-				leftIdentifier, ok := indexIdent.Left.(*parser.Identifier)
-				if !ok {
-					return Null, Null, Null, nil
-				}
-
-				leftValue, err := e.evaluateIndexExpression(left, &StringValue{Value: leftIdentifier.Value})
-				if err != nil {
-					return nil, nil, nil, err
-				}
-
-				arrayObject, ok := leftValue.(*ArrayValue)
-				if !ok {
-					return Null, Null, Null, nil
-				}
-
-				left, err = e.evaluateIndexExpression(arrayObject, indexValue)
-				if err != nil {
-					return nil, nil, nil, err
-				}
-
-				right = r.Property
-				break
-			}
-
-			return Null, Null, Null, nil
-		case *parser.Identifier:
-			idx := &StringValue{Value: r.Value}
-			v, err := e.evaluateIndexExpression(left, &StringValue{Value: r.Value})
-			if err != nil {
-				return nil, nil, nil, err
-			}
-
-			return left, idx, v, nil
-		default:
-			return Null, Null, Null, nil
-		}
-	}
-}
-
 func unwrapReturnValue(obj Object) Object {
 	if returnValue, ok := obj.(*ReturnValue); ok {
 		return returnValue.Value
@@ -1413,6 +1237,15 @@ func unwrapReturnValue(obj Object) Object {
 	return obj
 }
 
+// not returns the result of the ! operator: the negation of a boolean, and
+// false for anything else.
+func not(obj Object) Object {
+	if b, ok := obj.(*BooleanValue); ok {
+		return nativeBool(!b.Value)
+	}
+	return falseValue
+}
+
 func isTruthy(obj Object) bool {
 	switch o := obj.(type) {
 	case *BooleanValue:
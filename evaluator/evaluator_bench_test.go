@@ -830,3 +830,83 @@ func BenchmarkEvalTypeBuiltin(b *testing.B) {
 		e.Evaluate(ctx)
 	}
 }
+
+// --- Compiled template benchmarks ---
+
+const benchmarkPageTemplate = `<html>
+<head><title>{% title %}</title></head>
+<body>
+<ul>
+{% foreach (items as item) { %}
+	<li>{% item.name %} - {% toString(item.price) %}</li>
+{% } %}
+</ul>
+</body>
+</html>`
+
+func benchmarkPageVars() Vars {
+	items := make([]any, 10)
+	for i := range items {
+		items[i] = map[string]any{"name": fmt.Sprintf("Item %d", i), "price": i * 10}
+	}
+	return Vars{"title": "Products", "items": items}
+}
+
+func BenchmarkRunTemplatePage(b *testing.B) {
+	e := New()
+	vars := benchmarkPageVars()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.RunTemplate(benchmarkPageTemplate, vars)
+	}
+}
+
+// BenchmarkRunTemplateSimple and BenchmarkRunScriptSimple parse and compile
+// their source on every run, as one-shot evaluations do.
+func BenchmarkRunTemplateSimple(b *testing.B) {
+	e := New()
+	vars := Vars{"name": "World"}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.RunTemplate(`Hello {% name %}!`, vars)
+	}
+}
+
+func BenchmarkRunScriptSimple(b *testing.B) {
+	e := New()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.RunScript(`let x = 1 + 2; return x * 3;`)
+	}
+}
+
+func BenchmarkCompiledTemplatePage(b *testing.B) {
+	tmpl, err := New().Compile(benchmarkPageTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	vars := benchmarkPageVars()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tmpl.Render(vars)
+	}
+}
+
+func BenchmarkCompiledTemplatePageParallel(b *testing.B) {
+	tmpl, err := New().Compile(benchmarkPageTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	vars := benchmarkPageVars()
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tmpl.Render(vars)
+		}
+	})
+}
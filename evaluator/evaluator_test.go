@@ -3,12 +3,15 @@ package evaluator
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ironfang-ltd/go-script/lexer"
 	"github.com/ironfang-ltd/go-script/parser"
+	"github.com/ironfang-ltd/go-script/resolver"
 )
 
 func TestEvaluateAssignment(t *testing.T) {
@@ -738,6 +741,273 @@ func TestEvaluateStringComparisons(t *testing.T) {
 	}
 }
 
+func TestEvaluateInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let name = \"Ada\"; let n = 3; return `Hello, ${name}! You have ${n} items`;", "Hello, Ada! You have 3 items"},
+		{"return `${1.5} ${true} ${null} ${[1, 2]} ${{\"a\": 1}}`;", "1.5 true null Array Hash"},
+		{"let user = {\"name\": \"ada\"}; return `${toUpper(user.name)}:${len([user])}`;", "ADA:1"},
+		{"let items = [\"a\", \"b\"]; return `<ul>\n${join(map(items, fn(x) { return `  <li>${x}</li>`; }), \"\\n\")}\n</ul>`;", "<ul>\n  <li>a</li>\n  <li>b</li>\n</ul>"},
+		{"return `multi\nline ${1 +\n 2}`;", "multi\nline 3"},
+		{"return `\\${1} \\` \\\\ ${\"}\"}`;", "${1} ` \\ }"},
+		{"return ``;", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			strVal, ok := val.(*StringValue)
+			if !ok {
+				t.Fatalf("expected StringValue, got %T", val)
+			}
+			if strVal.Value != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, strVal.Value)
+			}
+		})
+	}
+}
+
+func TestEvaluateInterpolatedStringMatchesConcatenation(t *testing.T) {
+	values := []string{`1`, `2.50`, `"s"`, `true`, `null`, `[1, "a"]`, `{"k": [2]}`, `fn(x) { return x; }`, `toUpper`}
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			interpolated := unwrapReturn(t, evalScript(t, "let v = "+value+"; return `<${v}>`;"))
+			concatenated := unwrapReturn(t, evalScript(t, "let v = "+value+"; return \"<\" + v + \">\";"))
+			if interpolated.Debug() != concatenated.Debug() {
+				t.Fatalf("expected %q, got %q", concatenated.Debug(), interpolated.Debug())
+			}
+		})
+	}
+}
+
+func TestEvaluateInterpolatedStringTemplate(t *testing.T) {
+	output := evalTemplate(t, "{% let name = \"Ada\"; %}<p>{% `Hi, ${name}` %}</p>")
+	if output != "<p>Hi, Ada</p>" {
+		t.Fatalf("expected '<p>Hi, Ada</p>', got %q", output)
+	}
+}
+
+func TestEvaluatePipe(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let name = \"ada\"; return name | toUpper;", "ADA"},
+		{"return \"hello world\" | toUpper | substring(0, 5);", "HELLO"},
+		{"return \"abc\" | substring(1);", "bc"},
+		{"fn truncate(s, n) { return substring(s, 0, n); } return \"goscript\" | truncate(2);", "go"},
+		{"return [1, 2, 3] | map(fn(x) { return x * 2; }) | join(\",\");", "2,4,6"},
+		{"return \"a\" | fn(s) { return s + s; };", "aa"},
+		{"let f = {\"twice\": fn(s) { return s + s; }}; return \"b\" | f.twice | f[\"twice\"];", "bbbb"},
+		{"return 1 + 2 | toString;", "3"},
+		{"return \"a,b\" | split(\",\") | len + 1;", "3"},
+		{"return null ?? \"x\" | toUpper;", "X"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			if val.Debug() != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, val.Debug())
+			}
+		})
+	}
+}
+
+func TestEvaluatePipeTemplate(t *testing.T) {
+	output := evalTemplate(t, "{% let name = \"ada lovelace\"; %}<p>{% name | toUpper | substring(0, 3) %}</p>")
+	if output != "<p>ADA</p>" {
+		t.Fatalf("expected '<p>ADA</p>', got %q", output)
+	}
+}
+
+func TestEvaluatePipeRegisteredFunctions(t *testing.T) {
+	e := New()
+	e.RegisterFunctionWithDoc(FunctionDoc{Name: "wrap", Signature: "wrap(str, left, right?)"}, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		right := args[1]
+		if len(args) == 3 {
+			right = args[2]
+		}
+		return &StringValue{Value: args[1].Debug() + args[0].Debug() + right.Debug()}, nil
+	})
+	// Without a signature, a function checks its own arguments.
+	e.RegisterFunction("count", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return &IntegerValue{Value: len(args)}, nil
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{"return \"x\" | wrap(\"*\");", "*x*", ""},
+		{"return \"x\" | wrap(\"(\", \")\");", "(x)", ""},
+		{"return \"x\" | count(1, 2, 3);", "4", ""},
+		{"return \"x\" | wrap;", "", "wrap: expected 2 or 3 arguments, got 1 including the piped value"},
+		{"return \"x\" | wrap(1, 2, 3);", "", "wrap: expected 2 or 3 arguments, got 4 including the piped value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := parser.New(lexer.NewScript(tt.input)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			val, err := e.Evaluate(NewExecutionContext(program))
+			if tt.err != "" {
+				if !errors.Is(err, ErrArgumentCount) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unwrapReturn(t, val).Debug(); got != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEvaluatePipeArgumentCount(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"return \"x\" | len(1);", "len: expected 1 argument, got 2 including the piped value"},
+		{"return \"x\" | replace(\"a\");", "replace: expected 3 arguments, got 2 including the piped value"},
+		{"fn f(a, b) { return a; } return 1 | f;", "f: expected 2 arguments, got 1 including the piped value"},
+		{"return 1 | fn() { return 2; };", "<anonymous>: expected 0 arguments, got 1 including the piped value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := evalScriptError(t, tt.input)
+			if !errors.Is(err, ErrArgumentCount) || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestFunctionDocArity(t *testing.T) {
+	tests := []struct {
+		signature string
+		least     int
+		most      int
+		ok        bool
+	}{
+		{"", 0, 0, false},
+		{"now()", 0, 0, true},
+		{"split(str, delim)", 2, 2, true},
+		{"substring(str, start, end?)", 2, 3, true},
+		{"log(val, ...)", 1, -1, true},
+		{"concat(strs...)", 0, -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.signature, func(t *testing.T) {
+			least, most, ok := FunctionDoc{Signature: tt.signature}.arity()
+			if least != tt.least || most != tt.most || ok != tt.ok {
+				t.Fatalf("expected %d, %d, %v, got %d, %d, %v", tt.least, tt.most, tt.ok, least, most, ok)
+			}
+		})
+	}
+}
+
+func TestBuiltinArity(t *testing.T) {
+	// The arguments a built-in takes are given when it is defined, and
+	// must agree with its documented signature.
+	for name, fn := range *New().functions.Load() {
+		least, most, _ := fn.Doc.arity()
+		if fn.MinArgs != least || fn.MaxArgs != most {
+			t.Errorf("%s: takes %d to %d arguments, but its signature %q says %d to %d", name, fn.MinArgs, fn.MaxArgs, fn.Doc.Signature, least, most)
+		}
+	}
+}
+
+func TestEvaluateOptionalAccess(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let user = {\"address\": {\"city\": \"London\"}}; return user?.address?.city;", "London"},
+		{"let none = null; return none?.address.city;", "null"},
+		{"let none = null; return none?[0].name;", "null"},
+		{"let none = null; return none?.greet(\"hi\").length;", "null"},
+		{"let user = {\"greet\": fn(s) { return s + \"!\"; }}; return user?.greet(\"hi\");", "hi!"},
+		{"let items = [{\"name\": \"a\"}]; return items?[0]?.name;", "a"},
+		{"let user = {}; return user?.nickname ?? \"none\";", "none"},
+		{"let user = {\"tags\": null}; return len(user.tags?[0] ?? \"\");", "0"},
+		{"let a = null; return len([a?.b, 1]);", "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			if val.Debug() != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, val.Debug())
+			}
+		})
+	}
+}
+
+func TestEvaluateOptionalAccessSkipsChain(t *testing.T) {
+	// Without ?., the rest of the chain runs, and fails on null.
+	err := evalScriptError(t, "let none = null; return none.items[0];")
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected a type mismatch, got %v", err)
+	}
+
+	// The arguments of a skipped call are not evaluated either.
+	val := unwrapReturn(t, evalScript(t, "let n = 0; fn count() { n += 1; return n; } let none = null; none?.f(count()); return n;"))
+	if val.Debug() != "0" {
+		t.Fatalf("expected the arguments to be skipped, got %s", val.Debug())
+	}
+}
+
+func TestEvaluateStrictProperties(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      error
+	}{
+		{"let user = {\"name\": \"ada\"}; return user.name;", "ada", nil},
+		{"let user = {\"name\": null}; return user.name;", "null", nil},
+		{"let user = {}; return user?.name;", "null", nil},
+		{"let none = null; return none?.name.first;", "null", nil},
+		{"let user = {}; return user?.name ?? \"anonymous\";", "anonymous", nil},
+		{"let user = {}; return user.name;", "", ErrUndefinedProperty},
+		{"let none = null; return none.name;", "", ErrTypeMismatch},
+		{"let s = \"ada\"; return s.name;", "", ErrTypeMismatch},
+		{"let s = \"ada\"; return s?.name;", "", ErrTypeMismatch},
+		{"let user = {}; try { return user.name; } catch (e) { return e.kind; }", "UndefinedProperty", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ctx := newScriptContext(t, tt.input)
+			ctx.Strict = true
+			val, err := New().Evaluate(ctx)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unwrapReturn(t, val).Debug(); got != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
 // --- Boolean operations ---
 
 func TestEvaluateBooleanInfix(t *testing.T) {
@@ -1641,10 +1911,10 @@ func TestBangOnNonBooleans(t *testing.T) {
 		input    string
 		expected bool
 	}{
-		{"return !0;", false},     // !<integer> → false (integer is truthy → !truthy = false)
-		{"return !1;", false},     // same
+		{"return !0;", false},       // !<integer> → false (integer is truthy → !truthy = false)
+		{"return !1;", false},       // same
 		{`return !"hello";`, false}, // !<string> → false
-		{"return ![];", false},    // !<array> → false
+		{"return ![];", false},      // !<array> → false
 	}
 
 	for _, tt := range tests {
@@ -2520,6 +2790,56 @@ func TestWhileFalseCondition(t *testing.T) {
 	}
 }
 
+// --- For Loop ---
+
+func TestForLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let sum = 0; for (let i = 0; i < 5; i += 1) { sum += i; } return sum;`, "10"},
+		{`let sum = 0; for (let i = 0; i < 10; i += 1) { if (i == 4) { break; } sum += i; } return sum;`, "6"},
+		{`let sum = 0; for (let i = 0; i < 5; i += 1) { if (i == 2) { continue; } sum += i; } return sum;`, "8"},
+		{`let i = 0; for (i = 10; i > 0; i -= 3) { } return i;`, "-2"},
+		{`let n = 0; for (;;) { n += 1; if (n == 3) { break; } } return n;`, "3"},
+		{`let out = ""; for (let i = 0; i < 2; i += 1) { for (let j = 0; j < 2; j += 1) { if (j == 1) { continue; } out += i + ":" + j + " "; } } return out;`, "0:0 1:0 "},
+		{`fn f() { for (let i = 0; i < 5; i += 1) { if (i == 3) { return i; } } return -1; } return f();`, "3"},
+		{`let r = []; for (let i = 0; i < 3; i += 1) { try { if (i == 1) { continue; } r.append(i); } catch (e) { } } return r.len();`, "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			if val.Debug() != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, val.Debug())
+			}
+		})
+	}
+}
+
+func TestForLoopScope(t *testing.T) {
+	// The variables of a for loop are shared by its iterations, like those
+	// of a while loop, and end with it.
+	test := `let fns = []; for (let i = 0; i < 3; i += 1) { let x = i * 10; fns.append(fn() { return x + i; }); } let i = "outer"; return fns[0]() + " " + i;`
+	result := unwrapReturn(t, evalScript(t, test))
+	if result.Debug() != "23 outer" {
+		t.Fatalf("expected '23 outer', got %s", result.Debug())
+	}
+}
+
+func TestForLoopTemplate(t *testing.T) {
+	result := evalTemplate(t, `<ul>{% for (let i = 1; i <= 3; i += 1) { %}<li>{% i %}</li>{% } %}</ul>`)
+	if result != "<ul><li>1</li><li>2</li><li>3</li></ul>" {
+		t.Fatalf("unexpected output %q", result)
+	}
+}
+
+func TestForAsName(t *testing.T) {
+	// for is only a keyword where a statement starts with for (.
+	val := unwrapReturn(t, evalScript(t, `let for = 2; let h = {"for": 3}; for *= h.for; return for + h?.for;`))
+	expectDebug(t, val, "9")
+}
+
 // --- Break/Continue in Foreach ---
 
 func TestForeachBreak(t *testing.T) {
@@ -2777,7 +3097,7 @@ func TestIntegerDecimalCoercion(t *testing.T) {
 		{`return 1.5 - 1;`, "0.5"},
 		{`return 1 < 1.5;`, "true"},
 		{`return 1.5 > 1;`, "true"},
-		{`return 1 == 1.0;`, "true"},    // int 1 == decimal 1.0
+		{`return 1 == 1.0;`, "true"}, // int 1 == decimal 1.0
 		{`return 2 != 1.5;`, "true"},
 	}
 
@@ -3042,14 +3362,21 @@ func TestRuntimeErrorWithoutSource(t *testing.T) {
 	}
 	e := New()
 	ctx := NewExecutionContext(program)
-	// Source not set — should get plain error
+	// Source not set — still located, but without a source snippet
 	_, err = e.Evaluate(ctx)
 	if err == nil {
 		t.Fatal("expected error")
 	}
 	var rtErr *RuntimeError
-	if errors.As(err, &rtErr) {
-		t.Fatal("expected plain error, not RuntimeError, when Source is empty")
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected RuntimeError, got %T", err)
+	}
+	if rtErr.Line != 1 || rtErr.Column != 8 {
+		t.Fatalf("expected line 1, column 8, got line %d, column %d", rtErr.Line, rtErr.Column)
+	}
+	expected := "error: identifier not found: y\n --> line 1, column 8"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
 	}
 }
 
@@ -3084,6 +3411,56 @@ func TestStepLimitInfiniteLoop(t *testing.T) {
 	}
 }
 
+func TestStepCounts(t *testing.T) {
+	// A step is a statement or expression evaluated, along with each block
+	// of an if expression and function body, however many instructions
+	// they compile to.
+	tests := []struct {
+		input string
+		steps int
+	}{
+		{`return 1;`, 2},
+		{`let x = 1; x;`, 4},
+		{`if (true) { 1; } else { 2; }`, 6},
+		{`let x = -1; let y = !true; return x;`, 8},
+		{`let a = [1, 2]; a[0] = 5; return a[0];`, 13},
+		{`let f = fn(a) { return a * 2; }; return f(3);`, 11},
+		{`let h = {"a": 1, "b": [1, 2]}; return h["b"][1] + len(h);`, 18},
+		{`let s = 0; foreach ([1, 2, 3, 4, 5, 6, 7, 8, 9, 10] as v) { s = s + v; } return s;`, 66},
+		{`let i = 0; while (i < 10) { if (i == 5) { break; } i = i + 1; }`, 79},
+		{`let i = 0; while (i < 1000) { i += 1; }`, 8007},
+		{`fn fib(n) { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); } return fib(10);`, 2391},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ctx := newScriptContext(t, tt.input)
+			if _, err := New().Evaluate(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if ctx.steps != tt.steps {
+				t.Fatalf("expected %d steps, got %d", tt.steps, ctx.steps)
+			}
+		})
+	}
+}
+
+func TestDefaultStepLimit(t *testing.T) {
+	// 96,009 steps.
+	ctx := newScriptContext(t, `let i = 0; while (i < 12000) { i += 1; } return i;`)
+	result, err := New().Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDebug(t, unwrapReturn(t, result), "12000")
+
+	// 112,875 steps.
+	ctx = newScriptContext(t, `fn fib(n) { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); } return fib(18);`)
+	if _, err := New().Evaluate(ctx); !errors.Is(err, ErrStepLimit) {
+		t.Fatalf("expected step limit error, got %v", err)
+	}
+}
+
 func TestDepthLimitRecursiveBomb(t *testing.T) {
 	input := `fn recurse(n) { return recurse(n + 1); } return recurse(0);`
 	l := lexer.NewScript(input)
@@ -3878,3 +4255,142 @@ func TestParseFloatBuiltinBadArgType(t *testing.T) {
 	}
 }
 
+func TestEvaluatorCheck(t *testing.T) {
+	e := New()
+	e.RegisterFunction("lookup", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return Null, nil
+	})
+
+	program := newScriptContext(t, "let a = len(user) + lookup();\nreturn missing;\ntotal = 1;").Program
+
+	diagnostics := e.Check(program, "user")
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diagnostics)
+	}
+	if !errors.Is(diagnostics[0], resolver.ErrUndefinedVariable) || diagnostics[0].Token.Line != 2 {
+		t.Fatalf("unexpected diagnostic: %v", diagnostics[0])
+	}
+	if !errors.Is(diagnostics[1], resolver.ErrInvalidAssignment) || diagnostics[1].Token.Line != 3 {
+		t.Fatalf("unexpected diagnostic: %v", diagnostics[1])
+	}
+}
+
+func TestEvaluatorFreeze(t *testing.T) {
+	e := New()
+	e.RegisterFunction("one", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return NewIntegerValue(1), nil
+	})
+	if e.Frozen() {
+		t.Fatal("expected a new evaluator not to be frozen")
+	}
+
+	e.Freeze()
+	if !e.Frozen() {
+		t.Fatal("expected the evaluator to be frozen")
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "frozen") {
+				t.Fatalf("expected a panic registering on a frozen evaluator, got %v", r)
+			}
+		}()
+		e.RegisterFunction("two", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+			return NewIntegerValue(2), nil
+		})
+	}()
+
+	result, err := e.RunScript(`return one();`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unwrapReturn(t, result).Debug(); got != "1" {
+		t.Fatalf("expected 1, got %s", got)
+	}
+	if _, err := e.RunScript(`return two();`); !errors.Is(err, ErrUndefinedVariable) {
+		t.Fatalf("expected two to be undefined, got %v", err)
+	}
+}
+
+func TestEvaluatorFunctions(t *testing.T) {
+	e := New()
+	e.RegisterFunctionWithDoc(FunctionDoc{Name: "greet", Signature: "greet(name)", Description: "Greets someone."}, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return &StringValue{Value: "hello " + args[0].Debug()}, nil
+	})
+	e.RegisterFunction("len", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return NewIntegerValue(0), nil
+	})
+
+	docs := e.Functions()
+	if !slices.IsSortedFunc(docs, func(a, b FunctionDoc) int { return strings.Compare(a.Name, b.Name) }) {
+		t.Fatalf("expected functions sorted by name, got %v", docs)
+	}
+
+	byName := make(map[string]FunctionDoc)
+	for _, doc := range docs {
+		byName[doc.Name] = doc
+	}
+	for name := range builtinDocs {
+		if _, ok := byName[name]; !ok {
+			t.Errorf("expected built-in %s", name)
+		}
+	}
+	for name, doc := range byName {
+		if name != "greet" && name != "len" && (doc.Signature == "" || doc.Description == "") {
+			t.Errorf("expected built-in %s to be documented, got %+v", name, doc)
+		}
+	}
+
+	if doc := byName["greet"]; doc.Signature != "greet(name)" || doc.Description != "Greets someone." {
+		t.Fatalf("unexpected doc for greet: %+v", doc)
+	}
+	// Replacing a built-in replaces its documentation.
+	if doc := byName["len"]; doc != (FunctionDoc{Name: "len"}) {
+		t.Fatalf("unexpected doc for len: %+v", doc)
+	}
+
+	result, err := e.RunScript(`return greet("bob");`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unwrapReturn(t, result).Debug(); got != "hello bob" {
+		t.Fatalf("expected hello bob, got %s", got)
+	}
+
+	// Evaluators share the built-in functions, but not registered ones.
+	result, err = New().RunScript(`return len("abc");`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unwrapReturn(t, result).Debug(); got != "3" {
+		t.Fatalf("expected the built-in len of another evaluator, got %s", got)
+	}
+}
+
+func TestEvaluatorRegisterFunctionWhileRunning(t *testing.T) {
+	e := New()
+	program := newScriptContext(t, `let n = 0; foreach ([1, 2, 3] as v) { n += len([v]); } return n;`).Program
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			result, err := e.Evaluate(NewExecutionContext(program))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := result.(*ReturnValue).Value.Debug(); got != "3" {
+				t.Errorf("expected 3, got %s", got)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			e.RegisterFunction(fmt.Sprintf("f%d", i), func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+				return Null, nil
+			})
+		}(i)
+	}
+	wg.Wait()
+}
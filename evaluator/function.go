@@ -1,11 +1,127 @@
 package evaluator
 
-import "github.com/ironfang-ltd/go-script/parser"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/parser"
+)
 
 type Function func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error)
 
 type BuiltInFunction struct {
-	Fn Function
+	Name string
+	Fn   Function
+	// Doc describes the function for editors and other tools. Its Name is
+	// always the function's.
+	Doc FunctionDoc
+	// MinArgs and MaxArgs are the least and most arguments the function
+	// takes, with a MaxArgs of -1 for no most, so that a filter or method
+	// passed the wrong number says so before it runs. If both are zero,
+	// the function checks its own arguments.
+	MinArgs int
+	MaxArgs int
+}
+
+// newFunction creates the function doc.Name, taking the arguments its
+// signature says it does.
+func newFunction(doc FunctionDoc, fn Function) *BuiltInFunction {
+	least, most, _ := doc.arity()
+	return &BuiltInFunction{Name: doc.Name, Fn: fn, Doc: doc, MinArgs: least, MaxArgs: most}
+}
+
+// FunctionDoc documents a built-in or registered function.
+type FunctionDoc struct {
+	Name string
+	// Signature shows how the function is called, such as
+	// "split(str, delim)". Optional parameters end with a question mark
+	// and repeated ones with an ellipsis.
+	Signature   string
+	Description string
+}
+
+// arity returns the least and most arguments the signature of doc takes,
+// with a most of -1 if a parameter repeats, or false if doc has no
+// signature.
+func (doc FunctionDoc) arity() (least, most int, ok bool) {
+	open := strings.IndexByte(doc.Signature, '(')
+	end := strings.LastIndexByte(doc.Signature, ')')
+	if open < 0 || end < open {
+		return 0, 0, false
+	}
+	params := strings.TrimSpace(doc.Signature[open+1 : end])
+	if params == "" {
+		return 0, 0, true
+	}
+	for _, param := range strings.Split(params, ",") {
+		param = strings.TrimSpace(param)
+		switch {
+		case strings.HasSuffix(param, "..."):
+			return least, -1, true
+		case strings.HasSuffix(param, "?"):
+			most++
+		default:
+			least++
+			most++
+		}
+	}
+	return least, most, true
+}
+
+// arity returns the least and most arguments fn takes, or false if fn
+// checks its own arguments.
+func (fn *BuiltInFunction) arity() (least, most int, ok bool) {
+	return fn.MinArgs, fn.MaxArgs, fn.MinArgs != 0 || fn.MaxArgs != 0
+}
+
+// checkFilter checks that fn can be called with n arguments, the first
+// being the value piped into it, so that a filter given the wrong number
+// of arguments says so before it runs. Functions without a number of
+// arguments check their own.
+func checkFilter(fn Object, n int) error {
+	var name string
+	var least, most int
+	switch f := fn.(type) {
+	case *FunctionValue:
+		name, least, most = f.Name, len(f.Parameters), len(f.Parameters)
+		if name == "" {
+			name = anonymousFunction
+		}
+	case *BuiltInFunction:
+		var ok bool
+		if least, most, ok = f.arity(); !ok {
+			return nil
+		}
+		name = f.Name
+	default:
+		return newError(ErrNotCallable, "cannot pipe into %s: not a function", fn.Type())
+	}
+
+	if n >= least && (most < 0 || n <= most) {
+		return nil
+	}
+	return newError(ErrArgumentCount, "%s: expected %s, got %d including the piped value", name, argumentCount(least, most), n)
+}
+
+// argumentCount describes a number of arguments from least to most, with
+// -1 for no most.
+func argumentCount(least, most int) string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+	switch {
+	case most < 0:
+		return "at least " + plural(least)
+	case least == most:
+		return plural(least)
+	case least+1 == most:
+		return fmt.Sprintf("%d or %s", least, plural(most))
+	default:
+		return fmt.Sprintf("%d to %s", least, plural(most))
+	}
 }
 
 func (bif *BuiltInFunction) Type() ObjectType {
@@ -17,9 +133,11 @@ func (bif *BuiltInFunction) Debug() string {
 }
 
 type FunctionValue struct {
+	Name       string
 	Parameters []*parser.Identifier
 	Body       *parser.BlockStatement
 	Scope      *Scope
+	code       *code
 }
 
 func (f *FunctionValue) Debug() string {
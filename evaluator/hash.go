@@ -1,7 +1,5 @@
 package evaluator
 
-import "fmt"
-
 type Hashable interface {
 	HashKey() HashKey
 }
@@ -52,7 +50,7 @@ func (h *HashValue) HasKey(key Hashable) bool {
 func (h *HashValue) Set(key Object, value Object) error {
 	hashable, ok := key.(Hashable)
 	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", key.Type())
+		return newError(ErrTypeMismatch, "unusable as hash key: %s", key.Type())
 	}
 	hk := hashable.HashKey()
 	if _, exists := h.Pairs[hk]; !exists {
@@ -65,7 +63,7 @@ func (h *HashValue) Set(key Object, value Object) error {
 func (h *HashValue) Delete(key Object) error {
 	hashable, ok := key.(Hashable)
 	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", key.Type())
+		return newError(ErrTypeMismatch, "unusable as hash key: %s", key.Type())
 	}
 	hk := hashable.HashKey()
 	if _, exists := h.Pairs[hk]; !exists {
@@ -13,7 +13,16 @@ import (
 type Vars map[string]any
 
 // ToObject converts a Go value to a script Object.
+//
+// Structs and pointers to structs become hashes of their exported fields,
+// keyed by the field name or by a `script:"name"` tag. A `script:"-"` tag
+// skips the field. Wrap a value with WithMethods to also expose its exported
+// methods as functions.
 func ToObject(v any) (Object, error) {
+	return (&converter{}).convert(v)
+}
+
+func (c *converter) convert(v any) (Object, error) {
 	if v == nil {
 		return Null, nil
 	}
@@ -48,10 +57,12 @@ func ToObject(v any) (Object, error) {
 			return Null, nil
 		}
 		return &DateTimeValue{Value: *val}, nil
+	case methodValue:
+		return (&converter{methods: true, visiting: c.visiting}).convert(val.value)
 	case []any:
 		elements := make([]Object, len(val))
 		for i, elem := range val {
-			obj, err := ToObject(elem)
+			obj, err := c.convert(elem)
 			if err != nil {
 				return nil, fmt.Errorf("element [%d]: %w", i, err)
 			}
@@ -61,7 +72,7 @@ func ToObject(v any) (Object, error) {
 	case map[string]any:
 		hash := NewHashValue()
 		for k, v := range val {
-			obj, err := ToObject(v)
+			obj, err := c.convert(v)
 			if err != nil {
 				return nil, fmt.Errorf("key %q: %w", k, err)
 			}
@@ -71,34 +82,7 @@ func ToObject(v any) (Object, error) {
 		}
 		return hash, nil
 	default:
-		rv := reflect.ValueOf(v)
-		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
-			elements := make([]Object, rv.Len())
-			for i := range rv.Len() {
-				obj, err := ToObject(rv.Index(i).Interface())
-				if err != nil {
-					return nil, fmt.Errorf("element [%d]: %w", i, err)
-				}
-				elements[i] = obj
-			}
-			return &ArrayValue{Elements: elements}, nil
-		}
-		if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
-			hash := NewHashValue()
-			iter := rv.MapRange()
-			for iter.Next() {
-				k := iter.Key().String()
-				obj, err := ToObject(iter.Value().Interface())
-				if err != nil {
-					return nil, fmt.Errorf("key %q: %w", k, err)
-				}
-				if err := hash.Set(&StringValue{Value: k}, obj); err != nil {
-					return nil, err
-				}
-			}
-			return hash, nil
-		}
-		return nil, fmt.Errorf("unsupported type: %T", v)
+		return c.convertValue(reflect.ValueOf(v))
 	}
 }
 
@@ -131,26 +115,23 @@ func (e *Evaluator) RunScript(source string, vars ...Vars) (Object, error) {
 		return nil, err
 	}
 
-	return e.Evaluate(ctx)
+	// The program is run only once, so its code is not worth caching.
+	compiled, err := compileProgram(program, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.evaluate(ctx, compiled)
 }
 
 // RunTemplate parses and evaluates source as a template, returning the output string.
 func (e *Evaluator) RunTemplate(source string, vars ...Vars) (string, error) {
-	l := lexer.NewTemplate(source)
-	p := parser.New(l)
-	program, err := p.Parse()
+	t, err := e.Compile(source)
 	if err != nil {
 		return "", err
 	}
 
-	ctx := NewExecutionContext(program)
-	ctx.Source = source
-
-	if err := applyVars(ctx.RootScope, vars); err != nil {
-		return "", err
-	}
-
-	return e.EvaluateString(ctx)
+	return t.Render(vars...)
 }
 
 // RunScript creates a fresh evaluator and evaluates source as a script.
@@ -277,7 +277,7 @@ func TestToObject_TypedMapStringString(t *testing.T) {
 }
 
 func TestToObject_TypedSliceNestedError(t *testing.T) {
-	input := []struct{ X int }{{1}}
+	input := []chan int{make(chan int)}
 	_, err := ToObject(input)
 	if err == nil {
 		t.Fatal("expected error for unsupported nested type in typed slice")
@@ -285,21 +285,21 @@ func TestToObject_TypedSliceNestedError(t *testing.T) {
 }
 
 func TestToObject_UnsupportedType(t *testing.T) {
-	_, err := ToObject(struct{}{})
+	_, err := ToObject(make(chan int))
 	if err == nil {
 		t.Fatal("expected error for unsupported type")
 	}
 }
 
 func TestToObject_SliceNestedError(t *testing.T) {
-	_, err := ToObject([]any{struct{}{}})
+	_, err := ToObject([]any{make(chan int)})
 	if err == nil {
 		t.Fatal("expected error for unsupported nested type")
 	}
 }
 
 func TestToObject_MapNestedError(t *testing.T) {
-	_, err := ToObject(map[string]any{"bad": struct{}{}})
+	_, err := ToObject(map[string]any{"bad": make(chan int)})
 	if err == nil {
 		t.Fatal("expected error for unsupported nested type")
 	}
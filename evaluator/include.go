@@ -0,0 +1,240 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+)
+
+// evaluateTemplateProgram evaluates ctx.program in template mode and then,
+// for as long as the rendered template extends a layout, the layout itself.
+func (e *Evaluator) evaluateTemplateProgram(ctx *ExecutionContext, scope *Scope) (Object, error) {
+	for {
+		compiled := ctx.code
+		if compiled == nil {
+			var err error
+			if compiled, err = compile(ctx.program, true); err != nil {
+				return nil, err
+			}
+		}
+		ctx.cover(compiled)
+
+		result, returned, err := e.run(ctx, compiled, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if returned {
+			return &ReturnValue{Value: result}, nil
+		}
+
+		if ctx.extends == "" {
+			return Null, nil
+		}
+
+		if err := e.enterLayout(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// enterLayout switches ctx over to the layout named by the last evaluated
+// extends statement, restoring the output that the child template's own
+// text was diverted from.
+func (e *Evaluator) enterLayout(ctx *ExecutionContext) error {
+	name := ctx.extends
+
+	if err := checkTemplateCycle(ctx, name); err != nil {
+		return runtimeError(ctx, ctx.extendsToken, fmt.Errorf("extends: %w", err))
+	}
+
+	layout, err := ctx.Templates.Get(name)
+	if err != nil {
+		return runtimeError(ctx, ctx.extendsToken, fmt.Errorf("extends: %w", err))
+	}
+
+	ctx.templateStack = append(ctx.templateStack, name)
+	ctx.program = layout.Program
+	ctx.code = layout.code
+	ctx.source = layout.Source
+	ctx.template = name
+	ctx.output = ctx.layoutOutput
+	ctx.layoutOutput = nil
+	ctx.extends = ""
+
+	return nil
+}
+
+func checkTemplateCycle(ctx *ExecutionContext, name string) error {
+	if !slices.Contains(ctx.templateStack, name) {
+		return nil
+	}
+
+	chain := append(slices.Clone(ctx.templateStack), name)
+	return fmt.Errorf("%w: %s", ErrTemplateCycle, strings.Join(chain, " -> "))
+}
+
+// includeDepthError is the error for an include of name that goes deeper
+// than MaxDepth. If the includes have looped back on themselves, it is
+// reported as a cycle, from the first template to repeat.
+func includeDepthError(ctx *ExecutionContext, name string) error {
+	err := newError(ErrDepthLimit, "maximum call depth exceeded: %d", ctx.MaxDepth)
+
+	stack := append(slices.Clone(ctx.templateStack), name)
+	for j := range stack {
+		if i := slices.Index(stack[:j], stack[j]); i >= 0 {
+			return fmt.Errorf("%w: %s: %w", ErrTemplateCycle, strings.Join(stack[i:j+1], " -> "), err)
+		}
+	}
+	return err
+}
+
+// extend records that the template being rendered extends the layout
+// named by val, which is rendered once the template finishes.
+func (ctx *ExecutionContext) extend(val Object, token lexer.Token) error {
+	if ctx.Templates == nil {
+		return errors.New("extends: no template set configured")
+	}
+
+	if ctx.extends != "" {
+		return fmt.Errorf("extends: template already extends %q", ctx.extends)
+	}
+
+	name, ok := val.(*StringValue)
+	if !ok {
+		return newError(ErrTypeMismatch, "extends: template name must be a string, got %s", val.Type())
+	}
+
+	// The child's own output is discarded; only its blocks reach the layout.
+	ctx.extends = name.Value
+	ctx.extendsToken = token
+	ctx.layoutOutput = ctx.output
+	ctx.output = io.Discard
+
+	return nil
+}
+
+// blockOverride is a block collected from a template that extends a layout,
+// along with the template it came from.
+type blockOverride struct {
+	body     *code
+	source   string
+	template string
+}
+
+// evaluateNamedBlock evaluates nb, or the override collected for it, in
+// scope. It reports whether the block ended with a return statement.
+func (e *Evaluator) evaluateNamedBlock(ctx *ExecutionContext, nb *namedBlock, scope *Scope) (Object, bool, error) {
+	name := nb.name
+
+	// While rendering a template that extends a layout, blocks are only
+	// collected. The most derived template's definition wins.
+	if ctx.extends != "" {
+		if ctx.blocks == nil {
+			ctx.blocks = make(map[string]*blockOverride)
+		}
+		if _, ok := ctx.blocks[name]; !ok {
+			ctx.blocks[name] = &blockOverride{
+				body:     nb.body,
+				source:   ctx.source,
+				template: ctx.template,
+			}
+		}
+		return Null, false, nil
+	}
+
+	body := nb.body
+	if override, ok := ctx.blocks[name]; ok {
+		// The override is positioned in the template that defined it.
+		body = override.body
+		source, template := ctx.source, ctx.template
+		ctx.source, ctx.template = override.source, override.template
+		defer func() { ctx.source, ctx.template = source, template }()
+	}
+
+	return e.run(ctx, body, scope)
+}
+
+// evaluateInclude renders another template from ctx.Templates into the
+// current output. The included template sees the root scope of the
+// including template plus the optional vars hash, and its own variables
+// do not leak back out.
+func (e *Evaluator) evaluateInclude(ctx *ExecutionContext, args []Object) (Object, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(ErrArgumentCount, "include: expected 1 or 2 arguments, got %d", len(args))
+	}
+
+	name, ok := args[0].(*StringValue)
+	if !ok {
+		return nil, newError(ErrTypeMismatch, "include: first argument must be a string, got %s", args[0].Type())
+	}
+
+	if ctx.Templates == nil {
+		return nil, fmt.Errorf("include: no template set configured")
+	}
+
+	// A template may include itself, as a partial rendering a tree does,
+	// so includes count towards MaxDepth like calls. Without a MaxDepth,
+	// nothing would stop a template that includes itself endlessly.
+	if ctx.MaxDepth > 0 {
+		ctx.depth++
+		defer func() { ctx.depth-- }()
+		if ctx.depth > ctx.MaxDepth {
+			return nil, fmt.Errorf("include: %w", includeDepthError(ctx, name.Value))
+		}
+	} else if err := checkTemplateCycle(ctx, name.Value); err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+
+	includeScope := NewChildScope(ctx.RootScope)
+
+	if len(args) == 2 {
+		vars, ok := args[1].(*HashValue)
+		if !ok {
+			return nil, newError(ErrTypeMismatch, "include: second argument must be a hash, got %s", args[1].Type())
+		}
+		for _, pair := range vars.OrderedPairs() {
+			key, ok := pair.Key.(*StringValue)
+			if !ok {
+				return nil, newError(ErrTypeMismatch, "include: variable names must be strings, got %s", pair.Key.Type())
+			}
+			includeScope.SetLocal(key.Value, pair.Value)
+		}
+	}
+
+	included, err := ctx.Templates.Get(name.Value)
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+
+	program, compiled, source, stack := ctx.program, ctx.code, ctx.source, ctx.templateStack
+	extends, layoutOutput, blocks := ctx.extends, ctx.layoutOutput, ctx.blocks
+	template := ctx.template
+
+	ctx.program, ctx.code, ctx.source, ctx.template = included.Program, included.code, included.Source, name.Value
+	ctx.templateStack = append(slices.Clone(stack), name.Value)
+	ctx.extends, ctx.layoutOutput, ctx.blocks = "", nil, nil
+
+	// The included template runs as a <main> frame of its own, called from
+	// include.
+	ctx.frames = append(ctx.frames, callFrame{function: mainFunction, template: template, token: ctx.callSite()})
+	_, err = e.evaluateTemplateProgram(ctx, includeScope)
+	if err != nil {
+		err = ctx.traceError(err, ctx.callSite())
+	}
+	ctx.popFrame()
+
+	ctx.program, ctx.code, ctx.source, ctx.templateStack = program, compiled, source, stack
+	ctx.extends, ctx.layoutOutput, ctx.blocks = extends, layoutOutput, blocks
+	ctx.template = template
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Null, nil
+}
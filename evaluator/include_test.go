@@ -0,0 +1,426 @@
+package evaluator
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func renderFromLoader(t *testing.T, templates MapLoader, name string, vars ...Vars) string {
+	t.Helper()
+	set := NewTemplateSetWithLoader(New(), templates)
+	output, err := set.Render(name, vars...)
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	return output
+}
+
+func renderFromLoaderError(t *testing.T, templates MapLoader, name string) error {
+	t.Helper()
+	set := NewTemplateSetWithLoader(New(), templates)
+	_, err := set.Render(name)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	return err
+}
+
+func TestMapLoader(t *testing.T) {
+	loader := MapLoader{"a": "hello"}
+	source, err := loader.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "hello" {
+		t.Fatalf("expected 'hello', got %q", source)
+	}
+	if _, err := loader.Load("missing"); err == nil || !strings.Contains(err.Error(), "template not found: missing") {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
+
+func TestFSLoader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pages/home.html":      {Data: []byte(`{% include("partials/nav") %}<main>home</main>`)},
+		"partials/nav.html":    {Data: []byte(`<nav>{% title %}</nav>`)},
+		"partials/broken.html": {Data: []byte(`{% let = %}`)},
+	}
+	set := NewTemplateSetWithLoader(New(), NewFSLoader(fsys, ".html"))
+
+	output, err := set.Render("pages/home", Vars{"title": "Site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<nav>Site</nav><main>home</main>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+
+	_, err = set.Render("missing")
+	if !errors.Is(err, ErrTemplateNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrTemplateNotFound and fs.ErrNotExist, got: %v", err)
+	}
+
+	_, err = set.Render("../escape")
+	if err == nil || !strings.Contains(err.Error(), "invalid template name") {
+		t.Fatalf("expected invalid name error, got: %v", err)
+	}
+
+	_, err = set.Render("partials/broken")
+	if err == nil || !strings.Contains(err.Error(), `template "partials/broken"`) {
+		t.Fatalf("expected parse error naming the template, got: %v", err)
+	}
+}
+
+func TestFSLoaderIncludeMissing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pages/home.html": {Data: []byte(`{% try { include("partials/missing"); } catch (e) { %}{% e.kind %}{% } %}`)},
+	}
+	set := NewTemplateSetWithLoader(New(), NewFSLoader(fsys, ".html"))
+
+	output, err := set.Render("pages/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "TemplateNotFound" {
+		t.Fatalf("expected a TemplateNotFound error, got %q", output)
+	}
+
+	fsys["pages/plain.html"] = &fstest.MapFile{Data: []byte(`{% include("partials/missing") %}`)}
+	_, err = set.Render("pages/plain")
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Fatalf("expected ErrTemplateNotFound, got: %v", err)
+	}
+}
+
+func TestTemplateSetLoaderCaches(t *testing.T) {
+	loader := MapLoader{"a": "one"}
+	set := NewTemplateSetWithLoader(New(), loader)
+
+	if output, _ := set.Render("a"); output != "one" {
+		t.Fatalf("expected 'one', got %q", output)
+	}
+
+	loader["a"] = "two"
+	if output, _ := set.Render("a"); output != "one" {
+		t.Fatalf("expected cached 'one', got %q", output)
+	}
+
+	set.Invalidate("a")
+	if output, _ := set.Render("a"); output != "two" {
+		t.Fatalf("expected reloaded 'two', got %q", output)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"page":   `<h1>{% title %}</h1>{% include("footer") %}`,
+		"footer": `<footer>{% title %}</footer>`,
+	}, "page", Vars{"title": "Home"})
+
+	if output != "<h1>Home</h1><footer>Home</footer>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeWithVars(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"list": `{% foreach (items as item) { %}{% include("item", {"name": item}) %}{% } %}`,
+		"item": `<li>{% name %}</li>`,
+	}, "list", Vars{"items": []any{"a", "b"}})
+
+	if output != "<li>a</li><li>b</li>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeDoesNotLeakVariables(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"page":    `{% let x = "outer"; %}{% include("partial") %}{% x %}`,
+		"partial": `{% let x = "inner"; %}{% x %}-`,
+	}, "page")
+
+	if output != "inner-outer" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeNested(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"a": `a[{% include("b") %}]`,
+		"b": `b[{% include("c") %}]`,
+		"c": `c`,
+	}, "a")
+
+	if output != "a[b[c]]" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeSameTemplateTwice(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"page": `{% include("x") %}{% include("x") %}`,
+		"x":    `x`,
+	}, "page")
+
+	if output != "xx" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	err := renderFromLoaderError(t, MapLoader{
+		"a": `{% include("b") %}`,
+		"b": `{% include("a") %}`,
+	}, "a")
+
+	if !strings.Contains(err.Error(), "template cycle detected: a -> b -> a") {
+		t.Fatalf("expected cycle error, got: %v", err)
+	}
+}
+
+func TestIncludeSelf(t *testing.T) {
+	err := renderFromLoaderError(t, MapLoader{"a": `{% include("a") %}`}, "a")
+	if !strings.Contains(err.Error(), "template cycle detected: a -> a") {
+		t.Fatalf("expected cycle error, got: %v", err)
+	}
+}
+
+func TestIncludeRecursive(t *testing.T) {
+	tree := map[string]any{
+		"name": "root",
+		"children": []any{
+			map[string]any{"name": "a", "children": []any{
+				map[string]any{"name": "b", "children": []any{}},
+			}},
+			map[string]any{"name": "c", "children": []any{}},
+		},
+	}
+
+	output := renderFromLoader(t, MapLoader{
+		"page": `{% include("node", {"node": tree}) %}`,
+		"node": `{% node.name %}[{% foreach (node.children as child) { %}{% include("node", {"node": child}) %}{% } %}]`,
+	}, "page", Vars{"tree": tree})
+
+	if output != "root[a[b[]]c[]]" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeRecursionDepth(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{"a": `{% include("a") %}`})
+	tmpl, err := set.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxDepth int
+		limit    bool
+	}{
+		{10, true},
+		{0, false},
+	}
+
+	for _, tt := range tests {
+		ctx, err := tmpl.NewExecutionContext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.MaxDepth = tt.maxDepth
+
+		_, err = tmpl.RenderContext(ctx)
+		if !errors.Is(err, ErrTemplateCycle) || errors.Is(err, ErrDepthLimit) != tt.limit {
+			t.Fatalf("MaxDepth %d: unexpected error: %v", tt.maxDepth, err)
+		}
+	}
+}
+
+func TestIncludeMissing(t *testing.T) {
+	err := renderFromLoaderError(t, MapLoader{"a": `{% include("nope") %}`}, "a")
+	if !strings.Contains(err.Error(), "template not found: nope") {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
+
+func TestIncludeWithoutTemplateSet(t *testing.T) {
+	_, err := RunTemplate(`{% include("x") %}`)
+	if err == nil || !strings.Contains(err.Error(), "include: no template set configured") {
+		t.Fatalf("expected no template set error, got: %v", err)
+	}
+}
+
+func TestIncludeBadArgs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{% include() %}`, "include: expected 1 or 2 arguments, got 0"},
+		{`{% include(1) %}`, "include: first argument must be a string"},
+		{`{% include("x", 1) %}`, "include: second argument must be a hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := renderFromLoaderError(t, MapLoader{"page": tt.input, "x": ""}, "page")
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestExtends(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"layout": `<title>{% block title { %}Default{% } %}</title><body>{% block body { %}{% } %}</body>`,
+		"page":   `{% extends "layout"; %}ignored{% block body { %}<p>{% msg %}</p>{% } %}`,
+	}, "page", Vars{"msg": "hi"})
+
+	if output != "<title>Default</title><body><p>hi</p></body>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestExtendsMultiLevel(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"base":    `[{% block header { %}base header{% } %}|{% block content { %}base content{% } %}]`,
+		"section": `{% extends "base"; %}{% block header { %}section header{% } %}{% block content { %}section content{% } %}`,
+		"page":    `{% extends "section"; %}{% block content { %}page content{% } %}`,
+	}, "page")
+
+	if output != "[section header|page content]" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestExtendsNestedBlocks(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"layout": `{% block outer { %}<{% block inner { %}default{% } %}>{% } %}`,
+		"page":   `{% extends "layout"; %}{% block inner { %}custom{% } %}`,
+	}, "page")
+
+	if output != "<custom>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestExtendsSharesRootScope(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"layout": `{% block body { %}{% } %}|{% title %}`,
+		"page":   `{% extends "layout"; let title = "Page"; %}{% block body { %}{% title %}{% } %}`,
+	}, "page")
+
+	if output != "Page|Page" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestExtendsDynamicName(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"a":    `A:{% block b { %}{% } %}`,
+		"page": `{% extends layout; %}{% block b { %}x{% } %}`,
+	}, "page", Vars{"layout": "a"})
+
+	if output != "A:x" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestExtendsIncludeInBlock(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"layout":  `<main>{% block body { %}{% } %}</main>`,
+		"page":    `{% extends "layout"; %}{% block body { %}{% include("partial") %}{% } %}`,
+		"partial": `<p>partial</p>`,
+	}, "page")
+
+	if output != "<main><p>partial</p></main>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestIncludeTemplateThatExtends(t *testing.T) {
+	output := renderFromLoader(t, MapLoader{
+		"page":  `before {% include("card") %} after`,
+		"card":  `{% extends "frame"; %}{% block inner { %}card{% } %}`,
+		"frame": `[{% block inner { %}{% } %}]`,
+	}, "page")
+
+	if output != "before [card] after" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestBlockWithoutExtendsRendersBody(t *testing.T) {
+	output, err := RunTemplate(`<p>{% block greeting { %}hello {% name %}{% } %}</p>`, Vars{"name": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<p>hello bob</p>" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestExtendsCycle(t *testing.T) {
+	err := renderFromLoaderError(t, MapLoader{
+		"a": `{% extends "b"; %}`,
+		"b": `{% extends "a"; %}`,
+	}, "a")
+
+	if !strings.Contains(err.Error(), "template cycle detected: a -> b -> a") {
+		t.Fatalf("expected cycle error, got: %v", err)
+	}
+}
+
+func TestExtendsTwice(t *testing.T) {
+	err := renderFromLoaderError(t, MapLoader{
+		"a":    ``,
+		"page": `{% extends "a"; extends "a"; %}`,
+	}, "page")
+
+	if !strings.Contains(err.Error(), `template already extends "a"`) {
+		t.Fatalf("expected duplicate extends error, got: %v", err)
+	}
+}
+
+func TestExtendsNonString(t *testing.T) {
+	err := renderFromLoaderError(t, MapLoader{"page": `{% extends 1; %}`}, "page")
+	if !strings.Contains(err.Error(), "extends: template name must be a string, got INTEGER") {
+		t.Fatalf("expected type error, got: %v", err)
+	}
+}
+
+func TestExtendsWithoutTemplateSet(t *testing.T) {
+	_, err := RunTemplate(`{% extends "layout"; %}`)
+	if err == nil || !strings.Contains(err.Error(), "extends: no template set configured") {
+		t.Fatalf("expected no template set error, got: %v", err)
+	}
+}
+
+func TestExtendsAndBlockAsNames(t *testing.T) {
+	// extends and block are only keywords where a template statement
+	// starts, so scripts and templates using them as names still work.
+	output := evalTemplate(t, `{% let block = {"block": 1, "extends": 2}; %}{% block.block + block.extends %},{% block.len() %}`)
+	if output != `3,2` {
+		t.Fatalf("unexpected output: %q", output)
+	}
+	expectDebug(t, unwrapReturn(t, evalScript(t, `let extends = 1; extends += 1; return extends;`)), "2")
+}
+
+func TestExtendsEscaping(t *testing.T) {
+	e := New()
+	e.Escaping = HTMLEscaping
+	set := NewTemplateSetWithLoader(e, MapLoader{
+		"layout": `<a href="{% block href { %}{% } %}">{% block text { %}{% } %}</a>`,
+		"page":   `{% extends "layout"; %}{% block href { %}{% url %}{% } %}{% block text { %}{% url %}{% } %}`,
+	})
+
+	output, err := set.Render("page", Vars{"url": "javascript:x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != `<a href="#ZgotmplZ">javascript:x</a>` {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
@@ -0,0 +1,57 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Loader resolves a template name to its source. TemplateSet uses a
+// Loader to fetch templates that are not yet cached, such as the targets
+// of include and extends.
+type Loader interface {
+	Load(name string) (string, error)
+}
+
+// MapLoader loads templates from an in-memory map of name to source.
+type MapLoader map[string]string
+
+func (m MapLoader) Load(name string) (string, error) {
+	source, ok := m[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return source, nil
+}
+
+// FSLoader loads templates from a file system. Extension, if set, is
+// appended to template names, so "partials/header" with an extension of
+// ".html" loads "partials/header.html".
+type FSLoader struct {
+	FS        fs.FS
+	Extension string
+}
+
+func NewFSLoader(fsys fs.FS, extension string) *FSLoader {
+	return &FSLoader{
+		FS:        fsys,
+		Extension: extension,
+	}
+}
+
+func (l *FSLoader) Load(name string) (string, error) {
+	path := name + l.Extension
+	if !fs.ValidPath(path) {
+		return "", fmt.Errorf("invalid template name: %s", name)
+	}
+
+	data, err := fs.ReadFile(l.FS, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s: %w", ErrTemplateNotFound, name, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("template %q: %w", name, err)
+	}
+
+	return string(data), nil
+}
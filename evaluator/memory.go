@@ -0,0 +1,65 @@
+package evaluator
+
+// Approximate sizes in bytes used for MaxMemory accounting. They only need
+// to be in the right ballpark: the budget is meant to stop runaway scripts,
+// not to mirror the Go heap.
+const (
+	stringHeaderSize = 16
+	valueSize        = 16
+	arrayHeaderSize  = 24
+	hashHeaderSize   = 48
+	hashEntrySize    = 64
+)
+
+// allocate charges n bytes against MaxMemory. Memory is counted as it is
+// allocated over the whole evaluation and is never credited back, so the
+// total also covers intermediate values that are no longer referenced.
+func (ctx *ExecutionContext) allocate(n int) error {
+	if ctx.MaxMemory <= 0 {
+		return nil
+	}
+
+	ctx.memory += n
+	if ctx.memory > ctx.MaxMemory {
+		return &MemoryLimitError{Limit: ctx.MaxMemory}
+	}
+
+	return nil
+}
+
+// allocString charges for a new string of n bytes.
+func (ctx *ExecutionContext) allocString(n int) error {
+	return ctx.allocate(stringHeaderSize + n)
+}
+
+// allocStrings charges for count new strings totalling n bytes.
+func (ctx *ExecutionContext) allocStrings(count, n int) error {
+	return ctx.allocate(count*stringHeaderSize + n)
+}
+
+// allocArray charges for a new array of n elements.
+func (ctx *ExecutionContext) allocArray(n int) error {
+	return ctx.allocate(arrayHeaderSize + n*valueSize)
+}
+
+// allocHash charges for a new hash of n entries.
+func (ctx *ExecutionContext) allocHash(n int) error {
+	return ctx.allocate(hashHeaderSize + n*hashEntrySize)
+}
+
+// allocHashSet charges for setting key in hash, which only allocates when
+// the key is new.
+func (ctx *ExecutionContext) allocHashSet(hash *HashValue, key Object) error {
+	if k, ok := key.(Hashable); ok {
+		if _, exists := hash.Pairs[k.HashKey()]; exists {
+			return nil
+		}
+	}
+	return ctx.allocate(hashEntrySize)
+}
+
+// MemoryUsed returns the approximate number of bytes charged against
+// MaxMemory so far. It is only tracked while MaxMemory is set.
+func (ctx *ExecutionContext) MemoryUsed() int {
+	return ctx.memory
+}
@@ -0,0 +1,119 @@
+package evaluator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func evalScriptWithMemory(t *testing.T, input string, maxMemory int, vars ...Vars) error {
+	t.Helper()
+	ctx := newScriptContext(t, input)
+	ctx.MaxMemory = maxMemory
+	ctx.MaxSteps = 0
+	ctx.MaxArraySize = 0
+	if err := applyVars(ctx.RootScope, vars); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New().Evaluate(ctx)
+	return err
+}
+
+func TestMaxMemory(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"string doubling", `let s = "x"; while (true) { s = s + s; }`},
+		{"string coercion", `let s = "x"; while (true) { s = s + 1; }`},
+		{"compound assignment", `let s = "x"; while (true) { s += s; }`},
+		{"interpolation", "let s = \"x\"; while (true) { s = `${s}${s}`; }"},
+		{"array literals", `let a = []; while (true) { a = [a, a, a, a]; }`},
+		{"append", `let a = []; while (true) { append(a, 1); }`},
+		{"hash growth", `let h = {}; let i = 0; while (true) { h[toString(i)] = i; i += 1; }`},
+		{"hash property growth", `let h = {}; let i = 0; while (true) { h.x = {"a": 1, "b": 2}; i += 1; }`},
+		{"split", `split(big, "");`},
+		{"replace", `replace(big, "a", big);`},
+		{"join", `let a = [big, big, big]; while (true) { a = [join(a, big)]; }`},
+		{"map", `let a = split(big, ""); while (true) { map(a, fn(x) { return x; }); }`},
+		{"toUpper", `while (true) { toUpper(big); }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := evalScriptWithMemory(t, tt.input, 1<<20, Vars{"big": strings.Repeat("a", 100_000)})
+
+			var memErr *MemoryLimitError
+			if !errors.As(err, &memErr) {
+				t.Fatalf("expected *MemoryLimitError, got: %v", err)
+			}
+			if memErr.Limit != 1<<20 {
+				t.Fatalf("expected limit %d, got %d", 1<<20, memErr.Limit)
+			}
+			if memErr.Error() != "memory limit exceeded: 1048576 bytes" {
+				t.Fatalf("unexpected message: %q", memErr.Error())
+			}
+		})
+	}
+}
+
+func TestMaxMemoryChecksBeforeAllocating(t *testing.T) {
+	// 100,000 copies of a 100,000 byte string would need 10GB.
+	err := evalScriptWithMemory(t, `replace(big, "a", big);`, 1<<20, Vars{"big": strings.Repeat("a", 100_000)})
+	var memErr *MemoryLimitError
+	if !errors.As(err, &memErr) {
+		t.Fatalf("expected *MemoryLimitError, got: %v", err)
+	}
+}
+
+func TestMaxMemoryNotReached(t *testing.T) {
+	err := evalScriptWithMemory(t, `
+let s = "";
+let h = {};
+foreach (split("a,b,c", ",") as part) {
+	s = s + toUpper(part);
+	h[part] = len(s);
+}
+return join(keys(h), s);`, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxMemoryHashReassignmentIsFree(t *testing.T) {
+	ctx := newScriptContext(t, `let h = {"n": 0}; while (h.n < 1000) { h.n = h.n + 1; h["n"] = h.n; }`)
+	ctx.MaxMemory = 1 << 20
+
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.MemoryUsed() > 1024 {
+		t.Fatalf("expected reassigning existing keys not to be charged, used %d bytes", ctx.MemoryUsed())
+	}
+}
+
+func TestMaxMemoryDisabled(t *testing.T) {
+	err := evalScriptWithMemory(t, `let s = "x"; let i = 0; while (i < 20) { s = s + s; i += 1; }`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxMemoryTemplateOutput(t *testing.T) {
+	ctx := newTemplateContext(t, `{% foreach (items as i) { %}0123456789{% } %}`, Vars{"items": make([]any, 1000)})
+	ctx.MaxMemory = 5_000
+	ctx.MaxOutputBytes = 0
+
+	_, err := New().EvaluateString(ctx)
+	var memErr *MemoryLimitError
+	if !errors.As(err, &memErr) {
+		t.Fatalf("expected *MemoryLimitError, got: %v", err)
+	}
+}
+
+func TestMaxMemoryDefault(t *testing.T) {
+	ctx := newScriptContext(t, `return 1;`)
+	if ctx.MaxMemory != 0 || ctx.MaxOutputBytes != 0 {
+		t.Fatalf("expected MaxMemory and MaxOutputBytes to be off by default, got %d and %d", ctx.MaxMemory, ctx.MaxOutputBytes)
+	}
+}
@@ -0,0 +1,151 @@
+package evaluator
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// methodTable holds the methods of each type by name. A method is a
+// function called with the value it is a method of as its first argument,
+// so that s.toUpper() calls toUpper(s).
+type methodTable map[ObjectType]functionTable
+
+// builtinMethods names the built-in functions that are also methods of
+// each type.
+var builtinMethods = map[ObjectType][]string{
+	StringObject: {
+		"len", "split", "trim", "toUpper", "toLower", "contains", "startsWith", "endsWith",
+		"indexOf", "replace", "substring", "parseInt", "parseFloat", "toString",
+	},
+	ArrayObject:    {"len", "append", "join", "map", "filter"},
+	HashObject:     {"len", "keys", "values"},
+	IntegerObject:  {"floor", "ceil", "round", "abs", "toString"},
+	DecimalObject:  {"floor", "ceil", "round", "abs", "toString"},
+	BooleanObject:  {"toString"},
+	DateTimeObject: {"toString"},
+}
+
+// builtinMethodTable holds the built-in methods, shared like
+// builtinFunctions.
+var builtinMethodTable = newMethodTable(builtinFunctions)
+
+// newMethodTable creates the built-in methods from the built-in functions.
+func newMethodTable(functions functionTable) methodTable {
+	methods := make(methodTable)
+	for t, names := range builtinMethods {
+		methods[t] = make(functionTable)
+		for _, name := range names {
+			methods[t][name] = functions[name]
+		}
+	}
+
+	methods[DateTimeObject]["format"] = &BuiltInFunction{
+		Name: "format",
+		Fn:   formatDateTime,
+		Doc: FunctionDoc{
+			Name:        "format",
+			Signature:   "format(dt, layout)",
+			Description: "Formats a date and time with a Go layout, such as \"2006-01-02 15:04\".",
+		},
+		MinArgs: 2,
+		MaxArgs: 2,
+	}
+
+	return methods
+}
+
+func formatDateTime(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+	if len(args) != 2 {
+		return nil, newError(ErrArgumentCount, "format: expected 2 arguments, got %d", len(args))
+	}
+	dt, ok := args[0].(*DateTimeValue)
+	if !ok {
+		return nil, newError(ErrTypeMismatch, "format: first argument must be a datetime, got %s", args[0].Type())
+	}
+	layout, ok := args[1].(*StringValue)
+	if !ok {
+		return nil, newError(ErrTypeMismatch, "format: second argument must be a string, got %s", args[1].Type())
+	}
+	s := dt.Value.Format(layout.Value)
+	if err := ctx.allocString(len(s)); err != nil {
+		return nil, err
+	}
+	return &StringValue{Value: s}, nil
+}
+
+// RegisterMethod makes fn callable as the method name of values of type t,
+// replacing any method t already has under that name. fn is called with
+// the value as its first argument, followed by the arguments of the call,
+// so that after RegisterMethod(StringObject, "slug", fn), s.slug("-")
+// calls fn with s and "-". Like RegisterFunction, it is safe to call while
+// the evaluator is in use, but panics once the evaluator has been frozen.
+//
+// The properties of a hash take precedence over the methods of HashObject.
+func (e *Evaluator) RegisterMethod(t ObjectType, name string, fn Function) {
+	e.RegisterMethodWithDoc(t, FunctionDoc{Name: name}, fn)
+}
+
+// RegisterMethodWithDoc is like RegisterMethod, registering fn as doc.Name
+// with documentation. The signature is that of fn, with the value the
+// method is called on as its first parameter, such as "slug(str, sep?)",
+// and checks the number of arguments a call passes the method.
+func (e *Evaluator) RegisterMethodWithDoc(t ObjectType, doc FunctionDoc, fn Function) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.frozen {
+		panic(fmt.Sprintf("evaluator: RegisterMethod(%s, %q) called on a frozen Evaluator", t, doc.Name))
+	}
+
+	methods := maps.Clone(*e.methods.Load())
+	methods[t] = maps.Clone(methods[t])
+	if methods[t] == nil {
+		methods[t] = make(functionTable)
+	}
+	methods[t][doc.Name] = newFunction(doc, fn)
+	e.methods.Store(&methods)
+}
+
+// Methods documents the built-in and registered methods of type t, sorted
+// by name.
+func (e *Evaluator) Methods(t ObjectType) []FunctionDoc {
+	methods := (*e.methods.Load())[t]
+	docs := make([]FunctionDoc, 0, len(methods))
+	for _, fn := range methods {
+		docs = append(docs, fn.Doc)
+	}
+	slices.SortFunc(docs, func(a, b FunctionDoc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return docs
+}
+
+// method returns the method key of receiver, if the type of receiver has
+// one and receiver is not a hash with a property key, which takes
+// precedence.
+func (e *Evaluator) method(receiver Object, key propertyKey) (*BuiltInFunction, bool) {
+	if hash, ok := receiver.(*HashValue); ok {
+		if _, ok := hash.Pairs[key.hash]; ok {
+			return nil, false
+		}
+	}
+	fn, ok := (*e.methods.Load())[receiver.Type()][key.name.Value]
+	return fn, ok
+}
+
+// checkMethod checks that the method fn can be called with n arguments,
+// besides the value it is called on.
+func checkMethod(fn *BuiltInFunction, n int) error {
+	least, most, ok := fn.arity()
+	if !ok || n+1 >= least && (most < 0 || n+1 <= most) {
+		return nil
+	}
+
+	least = max(least-1, 0)
+	if most > 0 {
+		most--
+	}
+	return newError(ErrArgumentCount, "%s: expected %s, got %d", fn.Name, argumentCount(least, most), n)
+}
@@ -0,0 +1,197 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return " Ada ".trim().toUpper();`, "ADA"},
+		{`return "a,b,c".split(",").len();`, "3"},
+		{`return "goscript".substring(0, 2);`, "go"},
+		{`return "goscript".startsWith("go");`, "true"},
+		{`return "42".parseInt() + 1;`, "43"},
+		{`return [1, 2, 3].map(fn(x) { return x * 2; }).join(",");`, "2,4,6"},
+		{`return [1, 2, 3, 4].filter(fn(x) { return x % 2 == 0; }).len();`, "2"},
+		{`let arr = [1]; arr.append(2); return len(arr);`, "2"},
+		{`return join({"a": 1, "b": 2}.keys(), ",");`, "a,b"},
+		{`return {"a": 1, "b": 2}.values().len();`, "2"},
+		{`return (2.5).floor();`, "2"},
+		{`return (-3).abs().toString() + "!";`, "3!"},
+		{`return true.toString();`, "true"},
+		{`return "ada"?.toUpper();`, "ADA"},
+		{`let name = null; return name?.toUpper();`, "null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			if val.Debug() != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, val.Debug())
+			}
+		})
+	}
+}
+
+func TestMethodsOnlyCalled(t *testing.T) {
+	// Methods are only looked up by calls, so reading a missing property
+	// with the name of a method still reads null.
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return {"a": 1}.keys;`, "null"},
+		{`let h = {"a": 1}; return h.values;`, "null"},
+		{`let h = {"a": 1}; return h.len ?? "none";`, "none"},
+		{`return "abc".len;`, "null"},
+		{`if ("abc".len) { return "truthy"; } return "falsy";`, "falsy"},
+		{`let h = {"len": 5}; return h.len;`, "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			if val.Debug() != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, val.Debug())
+			}
+		})
+	}
+
+	if output := evalTemplate(t, `{% let h = {"a": 1}; %}[{% h.keys %}{% "abc".len %}]`); output != "[]" {
+		t.Fatalf("expected no output for missing properties, got %q", output)
+	}
+
+	ctx := newScriptContext(t, `let h = {"a": 1}; return h.keys;`)
+	ctx.Strict = true
+	if _, err := New().Evaluate(ctx); !errors.Is(err, ErrUndefinedProperty) {
+		t.Fatalf("expected an undefined property, got %v", err)
+	}
+}
+
+func TestMethodsHashProperties(t *testing.T) {
+	// A hash's own properties come before the methods of hashes.
+	input := `let h = {"keys": fn() { return "own"; }, "greet": fn(s) { return "hi " + s; }}; return h.keys() + " " + h.greet("ada") + " " + h.len();`
+	val := unwrapReturn(t, evalScript(t, input))
+	if val.Debug() != "own hi ada 2" {
+		t.Fatalf("expected 'own hi ada 2', got %s", val.Debug())
+	}
+}
+
+func TestMethodsDateTime(t *testing.T) {
+	dt := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC)
+	result, err := RunScript(`return when.format("2006-01-02") + " " + when.format("15:04");`, Vars{"when": dt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Debug() != "2024-03-05 14:30" {
+		t.Fatalf("expected '2024-03-05 14:30', got %s", result.Debug())
+	}
+
+	_, err = RunScript(`return when.format(1);`, Vars{"when": dt})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected a type mismatch, got %v", err)
+	}
+}
+
+func TestMethodsArgumentCount(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return "x".toUpper(1);`, "toUpper: expected 0 arguments, got 1"},
+		{`return "x".substring();`, "substring: expected 1 or 2 arguments, got 0"},
+		{`return [1].map();`, "map: expected 1 argument, got 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := evalScriptError(t, tt.input)
+			if !errors.Is(err, ErrArgumentCount) || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestMethodsMissing(t *testing.T) {
+	// Without a method, a property of anything but a hash is null, as
+	// before, or an error when strict.
+	val := unwrapReturn(t, evalScript(t, `return "x".missing;`))
+	if val != Null {
+		t.Fatalf("expected null, got %s", val.Debug())
+	}
+
+	ctx := newScriptContext(t, `return "x".missing();`)
+	ctx.Strict = true
+	if _, err := New().Evaluate(ctx); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected a type mismatch, got %v", err)
+	}
+}
+
+func TestRegisterMethod(t *testing.T) {
+	e := New()
+	e.RegisterMethodWithDoc(StringObject, FunctionDoc{Name: "slug", Signature: "slug(str, sep?)"}, func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		sep := "-"
+		if len(args) == 2 {
+			sep = args[1].Debug()
+		}
+		return &StringValue{Value: strings.ReplaceAll(strings.ToLower(args[0].Debug()), " ", sep)}, nil
+	})
+	// Registering replaces a built-in method of the type only.
+	e.RegisterMethod(ArrayObject, "len", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return &StringValue{Value: "replaced"}, nil
+	})
+
+	result, err := e.RunScript(`return "Hello World".slug() + " " + "A B".slug("_") + " " + [1].len() + " " + "ab".len() + " " + len([1]);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Debug() != "hello-world a_b replaced 2 1" {
+		t.Fatalf("unexpected result %s", result.Debug())
+	}
+
+	_, err = e.RunScript(`return "a".slug("-", 1);`)
+	if !errors.Is(err, ErrArgumentCount) || !strings.Contains(err.Error(), "slug: expected 0 or 1 argument") {
+		t.Fatalf("expected an argument count error, got %v", err)
+	}
+
+	// Methods belong to the evaluator they are registered with.
+	if _, err := New().RunScript(`return "a".slug();`); !errors.Is(err, ErrNotCallable) {
+		t.Fatalf("expected slug to be unknown to another evaluator, got %v", err)
+	}
+
+	e.Freeze()
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "frozen") {
+			t.Fatalf("expected a panic registering on a frozen evaluator, got %v", r)
+		}
+	}()
+	e.RegisterMethod(StringObject, "other", nil)
+}
+
+func TestEvaluatorMethods(t *testing.T) {
+	e := New()
+	e.RegisterMethodWithDoc(HashObject, FunctionDoc{Name: "size", Signature: "size(hash)", Description: "Counts the keys."}, nil)
+
+	var names []string
+	for _, doc := range e.Methods(HashObject) {
+		names = append(names, doc.Name)
+	}
+	if !slices.Equal(names, []string{"keys", "len", "size", "values"}) {
+		t.Fatalf("unexpected methods %v", names)
+	}
+	if docs := e.Methods(DateTimeObject); len(docs) != 2 || docs[0].Signature != "format(dt, layout)" {
+		t.Fatalf("unexpected datetime methods %+v", docs)
+	}
+	if docs := e.Methods(NullObject); len(docs) != 0 {
+		t.Fatalf("expected no methods of null, got %+v", docs)
+	}
+}
@@ -11,10 +11,12 @@ const (
 	IntegerObject         ObjectType = "INTEGER"
 	DecimalObject         ObjectType = "DECIMAL"
 	StringObject          ObjectType = "STRING"
+	SafeStringObject      ObjectType = "SAFE_STRING"
 	DateTimeObject        ObjectType = "DATETIME"
 	FunctionObject        ObjectType = "FUNCTION"
 	ArrayObject           ObjectType = "ARRAY"
 	HashObject            ObjectType = "HASH"
+	RangeObject           ObjectType = "RANGE"
 	FileObject            ObjectType = "FILE"
 	BuiltInFunctionObject ObjectType = "BUILTIN_FUNCTION"
 )
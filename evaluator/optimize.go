@@ -0,0 +1,393 @@
+package evaluator
+
+import (
+	"strconv"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// maxFoldedString is the longest string constant folding produces. Longer
+// results are left to be built at run time, where MaxMemory applies.
+const maxFoldedString = 4 << 10
+
+// Optimize returns an optimized copy of program, leaving program itself
+// unchanged. It folds infix and prefix expressions whose operands are
+// literals, and literals interpolated in strings, drops the branches of if
+// expressions that can never be taken, and merges adjacent template text.
+//
+// Folding uses the same operators as evaluation. An expression that would
+// fail, such as an integer overflow or a division by zero, is left as it is,
+// so that the error is still reported when, and where, it is evaluated.
+func Optimize(program *parser.Program) *parser.Program {
+	o := &optimizer{e: New(), ctx: &ExecutionContext{}}
+	return &parser.Program{Statements: o.statements(program.Statements)}
+}
+
+type optimizer struct {
+	e   *Evaluator
+	ctx *ExecutionContext
+}
+
+func (o *optimizer) statements(statements []parser.Statement) []parser.Statement {
+	optimized := make([]parser.Statement, 0, len(statements))
+
+	for i, statement := range statements {
+		statement = o.statement(statement)
+
+		if block, ok := takenBranch(statement); ok {
+			switch {
+			case len(block.Statements) == 0 && i < len(statements)-1:
+				// Only the last statement's value can be used.
+				continue
+			case canSplice(block):
+				for _, s := range block.Statements {
+					optimized = appendStatement(optimized, s)
+				}
+				continue
+			}
+		}
+
+		optimized = appendStatement(optimized, statement)
+	}
+
+	return optimized
+}
+
+// appendStatement appends statement to statements, merging adjacent
+// template text into a single print statement.
+func appendStatement(statements []parser.Statement, statement parser.Statement) []parser.Statement {
+	if print, ok := statement.(*parser.PrintStatement); ok && len(statements) > 0 {
+		if last, ok := statements[len(statements)-1].(*parser.PrintStatement); ok {
+			statements[len(statements)-1] = &parser.PrintStatement{Token: last.Token, Value: last.Value + print.Value}
+			return statements
+		}
+	}
+	return append(statements, statement)
+}
+
+// takenBranch returns the block of an if statement whose condition is known
+// to be true, which is all that is left of an if expression once its
+// untaken branches are dropped.
+func takenBranch(statement parser.Statement) (*parser.BlockStatement, bool) {
+	if es, ok := statement.(*parser.ExpressionStatement); ok {
+		statement = es.Expression
+	}
+
+	ie, ok := statement.(*parser.IfExpression)
+	if !ok || ie.Alternative != nil {
+		return nil, false
+	}
+	if b, ok := ie.Condition.(*parser.BooleanLiteral); !ok || !b.Value {
+		return nil, false
+	}
+	return ie.Consequence, true
+}
+
+// canSplice reports whether the statements of block behave the same when
+// they replace the if statement holding it. The if statement's own value is
+// that of its block's last statement, which must be one whose value is
+// never used as template output, and a break or continue must not end up at
+// the top level of a program, where it ends only its own statement.
+func canSplice(block *parser.BlockStatement) bool {
+	if len(block.Statements) == 0 || jumpsOut(block.Statements) {
+		return false
+	}
+
+	switch last := block.Statements[len(block.Statements)-1].(type) {
+	case *parser.PrintStatement:
+		return true
+	case *parser.ExpressionStatement:
+		_, assignment := last.Expression.(*parser.AssignmentExpression)
+		return !assignment
+	default:
+		return false
+	}
+}
+
+// jumpsOut reports whether statements contain a break or continue outside
+// any loop or function in them.
+func jumpsOut(statements []parser.Statement) bool {
+	for _, statement := range statements {
+		if es, ok := statement.(*parser.ExpressionStatement); ok {
+			statement = es.Expression
+		}
+
+		switch s := statement.(type) {
+		case *parser.BreakStatement, *parser.ContinueStatement:
+			return true
+		case *parser.BlockStatement:
+			if jumpsOut(s.Statements) {
+				return true
+			}
+		case *parser.IfExpression:
+			if jumpsOut(s.Consequence.Statements) || s.Alternative != nil && jumpsOut(s.Alternative.Statements) {
+				return true
+			}
+		case *parser.TryStatement:
+			if jumpsOut(s.Body.Statements) || jumpsOut(s.Catch.Statements) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o *optimizer) block(block *parser.BlockStatement) *parser.BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return &parser.BlockStatement{Token: block.Token, Statements: o.statements(block.Statements), End: block.End}
+}
+
+func (o *optimizer) statement(statement parser.Statement) parser.Statement {
+	switch n := statement.(type) {
+	case *parser.LetStatement:
+		return &parser.LetStatement{Token: n.Token, Name: n.Name, Value: o.expression(n.Value)}
+	case *parser.ReturnStatement:
+		return &parser.ReturnStatement{Token: n.Token, Value: o.expression(n.Value)}
+	case *parser.ThrowStatement:
+		return &parser.ThrowStatement{Token: n.Token, Value: o.expression(n.Value)}
+	case *parser.ExpressionStatement:
+		return &parser.ExpressionStatement{Expression: o.expression(n.Expression)}
+	case *parser.BlockStatement:
+		return o.block(n)
+	case *parser.ExtendsStatement:
+		return &parser.ExtendsStatement{Token: n.Token, Template: o.expression(n.Template)}
+	case *parser.NamedBlockStatement:
+		return &parser.NamedBlockStatement{Token: n.Token, Name: n.Name, Body: o.block(n.Body)}
+	case *parser.TryStatement:
+		return &parser.TryStatement{Token: n.Token, Body: o.block(n.Body), Parameter: n.Parameter, Catch: o.block(n.Catch)}
+	case *parser.PrintStatement, *parser.BreakStatement, *parser.ContinueStatement:
+		return n
+	default:
+		return o.expression(n)
+	}
+}
+
+func (o *optimizer) expression(expression parser.Expression) parser.Expression {
+	switch n := expression.(type) {
+	case *parser.InfixExpression:
+		return o.infixExpression(n)
+	case *parser.PrefixExpression:
+		return o.prefixExpression(n)
+	case *parser.InterpolatedString:
+		return o.interpolatedString(n)
+	case *parser.IfExpression:
+		return o.ifExpression(n)
+	case *parser.WhileExpression:
+		return &parser.WhileExpression{Token: n.Token, Condition: o.expression(n.Condition), Body: o.block(n.Body)}
+	case *parser.ForeachExpression:
+		return &parser.ForeachExpression{Token: n.Token, Index: n.Index, Variable: n.Variable, Iterable: o.expression(n.Iterable), Body: o.block(n.Body)}
+	case *parser.ForExpression:
+		loop := &parser.ForExpression{Token: n.Token, Condition: o.expression(n.Condition), Update: o.expression(n.Update), Body: o.block(n.Body)}
+		if n.Init != nil {
+			loop.Init = o.statement(n.Init)
+		}
+		return loop
+	case *parser.RangeExpression:
+		return &parser.RangeExpression{Token: n.Token, Start: o.expression(n.Start), End: o.expression(n.End), Step: o.expression(n.Step), Exclusive: n.Exclusive}
+	case *parser.FunctionLiteral:
+		return &parser.FunctionLiteral{Token: n.Token, Identifier: n.Identifier, Body: o.block(n.Body), Parameters: n.Parameters}
+	case *parser.CallExpression:
+		return &parser.CallExpression{Token: n.Token, Function: o.expression(n.Function), Args: o.expressions(n.Args)}
+	case *parser.PipeExpression:
+		return &parser.PipeExpression{Token: n.Token, Left: o.expression(n.Left), Filter: o.expression(n.Filter)}
+	case *parser.ArrayLiteral:
+		return &parser.ArrayLiteral{Token: n.Token, Elements: o.expressions(n.Elements)}
+	case *parser.HashLiteral:
+		pairs := make([]parser.HashPair, len(n.Pairs))
+		for i, pair := range n.Pairs {
+			pairs[i] = parser.HashPair{Key: o.expression(pair.Key), Value: o.expression(pair.Value)}
+		}
+		return &parser.HashLiteral{Token: n.Token, Pairs: pairs}
+	case *parser.IndexExpression:
+		return &parser.IndexExpression{Token: n.Token, Left: o.expression(n.Left), Index: o.expression(n.Index), Optional: n.Optional}
+	case *parser.PropertyExpression:
+		return &parser.PropertyExpression{Token: n.Token, Left: o.expression(n.Left), Property: n.Property, Optional: n.Optional}
+	case *parser.AssignmentExpression:
+		left := n.Left
+		if _, ok := left.(*parser.Identifier); !ok {
+			left = o.expression(left)
+		}
+		return &parser.AssignmentExpression{Token: n.Token, Left: left, Right: o.expression(n.Right)}
+	default:
+		return n
+	}
+}
+
+func (o *optimizer) expressions(expressions []parser.Expression) []parser.Expression {
+	optimized := make([]parser.Expression, len(expressions))
+	for i, expression := range expressions {
+		optimized[i] = o.expression(expression)
+	}
+	return optimized
+}
+
+func (o *optimizer) infixExpression(ie *parser.InfixExpression) parser.Expression {
+	left, right := o.expression(ie.Left), o.expression(ie.Right)
+	folded := &parser.InfixExpression{Token: ie.Token, Left: left, Right: right}
+
+	l, ok := constant(left)
+	if !ok {
+		return folded
+	}
+
+	// With a constant left operand, &&, || and ?? evaluate to one operand
+	// or the other, whatever the right one is.
+	switch ie.Token.Source {
+	case "&&":
+		if !isTruthy(l) {
+			return left
+		}
+		return right
+	case "||":
+		if isTruthy(l) {
+			return left
+		}
+		return right
+	case "??":
+		if l != Null {
+			return left
+		}
+		return right
+	}
+
+	r, ok := constant(right)
+	if !ok {
+		return folded
+	}
+
+	result, err := o.e.evaluateInfixExpression(o.ctx, ie.Token, l, r)
+	if err != nil {
+		return folded
+	}
+	if literal, ok := literal(result, ie.Token); ok {
+		return literal
+	}
+	return folded
+}
+
+// interpolatedString folds the constant expressions of an interpolated
+// string into the strings around them, leaving a string literal if every
+// expression is constant.
+func (o *optimizer) interpolatedString(is *parser.InterpolatedString) parser.Expression {
+	strs := []string{is.Strings[0]}
+	var expressions []parser.Expression
+
+	for i, expression := range is.Expressions {
+		expression = o.expression(expression)
+		if value, ok := constant(expression); ok {
+			if s, err := o.ctx.interpolate([]Object{value}); err == nil {
+				strs[len(strs)-1] += s.(*StringValue).Value + is.Strings[i+1]
+				continue
+			}
+		}
+		expressions = append(expressions, expression)
+		strs = append(strs, is.Strings[i+1])
+	}
+
+	if len(expressions) == 0 {
+		if literal, ok := literal(&StringValue{Value: strs[0]}, is.Token); ok {
+			return literal
+		}
+	}
+	return &parser.InterpolatedString{Token: is.Token, Strings: strs, Expressions: expressions}
+}
+
+func (o *optimizer) prefixExpression(pe *parser.PrefixExpression) parser.Expression {
+	right := o.expression(pe.Right)
+	folded := &parser.PrefixExpression{Token: pe.Token, Operator: pe.Operator, Right: right}
+
+	r, ok := constant(right)
+	if !ok {
+		return folded
+	}
+
+	var result Object
+	switch pe.Operator {
+	case "!":
+		result = not(r)
+	case "-":
+		var err error
+		if result, err = o.e.evaluateMinusPrefixOperatorExpression(r); err != nil {
+			return folded
+		}
+	default:
+		return folded
+	}
+
+	if literal, ok := literal(result, pe.Token); ok {
+		return literal
+	}
+	return folded
+}
+
+// ifExpression drops the branches of ie that cannot be taken if its
+// condition is constant, leaving an if expression whose condition is true.
+func (o *optimizer) ifExpression(ie *parser.IfExpression) parser.Expression {
+	condition := o.expression(ie.Condition)
+	consequence, alternative := o.block(ie.Consequence), o.block(ie.Alternative)
+
+	c, ok := constant(condition)
+	if !ok {
+		return &parser.IfExpression{Token: ie.Token, Condition: condition, Consequence: consequence, Alternative: alternative}
+	}
+
+	taken := consequence
+	if !isTruthy(c) {
+		taken = alternative
+		if taken == nil {
+			taken = &parser.BlockStatement{Token: ie.Consequence.Token}
+		}
+	}
+
+	always := &parser.BooleanLiteral{Token: lexer.NewToken(lexer.True, "true", ie.Token.Position, ie.Token.Line, ie.Token.Column), Value: true}
+	return &parser.IfExpression{Token: ie.Token, Condition: always, Consequence: taken}
+}
+
+// constant returns the value of a literal expression.
+func constant(expression parser.Expression) (Object, bool) {
+	switch n := expression.(type) {
+	case *parser.IntegerLiteral:
+		return &IntegerValue{Value: n.Value}, true
+	case *parser.FloatLiteral:
+		return &DecimalValue{Value: n.Value}, true
+	case *parser.StringLiteral:
+		return &StringValue{Value: n.Value}, true
+	case *parser.BooleanLiteral:
+		return nativeBool(n.Value), true
+	case *parser.NullLiteral:
+		return Null, true
+	default:
+		return nil, false
+	}
+}
+
+// literal returns a literal expression for obj, positioned at token.
+func literal(obj Object, token lexer.Token) (parser.Expression, bool) {
+	at := func(typ lexer.TokenType, source string) lexer.Token {
+		return lexer.NewToken(typ, source, token.Position, token.Line, token.Column)
+	}
+
+	switch v := obj.(type) {
+	case *IntegerValue:
+		return &parser.IntegerLiteral{Token: at(lexer.Integer, strconv.Itoa(v.Value)), Value: v.Value}, true
+	case *DecimalValue:
+		return &parser.FloatLiteral{Token: at(lexer.Float, v.Debug()), Value: v.Value}, true
+	case *StringValue:
+		if len(v.Value) > maxFoldedString {
+			return nil, false
+		}
+		return &parser.StringLiteral{Token: at(lexer.String, strconv.Quote(v.Value)), Value: v.Value}, true
+	case *BooleanValue:
+		if v.Value {
+			return &parser.BooleanLiteral{Token: at(lexer.True, "true"), Value: true}, true
+		}
+		return &parser.BooleanLiteral{Token: at(lexer.False, "false"), Value: false}, true
+	case *NullValue:
+		return &parser.NullLiteral{Token: at(lexer.Null, "null")}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,164 @@
+package evaluator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+func parseScript(t *testing.T, input string) *parser.Program {
+	t.Helper()
+	program, err := parser.New(lexer.NewScript(input)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+func parseTemplate(t *testing.T, input string) *parser.Program {
+	t.Helper()
+	program, err := parser.New(lexer.NewTemplate(input)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+func TestOptimizeFolding(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return 1 + 2 * 3;`, `return 7`},
+		{`return -(2 - 5);`, `return 3`},
+		{`return 1.5 * 2;`, `return 3.0`},
+		{`return "a" + "b" + 1;`, `return "ab1"`},
+		{`return !(1 < 2);`, `return false`},
+		{`return !null;`, `return false`},
+		{`return 1 == 1 && 2 != 3;`, `return true`},
+		{`return false && x;`, `return false`},
+		{`return true && x;`, `return x`},
+		{`return 0 || x;`, `return 0`},
+		{`return null ?? x;`, `return x`},
+		{`return x + 1 * 2;`, `return x + 2`},
+		{`return 1 / 0;`, `return 1 / 0`},
+		{`return 9223372036854775807 + 1;`, `return 9223372036854775807 + 1`},
+		{`return 1 + true;`, `return 1 + true`},
+		{"return `a${1 + 1}b${\"c\"}`;", `return "a2bc"`},
+		{"return `a${x}b${2 * 3}c${y}`;", "return `a${x}b6c${y}`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			optimized := Optimize(parseScript(t, tt.input))
+			if got := optimized.Statements[0].Debug(); got != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepsErrors(t *testing.T) {
+	tests := []string{
+		"let a = 1;\nreturn a + 10 / 0;",
+		"let a = 1;\nreturn a + 10 % (2 - 2);",
+		"return [\n  9223372036854775807 + 1\n];",
+		"return 3 * -9223372036854775807 * 2;",
+		"if (1 + 1 == 2) {\n  return 1.5 / 0;\n}",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			program := parseScript(t, input)
+
+			_, want := New().Evaluate(NewExecutionContext(program))
+			_, got := New().Evaluate(NewExecutionContext(Optimize(program)))
+
+			var wantErr, gotErr *RuntimeError
+			if !errors.As(want, &wantErr) || !errors.As(got, &gotErr) {
+				t.Fatalf("expected runtime errors, got %v and %v", want, got)
+			}
+			if gotErr.Message != wantErr.Message || gotErr.Line != wantErr.Line || gotErr.Column != wantErr.Column {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestOptimizeDeadBranches(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"true condition", `if (1 < 2) { let a = 1; f(a); } return 0;`, "let a = 1\nf(a)\nreturn 0\n"},
+		{"false condition", `if (1 > 2) { f(1); } else { f(2); } return 0;`, "f(2)\nreturn 0\n"},
+		{"false without else", `if (false) { f(1); } return 0;`, "return 0\n"},
+		{"nested", `fn g() { if (!true) { return 1; } return 2; }`, "fn g() \n{\n    return 2\n}\n"},
+		{"ends with let", `if (true) { let a = 1; }`, "if true {\n    let a = 1\n}\n"},
+		{"break", `while (x) { if (true) { break; } }`, "while x {\n    if true {\n    break\n}\n}\n"},
+		{"top level break", `if (true) { break; }`, "if true {\n    break\n}\n"},
+		{"unknown condition", `if (x) { f(1); } else { f(2); }`, "if x {\n    f(1)\n} else {\n    f(2)\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Optimize(parseScript(t, tt.input)).Debug(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOptimizeMergesText(t *testing.T) {
+	program := Optimize(parseTemplate(t, `<p>{% if (true) { %}a{% } %}b{% if (false) { %}c{% } %}</p>`))
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected a single statement, got %q", program.Debug())
+	}
+	print, ok := program.Statements[0].(*parser.PrintStatement)
+	if !ok || print.Value != "<p>ab</p>" {
+		t.Fatalf("unexpected statement: %q", program.Statements[0].Debug())
+	}
+}
+
+func TestOptimizeTemplateOutput(t *testing.T) {
+	tests := []string{
+		`{% let n = 2 * 3; %}[{% n %}]{% if (n > 5) { %}big{% } else { %}small{% } %}`,
+		`{% if (true) { let x = 1; } %}|{% if (1 == 1) { x = 2; } %}|{% if (true) { "v"; } %}`,
+		`{% foreach ([1, 2, 3] as v) { if (true) { if (v == 2) { continue; } } %}<{% v %}>{% } %}`,
+		`{% if (false) { %}no{% } %}{% "a" + "b" %}{% if (true) { break; } %}after`,
+		`{% let s = "x" ?? "y"; s + (true || false); %}`,
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			program := parseTemplate(t, input)
+
+			want, err := New().EvaluateString(NewExecutionContext(program))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := New().EvaluateString(NewExecutionContext(Optimize(program)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestOptimizeLeavesProgram(t *testing.T) {
+	program := parseScript(t, `let a = 1 + 2; if (true) { f(a); } return -a;`)
+	before := program.Debug()
+
+	Optimize(program)
+
+	if after := program.Debug(); after != before {
+		t.Fatalf("expected %q, got %q", before, after)
+	}
+}
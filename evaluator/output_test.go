@@ -0,0 +1,227 @@
+package evaluator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+func newTemplateContext(t *testing.T, input string, vars ...Vars) *ExecutionContext {
+	t.Helper()
+	l := lexer.NewTemplate(input)
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewExecutionContext(program)
+	if err := applyVars(ctx.RootScope, vars); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+// recordingWriter records each write separately so tests can check that
+// output is streamed rather than buffered.
+type recordingWriter struct {
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+type failingWriter struct {
+	limit int
+	n     int
+}
+
+var errWriterClosed = errors.New("writer closed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > w.limit {
+		return 0, errWriterClosed
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestEvaluateTo(t *testing.T) {
+	ctx := newTemplateContext(t, `<ul>{% foreach (items as item) { %}<li>{% item %}</li>{% } %}</ul>`, Vars{"items": []any{"a", "b"}})
+
+	var sb strings.Builder
+	if err := New().EvaluateTo(ctx, &sb); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "<ul><li>a</li><li>b</li></ul>" {
+		t.Fatalf("unexpected output: %q", sb.String())
+	}
+}
+
+func TestEvaluateToStreams(t *testing.T) {
+	ctx := newTemplateContext(t, `<p>{% name %}</p>`, Vars{"name": "bob"})
+
+	w := &recordingWriter{}
+	if err := New().EvaluateTo(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"<p>", "bob", "</p>"}
+	if strings.Join(w.writes, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected writes %q, got %q", expected, w.writes)
+	}
+}
+
+func TestEvaluateToPartialOutputOnError(t *testing.T) {
+	ctx := newTemplateContext(t, `before{% missing %}after`)
+
+	var sb strings.Builder
+	err := New().EvaluateTo(ctx, &sb)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if sb.String() != "before" {
+		t.Fatalf("expected output before the error to be written, got %q", sb.String())
+	}
+}
+
+func TestEvaluateToWriterError(t *testing.T) {
+	ctx := newTemplateContext(t, `{% let i = 0; while (true) { print("x"); i += 1; } %}`)
+	ctx.MaxSteps = 0
+
+	err := New().EvaluateTo(ctx, &failingWriter{limit: 10})
+	if !errors.Is(err, errWriterClosed) {
+		t.Fatalf("expected writer error, got: %v", err)
+	}
+}
+
+func TestEvaluateToReturn(t *testing.T) {
+	ctx := newTemplateContext(t, `a{% return 1; %}b`)
+
+	var sb strings.Builder
+	if err := New().EvaluateTo(ctx, &sb); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "a" {
+		t.Fatalf("expected output to stop at return, got %q", sb.String())
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"text", `{% foreach (items as i) { %}0123456789{% } %}`},
+		{"expression", `{% foreach (items as i) { %}{% "0123456789" %}{% } %}`},
+		{"print", `{% foreach (items as i) { print("0123456789"); } %}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTemplateContext(t, tt.input, Vars{"items": make([]any, 100)})
+			ctx.MaxOutputBytes = 95
+
+			var sb strings.Builder
+			err := New().EvaluateTo(ctx, &sb)
+			if err == nil || !strings.Contains(err.Error(), "output limit exceeded: 95 bytes") {
+				t.Fatalf("expected output limit error, got: %v", err)
+			}
+			if sb.Len() != 90 {
+				t.Fatalf("expected 90 bytes written before the limit, got %d", sb.Len())
+			}
+		})
+	}
+}
+
+func TestMaxOutputBytesExact(t *testing.T) {
+	ctx := newTemplateContext(t, `0123456789`)
+	ctx.MaxOutputBytes = 10
+
+	output, err := New().EvaluateString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "0123456789" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestMaxOutputBytesDisabled(t *testing.T) {
+	ctx := newTemplateContext(t, `{% foreach (items as i) { %}0123456789{% } %}`, Vars{"items": make([]any, 100)})
+	ctx.MaxOutputBytes = 0
+
+	output, err := New().EvaluateString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 1000 {
+		t.Fatalf("expected 1000 bytes, got %d", len(output))
+	}
+}
+
+func TestMaxOutputBytesCountsIncludes(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"page":    `{% include("partial") %}{% include("partial") %}`,
+		"partial": `0123456789`,
+	})
+	tmpl, err := set.Get("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tmpl.NewExecutionContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.MaxOutputBytes = 15
+
+	_, err = tmpl.RenderContext(ctx)
+	if err == nil || !strings.Contains(err.Error(), "output limit exceeded") {
+		t.Fatalf("expected output limit error, got: %v", err)
+	}
+}
+
+func TestTemplateRenderTo(t *testing.T) {
+	tmpl, err := Compile(`Hello, {% name %}!`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.RenderTo(&sb, Vars{"name": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "Hello, Alice!" {
+		t.Fatalf("unexpected output: %q", sb.String())
+	}
+}
+
+func TestTemplateSetRenderTo(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"layout": `<main>{% block body { %}{% } %}</main>`,
+		"page":   `{% extends "layout"; %}{% block body { %}{% msg %}{% } %}`,
+	})
+
+	var sb strings.Builder
+	if err := set.RenderTo(&sb, "page", Vars{"msg": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "<main>hi</main>" {
+		t.Fatalf("unexpected output: %q", sb.String())
+	}
+
+	if err := set.RenderTo(&sb, "missing"); err == nil {
+		t.Fatal("expected error for missing template")
+	}
+}
+
+func TestPrintInScriptModeIsDiscarded(t *testing.T) {
+	result := evalScript(t, `print("hello"); return 1;`)
+	val := unwrapReturn(t, result)
+	if val.(*IntegerValue).Value != 1 {
+		t.Fatalf("expected 1, got %s", val.Debug())
+	}
+}
@@ -0,0 +1,514 @@
+package evaluator
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Profile records the steps evaluations take and the time they spend on
+// each line of each function, when set on their ExecutionContext. One
+// profile can be shared by any number of evaluations, including concurrent
+// ones, and adds up all of them.
+//
+// Steps are counted as they are against MaxSteps, one for each statement
+// and expression, on the line of the first of them. The time until the
+// next instruction is charged to the line of each instruction, so the time
+// spent in a built-in function goes to the line calling it. Timing every
+// instruction makes a profiled evaluation several times slower than it
+// would otherwise be.
+type Profile struct {
+	// Path returns the path of the source file of a template, given its
+	// name or "" for a program that is not a named template, for the
+	// functions of WritePprof. If Path is nil, the names are used.
+	Path func(template string) string
+
+	mu          sync.Mutex
+	evaluations int
+	samples     profileSamples
+}
+
+// NewProfile creates an empty profile.
+func NewProfile() *Profile {
+	return &Profile{}
+}
+
+// ProfileEntry is the cost of a function, or of a line of one, in a
+// profile. Steps and Time are spent in the function or on the line itself,
+// and CumSteps and CumTime also include the functions it calls. Native
+// entries are Go functions registered with RegisterFunction, which only
+// take steps in the script functions they call back, and have no position.
+type ProfileEntry struct {
+	Function string
+	Template string
+	Line     int
+	Native   bool
+	Steps    int
+	Time     time.Duration
+	CumSteps int
+	CumTime  time.Duration
+}
+
+func (e ProfileEntry) String() string {
+	switch {
+	case e.Native:
+		return fmt.Sprintf("%s (native)", e.Function)
+	case e.Line == 0 && e.Template == "":
+		return e.Function
+	case e.Line == 0:
+		return fmt.Sprintf("%s (%s)", e.Function, e.Template)
+	case e.Template == "":
+		return fmt.Sprintf("%s (line %d)", e.Function, e.Line)
+	}
+	return fmt.Sprintf("%s (%s:%d)", e.Function, e.Template, e.Line)
+}
+
+// Functions returns the cost of each function, the most expensive first.
+// The code of a template or script outside any function is its <main>
+// function.
+func (p *Profile) Functions() []ProfileEntry {
+	return p.entries(func(loc profileLocation) profileLocation {
+		loc.line = 0
+		return loc
+	})
+}
+
+// Lines returns the cost of each line of each function, the most
+// expensive first.
+func (p *Profile) Lines() []ProfileEntry {
+	return p.entries(func(loc profileLocation) profileLocation {
+		return loc
+	})
+}
+
+// entries adds up the samples of the profile by the key of their
+// locations, sorted by steps and then cumulative steps.
+func (p *Profile) entries(key func(profileLocation) profileLocation) []ProfileEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	totals := make(map[profileLocation]*ProfileEntry)
+	entry := func(loc profileLocation) *ProfileEntry {
+		loc = key(loc)
+		e, ok := totals[loc]
+		if !ok {
+			e = &ProfileEntry{Function: loc.function, Template: loc.template, Line: loc.line, Native: loc.native}
+			totals[loc] = e
+		}
+		return e
+	}
+
+	var seen []*ProfileEntry
+	for id, counts := range p.samples.counts {
+		if counts.steps == 0 && counts.time == 0 {
+			continue
+		}
+
+		leaf := entry(p.samples.nodes[id].location)
+		leaf.Steps += counts.steps
+		leaf.Time += counts.time
+
+		// A recursive function is only charged once for each sample.
+		seen = seen[:0]
+		for node := id + 1; node > 0; node = p.samples.nodes[node-1].parent {
+			e := entry(p.samples.nodes[node-1].location)
+			if slices.Contains(seen, e) {
+				continue
+			}
+			seen = append(seen, e)
+			e.CumSteps += counts.steps
+			e.CumTime += counts.time
+		}
+	}
+
+	entries := make([]ProfileEntry, 0, len(totals))
+	for _, e := range totals {
+		entries = append(entries, *e)
+	}
+	slices.SortFunc(entries, func(a, b ProfileEntry) int {
+		if c := cmp.Compare(b.Steps, a.Steps); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.CumSteps, a.CumSteps); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.String(), b.String())
+	})
+	return entries
+}
+
+// WriteFunctions writes a report of the cost of each function to w, the
+// most expensive first, in the layout of go tool pprof -top.
+func (p *Profile) WriteFunctions(w io.Writer) error {
+	return p.writeReport(w, p.Functions())
+}
+
+// WriteLines writes a report of the cost of each line to w, the most
+// expensive first, in the layout of go tool pprof -top -lines.
+func (p *Profile) WriteLines(w io.Writer) error {
+	return p.writeReport(w, p.Lines())
+}
+
+func (p *Profile) writeReport(w io.Writer, entries []ProfileEntry) error {
+	p.mu.Lock()
+	evaluations := p.evaluations
+	p.mu.Unlock()
+
+	var steps int
+	var elapsed time.Duration
+	for _, e := range entries {
+		steps += e.Steps
+		elapsed += e.Time
+	}
+
+	percent := func(n int) float64 {
+		if steps == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(steps)
+	}
+
+	if _, err := fmt.Fprintf(w, "Total: %d steps, %s in %d evaluations\n", steps, roundDuration(elapsed), evaluations); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%10s %7s %7s %10s %7s %10s %10s\n", "flat", "flat%", "sum%", "cum", "cum%", "time", "cum time"); err != nil {
+		return err
+	}
+
+	sum := 0
+	for _, e := range entries {
+		sum += e.Steps
+		_, err := fmt.Fprintf(w, "%10d %6.2f%% %6.2f%% %10d %6.2f%% %10s %10s  %s\n",
+			e.Steps, percent(e.Steps), percent(sum), e.CumSteps, percent(e.CumSteps),
+			roundDuration(e.Time), roundDuration(e.CumTime), e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roundDuration rounds d for a report, keeping three significant digits of
+// the durations of most lines.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond)
+	}
+	return d
+}
+
+// WritePprof writes the profile to w in the gzipped protocol buffer format
+// of pprof, with the steps and time of each call stack as its samples.
+func (p *Profile) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var table []string
+	tableIDs := make(map[string]int)
+	str := func(s string) int {
+		id, ok := tableIDs[s]
+		if !ok {
+			id = len(table)
+			table = append(table, s)
+			tableIDs[s] = id
+		}
+		return id
+	}
+	str("")
+
+	var b protobuf
+
+	for _, sampleType := range [][2]string{{"steps", "count"}, {"time", "nanoseconds"}} {
+		b.message(1, func(vt *protobuf) {
+			vt.int64(1, int64(str(sampleType[0])))
+			vt.int64(2, int64(str(sampleType[1])))
+		})
+	}
+
+	// Functions and locations are numbered from 1 in the order they are
+	// first used by a sample.
+	type function struct {
+		name, template string
+		native         bool
+	}
+	var functions []function
+	functionIDs := make(map[function]int)
+	var locations []profileLocation
+	locationIDs := make(map[profileLocation]int)
+	location := func(loc profileLocation) uint64 {
+		id, ok := locationIDs[loc]
+		if !ok {
+			fn := function{loc.function, loc.template, loc.native}
+			if _, ok := functionIDs[fn]; !ok {
+				functions = append(functions, fn)
+				functionIDs[fn] = len(functions)
+			}
+			locations = append(locations, loc)
+			id = len(locations)
+			locationIDs[loc] = id
+		}
+		return uint64(id)
+	}
+
+	for id, counts := range p.samples.counts {
+		if counts.steps == 0 && counts.time == 0 {
+			continue
+		}
+		var stack []uint64
+		for node := id + 1; node > 0; node = p.samples.nodes[node-1].parent {
+			stack = append(stack, location(p.samples.nodes[node-1].location))
+		}
+		b.message(2, func(s *protobuf) {
+			s.packed(1, stack)
+			s.packed(2, []uint64{uint64(counts.steps), uint64(counts.time)})
+		})
+	}
+
+	for i, loc := range locations {
+		b.message(4, func(l *protobuf) {
+			l.int64(1, int64(i+1))
+			l.message(4, func(line *protobuf) {
+				line.int64(1, int64(functionIDs[function{loc.function, loc.template, loc.native}]))
+				line.int64(2, int64(loc.line))
+			})
+		})
+	}
+
+	for i, fn := range functions {
+		// pprof drops what is in angle brackets from names, taking it for
+		// C++ template arguments, and shows functions by name alone, so
+		// their names are qualified by their templates as Go functions are
+		// by their packages.
+		name := fn.name
+		if !fn.native {
+			name = strings.Trim(name, "<>")
+			if fn.template != "" {
+				name = fn.template + "." + name
+			}
+		}
+		b.message(5, func(f *protobuf) {
+			f.int64(1, int64(i+1))
+			f.int64(2, int64(str(name)))
+			f.int64(3, int64(str(fn.name)))
+			if !fn.native {
+				f.int64(4, int64(str(p.path(fn.template))))
+			}
+		})
+	}
+
+	b.message(11, func(vt *protobuf) {
+		vt.int64(1, int64(str("steps")))
+		vt.int64(2, int64(str("count")))
+	})
+	b.int64(12, 1)
+	b.int64(14, int64(str("steps")))
+
+	// The string table comes last, once everything has added its strings.
+	for _, s := range table {
+		b.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (p *Profile) path(template string) string {
+	if p.Path == nil {
+		return template
+	}
+	return p.Path(template)
+}
+
+// add adds the samples of an evaluation that has finished.
+func (p *Profile) add(r *profiler) {
+	if r.node > 0 {
+		r.samples.counts[r.node-1].time += time.Since(r.last)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evaluations++
+	p.samples.add(&r.samples)
+}
+
+// profileLocation is a line of a function, or a native function.
+type profileLocation struct {
+	function string
+	template string
+	line     int
+	native   bool
+}
+
+// profileNode is a location and the call stack leading to it, given by
+// the node of the call site it was reached from, or 0 at the top.
+type profileNode struct {
+	parent   int
+	location profileLocation
+}
+
+type profileCounts struct {
+	steps int
+	time  time.Duration
+}
+
+// profileSamples holds the call stacks of samples as a tree of nodes
+// numbered from 1, and the counts of the samples ending at each node.
+type profileSamples struct {
+	nodes  []profileNode
+	ids    map[profileNode]int
+	counts []profileCounts
+}
+
+// node returns the number of the node for loc reached from parent.
+func (s *profileSamples) node(parent int, loc profileLocation) int {
+	n := profileNode{parent: parent, location: loc}
+	id, ok := s.ids[n]
+	if !ok {
+		if s.ids == nil {
+			s.ids = make(map[profileNode]int)
+		}
+		s.nodes = append(s.nodes, n)
+		s.counts = append(s.counts, profileCounts{})
+		id = len(s.nodes)
+		s.ids[n] = id
+	}
+	return id
+}
+
+// add adds the samples of other to s. A node always comes after its
+// parent, so the parents are numbered in s first.
+func (s *profileSamples) add(other *profileSamples) {
+	ids := make([]int, len(other.nodes))
+	for i, n := range other.nodes {
+		parent := 0
+		if n.parent > 0 {
+			parent = ids[n.parent-1]
+		}
+		ids[i] = s.node(parent, n.location)
+		s.counts[ids[i]-1].steps += other.counts[i].steps
+		s.counts[ids[i]-1].time += other.counts[i].time
+	}
+}
+
+// profiler records the profile of one evaluation, to be added to the
+// Profile of its context once it finishes.
+type profiler struct {
+	samples profileSamples
+	// node is the sample of the last instruction, which is charged with
+	// the time from last until the next instruction.
+	node int
+	last time.Time
+}
+
+// step records the instruction at pc of c.
+func (p *profiler) step(ctx *ExecutionContext, c *code, pc int) {
+	now := time.Now()
+	if p.node > 0 {
+		p.samples.counts[p.node-1].time += now.Sub(p.last)
+	}
+	p.last = now
+
+	// Instructions the compiler added with no position of their own count
+	// towards the line of those before them.
+	line := 0
+	for i := pc; i >= 0 && line == 0; i-- {
+		line = c.tokens[i].Line
+	}
+
+	caller := p.caller(ctx)
+	loc := profileLocation{function: mainFunction, template: ctx.template, line: line}
+	if n := len(ctx.frames); n > 0 {
+		loc.function = ctx.frames[n-1].function
+	}
+
+	if p.node == 0 || p.samples.nodes[p.node-1] != (profileNode{parent: caller, location: loc}) {
+		p.node = p.samples.node(caller, loc)
+	}
+	if in := c.instructions[pc]; in.op == opStep {
+		p.samples.counts[p.node-1].steps += int(in.arg)
+	}
+}
+
+// caller returns the node of the call site of the running function, or 0
+// outside any function. The node of each call is kept on its frame, so
+// that it is only looked up once.
+func (p *profiler) caller(ctx *ExecutionContext) int {
+	frames := ctx.frames
+	i := len(frames)
+	for i > 0 && frames[i-1].profile == 0 {
+		i--
+	}
+
+	for ; i < len(frames); i++ {
+		parent := 0
+		site := profileLocation{function: mainFunction, template: frames[i].template, line: frames[i].token.Line}
+		if i > 0 {
+			parent = frames[i-1].profile
+			site.function = frames[i-1].function
+			if frames[i-1].native {
+				site = profileLocation{function: frames[i-1].function, native: true}
+			}
+		}
+		frames[i].profile = p.samples.node(parent, site)
+	}
+
+	if len(frames) == 0 {
+		return 0
+	}
+	return frames[len(frames)-1].profile
+}
+
+// protobuf encodes a protocol buffer message.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	b.data = binary.AppendUvarint(b.data, x)
+}
+
+func (b *protobuf) key(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// int64 encodes a varint field, leaving it out if it is 0.
+func (b *protobuf) int64(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(uint64(x))
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// packed encodes a repeated varint field.
+func (b *protobuf) packed(field int, xs []uint64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.data)
+}
+
+func (b *protobuf) message(field int, fill func(*protobuf)) {
+	var m protobuf
+	fill(&m)
+	b.bytes(field, m.data)
+}
@@ -0,0 +1,317 @@
+package evaluator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// profileSteps reduces the entries of a profile to their steps, which
+// unlike their times are the same on every run.
+func profileSteps(entries []ProfileEntry) []ProfileEntry {
+	steps := make([]ProfileEntry, len(entries))
+	for i, e := range entries {
+		steps[i] = ProfileEntry{Function: e.Function, Template: e.Template, Line: e.Line, Native: e.Native, Steps: e.Steps, CumSteps: e.CumSteps}
+	}
+	return steps
+}
+
+const profiledScript = "fn fib(n) {\n  if (n < 2) { return n; }\n  return fib(n - 1) + fib(n - 2);\n}\nlet doubled = map([1, 2], fn(x) { return x * 2; });\nfib(5);"
+
+func TestProfileFunctions(t *testing.T) {
+	ctx := newScriptContext(t, profiledScript)
+	ctx.Profile = NewProfile()
+
+	result, err := New().Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDebug(t, result, "5")
+
+	expected := []ProfileEntry{
+		{Function: "fib", Steps: 198, CumSteps: 198},
+		{Function: "<main>", Steps: 13, CumSteps: 221},
+		{Function: "<anonymous>", Steps: 10, CumSteps: 10},
+		{Function: "map", Native: true, CumSteps: 10},
+	}
+	if entries := profileSteps(ctx.Profile.Functions()); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected functions %#v, got %#v", expected, entries)
+	}
+
+	// The profile counts the same steps as MaxSteps does.
+	if ctx.steps != 221 {
+		t.Fatalf("expected 221 steps, got %d", ctx.steps)
+	}
+}
+
+func TestProfileLines(t *testing.T) {
+	ctx := newScriptContext(t, profiledScript)
+	ctx.Profile = NewProfile()
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ProfileEntry{
+		{Function: "fib", Line: 2, Steps: 99, CumSteps: 99},
+		{Function: "fib", Line: 3, Steps: 84, CumSteps: 192},
+		{Function: "fib", Line: 1, Steps: 15, CumSteps: 15},
+		{Function: "<anonymous>", Line: 5, Steps: 10, CumSteps: 10},
+		{Function: "<main>", Line: 5, Steps: 7, CumSteps: 17},
+		{Function: "<main>", Line: 6, Steps: 4, CumSteps: 202},
+		{Function: "<main>", Line: 1, Steps: 2, CumSteps: 2},
+		{Function: "map", Native: true, CumSteps: 10},
+	}
+	if entries := profileSteps(ctx.Profile.Lines()); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected lines %#v, got %#v", expected, entries)
+	}
+}
+
+func TestProfileTemplates(t *testing.T) {
+	e := New()
+	set := NewTemplateSet(e)
+	if _, err := set.Add("item", "<li>{% name %}</li>"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := set.Add("page", "<ul>\n{% foreach (items as item) { include(\"item\", {\"name\": item}); } %}\n</ul>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A profile adds up every evaluation it is set on.
+	profile := NewProfile()
+	for range 2 {
+		ctx, err := page.NewExecutionContext(Vars{"items": []any{"a", "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.Profile = profile
+		if _, err := page.RenderContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []ProfileEntry{
+		{Function: "<main>", Template: "page", Steps: 36, CumSteps: 52},
+		{Function: "<main>", Template: "item", Steps: 16, CumSteps: 16},
+		{Function: "include", Native: true, CumSteps: 16},
+	}
+	if entries := profileSteps(profile.Functions()); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected functions %#v, got %#v", expected, entries)
+	}
+
+	var report strings.Builder
+	if err := profile.WriteLines(&report); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(report.String(), "\n")
+	if !strings.HasPrefix(lines[0], "Total: 52 steps, ") || !strings.HasSuffix(lines[0], " in 2 evaluations") {
+		t.Fatalf("unexpected total %q", lines[0])
+	}
+	if lines[1] != "      flat   flat%    sum%        cum    cum%       time   cum time" {
+		t.Fatalf("unexpected header %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "        34  65.38%  65.38%         50  96.15% ") || !strings.HasSuffix(lines[2], "  <main> (page:2)") {
+		t.Fatalf("unexpected first line %q", lines[2])
+	}
+}
+
+func TestProfileTime(t *testing.T) {
+	ctx := newScriptContext(t, profiledScript)
+	ctx.Profile = NewProfile()
+	if _, err := New().Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range ctx.Profile.Functions() {
+		if e.Time < 0 || e.CumTime < e.Time || e.Steps > 0 && e.Time == 0 {
+			t.Errorf("unexpected times of %s: %v, %v", e, e.Time, e.CumTime)
+		}
+	}
+}
+
+func TestProfileConcurrent(t *testing.T) {
+	tmpl, err := Compile("{% foreach (items as item) { %}{% item %}{% } %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := NewProfile()
+	coverage := NewCoverage()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			ctx, err := tmpl.NewExecutionContext(Vars{"items": []any{1, 2, 3}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ctx.Profile, ctx.Coverage = profile, coverage
+			if _, err := tmpl.RenderContext(ctx); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if entries := profile.Functions(); len(entries) != 1 || entries[0].Steps != 8*ctxSteps(t, tmpl) {
+		t.Fatalf("unexpected profile %+v", entries)
+	}
+	if files := coverage.Files(); len(files) != 1 || files[0].Branches[0].Taken != 24 {
+		t.Fatalf("unexpected coverage %+v", files)
+	}
+}
+
+// ctxSteps returns the number of steps a render of tmpl takes.
+func ctxSteps(t *testing.T, tmpl *Template) int {
+	ctx, err := tmpl.NewExecutionContext(Vars{"items": []any{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.RenderContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return ctx.steps
+}
+
+func TestProfilePprof(t *testing.T) {
+	set := NewTemplateSet(New())
+	if _, err := set.Add("item", "{% fn twice(s) { return s + s; } %}{% twice(name) %}"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := set.Add("page", "{% include(\"item\", {\"name\": \"a\"}) %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := page.NewExecutionContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Profile = NewProfile()
+	ctx.Profile.Path = func(template string) string { return "/templates/" + template + ".html" }
+	if _, err := page.RenderContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ctx.Profile.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := decodeProtobuf(t, data)
+
+	var table []string
+	for _, s := range profile[6] {
+		table = append(table, string(s.([]byte)))
+	}
+	str := func(v any) string { return table[v.(uint64)] }
+
+	functions := make(map[uint64]string)
+	for _, f := range profile[5] {
+		fields := decodeProtobuf(t, f.([]byte))
+		name := str(fields[2][0])
+		if file, ok := fields[4]; ok {
+			name += " " + str(file[0])
+		}
+		functions[fields[1][0].(uint64)] = name
+	}
+
+	locations := make(map[uint64]string)
+	for _, l := range profile[4] {
+		fields := decodeProtobuf(t, l.([]byte))
+		line := decodeProtobuf(t, fields[4][0].([]byte))
+		var number uint64
+		if n, ok := line[2]; ok {
+			number = n[0].(uint64)
+		}
+		locations[fields[1][0].(uint64)] = functions[line[1][0].(uint64)] + ":" + strconv.FormatUint(number, 10)
+	}
+
+	var samples []string
+	steps := 0
+	for _, s := range profile[2] {
+		fields := decodeProtobuf(t, s.([]byte))
+		var stack []string
+		for _, id := range decodePacked(t, fields[1][0].([]byte)) {
+			stack = append(stack, locations[id])
+		}
+		values := decodePacked(t, fields[2][0].([]byte))
+		steps += int(values[0])
+		samples = append(samples, strings.Join(stack, " < "))
+	}
+
+	expected := []string{
+		"page.main /templates/page.html:1",
+		"item.main /templates/item.html:1 < include:0 < page.main /templates/page.html:1",
+		"item.twice /templates/item.html:1 < item.main /templates/item.html:1 < include:0 < page.main /templates/page.html:1",
+	}
+	if !reflect.DeepEqual(samples, expected) {
+		t.Fatalf("expected samples %q, got %q", expected, samples)
+	}
+	if steps != ctx.steps {
+		t.Fatalf("expected %d steps, got %d", ctx.steps, steps)
+	}
+	if types := len(profile[1]); types != 2 || str(decodeProtobuf(t, profile[1][0].([]byte))[1][0]) != "steps" {
+		t.Fatalf("unexpected sample types %v", profile[1])
+	}
+}
+
+// decodeProtobuf decodes the varint and length-delimited fields of a
+// protocol buffer message, by field number.
+func decodeProtobuf(t *testing.T, data []byte) map[int][]any {
+	t.Helper()
+	fields := make(map[int][]any)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid key in %x", data)
+		}
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatalf("invalid varint in %x", data)
+			}
+			data = data[n:]
+			fields[field] = append(fields[field], v)
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || int(size) > len(data)-n {
+				t.Fatalf("invalid length in %x", data)
+			}
+			fields[field] = append(fields[field], data[n:n+int(size)])
+			data = data[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func decodePacked(t *testing.T, data []byte) []uint64 {
+	t.Helper()
+	var values []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid varint in %x", data)
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values
+}
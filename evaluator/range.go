@@ -0,0 +1,83 @@
+package evaluator
+
+import "fmt"
+
+// RangeValue is the integers from Start to End, counting by Step, which is
+// never zero. End is only included if the range is not Exclusive and Step
+// lands on it. A range is lazy: foreach counts through it without building
+// an array, so that it does not count against MaxArraySize.
+type RangeValue struct {
+	Start     int
+	End       int
+	Step      int
+	Exclusive bool
+}
+
+// newRange creates the range of the operands of a range expression, with
+// a step of 1 if step is nil.
+func newRange(start, end, step Object, exclusive bool) (*RangeValue, error) {
+	from, ok := start.(*IntegerValue)
+	if !ok {
+		return nil, newError(ErrTypeMismatch, "range start must be an integer, got %s", start.Type())
+	}
+	to, ok := end.(*IntegerValue)
+	if !ok {
+		return nil, newError(ErrTypeMismatch, "range end must be an integer, got %s", end.Type())
+	}
+
+	r := &RangeValue{Start: from.Value, End: to.Value, Step: 1, Exclusive: exclusive}
+	if step != nil {
+		by, ok := step.(*IntegerValue)
+		if !ok {
+			return nil, newError(ErrTypeMismatch, "range step must be an integer, got %s", step.Type())
+		}
+		if by.Value == 0 {
+			return nil, newError(ErrTypeMismatch, "range step cannot be zero")
+		}
+		r.Step = by.Value
+	}
+	return r, nil
+}
+
+func (r *RangeValue) Type() ObjectType {
+	return RangeObject
+}
+
+func (r *RangeValue) Debug() string {
+	op := ".."
+	if r.Exclusive {
+		op = "..<"
+	}
+	if r.Step != 1 {
+		return fmt.Sprintf("%d%s%d step %d", r.Start, op, r.End, r.Step)
+	}
+	return fmt.Sprintf("%d%s%d", r.Start, op, r.End)
+}
+
+// Len returns the number of integers in r. A range counting up from past
+// its end, or down from before it, is empty.
+func (r *RangeValue) Len() int {
+	end := r.End
+	if r.Step > 0 {
+		if r.Exclusive {
+			end--
+		}
+		if end < r.Start {
+			return 0
+		}
+		return (end-r.Start)/r.Step + 1
+	}
+
+	if r.Exclusive {
+		end++
+	}
+	if end > r.Start {
+		return 0
+	}
+	return (r.Start-end)/-r.Step + 1
+}
+
+// At returns the ith integer of r.
+func (r *RangeValue) At(i int) int {
+	return r.Start + i*r.Step
+}
@@ -0,0 +1,96 @@
+package evaluator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let r = []; foreach (0..4 as i) { r.append(i); } return r.join(",");`, "0,1,2,3,4"},
+		{`let r = []; foreach (0..<4 as i) { r.append(i); } return r.join(",");`, "0,1,2,3"},
+		{`let r = []; foreach (1..10 step 3 as i) { r.append(i); } return r.join(",");`, "1,4,7,10"},
+		{`let r = []; foreach (1..<10 step 3 as i) { r.append(i); } return r.join(",");`, "1,4,7"},
+		{`let r = []; foreach (5..1 step -2 as i) { r.append(i); } return r.join(",");`, "5,3,1"},
+		{`let r = []; foreach (5..<1 step -2 as i) { r.append(i); } return r.join(",");`, "5,3"},
+		{`let r = []; foreach (3..0 as i) { r.append(i); } return r.len();`, "0"},
+		{`let r = []; foreach (0..<0 as i) { r.append(i); } return r.len();`, "0"},
+		{`let r = []; foreach (10..12 as i, n) { r.append(i + ":" + n); } return r.join(",");`, "0:10,1:11,2:12"},
+		{`let n = 3; let r = []; foreach (0..n - 1 as i) { r.append(i); } return r.join(",");`, "0,1,2"},
+		{`let sum = 0; foreach (1..100 as i) { if (i > 4) { break; } if (i == 2) { continue; } sum += i; } return sum;`, "8"},
+		{`return 0..<10 step 2;`, "0..<10 step 2"},
+		{`return "${1..3}";`, "${1..3}"},
+		{"return `${1..3}`;", "1..3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			val := unwrapReturn(t, evalScript(t, tt.input))
+			if val.Debug() != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, val.Debug())
+			}
+		})
+	}
+}
+
+func TestEvaluateRangeIsLazy(t *testing.T) {
+	// Iterating over a range builds no array, so its size is not limited
+	// by MaxArraySize.
+	ctx := newScriptContext(t, `let sum = 0; foreach (1..1000 as i) { sum += i; } return sum;`)
+	ctx.MaxArraySize = 10
+	result, err := New().Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDebug(t, unwrapReturn(t, result), "500500")
+}
+
+func TestEvaluateRangeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return 0..<"3";`, "range end must be an integer, got STRING"},
+		{`return 1.5..3;`, "range start must be an integer, got DECIMAL"},
+		{`return 0..3 step null;`, "range step must be an integer, got NULL"},
+		{`return 0..3 step 0;`, "range step cannot be zero"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := evalScriptError(t, tt.input)
+			if !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestRangeLen(t *testing.T) {
+	tests := []struct {
+		r        RangeValue
+		expected int
+	}{
+		{RangeValue{Start: 0, End: 10, Step: 1}, 11},
+		{RangeValue{Start: 0, End: 10, Step: 1, Exclusive: true}, 10},
+		{RangeValue{Start: 0, End: 10, Step: 3}, 4},
+		{RangeValue{Start: 0, End: 9, Step: 3, Exclusive: true}, 3},
+		{RangeValue{Start: 10, End: 0, Step: -5}, 3},
+		{RangeValue{Start: 10, End: 0, Step: -5, Exclusive: true}, 2},
+		{RangeValue{Start: 10, End: 0, Step: 1}, 0},
+		{RangeValue{Start: 0, End: 10, Step: -1}, 0},
+		{RangeValue{Start: 5, End: 5, Step: 1, Exclusive: true}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.r.Debug(), func(t *testing.T) {
+			if n := tt.r.Len(); n != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, n)
+			}
+		})
+	}
+}
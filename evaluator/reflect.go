@@ -0,0 +1,462 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// converter converts Go values to script objects by reflection.
+type converter struct {
+	// methods exposes the exported methods of structs as functions.
+	methods bool
+	// visiting holds the struct pointers being converted, to detect cycles.
+	visiting map[uintptr]bool
+}
+
+// methodValue marks a value whose methods ToObject should expose.
+type methodValue struct {
+	value any
+}
+
+// WithMethods marks v so that ToObject exposes the exported methods of the
+// structs in it as script functions, alongside their fields:
+//
+//	evaluator.Vars{"user": evaluator.WithMethods(user)}
+//
+// Methods may take a context.Context as their first parameter, which
+// receives the execution context's Context, and may return a value, an
+// error, or both. Methods with pointer receivers are only available when v
+// holds a pointer.
+func WithMethods(v any) any {
+	return methodValue{value: v}
+}
+
+func (c *converter) convertValue(rv reflect.Value) (Object, error) {
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return Null, nil
+		}
+		if rv.Elem().Kind() != reflect.Struct {
+			return c.convert(rv.Elem().Interface())
+		}
+
+		if c.visiting == nil {
+			c.visiting = make(map[uintptr]bool)
+		}
+		ptr := rv.Pointer()
+		if c.visiting[ptr] {
+			return nil, fmt.Errorf("cycle detected at %s", rv.Type())
+		}
+		c.visiting[ptr] = true
+		defer delete(c.visiting, ptr)
+
+		return c.convertStruct(rv.Elem(), rv)
+	case reflect.Struct:
+		return c.convertStruct(rv, rv)
+	case reflect.Slice, reflect.Array:
+		elements := make([]Object, rv.Len())
+		for i := range rv.Len() {
+			obj, err := c.convert(rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("element [%d]: %w", i, err)
+			}
+			elements[i] = obj
+		}
+		return &ArrayValue{Elements: elements}, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		hash := NewHashValue()
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := iter.Key().String()
+			obj, err := c.convert(iter.Value().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k, err)
+			}
+			if err := hash.Set(&StringValue{Value: k}, obj); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.String:
+		return &StringValue{Value: rv.String()}, nil
+	case reflect.Bool:
+		return &BooleanValue{Value: rv.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &IntegerValue{Value: int(rv.Int())}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt {
+			return nil, fmt.Errorf("integer overflow: %d", rv.Uint())
+		}
+		return &IntegerValue{Value: int(rv.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &DecimalValue{Value: rv.Float()}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type: %s", rv.Type())
+}
+
+// convertStruct converts the struct rv to a hash of its exported fields,
+// plus the methods of receiver when methods are exposed.
+func (c *converter) convertStruct(rv, receiver reflect.Value) (Object, error) {
+	hash := NewHashValue()
+
+	if err := c.addFields(hash, rv, false); err != nil {
+		return nil, err
+	}
+
+	if c.methods {
+		if err := c.addMethods(hash, receiver); err != nil {
+			return nil, err
+		}
+	}
+
+	return hash, nil
+}
+
+// addFields adds the exported fields of rv to hash. Fields of embedded
+// structs are promoted, unless a shallower field has the same name, and
+// fields tagged script:"-" or holding unconvertible values are skipped.
+func (c *converter) addFields(hash *HashValue, rv reflect.Value, promoted bool) error {
+	t := rv.Type()
+
+	var embedded []reflect.Value
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		name := field.Tag.Get("script")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				// Exported fields are promoted even when the embedded
+				// type itself is unexported.
+				embedded = append(embedded, rv.Field(i))
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fv := rv.Field(i)
+		if unconvertible(fv) {
+			continue
+		}
+
+		key := &StringValue{Value: name}
+		if promoted && hash.HasKey(key) {
+			continue
+		}
+
+		obj, err := c.convert(fv.Interface())
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if err := hash.Set(key, obj); err != nil {
+			return err
+		}
+	}
+
+	for _, fv := range embedded {
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if err := c.addFields(hash, fv, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unconvertible reports whether the field fv holds a value that has no
+// script equivalent, such as a function or a channel. Such fields are left
+// out of the hash rather than failing the whole struct.
+func unconvertible(fv reflect.Value) bool {
+	if fv.Kind() == reflect.Interface {
+		fv = fv.Elem()
+	}
+	switch fv.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Map:
+		return fv.Type().Key().Kind() != reflect.String
+	}
+	return false
+}
+
+// addMethods adds the exported methods of receiver to hash as functions.
+// Fields take precedence over methods with the same name, and methods with
+// signatures that can't be called from scripts are skipped.
+func (c *converter) addMethods(hash *HashValue, receiver reflect.Value) error {
+	t := receiver.Type()
+
+	for i := range t.NumMethod() {
+		method := t.Method(i)
+		fn := receiver.Method(i)
+		if !callableMethod(fn.Type()) {
+			continue
+		}
+
+		key := &StringValue{Value: method.Name}
+		if hash.HasKey(key) {
+			continue
+		}
+
+		if err := hash.Set(key, c.methodFunction(method.Name, fn)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// callableMethod reports whether a method returns nothing, a value, an
+// error, or a value and an error.
+func callableMethod(ft reflect.Type) bool {
+	switch ft.NumOut() {
+	case 0, 1:
+		return true
+	case 2:
+		return ft.Out(1) == errorType
+	default:
+		return false
+	}
+}
+
+func (c *converter) methodFunction(name string, fn reflect.Value) *BuiltInFunction {
+	ft := fn.Type()
+	methods := c.methods
+
+	return &BuiltInFunction{
+		Name: name,
+		Fn: func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+			params := ft.NumIn()
+			in := make([]reflect.Value, 0, params+len(args))
+
+			first := 0
+			if params > 0 && ft.In(0) == contextType {
+				goCtx := ctx.Context
+				if goCtx == nil {
+					goCtx = context.Background()
+				}
+				in = append(in, reflect.ValueOf(goCtx))
+				first = 1
+			}
+
+			expected := params - first
+			if ft.IsVariadic() {
+				if len(args) < expected-1 {
+					return nil, newError(ErrArgumentCount, "%s: expected at least %d arguments, got %d", name, expected-1, len(args))
+				}
+			} else if len(args) != expected {
+				return nil, newError(ErrArgumentCount, "%s: expected %d arguments, got %d", name, expected, len(args))
+			}
+
+			for i, arg := range args {
+				var pt reflect.Type
+				if ft.IsVariadic() && first+i >= params-1 {
+					pt = ft.In(params - 1).Elem()
+				} else {
+					pt = ft.In(first + i)
+				}
+
+				v, err := fromObject(arg, pt)
+				if err != nil {
+					return nil, newError(ErrTypeMismatch, "%s: argument %d: %v", name, i+1, err)
+				}
+				in = append(in, v)
+			}
+
+			out := fn.Call(in)
+
+			if len(out) > 0 && ft.Out(len(out)-1) == errorType {
+				if err, _ := out[len(out)-1].Interface().(error); err != nil {
+					return nil, err
+				}
+				out = out[:len(out)-1]
+			}
+
+			if len(out) == 0 {
+				return Null, nil
+			}
+
+			return (&converter{methods: methods}).convert(out[0].Interface())
+		},
+	}
+}
+
+// fromObject converts obj to a Go value of type t.
+func fromObject(obj Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if goValue := FromObject(obj); goValue != nil {
+			return reflect.ValueOf(goValue), nil
+		}
+		return reflect.Zero(t), nil
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	if _, ok := obj.(*NullValue); ok {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	v := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.String:
+		switch o := obj.(type) {
+		case *StringValue:
+			v.SetString(o.Value)
+			return v, nil
+		case *SafeStringValue:
+			v.SetString(o.Value)
+			return v, nil
+		}
+	case reflect.Bool:
+		if o, ok := obj.(*BooleanValue); ok {
+			v.SetBool(o.Value)
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o, ok := obj.(*IntegerValue); ok {
+			if v.OverflowInt(int64(o.Value)) {
+				return v, fmt.Errorf("%d overflows %s", o.Value, t)
+			}
+			v.SetInt(int64(o.Value))
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if o, ok := obj.(*IntegerValue); ok {
+			if o.Value < 0 || v.OverflowUint(uint64(o.Value)) {
+				return v, fmt.Errorf("%d overflows %s", o.Value, t)
+			}
+			v.SetUint(uint64(o.Value))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch o := obj.(type) {
+		case *DecimalValue:
+			v.SetFloat(o.Value)
+			return v, nil
+		case *IntegerValue:
+			v.SetFloat(float64(o.Value))
+			return v, nil
+		}
+	case reflect.Slice:
+		if o, ok := obj.(*ArrayValue); ok {
+			v = reflect.MakeSlice(t, len(o.Elements), len(o.Elements))
+			for i, elem := range o.Elements {
+				ev, err := fromObject(elem, t.Elem())
+				if err != nil {
+					return v, fmt.Errorf("element [%d]: %w", i, err)
+				}
+				v.Index(i).Set(ev)
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if o, ok := obj.(*HashValue); ok && t.Key().Kind() == reflect.String {
+			v = reflect.MakeMapWithSize(t, len(o.Pairs))
+			for _, pair := range o.OrderedPairs() {
+				key, ok := pair.Key.(*StringValue)
+				if !ok {
+					return v, fmt.Errorf("key %s is not a string", pair.Key.Type())
+				}
+				ev, err := fromObject(pair.Value, t.Elem())
+				if err != nil {
+					return v, fmt.Errorf("key %q: %w", key.Value, err)
+				}
+				v.SetMapIndex(reflect.ValueOf(key.Value).Convert(t.Key()), ev)
+			}
+			return v, nil
+		}
+	case reflect.Struct:
+		if o, ok := obj.(*DateTimeValue); ok && t == timeType {
+			return reflect.ValueOf(o.Value), nil
+		}
+	case reflect.Pointer:
+		ev, err := fromObject(obj, t.Elem())
+		if err != nil {
+			return v, err
+		}
+		v = reflect.New(t.Elem())
+		v.Elem().Set(ev)
+		return v, nil
+	}
+
+	return v, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
+
+// FromObject converts a script Object to a plain Go value: strings, ints,
+// float64s, bools, time.Time, []any and map[string]any, with nil for null.
+// Other objects, such as functions, are returned unchanged.
+func FromObject(obj Object) any {
+	switch o := obj.(type) {
+	case *StringValue:
+		return o.Value
+	case *SafeStringValue:
+		return o.Value
+	case *IntegerValue:
+		return o.Value
+	case *DecimalValue:
+		return o.Value
+	case *BooleanValue:
+		return o.Value
+	case *NullValue:
+		return nil
+	case *DateTimeValue:
+		return o.Value
+	case *ArrayValue:
+		elements := make([]any, len(o.Elements))
+		for i, elem := range o.Elements {
+			elements[i] = FromObject(elem)
+		}
+		return elements
+	case *HashValue:
+		m := make(map[string]any, len(o.Pairs))
+		for _, pair := range o.OrderedPairs() {
+			key := pair.Key.Debug()
+			if s, ok := pair.Key.(*StringValue); ok {
+				key = s.Value
+			}
+			m[key] = FromObject(pair.Value)
+		}
+		return m
+	default:
+		return obj
+	}
+}
@@ -0,0 +1,340 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testStatus string
+
+type testAddress struct {
+	City    string `script:"city"`
+	Country string `script:"country"`
+}
+
+type testAudit struct {
+	CreatedBy string
+	Name      string `script:"name"`
+}
+
+type testUser struct {
+	testAudit
+	ID        uint
+	Name      string       `script:"name"`
+	Email     string       `script:"email"`
+	Password  string       `script:"-"`
+	Status    testStatus   `script:"status"`
+	Address   *testAddress `script:"address"`
+	Previous  *testAddress `script:"previous"`
+	Tags      []string     `script:"tags"`
+	CreatedAt time.Time    `script:"createdAt"`
+	internal  string
+}
+
+func (u testUser) Greeting(greeting string) string {
+	return greeting + ", " + u.Name + "!"
+}
+
+func (u *testUser) Rename(name string) {
+	u.Name = name
+}
+
+func (u testUser) Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+func (u testUser) Lookup(key string) (string, error) {
+	if key == "" {
+		return "", errTestLookup
+	}
+	return strings.ToUpper(key), nil
+}
+
+func (u testUser) Sum(values ...int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func (u testUser) Describe(opts map[string]any, limits []int) string {
+	return opts["label"].(string) + ":" + strings.Repeat("*", len(limits))
+}
+
+func (u testUser) Home() *testAddress {
+	return u.Address
+}
+
+func (a *testAddress) Label() string {
+	return a.City + ", " + a.Country
+}
+
+type tenantContextKey struct{}
+
+var errTestLookup = errors.New("empty key")
+
+func newTestUser() *testUser {
+	return &testUser{
+		testAudit: testAudit{CreatedBy: "admin", Name: "shadowed"},
+		ID:        7,
+		Name:      "Alice",
+		Email:     "alice@example.com",
+		Password:  "secret",
+		Status:    "active",
+		Address:   &testAddress{City: "Leeds", Country: "UK"},
+		Tags:      []string{"admin", "beta"},
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		internal:  "hidden",
+	}
+}
+
+func TestToObject_Struct(t *testing.T) {
+	obj, err := ToObject(newTestUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, ok := obj.(*HashValue)
+	if !ok {
+		t.Fatalf("expected *HashValue, got %T", obj)
+	}
+
+	expected := map[string]string{
+		"ID":        "7",
+		"name":      "Alice",
+		"email":     "alice@example.com",
+		"status":    "active",
+		"tags":      "[admin, beta]",
+		"createdAt": "2024-01-02T03:04:05Z",
+		"CreatedBy": "admin",
+	}
+	for key, want := range expected {
+		val, ok := hash.GetValue(NewStringValue(key))
+		if !ok {
+			t.Fatalf("expected key %q", key)
+		}
+		if got := val.Debug(); key != "tags" && got != want {
+			t.Fatalf("key %q: expected %q, got %q", key, want, got)
+		}
+	}
+
+	for _, key := range []string{"Password", "internal", "Name", "testAudit", "Address"} {
+		if hash.HasKey(NewStringValue(key)) {
+			t.Fatalf("did not expect key %q", key)
+		}
+	}
+
+	if val, _ := hash.GetValue(NewStringValue("previous")); val.Type() != NullObject {
+		t.Fatalf("expected nil pointer to convert to null, got %s", val.Type())
+	}
+}
+
+func TestToObject_StructInScript(t *testing.T) {
+	result, err := RunScript(`return user.name + " " + user.address.city + " " + user.tags[1] + " " + len(user.tags);`, Vars{"user": newTestUser()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unwrapReturn(t, result).Debug(); got != "Alice Leeds beta 2" {
+		t.Fatalf("unexpected result: %q", got)
+	}
+}
+
+func TestToObject_StructInTemplate(t *testing.T) {
+	users := []testUser{*newTestUser(), {Name: "Bob", Status: "invited"}}
+
+	out, err := RunTemplate(`{% foreach (users as u) { %}<li>{% u.name %} ({% u.status %})</li>{% } %}`, Vars{"users": users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "<li>Alice (active)</li><li>Bob (invited)</li>" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestToObject_StructNoMethods(t *testing.T) {
+	obj, err := ToObject(newTestUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.(*HashValue).HasKey(NewStringValue("Greeting")) {
+		t.Fatal("did not expect methods without WithMethods")
+	}
+}
+
+func TestToObject_StructCycle(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	n := &node{}
+	n.Next = n
+
+	_, err := ToObject(n)
+	if err == nil || !strings.Contains(err.Error(), "cycle detected") {
+		t.Fatalf("expected cycle error, got: %v", err)
+	}
+}
+
+func TestToObject_StructSharedPointer(t *testing.T) {
+	addr := &testAddress{City: "Leeds"}
+	_, err := ToObject(map[string]any{"home": addr, "work": addr, "list": []*testAddress{addr, addr}})
+	if err != nil {
+		t.Fatalf("expected a shared pointer not to be a cycle, got: %v", err)
+	}
+}
+
+func TestToObject_StructUnconvertibleFields(t *testing.T) {
+	obj, err := ToObject(struct {
+		Name    string
+		OnSave  func() error
+		Done    chan struct{}
+		Handler any
+		Counts  map[int]string
+	}{Name: "a", OnSave: func() error { return nil }, Handler: func() {}})
+	if err != nil {
+		t.Fatalf("expected unconvertible fields to be skipped, got: %v", err)
+	}
+	hash := obj.(*HashValue)
+	if len(hash.Pairs) != 1 || !hash.HasKey(NewStringValue("Name")) {
+		t.Fatalf("expected only Name, got %v", FromObject(hash))
+	}
+}
+
+func TestToObject_StructFieldError(t *testing.T) {
+	_, err := ToObject(struct{ N uint64 }{N: math.MaxUint64})
+	if err == nil || !strings.Contains(err.Error(), "field N") {
+		t.Fatalf("expected error naming the field, got: %v", err)
+	}
+}
+
+func TestToObject_NamedAndUnsignedTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		val  any
+		want string
+	}{
+		{"named string", testStatus("active"), "active"},
+		{"uint", uint(5), "5"},
+		{"uint8", uint8(8), "8"},
+		{"uint64", uint64(64), "64"},
+		{"pointer to int", new(int), "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := ToObject(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obj.Debug() != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, obj.Debug())
+			}
+		})
+	}
+
+	if _, err := ToObject(uint64(1 << 63)); err == nil {
+		t.Fatal("expected overflow error for uint64 beyond int range")
+	}
+}
+
+func TestWithMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"value method", `return user.Greeting("Hello");`, "Hello, Alice!"},
+		{"pointer method", `user.Rename("Carol"); return user.Greeting("Hi");`, "Hi, Carol!"},
+		{"context method", `return user.Tenant();`, "acme"},
+		{"value and error", `return user.Lookup("abc");`, "ABC"},
+		{"variadic", `return user.Sum(1, 2, 3);`, "6"},
+		{"variadic empty", `return user.Sum();`, "0"},
+		{"hash and array arguments", `return user.Describe({"label": "x"}, [1, 2]);`, "x:**"},
+		{"nested methods", `return user.Home().Label();`, "Leeds, UK"},
+		{"nested field methods", `return user.address.Label();`, "Leeds, UK"},
+		{"fields still present", `return user.name;`, "Alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newScriptContext(t, tt.input)
+			ctx.Context = context.WithValue(context.Background(), tenantContextKey{}, "acme")
+			if err := applyVars(ctx.RootScope, []Vars{{"user": WithMethods(newTestUser())}}); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := New().Evaluate(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unwrapReturn(t, result).Debug(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWithMethodsPointerReceiver(t *testing.T) {
+	user := newTestUser()
+
+	if _, err := RunScript(`user.Rename("Carol");`, Vars{"user": WithMethods(user)}); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Carol" {
+		t.Fatalf("expected pointer method to modify the struct, got %q", user.Name)
+	}
+
+	_, err := RunScript(`user.Rename("Dave");`, Vars{"user": WithMethods(*user)})
+	if !errors.Is(err, ErrNotCallable) {
+		t.Fatalf("expected pointer methods to be missing from a struct value, got: %v", err)
+	}
+}
+
+func TestWithMethodsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		kind  error
+	}{
+		{"method error", `user.Lookup("");`, errTestLookup},
+		{"argument count", `user.Greeting();`, ErrArgumentCount},
+		{"too many arguments", `user.Greeting("a", "b");`, ErrArgumentCount},
+		{"type mismatch", `user.Greeting(1);`, ErrTypeMismatch},
+		{"variadic type mismatch", `user.Sum(1, "2");`, ErrTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RunScript(tt.input, Vars{"user": WithMethods(newTestUser())})
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got: %v", tt.kind, err)
+			}
+
+			var rtErr *RuntimeError
+			if !errors.As(err, &rtErr) || len(rtErr.Stack) == 0 || !rtErr.Stack[0].Native {
+				t.Fatalf("expected a native frame for the method, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	hash := NewHashValue()
+	_ = hash.Set(NewStringValue("name"), NewStringValue("Alice"))
+	_ = hash.Set(NewStringValue("scores"), NewArrayValue([]Object{NewIntegerValue(1), NewDecimalValue(2.5), Null}))
+	_ = hash.Set(NewStringValue("active"), NewBooleanValue(true))
+
+	expected := map[string]any{
+		"name":   "Alice",
+		"scores": []any{1, 2.5, nil},
+		"active": true,
+	}
+	if got := FromObject(hash); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
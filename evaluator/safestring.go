@@ -0,0 +1,29 @@
+package evaluator
+
+import "hash/fnv"
+
+// SafeStringValue is a string that has been marked as safe for direct
+// output. Template auto-escaping writes it to the output unchanged.
+type SafeStringValue struct {
+	Value string
+}
+
+func NewSafeStringValue(s string) *SafeStringValue {
+	return &SafeStringValue{s}
+}
+
+func (s *SafeStringValue) Debug() string {
+	return s.Value
+}
+
+func (s *SafeStringValue) Type() ObjectType {
+	return SafeStringObject
+}
+
+func (s *SafeStringValue) HashKey() HashKey {
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s.Value))
+
+	return HashKey{Type: StringObject, Value: h.Sum64()}
+}
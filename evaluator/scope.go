@@ -1,7 +1,14 @@
 package evaluator
 
+import "slices"
+
+// Scope holds variables. The scopes of function calls, foreach iterations
+// and catch blocks keep the variables the resolver found declared in them
+// in slots, and any others set by name in store.
 type Scope struct {
 	store  map[string]Object
+	slots  []Object
+	names  []string
 	parent *Scope
 }
 
@@ -17,41 +24,97 @@ func NewChildScope(parent *Scope) *Scope {
 	return s
 }
 
+// newSlotScope creates a child scope of parent with a slot for each of
+// names. A slot holds nil until its variable is set.
+func newSlotScope(parent *Scope, names []string) *Scope {
+	return &Scope{
+		slots:  make([]Object, len(names)),
+		names:  names,
+		parent: parent,
+	}
+}
+
+// slot returns the index of the slot for name, or -1 if there is none.
+func (s *Scope) slot(name string) int {
+	for i, n := range s.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parent returns the scope s was created in, or nil for a root scope.
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
 func (s *Scope) Get(name string) (Object, bool) {
-	val, ok := s.store[name]
-	if !ok && s.parent != nil {
-		val, ok = s.parent.Get(name)
+	for ; s != nil; s = s.parent {
+		if val, ok := s.GetLocal(name); ok {
+			return val, true
+		}
 	}
-	return val, ok
+	return nil, false
 }
 
 func (s *Scope) GetLocal(name string) (Object, bool) {
+	if i := s.slot(name); i >= 0 {
+		return s.slots[i], s.slots[i] != nil
+	}
 	val, ok := s.store[name]
 	return val, ok
 }
 
 func (s *Scope) Assign(name string, val Object) bool {
+	for ; s != nil; s = s.parent {
+		if i := s.slot(name); i >= 0 && s.slots[i] != nil {
+			s.slots[i] = val
+			return true
+		}
 
-	_, ok := s.store[name]
-	if !ok {
-		// if the variable is not found in the current scope,
-		// check the parent scope if it exists
-		if s.parent != nil {
-			return s.parent.Assign(name, val)
+		// if the variable is found in the current scope,
+		// update its value
+		if _, ok := s.store[name]; ok {
+			s.store[name] = val
+			return true
 		}
-		return false
 	}
 
-	// if the variable is found in the current scope,
-	// update its value
-	s.store[name] = val
-	return true
+	return false
 }
 
 func (s *Scope) SetLocal(name string, val Object) {
+	if i := s.slot(name); i >= 0 {
+		s.slots[i] = val
+		return
+	}
+	if s.store == nil {
+		s.store = make(map[string]Object)
+	}
 	s.store[name] = val
 }
 
 func (s *Scope) DeleteLocal(name string) {
+	if i := s.slot(name); i >= 0 {
+		s.slots[i] = nil
+		return
+	}
 	delete(s.store, name)
 }
+
+// Names returns the names of the variables set in s itself, not in its
+// parents, in sorted order.
+func (s *Scope) Names() []string {
+	names := make([]string, 0, len(s.store)+len(s.slots))
+	for i, val := range s.slots {
+		if val != nil {
+			names = append(names, s.names[i])
+		}
+	}
+	for name := range s.store {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
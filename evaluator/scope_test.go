@@ -0,0 +1,25 @@
+package evaluator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScopeNames(t *testing.T) {
+	parent := NewScope()
+	parent.SetLocal("outer", Null)
+
+	s := newSlotScope(parent, []string{"b", "unset", "a"})
+	s.SetLocal("b", Null)
+	s.SetLocal("a", Null)
+	s.SetLocal("extra", Null)
+
+	if got := s.Names(); !reflect.DeepEqual(got, []string{"a", "b", "extra"}) {
+		t.Fatalf("unexpected names: %v", got)
+	}
+
+	s.DeleteLocal("b")
+	if got := s.Names(); !reflect.DeepEqual(got, []string{"a", "extra"}) {
+		t.Fatalf("unexpected names after delete: %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+)
+
+// StackFrame is one entry of a script stack trace. Line and Column give the
+// position reached in Function, which is the error position for the
+// innermost frame and a call site for the others. Native frames are Go
+// functions registered with RegisterFunction and have no position.
+type StackFrame struct {
+	Function string
+	Template string
+	Line     int
+	Column   int
+	Native   bool
+}
+
+func (f StackFrame) String() string {
+	if f.Native {
+		return fmt.Sprintf("at %s (native)", f.Function)
+	}
+	if f.Template != "" {
+		return fmt.Sprintf("at %s (%s, line %d, column %d)", f.Function, f.Template, f.Line, f.Column)
+	}
+	return fmt.Sprintf("at %s (line %d, column %d)", f.Function, f.Line, f.Column)
+}
+
+const (
+	mainFunction      = "<main>"
+	anonymousFunction = "<anonymous>"
+)
+
+// callFrame records a function call in progress: the function being called,
+// and where and in which scope it was called from. profile is the node of
+// the call in the profile of the evaluation, once it has one.
+type callFrame struct {
+	function string
+	native   bool
+	template string
+	token    lexer.Token
+	scope    *Scope
+	profile  int
+}
+
+func (ctx *ExecutionContext) pushFrame(fn Object, scope *Scope, token lexer.Token) {
+	frame := callFrame{
+		function: anonymousFunction,
+		template: ctx.template,
+		token:    token,
+		scope:    scope,
+	}
+
+	switch f := fn.(type) {
+	case *FunctionValue:
+		if f.Name != "" {
+			frame.function = f.Name
+		}
+	case *BuiltInFunction:
+		frame.native = true
+		if f.Name != "" {
+			frame.function = f.Name
+		}
+	}
+
+	ctx.frames = append(ctx.frames, frame)
+}
+
+func (ctx *ExecutionContext) popFrame() {
+	ctx.frames = ctx.frames[:len(ctx.frames)-1]
+}
+
+// callSite returns the call site of the innermost function call, which
+// built-ins use when they call back into script functions.
+func (ctx *ExecutionContext) callSite() lexer.Token {
+	if len(ctx.frames) == 0 {
+		return lexer.Token{}
+	}
+	return ctx.frames[len(ctx.frames)-1].token
+}
+
+// traceError locates err at token if nothing inside the call has, and
+// attaches the current call stack to it. Only the innermost call sees an
+// error without a stack, so the trace reaches the point of failure.
+func (ctx *ExecutionContext) traceError(err error, token lexer.Token) error {
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		rtErr = runtimeError(ctx, token, err)
+		err = rtErr
+	}
+
+	if rtErr.Stack == nil {
+		rtErr.Stack = ctx.stackTrace(rtErr.Line, rtErr.Column)
+	}
+
+	return err
+}
+
+// stackTrace converts the call stack into a trace, innermost frame first,
+// for an error at line and column in the innermost function.
+func (ctx *ExecutionContext) stackTrace(line, column int) []StackFrame {
+	trace := make([]StackFrame, 0, len(ctx.frames)+1)
+
+	template := ctx.template
+	for i := len(ctx.frames) - 1; i >= 0; i-- {
+		frame := ctx.frames[i]
+		if frame.native {
+			trace = append(trace, StackFrame{Function: frame.function, Native: true})
+		} else {
+			trace = append(trace, StackFrame{
+				Function: frame.function,
+				Template: template,
+				Line:     line,
+				Column:   column,
+			})
+		}
+
+		line, column = frame.token.Line, frame.token.Column
+		template = frame.template
+	}
+
+	return append(trace, StackFrame{
+		Function: mainFunction,
+		Template: template,
+		Line:     line,
+		Column:   column,
+	})
+}
@@ -0,0 +1,231 @@
+package evaluator
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func evaluateStackError(t *testing.T, e *Evaluator, input string) *RuntimeError {
+	t.Helper()
+	ctx := newScriptContext(t, input)
+	ctx.Source = input
+
+	_, err := e.Evaluate(ctx)
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got: %v", err)
+	}
+	return rtErr
+}
+
+func TestStackTrace(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []StackFrame
+	}{
+		{
+			"nested functions",
+			"fn inner(x) {\n  return x / 0;\n}\nfn outer(x) {\n  return inner(x);\n}\nouter(1);",
+			[]StackFrame{
+				{Function: "inner", Line: 2, Column: 12},
+				{Function: "outer", Line: 5, Column: 15},
+				{Function: "<main>", Line: 7, Column: 6},
+			},
+		},
+		{
+			"builtin",
+			"fn f(x) {\n  return len(x);\n}\nf(1);",
+			[]StackFrame{
+				{Function: "len", Native: true},
+				{Function: "f", Line: 2, Column: 13},
+				{Function: "<main>", Line: 4, Column: 2},
+			},
+		},
+		{
+			"let named function",
+			"let g = fn() { return missing; };\ng();",
+			[]StackFrame{
+				{Function: "g", Line: 1, Column: 23},
+				{Function: "<main>", Line: 2, Column: 2},
+			},
+		},
+		{
+			"anonymous function",
+			"let fns = [fn() { return missing; }];\nfns[0]();",
+			[]StackFrame{
+				{Function: "<anonymous>", Line: 1, Column: 26},
+				{Function: "<main>", Line: 2, Column: 7},
+			},
+		},
+		{
+			"map callback",
+			"fn double(x) {\n  return x * \"a\";\n}\nmap([1, 2], double);",
+			[]StackFrame{
+				{Function: "double", Line: 2, Column: 12},
+				{Function: "map", Native: true},
+				{Function: "<main>", Line: 4, Column: 4},
+			},
+		},
+		{
+			"argument count",
+			"fn f(a) { return a; }\nfn g() { return f(); }\ng();",
+			[]StackFrame{
+				{Function: "g", Line: 2, Column: 18},
+				{Function: "<main>", Line: 3, Column: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtErr := evaluateStackError(t, New(), tt.input)
+			if !reflect.DeepEqual(rtErr.Stack, tt.expected) {
+				t.Fatalf("unexpected stack:\nexpected %+v\ngot      %+v", tt.expected, rtErr.Stack)
+			}
+		})
+	}
+}
+
+func TestStackTraceTopLevel(t *testing.T) {
+	rtErr := evaluateStackError(t, New(), "let x = 1 / 0;")
+	if rtErr.Stack != nil {
+		t.Fatalf("expected no stack for a top-level error, got %+v", rtErr.Stack)
+	}
+	if strings.Contains(rtErr.Error(), "stack trace") {
+		t.Fatalf("did not expect a stack trace in:\n%s", rtErr.Error())
+	}
+}
+
+func TestStackTraceCustomFunction(t *testing.T) {
+	errTenant := errors.New("tenant not found")
+
+	e := New()
+	e.RegisterFunction("lookup", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return nil, errTenant
+	})
+
+	rtErr := evaluateStackError(t, e, "fn load() {\n  return lookup();\n}\nload();")
+	if !errors.Is(rtErr, errTenant) {
+		t.Fatalf("expected custom error to be wrapped, got: %v", rtErr)
+	}
+	if rtErr.Line != 2 || rtErr.Column != 16 {
+		t.Fatalf("expected error at line 2, column 16, got line %d, column %d", rtErr.Line, rtErr.Column)
+	}
+
+	expected := []StackFrame{
+		{Function: "lookup", Native: true},
+		{Function: "load", Line: 2, Column: 16},
+		{Function: "<main>", Line: 4, Column: 5},
+	}
+	if !reflect.DeepEqual(rtErr.Stack, expected) {
+		t.Fatalf("unexpected stack:\nexpected %+v\ngot      %+v", expected, rtErr.Stack)
+	}
+}
+
+func TestStackTraceError(t *testing.T) {
+	rtErr := evaluateStackError(t, New(), "fn f() {\n  return 1 / 0;\n}\nf();")
+
+	expected := "error: division by zero\n" +
+		" --> line 2, column 12\n" +
+		"  |\n" +
+		"2 |   return 1 / 0;\n" +
+		"  |            ^\n" +
+		"stack trace:\n" +
+		"    at f (line 2, column 12)\n" +
+		"    at <main> (line 4, column 2)"
+	if rtErr.Error() != expected {
+		t.Fatalf("unexpected error:\n%s\nexpected:\n%s", rtErr.Error(), expected)
+	}
+}
+
+func TestStackTraceElided(t *testing.T) {
+	e := New()
+	ctx := newScriptContext(t, "fn f(n) {\n  if (n == 0) { return 1 / 0; }\n  return f(n - 1);\n}\nf(50);")
+	ctx.MaxDepth = 0
+
+	_, err := e.Evaluate(ctx)
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got: %v", err)
+	}
+	if len(rtErr.Stack) != 52 {
+		t.Fatalf("expected 52 frames, got %d", len(rtErr.Stack))
+	}
+
+	msg := rtErr.Error()
+	if !strings.Contains(msg, "\n    ... 32 more frames\n") {
+		t.Fatalf("expected elided frames in:\n%s", msg)
+	}
+	if strings.Count(msg, "\n    at ") != 20 {
+		t.Fatalf("expected 20 printed frames in:\n%s", msg)
+	}
+	if !strings.HasSuffix(msg, "at <main> (line 5, column 2)") {
+		t.Fatalf("expected trace to end with <main>:\n%s", msg)
+	}
+}
+
+func TestStackTraceIncludedTemplate(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"page":    "<h1>\n{% include(\"partial\") %}",
+		"partial": "{% fn render() { return missing; } %}\n{% render() %}",
+	})
+
+	_, err := set.Render("page")
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got: %v", err)
+	}
+
+	expected := []StackFrame{
+		{Function: "render", Template: "partial", Line: 1, Column: 25},
+		{Function: "<main>", Template: "partial", Line: 2, Column: 10},
+		{Function: "include", Native: true},
+		{Function: "<main>", Template: "page", Line: 2, Column: 11},
+	}
+	if !reflect.DeepEqual(rtErr.Stack, expected) {
+		t.Fatalf("unexpected stack:\nexpected %+v\ngot      %+v", expected, rtErr.Stack)
+	}
+	if !strings.Contains(err.Error(), "at render (partial, line 1, column 25)") {
+		t.Fatalf("expected template name in trace:\n%s", err.Error())
+	}
+}
+
+func TestStackTraceLayoutBlock(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"layout": "<html>{% block content { %}{% } %}</html>",
+		"page":   "{% extends \"layout\"; %}\n{% block content { %}\n{% 1 / 0 %}{% } %}",
+	})
+
+	_, err := set.Render("page")
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got: %v", err)
+	}
+	if rtErr.Line != 3 || !strings.Contains(rtErr.Source, "extends") {
+		t.Fatalf("expected error located in the page template, got line %d in %q", rtErr.Line, rtErr.Source)
+	}
+}
+
+func TestStackFrameString(t *testing.T) {
+	tests := []struct {
+		frame    StackFrame
+		expected string
+	}{
+		{StackFrame{Function: "len", Native: true}, "at len (native)"},
+		{StackFrame{Function: "f", Line: 3, Column: 4}, "at f (line 3, column 4)"},
+		{StackFrame{Function: "f", Template: "page", Line: 3, Column: 4}, "at f (page, line 3, column 4)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.frame.String(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// Template is a compiled template. The parsed program is never modified by
+// evaluation, so a Template can be rendered any number of times, including
+// concurrently from multiple goroutines.
+type Template struct {
+	Name      string
+	Source    string
+	Program   *parser.Program
+	evaluator *Evaluator
+	set       *TemplateSet
+	// code is Program compiled in template mode. It is compiled once, by
+	// Compile, and is not affected by later changes to Program.
+	code *code
+}
+
+// Compile lexes and parses source as a template for rendering with e.
+func (e *Evaluator) Compile(source string) (*Template, error) {
+	l := lexer.NewTemplate(source)
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := compileProgram(program, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{
+		Source:    source,
+		Program:   program,
+		evaluator: e,
+		code:      compiled,
+	}, nil
+}
+
+// Compile creates a fresh evaluator and compiles source as a template.
+func Compile(source string) (*Template, error) {
+	return New().Compile(source)
+}
+
+// NewExecutionContext creates an execution context for a single render of
+// the template, with vars applied to its root scope.
+func (t *Template) NewExecutionContext(vars ...Vars) (*ExecutionContext, error) {
+	ctx := NewExecutionContext(t.Program)
+	ctx.Source = t.Source
+	ctx.Escaping = t.evaluator.Escaping
+	ctx.Templates = t.set
+	ctx.name = t.Name
+	ctx.tmpl = t
+
+	if err := applyVars(ctx.RootScope, vars); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// Render evaluates the template with vars, returning the output string.
+func (t *Template) Render(vars ...Vars) (string, error) {
+	ctx, err := t.NewExecutionContext(vars...)
+	if err != nil {
+		return "", err
+	}
+
+	return t.RenderContext(ctx)
+}
+
+// RenderContext evaluates the template with a context created by
+// NewExecutionContext, allowing limits and metadata to be set first.
+func (t *Template) RenderContext(ctx *ExecutionContext) (string, error) {
+	return t.evaluator.EvaluateString(ctx)
+}
+
+// RenderTo evaluates the template with vars, streaming the output to w.
+func (t *Template) RenderTo(w io.Writer, vars ...Vars) error {
+	ctx, err := t.NewExecutionContext(vars...)
+	if err != nil {
+		return err
+	}
+
+	return t.RenderContextTo(ctx, w)
+}
+
+// RenderContextTo evaluates the template with a context created by
+// NewExecutionContext, streaming the output to w.
+func (t *Template) RenderContextTo(ctx *ExecutionContext, w io.Writer) error {
+	return t.evaluator.EvaluateTo(ctx, w)
+}
+
+// TemplateSet is a concurrency-safe cache of compiled templates keyed by name.
+// Templates rendered from a set can include and extend other templates in
+// the same set.
+type TemplateSet struct {
+	evaluator *Evaluator
+	loader    Loader
+	mu        sync.RWMutex
+	templates map[string]*Template
+}
+
+// NewTemplateSet creates an empty template set whose templates render with e.
+func NewTemplateSet(e *Evaluator) *TemplateSet {
+	return &TemplateSet{
+		evaluator: e,
+		templates: make(map[string]*Template),
+	}
+}
+
+// NewTemplateSetWithLoader creates a template set that compiles templates
+// from loader on first use and caches them until invalidated.
+func NewTemplateSetWithLoader(e *Evaluator, loader Loader) *TemplateSet {
+	s := NewTemplateSet(e)
+	s.loader = loader
+	return s
+}
+
+// Add compiles source and stores it under name, replacing any existing
+// template with the same name.
+func (s *TemplateSet) Add(name, source string) (*Template, error) {
+	t, err := s.evaluator.Compile(source)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", name, err)
+	}
+	t.Name = name
+	t.set = s
+
+	s.mu.Lock()
+	s.templates[name] = t
+	s.mu.Unlock()
+
+	return t, nil
+}
+
+// Lookup returns the template stored under name.
+func (s *TemplateSet) Lookup(name string) (*Template, bool) {
+	s.mu.RLock()
+	t, ok := s.templates[name]
+	s.mu.RUnlock()
+	return t, ok
+}
+
+// Get returns the template stored under name, loading and compiling it
+// with the set's loader if it is not cached.
+func (s *TemplateSet) Get(name string) (*Template, error) {
+	if t, ok := s.Lookup(name); ok {
+		return t, nil
+	}
+
+	if s.loader == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	source, err := s.loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Add(name, source)
+}
+
+// Names returns the names of all cached templates in sorted order.
+func (s *TemplateSet) Names() []string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Invalidate removes the template stored under name from the cache. With a
+// loader, the template is loaded again on next use.
+func (s *TemplateSet) Invalidate(name string) {
+	s.mu.Lock()
+	delete(s.templates, name)
+	s.mu.Unlock()
+}
+
+// InvalidateAll removes every template from the cache.
+func (s *TemplateSet) InvalidateAll() {
+	s.mu.Lock()
+	s.templates = make(map[string]*Template)
+	s.mu.Unlock()
+}
+
+// Render renders the template stored under name with vars.
+func (s *TemplateSet) Render(name string, vars ...Vars) (string, error) {
+	t, err := s.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return t.Render(vars...)
+}
+
+// RenderTo renders the template stored under name with vars, streaming the
+// output to w.
+func (s *TemplateSet) RenderTo(w io.Writer, name string, vars ...Vars) error {
+	t, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	return t.RenderTo(w, vars...)
+}
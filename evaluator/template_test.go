@@ -0,0 +1,320 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"weak"
+)
+
+func TestCompileAndRender(t *testing.T) {
+	tmpl, err := Compile(`Hello, {% name %}!`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Alice", "Bob"} {
+		output, err := tmpl.Render(Vars{"name": name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != "Hello, "+name+"!" {
+			t.Fatalf("expected greeting for %s, got %q", name, output)
+		}
+	}
+}
+
+func TestCompileParseError(t *testing.T) {
+	_, err := Compile(`{% let = %}`)
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+func TestTemplateRenderDoesNotLeakState(t *testing.T) {
+	tmpl, err := Compile(`{% let items = []; let r = append(items, x); %}{% len(items) %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		output, err := tmpl.Render(Vars{"x": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != "1" {
+			t.Fatalf("render %d: expected '1', got %q", i, output)
+		}
+	}
+}
+
+func TestTemplateRenderContextLimits(t *testing.T) {
+	tmpl, err := Compile(`{% while (true) { } %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := tmpl.NewExecutionContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.MaxSteps = 100
+
+	_, err = tmpl.RenderContext(ctx)
+	if err == nil || !strings.Contains(err.Error(), "execution limit exceeded") {
+		t.Fatalf("expected step limit error, got: %v", err)
+	}
+}
+
+func TestTemplateUsesEvaluatorEscaping(t *testing.T) {
+	e := New()
+	e.Escaping = HTMLEscaping
+	tmpl, err := e.Compile(`<p>{% v %}</p>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := tmpl.Render(Vars{"v": "<b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<p>&lt;b&gt;</p>" {
+		t.Fatalf("expected escaped output, got %q", output)
+	}
+}
+
+func TestTemplateConcurrentRender(t *testing.T) {
+	tmpl, err := Compile(`{% fn double(n) { return n * 2; } %}{% foreach (items as item) { %}[{% double(item) %}]{% } %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			output, err := tmpl.Render(Vars{"items": []any{i, i + 1}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			expected := fmt.Sprintf("[%d][%d]", i*2, (i+1)*2)
+			if output != expected {
+				errs <- fmt.Errorf("expected %q, got %q", expected, output)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestTemplateSetAddLookupRender(t *testing.T) {
+	set := NewTemplateSet(New())
+
+	if _, err := set.Add("greeting", `Hi {% name %}`); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, ok := set.Lookup("greeting")
+	if !ok {
+		t.Fatal("expected template to be cached")
+	}
+	if tmpl.Name != "greeting" {
+		t.Fatalf("expected name 'greeting', got %q", tmpl.Name)
+	}
+
+	output, err := set.Render("greeting", Vars{"name": "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "Hi Ann" {
+		t.Fatalf("expected 'Hi Ann', got %q", output)
+	}
+}
+
+func TestTemplateSetAddParseError(t *testing.T) {
+	set := NewTemplateSet(New())
+	_, err := set.Add("broken", `{% let = %}`)
+	if err == nil || !strings.Contains(err.Error(), `template "broken"`) {
+		t.Fatalf("expected named parse error, got: %v", err)
+	}
+	if _, ok := set.Lookup("broken"); ok {
+		t.Fatal("expected broken template not to be cached")
+	}
+}
+
+func TestTemplateSetReplaceAndInvalidate(t *testing.T) {
+	set := NewTemplateSet(New())
+	_, _ = set.Add("a", `one`)
+	_, _ = set.Add("a", `two`)
+	_, _ = set.Add("b", `three`)
+
+	output, err := set.Render("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "two" {
+		t.Fatalf("expected replaced template, got %q", output)
+	}
+
+	if names := set.Names(); strings.Join(names, ",") != "a,b" {
+		t.Fatalf("expected names [a b], got %v", names)
+	}
+
+	set.Invalidate("a")
+	if _, err := set.Render("a"); err == nil || !strings.Contains(err.Error(), "template not found: a") {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	set.InvalidateAll()
+	if len(set.Names()) != 0 {
+		t.Fatalf("expected empty set, got %v", set.Names())
+	}
+}
+
+func TestTemplateSetConcurrentAccess(t *testing.T) {
+	set := NewTemplateSet(New())
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("t%d", i%4)
+			if _, err := set.Add(name, `{% n %}`); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := set.Render(name, Vars{"n": i}); err != nil && !strings.Contains(err.Error(), "template not found") {
+				t.Error(err)
+			}
+			set.Invalidate(name)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestTemplateRenderContextTwice(t *testing.T) {
+	set := NewTemplateSetWithLoader(New(), MapLoader{
+		"layout": `<main>{% block body { %}default{% } %}</main>`,
+		"page":   `{% extends "layout"; %}{% block body { %}{% include("part"); %}{% } %}`,
+		"part":   `<p>{% msg %}</p>`,
+	})
+	page, err := set.Get("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := page.NewExecutionContext(Vars{"msg": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rendering a page that extends a layout and includes a partial leaves
+	// the context's own configuration as it was.
+	for i := 0; i < 2; i++ {
+		output, err := page.RenderContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != "<main><p>hi</p></main>" {
+			t.Fatalf("render %d: unexpected output %q", i, output)
+		}
+		if ctx.Program != page.Program || ctx.Source != page.Source {
+			t.Fatalf("render %d: context switched to another template", i)
+		}
+	}
+}
+
+func TestTemplateSetConcurrentRenderSharedEvaluator(t *testing.T) {
+	e := New()
+	e.RegisterFunction("wrap", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return &StringValue{Value: "(" + args[0].Debug() + ")"}, nil
+	})
+	e.Freeze()
+
+	set := NewTemplateSetWithLoader(e, MapLoader{
+		"layout": `<h1>{% block title { %}{% } %}</h1>{% block body { %}{% } %}`,
+		"page":   `{% extends "layout"; %}{% block title { %}{% wrap(n) %}{% } %}{% block body { foreach (items as i) { include("item", {"i": i * n}); } } %}`,
+		"item":   `[{% i %}]`,
+	})
+	page, err := set.Get("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := page.Program.Debug()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+
+	for n := 0; n < 50; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			output, err := set.Render("page", Vars{"n": n, "items": []any{1, 2}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			expected := fmt.Sprintf("<h1>(%d)</h1>[%d][%d]", n, n, 2*n)
+			if output != expected {
+				errs <- fmt.Errorf("expected %q, got %q", expected, output)
+			}
+		}(n)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if after := page.Program.Debug(); after != before {
+		t.Fatalf("program changed by rendering:\n%s\nbecame\n%s", before, after)
+	}
+}
+
+func TestTemplateRunsItsOwnCode(t *testing.T) {
+	tmpl, err := Compile(`Hello, {% name %}!`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(Vars{"name": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The template's code is compiled with it, not cached by its program.
+	programs.mu.Lock()
+	_, cached := programs.programs[weak.Make(tmpl.Program)]
+	programs.mu.Unlock()
+	if cached {
+		t.Fatal("expected the template's program not to be cached")
+	}
+
+	// A context whose Program is replaced runs the new program.
+	other, err := Compile(`Goodbye, {% name %}!`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tmpl.NewExecutionContext(Vars{"name": "Bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Program = other.Program
+	output, err := tmpl.RenderContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "Goodbye, Bob!" {
+		t.Fatalf("expected the replaced program to run, got %q", output)
+	}
+}
@@ -0,0 +1,165 @@
+package evaluator
+
+import "errors"
+
+// errorKinds names the sentinel errors for scripts, which see them as the
+// kind of a caught error.
+var errorKinds = []struct {
+	err  error
+	name string
+}{
+	{ErrUndefinedVariable, "UndefinedVariable"},
+	{ErrUndefinedProperty, "UndefinedProperty"},
+	{ErrTypeMismatch, "TypeMismatch"},
+	{ErrUnknownOperator, "UnknownOperator"},
+	{ErrDivisionByZero, "DivisionByZero"},
+	{ErrIntegerOverflow, "IntegerOverflow"},
+	{ErrIndexOutOfRange, "IndexOutOfRange"},
+	{ErrArgumentCount, "ArgumentCount"},
+	{ErrNotCallable, "NotCallable"},
+	{ErrTemplateNotFound, "TemplateNotFound"},
+	{ErrTemplateCycle, "TemplateCycle"},
+}
+
+// uncatchable lists the errors that try/catch cannot handle, so that a
+// script cannot swallow the limits its host placed on it.
+var uncatchable = []error{
+	ErrStepLimit,
+	ErrDepthLimit,
+	ErrArrayLimit,
+	ErrOutputLimit,
+	ErrMemoryLimit,
+	ErrCancelled,
+	ErrStopped,
+}
+
+const (
+	defaultErrorKind    = "Error"
+	defaultErrorMessage = "error"
+)
+
+// ThrownError is the error raised by a throw statement. Kind is the kind a
+// catch block sees; rethrowing a caught built-in error keeps its kind, so
+// errors.Is still matches the original sentinel error.
+type ThrownError struct {
+	Value   Object
+	Message string
+	Kind    string
+}
+
+func (e *ThrownError) Error() string {
+	return e.Message
+}
+
+func (e *ThrownError) Is(target error) bool {
+	if target == ErrThrown {
+		return true
+	}
+	for _, k := range errorKinds {
+		if k.name == e.Kind {
+			return target == k.err
+		}
+	}
+	return false
+}
+
+func newThrownError(value Object) *ThrownError {
+	thrown := &ThrownError{
+		Value:   value,
+		Message: defaultErrorMessage,
+		Kind:    defaultErrorKind,
+	}
+
+	switch v := value.(type) {
+	case *StringValue:
+		thrown.Message = v.Value
+	case *HashValue:
+		if message, ok := v.GetValue(NewStringValue("message")); ok {
+			if s, ok := message.(*StringValue); ok {
+				thrown.Message = s.Value
+			}
+		}
+		if kind, ok := v.GetValue(NewStringValue("kind")); ok {
+			if s, ok := kind.(*StringValue); ok {
+				thrown.Kind = s.Value
+			}
+		}
+	default:
+		thrown.Message = value.Debug()
+	}
+
+	return thrown
+}
+
+// errorKind returns the name a script sees as the kind of err.
+func errorKind(err error) string {
+	var thrown *ThrownError
+	if errors.As(err, &thrown) {
+		return thrown.Kind
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+	return defaultErrorKind
+}
+
+func isCatchable(err error) bool {
+	for _, kind := range uncatchable {
+		if errors.Is(err, kind) {
+			return false
+		}
+	}
+	return true
+}
+
+// errorObject converts a caught error into the hash a catch block receives.
+// A thrown hash keeps its own keys, and any other thrown value is kept under
+// "value".
+func (ctx *ExecutionContext) errorObject(err error) (*HashValue, error) {
+	obj := NewHashValue()
+
+	var thrown *ThrownError
+	if errors.As(err, &thrown) {
+		if hash, ok := thrown.Value.(*HashValue); ok {
+			for _, pair := range hash.OrderedPairs() {
+				if err := obj.Set(pair.Key, pair.Value); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			if err := obj.Set(NewStringValue("value"), thrown.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	message := err.Error()
+	line, column := 0, 0
+	var rtErr *RuntimeError
+	if errors.As(err, &rtErr) {
+		message, line, column = rtErr.Message, rtErr.Line, rtErr.Column
+	}
+
+	fields := []HashPair{
+		{Key: NewStringValue("message"), Value: NewStringValue(message)},
+		{Key: NewStringValue("kind"), Value: NewStringValue(errorKind(err))},
+		{Key: NewStringValue("line"), Value: NewIntegerValue(line)},
+		{Key: NewStringValue("column"), Value: NewIntegerValue(column)},
+	}
+	for _, field := range fields {
+		if err := obj.Set(field.Key, field.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.allocHash(len(obj.Pairs)); err != nil {
+		return nil, err
+	}
+	if err := ctx.allocString(len(message)); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
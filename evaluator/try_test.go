@@ -0,0 +1,202 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"runtime error",
+			`try { let x = 1 / 0; return "no"; } catch (e) { return e.kind + ":" + e.message + ":" + e.line + ":" + e.column; }`,
+			"DivisionByZero:division by zero:1:17",
+		},
+		{
+			"no error",
+			`let x = 0; try { x = 1; } catch (e) { x = 2; } return x;`,
+			"1",
+		},
+		{
+			"throw string",
+			`try { throw "bad row"; } catch (e) { return e.message + "|" + e.kind + "|" + e.value; }`,
+			"bad row|Error|bad row",
+		},
+		{
+			"throw integer",
+			`try { throw 42; } catch (e) { return e.value + 1; }`,
+			"43",
+		},
+		{
+			"throw hash",
+			`try { throw {"message": "bad", "kind": "Validation", "row": 3}; } catch (e) { return e.message + e.kind + e.row; }`,
+			"badValidation3",
+		},
+		{
+			"throw hash without message",
+			`try { throw {"row": 3}; } catch (e) { return e.message + e.kind + e.row; }`,
+			"errorError3",
+		},
+		{
+			"nested function",
+			"fn parse(s) {\n  return parseInt(s);\n}\ntry { parse(\"abc\"); } catch (e) { return e.line; }",
+			"2",
+		},
+		{
+			"continues after catch",
+			`let total = 0; foreach ([1, 0, 2] as n) { try { total += 10 / n; } catch (e) { total += 100; } } return total;`,
+			"115",
+		},
+		{
+			"rethrow keeps kind",
+			`try { try { let x = 1 / 0; } catch (e) { throw e; } } catch (e2) { return e2.kind + ":" + e2.message; }`,
+			"DivisionByZero:division by zero",
+		},
+		{
+			"break in try",
+			`let i = 0; while (true) { try { i += 1; if (i == 3) { break; } } catch (e) { } } return i;`,
+			"3",
+		},
+		{
+			"return in try",
+			`fn f() { try { return 1; } catch (e) { return 2; } } return f();`,
+			"1",
+		},
+		{
+			"error in catch",
+			`try { try { throw "a"; } catch (e) { throw e.message + "b"; } } catch (e) { return e.message; }`,
+			"ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RunScript(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unwrapReturn(t, result).Debug(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTryCatchVariableScope(t *testing.T) {
+	_, err := RunScript(`try { throw "x"; } catch (e) { } return e;`)
+	if !errors.Is(err, ErrUndefinedVariable) {
+		t.Fatalf("expected the catch variable to be scoped to the catch block, got: %v", err)
+	}
+}
+
+func TestTryCatchThrowAsNames(t *testing.T) {
+	// try, catch and throw are only keywords where a statement starts.
+	val := unwrapReturn(t, evalScript(t, `let h = {"try": 1, "catch": 2, "throw": 3}; let throw = h.try + h?.catch; throw += h.throw; return throw;`))
+	expectDebug(t, val, "6")
+}
+
+func TestTryCatchCustomFunction(t *testing.T) {
+	e := New()
+	e.RegisterFunction("lookup", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+		return nil, errors.New("tenant not found")
+	})
+
+	result, err := e.RunScript(`try { lookup(); } catch (e) { return e.kind + ":" + e.message; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unwrapReturn(t, result).Debug(); got != "Error:tenant not found" {
+		t.Fatalf("unexpected result: %q", got)
+	}
+}
+
+func TestTryCatchRestoresCallStack(t *testing.T) {
+	ctx := newScriptContext(t, "fn f(n) { if (n == 0) { return 1 / 0; } return f(n - 1); }\ntry { f(5); } catch (e) { }\nfn g() { return missing; }\ng();")
+
+	_, err := New().Evaluate(ctx)
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected *RuntimeError, got: %v", err)
+	}
+	if len(rtErr.Stack) != 2 || rtErr.Stack[0].Function != "g" {
+		t.Fatalf("expected a stack of g and <main>, got %+v", rtErr.Stack)
+	}
+	if len(ctx.frames) != 0 {
+		t.Fatalf("expected call stack to be empty, got %d frames", len(ctx.frames))
+	}
+}
+
+func TestThrowUncaught(t *testing.T) {
+	_, err := RunScript("let x = 1;\nthrow \"bad row\";")
+	if !errors.Is(err, ErrThrown) {
+		t.Fatalf("expected ErrThrown, got: %v", err)
+	}
+
+	var thrown *ThrownError
+	if !errors.As(err, &thrown) || thrown.Message != "bad row" || thrown.Kind != "Error" {
+		t.Fatalf("expected *ThrownError with message 'bad row', got: %v", err)
+	}
+
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Line != 2 || rtErr.Column != 1 {
+		t.Fatalf("expected error located at the throw, got: %v", err)
+	}
+	if rtErr.Message != "bad row" {
+		t.Fatalf("unexpected message: %q", rtErr.Message)
+	}
+}
+
+func TestThrowUncaughtRethrow(t *testing.T) {
+	_, err := RunScript(`try { let x = 1 / 0; } catch (e) { throw e; }`)
+	if !errors.Is(err, ErrThrown) || !errors.Is(err, ErrDivisionByZero) {
+		t.Fatalf("expected rethrown error to match ErrThrown and ErrDivisionByZero, got: %v", err)
+	}
+}
+
+func TestTryCatchUncatchable(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		setup func(ctx *ExecutionContext)
+		kind  error
+	}{
+		{"step limit", `try { while (true) { } } catch (e) { }`, func(ctx *ExecutionContext) { ctx.MaxSteps = 100 }, ErrStepLimit},
+		{"depth limit", `fn f() { return f(); } try { f(); } catch (e) { }`, func(ctx *ExecutionContext) { ctx.MaxDepth = 10 }, ErrDepthLimit},
+		{"memory limit", `let s = "ab"; try { while (true) { s = s + s; } } catch (e) { }`, func(ctx *ExecutionContext) { ctx.MaxMemory = 1000 }, ErrMemoryLimit},
+		{"cancelled", `try { let x = 1; } catch (e) { }`, func(ctx *ExecutionContext) {
+			cancelCtx, cancel := context.WithCancel(context.Background())
+			cancel()
+			ctx.Context = cancelCtx
+		}, ErrCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newScriptContext(t, tt.input)
+			tt.setup(ctx)
+
+			_, err := New().Evaluate(ctx)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v to escape try/catch, got: %v", tt.kind, err)
+			}
+		})
+	}
+}
+
+func TestTryCatchTemplate(t *testing.T) {
+	input := `{% foreach (rows as r) { %}{% try { let price = 100 / r; %}<li>{% price %}</li>{% } catch (e) { %}<li>n/a</li>{% } %}{% } %}`
+
+	out, err := RunTemplate(input, Vars{"rows": []any{1, 0, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "<li>100</li><li>n/a</li><li>25</li>" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,648 @@
+package evaluator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	trueValue  = &BooleanValue{Value: true}
+	falseValue = &BooleanValue{Value: false}
+)
+
+// nativeBool returns the shared BooleanValue for b. Booleans are compared
+// by value, so a VM instruction can return the same one every time.
+func nativeBool(b bool) *BooleanValue {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+const iteratorObject ObjectType = "ITERATOR"
+
+// iterator walks the elements of an array, the pairs of a hash or the
+// integers of a range, for a foreach loop. It lives on the operand stack,
+// and remembers the scope the loop started in so that every iteration gets
+// a fresh child scope of it.
+type iterator struct {
+	scope    *Scope
+	elements []Object
+	pairs    []HashPair
+	rng      *RangeValue
+	length   int
+	position int
+}
+
+func newIterator(iterable Object, scope *Scope) *iterator {
+	it := &iterator{scope: scope, position: -1}
+	switch i := iterable.(type) {
+	case *ArrayValue:
+		it.elements = i.Elements
+	case *HashValue:
+		it.pairs = i.OrderedPairs()
+	case *RangeValue:
+		it.rng = i
+		it.length = i.Len()
+	}
+	return it
+}
+
+func (it *iterator) Type() ObjectType {
+	return iteratorObject
+}
+
+func (it *iterator) Debug() string {
+	return "iterator"
+}
+
+// next advances the iterator, returning false once it is exhausted.
+func (it *iterator) next() bool {
+	it.position++
+	if it.rng != nil {
+		return it.position < it.length
+	}
+	if it.pairs != nil {
+		return it.position < len(it.pairs)
+	}
+	return it.position < len(it.elements)
+}
+
+func (it *iterator) value() Object {
+	if it.rng != nil {
+		return &IntegerValue{Value: it.rng.At(it.position)}
+	}
+	if it.pairs != nil {
+		return it.pairs[it.position].Value
+	}
+	return it.elements[it.position]
+}
+
+func (it *iterator) key() Object {
+	if it.pairs != nil {
+		return it.pairs[it.position].Key
+	}
+	return &IntegerValue{Value: it.position}
+}
+
+// handler is an installed try/catch handler: where the catch block starts,
+// and the stack height and scope to restore before running it.
+type handler struct {
+	catch int
+	sp    int
+	scope *Scope
+}
+
+// reserve makes room for n more values on the operand stack above ctx.sp.
+func (ctx *ExecutionContext) reserve(n int) []Object {
+	if need := ctx.sp + n; need > len(ctx.stack) {
+		stack := make([]Object, max(need, 2*len(ctx.stack)))
+		copy(stack, ctx.stack[:ctx.sp])
+		ctx.stack = stack
+	}
+	return ctx.stack
+}
+
+// run executes c in scope, using the operand stack above ctx.sp. It
+// returns the value c finished with, and whether it came from a return
+// statement.
+//
+// Steps are charged against MaxSteps by the opStep instructions the
+// compiler emits, one for each statement and expression. Cancellation is
+// checked on entry, on every backward jump and after every built-in call,
+// so that neither loops nor recursion can outlive Context.
+func (e *Evaluator) run(ctx *ExecutionContext, c *code, scope *Scope) (Object, bool, error) {
+	if err := ctx.checkCancelled(); err != nil {
+		return nil, false, locateAt(ctx, err, c.tokens[0])
+	}
+
+	base := ctx.sp
+	stack := ctx.reserve(c.maxStack)
+	sp := base
+	ip := 0
+
+	var handlers []handler
+	debugger := ctx.Debugger != nil
+	profiler := ctx.profiler
+	var hits *codeHits
+	if ctx.coverage != nil {
+		hits = ctx.coverage[c]
+	}
+
+	for {
+		pc := ip
+		in := c.instructions[pc]
+		ip++
+
+		if profiler != nil {
+			profiler.step(ctx, c, pc)
+		}
+		if hits != nil {
+			hits.runs[pc]++
+		}
+		if debugger {
+			if err := e.statementHook(ctx, c, pc, scope); err != nil {
+				ctx.sp = base
+				return nil, false, err
+			}
+		}
+
+		var err error
+
+		switch in.op {
+		case opConstant:
+			stack[sp] = c.constants[in.arg]
+			sp++
+		case opNull:
+			stack[sp] = Null
+			sp++
+		case opTrue:
+			stack[sp] = trueValue
+			sp++
+		case opFalse:
+			stack[sp] = falseValue
+			sp++
+		case opPop:
+			sp--
+		case opDup:
+			stack[sp] = stack[sp-1]
+			sp++
+		case opGet:
+			stack[sp], err = e.get(scope, c.names[in.arg])
+			sp++
+		case opGetLocal:
+			v := &c.variables[in.arg]
+			if val := outer(scope, v.depth).slots[v.slot]; val != nil {
+				stack[sp] = val
+			} else {
+				// A variable declared further on is not set yet, and
+				// anything of the same name outside is still visible.
+				stack[sp], err = e.get(scope, v.name)
+			}
+			sp++
+		case opGetGlobal:
+			v := &c.variables[in.arg]
+			stack[sp], err = e.get(global(scope, v.depth, v.name), v.name)
+			sp++
+		case opDefine:
+			sp--
+			scope.SetLocal(c.names[in.arg], stack[sp])
+		case opDefineLocal:
+			sp--
+			scope.slots[in.arg] = stack[sp]
+		case opAssign:
+			sp--
+			err = assign(scope, c.names[in.arg], stack[sp])
+		case opAssignLocal:
+			sp--
+			v := &c.variables[in.arg]
+			if s := outer(scope, v.depth); s.slots[v.slot] != nil {
+				s.slots[v.slot] = stack[sp]
+			} else {
+				err = assign(scope, v.name, stack[sp])
+			}
+		case opAssignGlobal:
+			sp--
+			v := &c.variables[in.arg]
+			err = assign(global(scope, v.depth, v.name), v.name, stack[sp])
+		case opFunction:
+			stack[sp], err = e.closure(c.functions[in.arg], scope)
+			sp++
+		case opAdd, opSubtract, opMultiply, opDivide, opModulo,
+			opLess, opGreater, opLessEqual, opGreaterEqual, opEqual, opNotEqual, opInfix:
+			sp--
+			stack[sp-1], err = e.binary(ctx, c, pc, stack[sp-1], stack[sp])
+		case opNot:
+			stack[sp-1] = not(stack[sp-1])
+		case opNegate:
+			stack[sp-1], err = e.evaluateMinusPrefixOperatorExpression(stack[sp-1])
+		case opIndex:
+			sp--
+			stack[sp-1], err = e.evaluateIndexExpression(stack[sp-1], stack[sp])
+		case opRange:
+			var step Object
+			if in.arg&rangeStep != 0 {
+				sp--
+				step = stack[sp]
+			}
+			sp--
+			var r *RangeValue
+			if r, err = newRange(stack[sp-1], stack[sp], step, in.arg&rangeExclusive != 0); err == nil {
+				stack[sp-1] = r
+			}
+		case opProperty, opSafeProperty:
+			stack[sp-1], err = ctx.property(stack[sp-1], c.keys[in.arg], in.op == opSafeProperty)
+		case opMethod, opSafeMethod:
+			// The value the method is called on stays under it, or nil
+			// for a property that is not a method.
+			receiver, key := stack[sp-1], c.keys[in.arg]
+			if method, ok := e.method(receiver, key); ok {
+				stack[sp-1], stack[sp] = method, receiver
+			} else {
+				stack[sp-1], err = ctx.property(receiver, key, in.op == opSafeMethod)
+				stack[sp] = nil
+			}
+			sp++
+		case opPropertyTarget:
+			if _, ok := stack[sp-1].(*HashValue); !ok {
+				err = newError(ErrTypeMismatch, "cannot assign to property: left side evaluated to null")
+			}
+		case opSetProperty:
+			sp--
+			hash, value := stack[sp-1].(*HashValue), stack[sp]
+			key := c.keys[in.arg].name
+			if err = ctx.allocHashSet(hash, key); err == nil {
+				err = hash.Set(key, value)
+			}
+			stack[sp-1] = value
+		case opSetIndex:
+			sp -= 2
+			err = ctx.setIndex(stack[sp-1], stack[sp], stack[sp+1])
+			stack[sp-1] = stack[sp+1]
+		case opArray:
+			n := int(in.arg)
+			sp -= n
+			stack[sp], err = ctx.newArray(stack[sp : sp+n])
+			sp++
+		case opHash:
+			n := 2 * int(in.arg)
+			sp -= n
+			stack[sp], err = ctx.newHash(stack[sp : sp+n])
+			sp++
+		case opInterpolate:
+			n := int(in.arg)
+			sp -= n
+			stack[sp], err = ctx.interpolate(stack[sp : sp+n])
+			sp++
+		case opCall, opPipe:
+			n := int(in.arg)
+			fn, args := stack[sp-n-1], stack[sp-n:sp]
+			if in.op == opPipe {
+				if err = checkFilter(fn, n); err != nil {
+					break
+				}
+			}
+			if _, ok := fn.(*FunctionValue); !ok {
+				// Built-ins may keep their arguments, which must not
+				// alias the stack.
+				args = slices.Clone(args)
+			}
+			ctx.sp = sp
+			var result Object
+			result, err = e.applyFunction(ctx, scope, c.tokens[pc], fn, args)
+			stack = ctx.stack
+			sp -= n
+			stack[sp-1] = result
+		case opMethodCall:
+			n := int(in.arg)
+			fn, receiver, args := stack[sp-n-2], stack[sp-n-1], stack[sp-n:sp]
+			if receiver != nil {
+				if err = checkMethod(fn.(*BuiltInFunction), n); err != nil {
+					break
+				}
+				args = stack[sp-n-1 : sp]
+			}
+			if _, ok := fn.(*FunctionValue); !ok {
+				args = slices.Clone(args)
+			}
+			ctx.sp = sp
+			var result Object
+			result, err = e.applyFunction(ctx, scope, c.tokens[pc], fn, args)
+			stack = ctx.stack
+			sp -= n + 1
+			stack[sp-1] = result
+		case opJump:
+			ip = int(in.arg)
+		case opJumpIfFalse:
+			sp--
+			if !isTruthy(stack[sp]) {
+				ip = int(in.arg)
+			}
+		case opAnd:
+			if !isTruthy(stack[sp-1]) {
+				ip = int(in.arg)
+			} else {
+				sp--
+			}
+		case opOr:
+			if isTruthy(stack[sp-1]) {
+				ip = int(in.arg)
+			} else {
+				sp--
+			}
+		case opCoalesce:
+			if _, isNull := stack[sp-1].(*NullValue); !isNull {
+				ip = int(in.arg)
+			} else {
+				sp--
+			}
+		case opSkipNull:
+			if _, isNull := stack[sp-1].(*NullValue); isNull {
+				ip = int(in.arg)
+			}
+		case opLoop:
+			ip = int(in.arg)
+			err = ctx.checkCancelled()
+		case opText:
+			err = ctx.writeText(c.texts[in.arg])
+		case opOutput:
+			sp--
+			if shouldWriteTemplateOutput(stack[sp]) {
+				err = ctx.writeValue(stack[sp])
+			}
+		case opIterate:
+			stack[sp-1] = newIterator(stack[sp-1], scope)
+		case opNext:
+			it := stack[sp-1].(*iterator)
+			loop := &c.loops[in.arg]
+			if !it.next() {
+				sp--
+				scope = it.scope
+				ip = loop.exit
+				break
+			}
+			scope = newSlotScope(it.scope, loop.locals)
+			scope.slots[loop.variable] = it.value()
+			if loop.index >= 0 {
+				scope.slots[loop.index] = it.key()
+			}
+		case opEndIterate:
+			sp--
+			scope = stack[sp].(*iterator).scope
+		case opTry:
+			handlers = append(handlers, handler{catch: int(in.arg), sp: sp, scope: scope})
+		case opEndTry:
+			handlers = handlers[:len(handlers)-1]
+		case opCatch:
+			sp--
+			catch := &c.catches[in.arg]
+			scope = newSlotScope(scope, catch.locals)
+			scope.slots[catch.parameter] = stack[sp]
+		case opPushScope:
+			scope = newSlotScope(scope, c.scopes[in.arg])
+		case opPopScope:
+			scope = scope.parent
+		case opThrow:
+			sp--
+			err = newThrownError(stack[sp])
+		case opExtends:
+			sp--
+			err = ctx.extend(stack[sp], c.tokens[pc])
+		case opBlock:
+			ctx.sp = sp
+			var result Object
+			var returned bool
+			result, returned, err = e.evaluateNamedBlock(ctx, c.blocks[in.arg], scope)
+			stack = ctx.stack
+			if err == nil && returned {
+				ctx.sp = base
+				return result, true, nil
+			}
+		case opStep:
+			if ctx.MaxSteps > 0 {
+				ctx.steps += int(in.arg)
+				if ctx.steps > ctx.MaxSteps {
+					ctx.sp = base
+					return nil, false, locateAt(ctx, newError(ErrStepLimit, "execution limit exceeded: %d steps", ctx.MaxSteps), c.tokens[pc])
+				}
+			}
+		case opReturn:
+			ctx.sp = base
+			return stack[sp-1], true, nil
+		case opHalt:
+			ctx.sp = base
+			return stack[sp-1], false, nil
+		}
+
+		// The branches of conditional jumps are told apart by whether
+		// they jumped.
+		if hits != nil && ip != pc+1 {
+			hits.jumps[pc]++
+		}
+
+		if err == nil {
+			continue
+		}
+
+		err = locateAt(ctx, err, c.tokens[pc])
+		if len(handlers) == 0 || !isCatchable(err) {
+			ctx.sp = base
+			return nil, false, err
+		}
+
+		h := handlers[len(handlers)-1]
+		handlers = handlers[:len(handlers)-1]
+
+		caught, err := ctx.errorObject(err)
+		if err != nil {
+			ctx.sp = base
+			return nil, false, locateAt(ctx, err, c.tokens[pc])
+		}
+
+		sp, scope, ip = h.sp, h.scope, h.catch
+		stack[sp] = caught
+		sp++
+	}
+}
+
+// binary applies the infix operator of the instruction at ip to left and
+// right. Integer arithmetic and comparisons are handled inline; everything
+// else, including overflow, goes through evaluateInfixExpression.
+func (e *Evaluator) binary(ctx *ExecutionContext, c *code, ip int, left, right Object) (Object, error) {
+	if l, ok := left.(*IntegerValue); ok {
+		if r, ok := right.(*IntegerValue); ok {
+			switch c.instructions[ip].op {
+			case opAdd:
+				if result := l.Value + r.Value; (result > l.Value) == (r.Value > 0) {
+					return &IntegerValue{Value: result}, nil
+				}
+			case opSubtract:
+				if result := l.Value - r.Value; (result < l.Value) == (r.Value > 0) {
+					return &IntegerValue{Value: result}, nil
+				}
+			case opLess:
+				return nativeBool(l.Value < r.Value), nil
+			case opGreater:
+				return nativeBool(l.Value > r.Value), nil
+			case opLessEqual:
+				return nativeBool(l.Value <= r.Value), nil
+			case opGreaterEqual:
+				return nativeBool(l.Value >= r.Value), nil
+			case opEqual:
+				return nativeBool(l.Value == r.Value), nil
+			case opNotEqual:
+				return nativeBool(l.Value != r.Value), nil
+			}
+		}
+	}
+
+	return e.evaluateInfixExpression(ctx, c.tokens[ip], left, right)
+}
+
+// get returns the variable name, looking it up by name from scope, or
+// failing that the built-in function of that name.
+func (e *Evaluator) get(scope *Scope, name string) (Object, error) {
+	if val, ok := scope.Get(name); ok {
+		return val, nil
+	}
+	if builtin, ok := e.function(name); ok {
+		return builtin, nil
+	}
+	return nil, newError(ErrUndefinedVariable, "identifier not found: %s", name)
+}
+
+// assign sets the existing variable name, looking it up by name from scope.
+func assign(scope *Scope, name string, val Object) error {
+	if !scope.Assign(name, val) {
+		return newError(ErrUndefinedVariable, "identifier not found in scope: %s", name)
+	}
+	return nil
+}
+
+// outer returns the scope depth levels above scope.
+func outer(scope *Scope, depth int) *Scope {
+	for ; depth > 0; depth-- {
+		scope = scope.parent
+	}
+	return scope
+}
+
+// global returns the scope from which a global variable, one not local to
+// any of the depth scopes above scope, is looked up. Those scopes can only
+// hold it if it was set by name, for example by a named block, which keeps
+// it out of their slots.
+func global(scope *Scope, depth int, name string) *Scope {
+	for ; depth > 0; depth-- {
+		if _, ok := scope.store[name]; ok {
+			return scope
+		}
+		scope = scope.parent
+	}
+	return scope
+}
+
+// closure creates the function value for fn in scope. A named function is
+// also declared in scope.
+func (e *Evaluator) closure(fn *functionCode, scope *Scope) (Object, error) {
+	fv := &FunctionValue{
+		Name:       fn.name,
+		Parameters: fn.literal.Parameters,
+		Body:       fn.literal.Body,
+		Scope:      scope,
+		code:       fn.body,
+	}
+
+	if fn.literal.Identifier != nil {
+		name := fn.literal.Identifier.Value
+		if _, ok := scope.GetLocal(name); ok {
+			return nil, fmt.Errorf("identifier already defined in local scope: %s", name)
+		}
+		if fn.slot >= 0 {
+			scope.slots[fn.slot] = fv
+		} else {
+			scope.SetLocal(name, fv)
+		}
+	}
+
+	return fv, nil
+}
+
+// property returns the property key of left, or null if left is not a hash
+// or has no such property. When the context is Strict, both are errors,
+// except for a missing property read with ?., which is safe.
+func (ctx *ExecutionContext) property(left Object, key propertyKey, safe bool) (Object, error) {
+	hash, ok := left.(*HashValue)
+	if ok {
+		if pair, ok := hash.Pairs[key.hash]; ok {
+			return pair.Value, nil
+		}
+	}
+
+	switch {
+	case !ctx.Strict || ok && safe:
+		return Null, nil
+	case ok:
+		return nil, newError(ErrUndefinedProperty, "undefined property: %s", key.name.Value)
+	default:
+		return nil, newError(ErrTypeMismatch, "cannot read property %s of %s", key.name.Value, left.Type())
+	}
+}
+
+// setIndex assigns value to left[index] for an array or hash.
+func (ctx *ExecutionContext) setIndex(left, index, value Object) error {
+	if left == Null || index == Null {
+		return newError(ErrTypeMismatch, "index expression left side or index evaluated to null")
+	}
+
+	switch l := left.(type) {
+	case *ArrayValue:
+		i, ok := index.(*IntegerValue)
+		if !ok {
+			return newError(ErrTypeMismatch, "index must be an integer, got %T", index)
+		}
+		if i.Value < 0 || i.Value >= len(l.Elements) {
+			return newError(ErrIndexOutOfRange, "index out of bounds: %d", i.Value)
+		}
+		l.Elements[i.Value] = value
+		return nil
+	case *HashValue:
+		if err := ctx.allocHashSet(l, index); err != nil {
+			return err
+		}
+		return l.Set(index, value)
+	default:
+		return newError(ErrTypeMismatch, "left side of index expression must be an array or hash, got %T", left)
+	}
+}
+
+// newArray creates an array of elements, which are copied.
+func (ctx *ExecutionContext) newArray(elements []Object) (Object, error) {
+	if ctx.MaxArraySize > 0 && len(elements) > ctx.MaxArraySize {
+		return nil, newError(ErrArrayLimit, "maximum array size exceeded: %d", ctx.MaxArraySize)
+	}
+
+	if err := ctx.allocArray(len(elements)); err != nil {
+		return nil, err
+	}
+
+	if len(elements) == 0 {
+		return &ArrayValue{}, nil
+	}
+
+	return &ArrayValue{Elements: slices.Clone(elements)}, nil
+}
+
+// interpolate joins values into a string, converting those that are not
+// strings the way + does.
+func (ctx *ExecutionContext) interpolate(values []Object) (Object, error) {
+	var sb strings.Builder
+	for _, v := range values {
+		if s, ok := v.(*StringValue); ok {
+			sb.WriteString(s.Value)
+		} else {
+			sb.WriteString(v.Debug())
+		}
+	}
+
+	if err := ctx.allocString(sb.Len()); err != nil {
+		return nil, err
+	}
+	return &StringValue{Value: sb.String()}, nil
+}
+
+// newHash creates a hash from alternating keys and values.
+func (ctx *ExecutionContext) newHash(pairs []Object) (Object, error) {
+	if err := ctx.allocHash(len(pairs) / 2); err != nil {
+		return nil, err
+	}
+
+	hash := NewHashValue()
+	for i := 0; i < len(pairs); i += 2 {
+		if err := hash.Set(pairs[i], pairs[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,148 @@
+package evaluator
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestVMControlFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"break from nested try",
+			`let i = 0; while (true) { try { try { i += 1; if (i == 2) { break; } } catch (e) { } } catch (e) { } } try { throw "x"; } catch (e) { i += 10; } return i;`,
+			"12",
+		},
+		{
+			"continue from catch",
+			`let n = 0; foreach ([1, 0, 2] as v) { try { let x = 1 / v; } catch (e) { continue; } n += 1; } return n;`,
+			"2",
+		},
+		{
+			"break from nested loops",
+			`let n = 0; foreach ([1, 2, 3] as a) { foreach ([1, 2, 3] as b) { if (b == 2) { break; } n += 1; } } return n;`,
+			"3",
+		},
+		{
+			"return from loop in function",
+			`fn find(items, want) { foreach (items as i, v) { if (v == want) { return i; } } return -1; } return find([4, 5, 6], 6) + find([], 1);`,
+			"1",
+		},
+		{
+			"closures capture each iteration",
+			`let fns = []; foreach ([1, 2, 3] as v) { append(fns, fn() { return v; }); } return fns[0]() + fns[1]() * 10 + fns[2]() * 100;`,
+			"321",
+		},
+		{
+			"catch restores stack",
+			`fn f() { return [1, 2, {"a": 1 / 0}]; } let r = 0; foreach ([1, 2] as v) { try { r = r + v + f(); } catch (e) { r += 10; } } return r;`,
+			"20",
+		},
+		{
+			"if as value",
+			`fn f(x) { if (x) { "yes"; } else { "no"; } } return f(true) + f(false);`,
+			"yesno",
+		},
+		{
+			"short circuit",
+			`let calls = 0; fn t() { calls += 1; return true; } let a = false && t(); let b = true || t(); let c = null ?? t(); return calls;`,
+			"1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RunScript(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unwrapReturn(t, result).Debug(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVMLocals(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"global read before local let", `let x = 1; fn f() { let r = x; if (false) { let x = 2; } return r; } return f();`, "1"},
+		{"global assign before local let", `let x = 1; fn f() { x = 5; if (false) { let x = 2; } } f(); return x;`, "5"},
+		{"declared after closure", `fn g() { fn h() { return y; } let y = 2; return h(); } return g();`, "2"},
+		{"duplicate parameter", `fn f(a, a) { return a; } return f(1, 2);`, "2"},
+		{"assign global from loop", `let total = 0; fn add(items) { foreach (items as v) { total += v; } } add([1, 2, 3]); return total;`, "6"},
+		{"shared closure state", `fn counter() { let n = 0; return fn() { n += 1; return n; }; } let c = counter(); c(); return c();`, "2"},
+		{"catch parameter", `fn f() { try { throw 1; } catch (e) { let v = e.value + 1; return v; } } return f();`, "2"},
+		{"host function sees locals", `fn f(name) { return lookup(); } return f("x");`, "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			e.RegisterFunction("lookup", func(ctx *ExecutionContext, scope *Scope, args ...Object) (Object, error) {
+				val, _ := scope.Get("name")
+				return val, nil
+			})
+
+			result, err := e.RunScript(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unwrapReturn(t, result).Debug(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVMTemplateLoops(t *testing.T) {
+	out, err := RunTemplate(`{% foreach (rows as r) { if (r == 2) { continue; } %}[{% r %}]{% if (r == 3) { break; } } %}done`, Vars{"rows": []any{1, 2, 3, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "[1][3]done" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestVMStepLimitCountsInstructions(t *testing.T) {
+	ctx := newScriptContext(t, `let i = 0; while (i < 10) { i += 1; } return i;`)
+	ctx.MaxSteps = 20
+
+	_, err := New().Evaluate(ctx)
+	if !errors.Is(err, ErrStepLimit) {
+		t.Fatalf("expected step limit error, got: %v", err)
+	}
+}
+
+func TestVMSharedProgram(t *testing.T) {
+	program := newScriptContext(t, `fn fib(n) { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); } return fib(n);`).Program
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			ctx := NewExecutionContext(program)
+			ctx.RootScope.SetLocal("n", NewIntegerValue(n))
+
+			result, err := New().Evaluate(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			want := []string{"0", "1", "1", "2", "3", "5", "8", "13"}[n]
+			if got := result.(*ReturnValue).Value.Debug(); got != want {
+				t.Errorf("fib(%d): expected %s, got %s", n, want, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,55 @@
+// Package formatter prints scripts and templates in a canonical layout.
+//
+// Statements are put on lines of their own and indented by four spaces per
+// block, with single spaces around binary operators and after commas. Blank
+// lines between statements are kept, but collapsed to one. Comments are
+// kept: a comment that follows code on the same line stays on that line,
+// and any other comment is put on a line of its own before the next
+// statement. Parentheses are reduced to those the precedence of the
+// operators needs. An array or hash literal whose first element starts on a
+// later line than its opening bracket is printed with one element per line;
+// other literals, arguments and parameters are printed on a single line.
+//
+// In templates only the code between {% and %} is formatted, and the text
+// around it is kept as it is. A tag holding one line of code is printed as
+// {% code %}, leaving out the semicolon after an expression that is alone
+// in its tag, and a tag holding more lines is printed with the code
+// indented on lines of its own between {% and %}.
+//
+// Formatting is idempotent, and the formatted source parses to the same
+// program as the original.
+package formatter
+
+import (
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// Script formats the source of a script. It returns the errors of the
+// lexer or parser if the source is not valid.
+func Script(source string) (string, error) {
+	return format(source, false)
+}
+
+// Template formats the source of a template. It returns the errors of the
+// lexer or parser if the source is not valid.
+func Template(source string) (string, error) {
+	return format(source, true)
+}
+
+func format(source string, template bool) (string, error) {
+	l := lexer.NewScript(source)
+	if template {
+		l = lexer.NewTemplate(source)
+	}
+	l.KeepComments()
+
+	program, err := parser.New(l).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	p := newPrinter(source, template, program.Comments)
+	p.program(program)
+	return p.out.String(), nil
+}
@@ -0,0 +1,186 @@
+package formatter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// parse parses source, keeping its comments, and clears the positions of
+// all its tokens so that programs can be compared by their structure.
+func parse(t *testing.T, source string, template bool) *parser.Program {
+	t.Helper()
+	l := lexer.NewScript(source)
+	if template {
+		l = lexer.NewTemplate(source)
+	}
+	l.KeepComments()
+	program, err := parser.New(l).Parse()
+	if err != nil {
+		t.Fatalf("parse %q: %v", source, err)
+	}
+	clearPositions(reflect.ValueOf(program))
+	return program
+}
+
+func clearPositions(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			clearPositions(v.Elem())
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			clearPositions(v.Index(i))
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeFor[lexer.Token]() {
+			if v.CanSet() {
+				v.Set(reflect.ValueOf(lexer.Token{Type: v.Interface().(lexer.Token).Type, Source: v.Interface().(lexer.Token).Source}))
+			}
+			return
+		}
+		for i := range v.NumField() {
+			clearPositions(v.Field(i))
+		}
+	}
+}
+
+// check formats source, compares the result with expected, and checks that
+// formatting it again changes nothing and that it parses to the same
+// program as source.
+func check(t *testing.T, source, expected string, template bool) {
+	t.Helper()
+	formatter := Script
+	if template {
+		formatter = Template
+	}
+
+	got, err := formatter(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != expected {
+		t.Fatalf("format %q:\nexpected:\n%s\ngot:\n%s", source, expected, got)
+	}
+
+	again, err := formatter(got)
+	if err != nil {
+		t.Fatalf("unexpected error formatting again: %v", err)
+	}
+	if again != got {
+		t.Fatalf("format is not idempotent:\nfirst:\n%s\nsecond:\n%s", got, again)
+	}
+
+	if want, have := parse(t, source, template), parse(t, got, template); !reflect.DeepEqual(want, have) {
+		t.Fatalf("formatted source parses to a different program:\n%s", got)
+	}
+}
+
+func TestScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"spacing", "let   a=1+2*3;let b=a ;", "let a = 1 + 2 * 3;\nlet b = a;\n"},
+		{"blank lines", "let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"parentheses", "let a = (1 + 2) * 3 + (4 * 5) - (6 - 7) - 8;", "let a = (1 + 2) * 3 + 4 * 5 - (6 - 7) - 8;\n"},
+		{"logical", "let a = (x ?? y) ?? (z || (w && v));", "let a = x ?? y ?? z || w && v;\n"},
+		{"prefix", "let a = -(n + 1) * !ok; let b = (-n).c; let c = -n.c;", "let a = -(n + 1) * !ok;\nlet b = (-n).c;\nlet c = -n.c;\n"},
+		{"postfix", "(a + b).c(1)(2)[3];", "(a + b).c(1)(2)[3];\n"},
+		{"compound assignment", "x+=1; y.z -= 2*3; w[0]%=4;", "x += 1;\ny.z -= 2 * 3;\nw[0] %= 4;\n"},
+		{"assignment", "x.y[0]=fn(a,b){return a;};", "x.y[0] = fn(a, b) {\n    return a;\n};\n"},
+		{"function", "fn add(x,y){return x+y;}\nadd(1,2);", "fn add(x, y) {\n    return x + y;\n}\nadd(1, 2);\n"},
+		{"empty block", "fn f() {  }\nwhile (true) {}", "fn f() {}\nwhile (true) {}\n"},
+		{"if else", "if (a) { b(); } else if (c) { d(); } else { e(); }",
+			"if (a) {\n    b();\n} else if (c) {\n    d();\n} else {\n    e();\n}\n"},
+		{"else block", "if (a) { b(); } else { if (c) { d(); } }",
+			"if (a) {\n    b();\n} else {\n    if (c) {\n        d();\n    }\n}\n"},
+		{"loops", "foreach (items as i, v) { if (v) { break } continue; }\nwhile (x<10) { x = x+1; }",
+			"foreach (items as i, v) {\n    if (v) {\n        break;\n    }\n    continue;\n}\nwhile (x < 10) {\n    x = x + 1;\n}\n"},
+		{"try", "try { throw \"oops\"; } catch (e) { log(e.message); }",
+			"try {\n    throw \"oops\";\n} catch (e) {\n    log(e.message);\n}\n"},
+		{"literals", `let a = [1,2.50,"s\n",true,null,[ ]]; let h = { };`, "let a = [1, 2.50, \"s\\n\", true, null, []];\nlet h = {};\n"},
+		{"hash", `let h = {"a":1,  "b":{"c":[1]}};`, "let h = {\"a\": 1, \"b\": {\"c\": [1]}};\n"},
+		{"interpolated string", "if (a) {\nlog(`x ${ b+1 } /* c */\n  y`  );\n}", "if (a) {\n    log(`x ${ b+1 } /* c */\n  y`);\n}\n"},
+		{"pipe", "log(name|toUpper|  substring(0,3));\nlet n = (a + b|len) * 2;\nlet m = a + (b | len);", "log(name | toUpper | substring(0, 3));\nlet n = (a + b | len) * 2;\nlet m = a + (b | len);\n"},
+		{"null-safe access", "let c = user ?. address?.city;\nlet f = items?[ 0 ]?.name;", "let c = user?.address?.city;\nlet f = items?[0]?.name;\n"},
+		{"range", "foreach (0 ..< n+1   step 2 as i) { log(i); }\nlet r = a..(b..c);", "foreach (0..<n + 1 step 2 as i) {\n    log(i);\n}\nlet r = a..(b..c);\n"},
+		{"for", "for(let i=0;i<n;i+=1){log(i);}\nfor (;;) { break; }\nfor (i = 0; ; next()) {}", "for (let i = 0; i < n; i += 1) {\n    log(i);\n}\nfor (;;) {\n    break;\n}\nfor (i = 0;; next()) {}\n"},
+		{"broken hash", "let h = {\n\"a\": 1, \"b\": [\n1,\n\n2]};", "let h = {\n    \"a\": 1,\n    \"b\": [\n        1,\n\n        2\n    ]\n};\n"},
+		{"comments", "// header\n\nlet a = 1; // trailing\n/* block */ let b = 2;\nfn f() { // opening\n    // inside\n    return 1;\n    // last\n}\n// end",
+			"// header\n\nlet a = 1; // trailing\n/* block */ let b = 2;\nfn f() { // opening\n    // inside\n    return 1;\n    // last\n}\n// end\n"},
+		{"comments in hash", "let h = {\n    // key\n    \"a\": 1, // one\n    \"b\": 2\n};",
+			"let h = {\n    // key\n    \"a\": 1, // one\n    \"b\": 2\n};\n"},
+		{"comment in expression", "let a = f(1, /* two */ 2);\nlet b = 3;", "let a = f(1, 2); /* two */\nlet b = 3;\n"},
+		{"multi-line comment", "if (a) {\n/* one\n   two */\nb();\n}", "if (a) {\n    /* one\n   two */\n    b();\n}\n"},
+		{"only comments", "// a\n\n\n// b", "// a\n\n// b\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check(t, tt.source, tt.expected, false)
+		})
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"text", "<p>\n  hello  \n</p>\n", "<p>\n  hello  \n</p>\n"},
+		{"expression", "<p>{%name%}</p>{%  f( a,b );%}", "<p>{% name %}</p>{% f(a, b) %}"},
+		{"statements", "{% let a=1 %}{% extends \"layout\" %}", "{% let a = 1; %}{% extends \"layout\"; %}"},
+		{"loop", "<ul>\n  {% foreach (items as i) { %}\n    <li>{%i%}</li>\n  {%}%}\n</ul>",
+			"<ul>\n  {% foreach (items as i) { %}\n    <li>{% i %}</li>\n  {% } %}\n</ul>"},
+		{"if else", "{%if(a){%}yes{%}else{%}no{%}%}", "{% if (a) { %}yes{% } else { %}no{% } %}"},
+		{"adjacent tags", "{% title %}{% subtitle %}", "{% title %}{% subtitle %}"},
+		{"empty tag", "a\n  {% %}b{%%}c", "a\n  {% %}b{% %}c"},
+		{"several lines", "<div>\n  {% let a = 1;\n  let b = 2; %}\n</div>", "<div>\n  {%\n      let a = 1;\n      let b = 2;\n  %}\n</div>"},
+		{"block in tag", "{% block body { a = 1; } %}", "{%\n    block body {\n        a = 1;\n    }\n%}"},
+		{"split block", "{% if (x) { let y = 1; %}{% y %}{% } %}", "{%\n    if (x) {\n        let y = 1;\n%}{% y %}{% } %}"},
+		{"comments", "{% /* note */ x %}a{% /* only */ %}b{% x // end\n%}",
+			"{% /* note */ x %}a{% /* only */ %}b{%\n    x // end\n%}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check(t, tt.source, tt.expected, true)
+		})
+	}
+}
+
+func TestTemplateUnclosedBlock(t *testing.T) {
+	// The parser lets a block run to the end of the source, where the
+	// formatter closes it.
+	got, err := Template("{% if (a) { %}{% x %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{% if (a) { %}{%\n        x;\n    }\n%}"; got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	parse(t, got, true)
+}
+
+func TestFormatErrors(t *testing.T) {
+	_, err := Script("let = 1;")
+	var parseErr *parser.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+
+	_, err = Template("<p>{% /* open %}</p>")
+	var tokenErr *lexer.TokenError
+	if !errors.As(err, &tokenErr) || !strings.Contains(tokenErr.Message, "unterminated comment") {
+		t.Fatalf("expected an unterminated comment error, got %v", err)
+	}
+}
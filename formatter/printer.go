@@ -0,0 +1,681 @@
+package formatter
+
+import (
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+const indent = "    "
+
+// atom is the precedence of expressions that are not infix expressions,
+// which never need parentheses as operands.
+const atom = 100
+
+// compoundOperators are the tokens of the assignments that the parser
+// desugars from x op= y to x = x op y.
+var compoundOperators = map[lexer.TokenType]bool{
+	lexer.PlusEqual:     true,
+	lexer.MinusEqual:    true,
+	lexer.AsteriskEqual: true,
+	lexer.SlashEqual:    true,
+	lexer.ModuloEqual:   true,
+}
+
+// line is a line of code, without its indentation.
+type line struct {
+	depth int
+	text  string
+}
+
+// printer prints a program a line at a time. In templates, the lines of
+// code are collected for one tag at a time and laid out when it closes.
+type printer struct {
+	source   string
+	template bool
+	comments []lexer.Token // the comments not printed yet
+
+	out   strings.Builder
+	lines []line
+	cur   strings.Builder // the line being printed
+	depth int             // the block depth of the next line
+	level int             // the block depth of the current line
+
+	inCode        bool   // in templates, whether a tag is open
+	base          string // the whitespace before the open tag on its line
+	tagStatements int    // the statements in the open tag
+	afterText     bool   // text was printed last
+
+	blockStart  bool // nothing has been printed since an opening brace
+	lineComment bool // the current line ends with a single-line comment
+	commentEnd  int  // the end of the block comment printed last, or -1
+}
+
+func newPrinter(source string, template bool, comments []lexer.Token) *printer {
+	return &printer{
+		source:     source,
+		template:   template,
+		comments:   comments,
+		inCode:     !template,
+		commentEnd: -1,
+	}
+}
+
+func (p *printer) program(program *parser.Program) {
+	p.statements(program.Statements, -1)
+	p.flushComments(len(p.source) + 1)
+
+	if p.template {
+		if p.inCode {
+			p.closeTag()
+		}
+		return
+	}
+
+	p.newline()
+	if len(p.lines) == 1 && p.lines[0].text == "" {
+		return
+	}
+	for _, l := range p.lines {
+		if l.text != "" {
+			p.out.WriteString(strings.Repeat(indent, l.depth))
+			p.out.WriteString(l.text)
+		}
+		p.out.WriteString("\n")
+	}
+}
+
+// write adds s to the current line, opening a tag first in templates.
+func (p *printer) write(s string) {
+	if !p.inCode {
+		p.openTag()
+	}
+	p.cur.WriteString(s)
+	p.lineComment = false
+	p.commentEnd = -1
+}
+
+// newline ends the current line.
+func (p *printer) newline() {
+	p.lines = append(p.lines, line{depth: p.level, text: p.cur.String()})
+	p.cur.Reset()
+	p.level = p.depth
+}
+
+func (p *printer) openTag() {
+	p.inCode = true
+	p.afterText = false
+	p.tagStatements = 0
+	p.level = p.depth
+
+	// A tag that starts its line keeps the indentation of the text before
+	// it, so a tag printed over several lines lines up with it.
+	out := p.out.String()
+	p.base = out[strings.LastIndexByte(out, '\n')+1:]
+	if strings.TrimLeft(p.base, " \t") != "" {
+		p.base = ""
+	}
+}
+
+// closeTag prints the code of the open tag between {% and %}, on the same
+// line if it fits on one.
+func (p *printer) closeTag() {
+	p.newline()
+	lines := p.lines
+	p.lines = nil
+	p.inCode = false
+
+	if len(lines) == 1 && !p.lineComment {
+		p.out.WriteString("{% " + lines[0].text + " %}")
+		return
+	}
+	p.lineComment = false
+
+	minDepth := -1
+	for _, l := range lines {
+		if l.text != "" && (minDepth < 0 || l.depth < minDepth) {
+			minDepth = l.depth
+		}
+	}
+
+	p.out.WriteString("{%\n")
+	for _, l := range lines {
+		if l.text != "" {
+			p.out.WriteString(p.base)
+			p.out.WriteString(strings.Repeat(indent, 1+l.depth-minDepth))
+			p.out.WriteString(l.text)
+		}
+		p.out.WriteString("\n")
+	}
+	p.out.WriteString(p.base + "%}")
+}
+
+// text prints the text of a template as it is.
+func (p *printer) text(ps *parser.PrintStatement) {
+	if p.inCode {
+		p.closeTag()
+	} else if p.afterText {
+		// Text is only split by a tag, which also decides whether the
+		// whitespace before it is printed, so an empty one is kept.
+		p.out.WriteString("{% %}")
+	}
+	p.out.WriteString(ps.Token.Source)
+	p.afterText = true
+}
+
+// item separates the comment or statement starting at pos from the code
+// printed before it.
+func (p *printer) item(pos int, comment bool) {
+	if p.inCode && p.startsTag(pos) {
+		p.closeTag()
+	}
+
+	switch {
+	case !p.inCode:
+		p.openTag()
+	case p.cur.Len() == 0 && len(p.lines) == 0:
+	case comment && p.followsCode(pos), p.followsComment(pos):
+		p.write(" ")
+	default:
+		p.newline()
+		if !p.blockStart && p.blankBefore(pos) {
+			p.lines = append(p.lines, line{})
+		}
+	}
+	p.blockStart = false
+}
+
+// followsCode reports whether there is code before pos on its line.
+func (p *printer) followsCode(pos int) bool {
+	for i := pos - 1; i >= 0; i-- {
+		switch p.source[i] {
+		case '\n':
+			return false
+		case ' ', '\t', '\r':
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// followsComment reports whether pos is on the line that the last printed
+// block comment ends on.
+func (p *printer) followsComment(pos int) bool {
+	return p.commentEnd >= 0 && !strings.Contains(p.source[p.commentEnd:pos], "\n")
+}
+
+// blankBefore reports whether there is a blank line before pos. The
+// parentheses of a grouped expression are skipped, since the expression
+// starts after them.
+func (p *printer) blankBefore(pos int) bool {
+	newlines := 0
+	for i := pos - 1; i >= 0; i-- {
+		switch p.source[i] {
+		case '\n':
+			newlines++
+		case ' ', '\t', '\r', '(':
+		default:
+			return newlines > 1
+		}
+	}
+	return false
+}
+
+// startsTag reports whether pos is the first code in a template tag.
+func (p *printer) startsTag(pos int) bool {
+	if !p.template {
+		return false
+	}
+	for i := pos - 1; i > 0; i-- {
+		switch p.source[i] {
+		case ' ', '\t', '\r', '\n', '(':
+		default:
+			return p.source[i-1:i+1] == lexer.ScriptStartToken
+		}
+	}
+	return false
+}
+
+// flushComments prints the comments before pos.
+func (p *printer) flushComments(pos int) {
+	for len(p.comments) > 0 && p.comments[0].Position < pos {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.item(c.Position, true)
+		p.write(c.Source)
+		if strings.HasPrefix(c.Source, "//") {
+			p.lineComment = true
+		} else {
+			p.commentEnd = c.Position + len(c.Source)
+		}
+	}
+}
+
+// statements prints a list of statements, which ends at the closing brace
+// at position end, or at the end of the source if end is -1.
+func (p *printer) statements(statements []parser.Statement, end int) {
+	for i, s := range statements {
+		pos := start(s).Position
+		p.flushComments(pos)
+
+		if ps, ok := s.(*parser.PrintStatement); ok {
+			p.text(ps)
+			continue
+		}
+
+		p.item(pos, false)
+		p.tagStatements++
+		alone := p.template && p.tagStatements == 1 && p.endsTag(statements, i, end)
+		p.statement(s, alone)
+	}
+}
+
+// endsTag reports whether the tag holding statements[i] ends after it.
+func (p *printer) endsTag(statements []parser.Statement, i int, end int) bool {
+	if i == len(statements)-1 {
+		return end < 0 || p.startsTag(end)
+	}
+	next := statements[i+1]
+	if _, ok := next.(*parser.PrintStatement); ok {
+		return true
+	}
+	return p.startsTag(start(next).Position)
+}
+
+// statement prints s. An expression alone in its tag is printed without a
+// semicolon.
+func (p *printer) statement(s parser.Statement, alone bool) {
+	switch s := s.(type) {
+	case *parser.LetStatement:
+		p.write("let " + s.Name.Value + " = ")
+		p.expression(s.Value)
+		p.write(";")
+	case *parser.ReturnStatement:
+		p.write("return ")
+		p.expression(s.Value)
+		p.write(";")
+	case *parser.ThrowStatement:
+		p.write("throw ")
+		p.expression(s.Value)
+		p.write(";")
+	case *parser.ExtendsStatement:
+		p.write("extends ")
+		p.expression(s.Template)
+		p.write(";")
+	case *parser.BreakStatement:
+		p.write("break;")
+	case *parser.ContinueStatement:
+		p.write("continue;")
+	case *parser.NamedBlockStatement:
+		p.write("block " + s.Name.Value + " ")
+		p.block(s.Body)
+	case *parser.TryStatement:
+		p.write("try ")
+		p.block(s.Body)
+		p.write(" catch (" + s.Parameter.Value + ") ")
+		p.block(s.Catch)
+	case *parser.ForeachExpression:
+		p.write("foreach (")
+		p.expression(s.Iterable)
+		p.write(" as ")
+		if s.Index != nil {
+			p.write(s.Index.Value + ", ")
+		}
+		p.write(s.Variable.Value + ") ")
+		p.block(s.Body)
+	case *parser.ExpressionStatement:
+		p.expression(s.Expression)
+		switch e := s.Expression.(type) {
+		case *parser.IfExpression, *parser.WhileExpression, *parser.ForExpression:
+		case *parser.FunctionLiteral:
+			if e.Identifier == nil {
+				p.write(";")
+			}
+		case *parser.AssignmentExpression:
+			p.write(";")
+		default:
+			if !alone {
+				p.write(";")
+			}
+		}
+	}
+}
+
+func (p *printer) block(b *parser.BlockStatement) {
+	p.write("{")
+	p.depth++
+	p.blockStart = true
+
+	// A block the parser made up for an else if has no end.
+	end := -1
+	if b.End.Type != "" {
+		end = b.End.Position
+	}
+
+	p.statements(b.Statements, end)
+	if end >= 0 {
+		p.flushComments(end)
+	}
+
+	p.depth--
+	if p.inCode && end >= 0 && p.startsTag(end) {
+		p.closeTag()
+	}
+	if p.inCode && !p.blockStart {
+		p.newline()
+	}
+	p.write("}")
+	p.blockStart = false
+}
+
+func (p *printer) expression(e parser.Expression) {
+	switch e := e.(type) {
+	case *parser.Identifier:
+		p.write(e.Value)
+	case *parser.IntegerLiteral:
+		p.write(e.Token.Source)
+	case *parser.FloatLiteral:
+		p.write(e.Token.Source)
+	case *parser.StringLiteral:
+		p.write(e.Token.Source)
+	case *parser.InterpolatedString:
+		// Written as it is, since the text around its expressions is
+		// not code to format.
+		p.write(e.Token.Source)
+	case *parser.BooleanLiteral:
+		p.write(e.Token.Source)
+	case *parser.NullLiteral:
+		p.write("null")
+	case *parser.PrefixExpression:
+		p.write(e.Operator)
+		p.operand(e.Right, precedence(e.Right) < atom)
+	case *parser.InfixExpression:
+		// Infix operators are left-associative, so an operand on the right
+		// needs parentheses for the same precedence, too.
+		prec := precedence(e)
+		p.operand(e.Left, precedence(e.Left) < prec)
+		p.write(" " + e.Token.Source + " ")
+		p.operand(e.Right, precedence(e.Right) <= prec)
+	case *parser.PipeExpression:
+		p.operand(e.Left, precedence(e.Left) < precedence(e))
+		p.write(" | ")
+		p.expression(e.Filter)
+	case *parser.RangeExpression:
+		prec := precedence(e)
+		p.operand(e.Start, precedence(e.Start) < prec)
+		p.write(e.Token.Source)
+		p.operand(e.End, precedence(e.End) <= prec)
+		if e.Step != nil {
+			p.write(" step ")
+			p.operand(e.Step, precedence(e.Step) <= prec)
+		}
+	case *parser.AssignmentExpression:
+		p.expression(e.Left)
+		if infix, ok := e.Right.(*parser.InfixExpression); ok && compoundOperators[e.Token.Type] {
+			p.write(" " + e.Token.Source + " ")
+			p.expression(infix.Right)
+			return
+		}
+		p.write(" = ")
+		p.expression(e.Right)
+	case *parser.PropertyExpression:
+		p.operand(e.Left, postfixNeedsParens(e.Left))
+		if e.Optional {
+			p.write("?.")
+		} else {
+			p.write(".")
+		}
+		p.expression(e.Property)
+	case *parser.IndexExpression:
+		p.operand(e.Left, postfixNeedsParens(e.Left))
+		if e.Optional {
+			p.write("?[")
+		} else {
+			p.write("[")
+		}
+		p.expression(e.Index)
+		p.write("]")
+	case *parser.CallExpression:
+		p.operand(e.Function, postfixNeedsParens(e.Function))
+		p.write("(")
+		for i, arg := range e.Args {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(arg)
+		}
+		p.write(")")
+	case *parser.ArrayLiteral:
+		p.list(e.Token, "]", len(e.Elements),
+			func(i int) lexer.Token { return start(e.Elements[i]) },
+			func(i int) { p.expression(e.Elements[i]) })
+	case *parser.HashLiteral:
+		p.list(e.Token, "}", len(e.Pairs),
+			func(i int) lexer.Token { return start(e.Pairs[i].Key) },
+			func(i int) {
+				p.expression(e.Pairs[i].Key)
+				p.write(": ")
+				p.expression(e.Pairs[i].Value)
+			})
+	case *parser.IfExpression:
+		p.ifExpression(e)
+	case *parser.WhileExpression:
+		p.write("while (")
+		p.expression(e.Condition)
+		p.write(") ")
+		p.block(e.Body)
+	case *parser.ForExpression:
+		p.write("for (")
+		switch init := e.Init.(type) {
+		case *parser.LetStatement:
+			p.write("let " + init.Name.Value + " = ")
+			p.expression(init.Value)
+		case *parser.ExpressionStatement:
+			p.expression(init.Expression)
+		}
+		p.write(";")
+		if e.Condition != nil {
+			p.write(" ")
+			p.expression(e.Condition)
+		}
+		p.write(";")
+		if e.Update != nil {
+			p.write(" ")
+			p.expression(e.Update)
+		}
+		p.write(") ")
+		p.block(e.Body)
+	case *parser.FunctionLiteral:
+		p.write("fn")
+		if e.Identifier != nil {
+			p.write(" " + e.Identifier.Value)
+		}
+		p.write("(")
+		for i, param := range e.Parameters {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(param.Value)
+		}
+		p.write(") ")
+		p.block(e.Body)
+	}
+}
+
+func (p *printer) operand(e parser.Expression, parens bool) {
+	if parens {
+		p.write("(")
+		p.expression(e)
+		p.write(")")
+		return
+	}
+	p.expression(e)
+}
+
+// list prints the n elements of an array or hash literal, each on a line of
+// its own if the first one starts on a later line than the opening bracket.
+func (p *printer) list(open lexer.Token, close string, n int, first func(int) lexer.Token, element func(int)) {
+	p.write(open.Source)
+	if n == 0 {
+		p.write(close)
+		return
+	}
+
+	if first(0).Line == open.Line {
+		for i := range n {
+			if i > 0 {
+				p.write(", ")
+			}
+			element(i)
+		}
+		p.write(close)
+		return
+	}
+
+	p.depth++
+	p.blockStart = true
+	for i := range n {
+		pos := first(i).Position
+		p.flushComments(pos)
+		p.item(pos, false)
+		element(i)
+		if i < n-1 {
+			p.write(",")
+		}
+	}
+	p.depth--
+	p.newline()
+	p.write(close)
+}
+
+func (p *printer) ifExpression(e *parser.IfExpression) {
+	p.write("if (")
+	p.expression(e.Condition)
+	p.write(") ")
+	p.block(e.Consequence)
+	if e.Alternative == nil {
+		return
+	}
+
+	p.write(" else ")
+	if elseIf := elseIf(e.Alternative); elseIf != nil {
+		p.ifExpression(elseIf)
+		return
+	}
+	p.block(e.Alternative)
+}
+
+// elseIf returns the if expression of an else if, which the parser wraps
+// in a block that does not start with a brace.
+func elseIf(b *parser.BlockStatement) *parser.IfExpression {
+	if b.Token.Type == lexer.LeftBrace || len(b.Statements) != 1 {
+		return nil
+	}
+	if es, ok := b.Statements[0].(*parser.ExpressionStatement); ok {
+		if ie, ok := es.Expression.(*parser.IfExpression); ok {
+			return ie
+		}
+	}
+	return nil
+}
+
+// precedence returns the precedence of e as an operand.
+func precedence(e parser.Expression) int {
+	switch e := e.(type) {
+	case *parser.InfixExpression:
+		return parser.Precedences[e.Token.Type]
+	case *parser.PipeExpression:
+		return parser.Precedences[lexer.Pipe]
+	case *parser.RangeExpression:
+		return parser.Precedences[lexer.Range]
+	case *parser.AssignmentExpression:
+		return 0
+	default:
+		return atom
+	}
+}
+
+// postfixNeedsParens reports whether e needs parentheses to be the operand
+// of a property access, index or call, which bind tighter than prefix
+// operators.
+func postfixNeedsParens(e parser.Expression) bool {
+	switch e.(type) {
+	case *parser.InfixExpression, *parser.PipeExpression, *parser.RangeExpression, *parser.PrefixExpression, *parser.AssignmentExpression:
+		return true
+	}
+	return false
+}
+
+// start returns the first token of node.
+func start(node any) lexer.Token {
+	switch n := node.(type) {
+	case *parser.ExpressionStatement:
+		return start(n.Expression)
+	case *parser.AssignmentExpression:
+		return start(n.Left)
+	case *parser.InfixExpression:
+		return start(n.Left)
+	case *parser.PipeExpression:
+		return start(n.Left)
+	case *parser.RangeExpression:
+		return start(n.Start)
+	case *parser.PropertyExpression:
+		return start(n.Left)
+	case *parser.IndexExpression:
+		return start(n.Left)
+	case *parser.CallExpression:
+		return start(n.Function)
+	case *parser.PrintStatement:
+		return n.Token
+	case *parser.LetStatement:
+		return n.Token
+	case *parser.ReturnStatement:
+		return n.Token
+	case *parser.ThrowStatement:
+		return n.Token
+	case *parser.ExtendsStatement:
+		return n.Token
+	case *parser.BreakStatement:
+		return n.Token
+	case *parser.ContinueStatement:
+		return n.Token
+	case *parser.NamedBlockStatement:
+		return n.Token
+	case *parser.TryStatement:
+		return n.Token
+	case *parser.ForeachExpression:
+		return n.Token
+	case *parser.WhileExpression:
+		return n.Token
+	case *parser.ForExpression:
+		return n.Token
+	case *parser.IfExpression:
+		return n.Token
+	case *parser.FunctionLiteral:
+		return n.Token
+	case *parser.PrefixExpression:
+		return n.Token
+	case *parser.Identifier:
+		return n.Token
+	case *parser.IntegerLiteral:
+		return n.Token
+	case *parser.FloatLiteral:
+		return n.Token
+	case *parser.StringLiteral:
+		return n.Token
+	case *parser.InterpolatedString:
+		return n.Token
+	case *parser.BooleanLiteral:
+		return n.Token
+	case *parser.NullLiteral:
+		return n.Token
+	case *parser.ArrayLiteral:
+		return n.Token
+	case *parser.HashLiteral:
+		return n.Token
+	}
+	return lexer.TokenNone
+}
@@ -0,0 +1,55 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, map[string]string{"a": "é"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMessage(&buf, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "Content-Length: 10\r\n\r\n{\"a\":\"é\"}") {
+		t.Fatalf("unexpected framing: %q", buf.String())
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, expected := range []string{`{"a":"é"}`, `[1]`} {
+		content, err := ReadMessage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("expected %s, got %s", expected, content)
+		}
+	}
+	if _, err := ReadMessage(r); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got: %v", err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Content-Length: x\r\n\r\n", `invalid Content-Length "x"`},
+		{"Content-Type: text\r\n\r\n", `invalid Content-Length ""`},
+		{"Content-Length: 10\r\n\r\n{}", "reading content: unexpected EOF"},
+		{"Content-Length: 2\r\n", "reading header: EOF"},
+	}
+	for _, tt := range tests {
+		_, err := ReadMessage(bufio.NewReader(strings.NewReader(tt.input)))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: expected %q, got: %v", tt.input, tt.expected, err)
+		}
+	}
+}
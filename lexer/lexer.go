@@ -2,6 +2,8 @@ package lexer
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 )
 
 type Mode int
@@ -31,6 +33,28 @@ var keywords = map[string]TokenType{
 	"null":     Null,
 }
 
+// contextualKeywords start a statement, but are not reserved: they are
+// lexed as identifiers, and only read as keywords by the parser where a
+// statement starts and the token after them cannot continue an expression.
+// Outside of that they remain usable as names, as in h.block.
+var contextualKeywords = []string{"block", "catch", "extends", "for", "throw", "try"}
+
+// Keywords returns the reserved words of the language, sorted.
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	slices.Sort(words)
+	return words
+}
+
+// ContextualKeywords returns the words that are keywords only at the
+// start of a statement, sorted.
+func ContextualKeywords() []string {
+	return slices.Clone(contextualKeywords)
+}
+
 type Lexer struct {
 	source        string
 	position      int
@@ -38,6 +62,7 @@ type Lexer struct {
 	col           int
 	mode          Mode
 	parseTemplate bool
+	keepComments  bool
 }
 
 func NewTemplate(source string) *Lexer {
@@ -62,6 +87,18 @@ func NewScript(source string) *Lexer {
 	}
 }
 
+// IsTemplate reports whether l reads a template, rather than a script.
+func (l *Lexer) IsTemplate() bool {
+	return l.parseTemplate
+}
+
+// KeepComments makes Read return comments as Comment tokens, rather than
+// skipping them. The source of a Comment token includes its delimiters,
+// but not the newline ending a single-line comment.
+func (l *Lexer) KeepComments() {
+	l.keepComments = true
+}
+
 func (l *Lexer) Read() (Token, error) {
 
 	if l.position >= len(l.source) {
@@ -153,6 +190,17 @@ func (l *Lexer) readScript() (Token, error) {
 			l.col++
 			return NewToken(RightBracket, l.source[pos:l.position], pos, line, col), nil
 		case '.':
+			if l.position+1 < len(l.source) && l.source[l.position+1] == '.' {
+				tokenType := Range
+				l.position += 2
+				l.col += 2
+				if l.position < len(l.source) && l.source[l.position] == '<' {
+					tokenType = RangeExclusive
+					l.position++
+					l.col++
+				}
+				return NewToken(tokenType, l.source[pos:l.position], pos, line, col), nil
+			}
 			l.position++
 			l.col++
 			return NewToken(Dot, l.source[pos:l.position], pos, line, col), nil
@@ -204,6 +252,9 @@ func (l *Lexer) readScript() (Token, error) {
 					l.position++
 					l.col++
 				}
+				if l.keepComments {
+					return NewToken(Comment, l.source[pos:l.position], pos, line, col), nil
+				}
 				continue
 			}
 			if l.position+1 < len(l.source) && l.source[l.position+1] == '*' {
@@ -231,6 +282,9 @@ func (l *Lexer) readScript() (Token, error) {
 				if !found {
 					return Token{}, NewTokenError("unterminated comment", l.source, commentLine, commentCol)
 				}
+				if l.keepComments {
+					return NewToken(Comment, l.source[pos:l.position], pos, line, col), nil
+				}
 				continue
 			}
 			if l.position+1 < len(l.source) && l.source[l.position+1] == '=' {
@@ -271,14 +325,25 @@ func (l *Lexer) readScript() (Token, error) {
 				l.col += 2
 				return NewToken(Or, l.source[pos:l.position], pos, line, col), nil
 			}
-			return Token{}, NewTokenError(
-				fmt.Sprintf("unexpected character '%c'", ch),
-				l.source, line, col)
+			l.position++
+			l.col++
+			return NewToken(Pipe, l.source[pos:l.position], pos, line, col), nil
 		case '?':
-			if l.position+1 < len(l.source) && l.source[l.position+1] == '?' {
-				l.position += 2
-				l.col += 2
-				return NewToken(NullCoalescing, l.source[pos:l.position], pos, line, col), nil
+			if l.position+1 < len(l.source) {
+				var tokenType TokenType
+				switch l.source[l.position+1] {
+				case '?':
+					tokenType = NullCoalescing
+				case '.':
+					tokenType = OptionalDot
+				case '[':
+					tokenType = OptionalBracket
+				}
+				if tokenType != "" {
+					l.position += 2
+					l.col += 2
+					return NewToken(tokenType, l.source[pos:l.position], pos, line, col), nil
+				}
 			}
 			return Token{}, NewTokenError(
 				fmt.Sprintf("unexpected character '%c'", ch),
@@ -322,6 +387,9 @@ func (l *Lexer) readScript() (Token, error) {
 		case '"':
 			token, _, err := l.tryString('"', String)
 			return token, err
+		case '`':
+			token, _, err := l.tryString('`', InterpolatedString)
+			return token, err
 		default:
 			if ch >= '0' && ch <= '9' {
 				token, _ := l.tryNumber()
@@ -365,6 +433,13 @@ func (l *Lexer) tryString(quote byte, tokenType TokenType) (Token, bool, error)
 	l.position++
 	l.col++
 
+	if quote == '`' {
+		if err := l.readInterpolated(line, col, nil); err != nil {
+			return TokenNone, false, err
+		}
+		return NewToken(tokenType, l.source[pos:l.position], pos, line, col), true, nil
+	}
+
 	for {
 		if l.position >= len(l.source) {
 			return TokenNone, false, NewTokenError("unterminated string literal", l.source, line, col)
@@ -396,6 +471,113 @@ func (l *Lexer) tryString(quote byte, tokenType TokenType) (Token, bool, error)
 	}
 }
 
+// readInterpolated reads the rest of an interpolated string, which starts
+// at line and col, from just after its opening backtick to just after its
+// closing one. Unlike other strings, it may span lines. If part is not nil,
+// it is called with the raw text before each ${expression} and a lexer of
+// the expression, and finally with the text after the last one and nil.
+func (l *Lexer) readInterpolated(line, col int, part func(text Token, expression *Lexer)) error {
+	start, textLine, textCol := l.position, l.line, l.col
+
+	for {
+		if l.position >= len(l.source) {
+			return NewTokenError("unterminated interpolated string", l.source, line, col)
+		}
+
+		switch {
+		case l.source[l.position] == '\\':
+			l.advance()
+			if l.position < len(l.source) {
+				l.advance()
+			}
+		case l.source[l.position] == '`':
+			if part != nil {
+				part(NewToken(Text, l.source[start:l.position], start, textLine, textCol), nil)
+			}
+			l.advance()
+			return nil
+		case strings.HasPrefix(l.source[l.position:], "${"):
+			text := NewToken(Text, l.source[start:l.position], start, textLine, textCol)
+			l.position += 2
+			l.col += 2
+			expression := *l
+			if err := l.skipInterpolation(line, col); err != nil {
+				return err
+			}
+			if part != nil {
+				// The expression ends before its closing brace.
+				expression.source = l.source[:l.position-1]
+				part(text, &expression)
+			}
+			start, textLine, textCol = l.position, l.line, l.col
+		default:
+			l.advance()
+		}
+	}
+}
+
+// skipInterpolation reads the tokens of an expression interpolated in a
+// string, up to and including the brace closing it.
+func (l *Lexer) skipInterpolation(line, col int) error {
+	depth := 0
+	for {
+		token, err := l.readScript()
+		if err != nil {
+			return err
+		}
+
+		switch token.Type {
+		case LeftBrace:
+			depth++
+		case RightBrace:
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		case EndOfFile, ScriptEnd:
+			return NewTokenError("unterminated interpolated string", l.source, line, col)
+		}
+	}
+}
+
+// advance moves past the current character, which may be a newline.
+func (l *Lexer) advance() {
+	if l.source[l.position] == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.position++
+}
+
+// Interpolation splits an InterpolatedString token read from source into
+// the raw text around its expressions, as Text tokens with their escapes
+// intact, and a lexer reading the tokens of each expression. There is one
+// more text than there are expressions, and all tokens are positioned in
+// source.
+func Interpolation(source string, token Token) ([]Token, []*Lexer, error) {
+	end := token.Position + len(token.Source)
+	if token.Type != InterpolatedString || end > len(source) || source[token.Position:end] != token.Source {
+		return nil, nil, NewTokenError("not an interpolated string", source, token.Line, token.Column)
+	}
+
+	l := &Lexer{source: source[:end], position: token.Position + 1, line: token.Line, col: token.Column + 1, mode: ModeScript}
+
+	var texts []Token
+	var expressions []*Lexer
+	err := l.readInterpolated(token.Line, token.Column, func(text Token, expression *Lexer) {
+		texts = append(texts, text)
+		if expression != nil {
+			expressions = append(expressions, expression)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return texts, expressions, nil
+}
+
 func (l *Lexer) tryNumber() (Token, bool) {
 
 	ok := false
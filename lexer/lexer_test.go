@@ -1745,7 +1745,6 @@ func TestMultipleUnexpectedCharacters(t *testing.T) {
 		{"$", "$"},
 		{"~", "~"},
 		{"&", "&"},
-		{"|", "|"},
 		{"^", "^"},
 		{"?", "?"},
 	}
@@ -2210,6 +2209,39 @@ func TestWhileBreakContinueTokens(t *testing.T) {
 	}
 }
 
+func TestTryCatchThrowTokens(t *testing.T) {
+	// try, catch and throw are contextual keywords, left to the parser.
+	script := "try { throw e; } catch (e) { }"
+
+	l := NewScript(script)
+
+	expected := []Token{
+		{Type: Identifier, Source: "try"},
+		{Type: LeftBrace, Source: "{"},
+		{Type: Identifier, Source: "throw"},
+		{Type: Identifier, Source: "e"},
+		{Type: Semicolon, Source: ";"},
+		{Type: RightBrace, Source: "}"},
+		{Type: Identifier, Source: "catch"},
+		{Type: LeftParen, Source: "("},
+		{Type: Identifier, Source: "e"},
+		{Type: RightParen, Source: ")"},
+		{Type: LeftBrace, Source: "{"},
+		{Type: RightBrace, Source: "}"},
+		{Type: EndOfFile, Source: ""},
+	}
+
+	for i, exp := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != exp.Type || tok.Source != exp.Source {
+			t.Fatalf("[%d] want %s %q, got %s %q", i, exp.Type, exp.Source, tok.Type, tok.Source)
+		}
+	}
+}
+
 func TestNullToken(t *testing.T) {
 	script := "null"
 
@@ -2237,16 +2269,43 @@ func TestSingleAmpersandError(t *testing.T) {
 	}
 }
 
-func TestSinglePipeError(t *testing.T) {
-	script := "x | y"
+func TestSinglePipe(t *testing.T) {
+	script := "x | y || z"
 
 	l := NewScript(script)
 
-	_, _ = l.Read() // skip 'x'
+	expected := []TokenType{Identifier, Pipe, Identifier, Or, Identifier, EndOfFile}
+	for i, exp := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != exp {
+			t.Fatalf("[%d] want %s, got %s %q", i, exp, tok.Type, tok.Source)
+		}
+	}
+}
 
-	_, err := l.Read()
-	if err == nil {
-		t.Fatal("expected error for single '|', got nil")
+func TestOptionalAccess(t *testing.T) {
+	script := "a?.b?[0] ?? c"
+
+	l := NewScript(script)
+
+	expected := []struct {
+		tokenType TokenType
+		source    string
+	}{
+		{Identifier, "a"}, {OptionalDot, "?."}, {Identifier, "b"}, {OptionalBracket, "?["},
+		{Integer, "0"}, {RightBracket, "]"}, {NullCoalescing, "??"}, {Identifier, "c"}, {EndOfFile, ""},
+	}
+	for i, exp := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != exp.tokenType || tok.Source != exp.source {
+			t.Fatalf("[%d] want %s %q, got %s %q", i, exp.tokenType, exp.source, tok.Type, tok.Source)
+		}
 	}
 }
 
@@ -2452,3 +2511,244 @@ func TestLexCompoundAssignmentSources(t *testing.T) {
 		})
 	}
 }
+
+func TestKeepComments(t *testing.T) {
+	script := "let x = 5; // trailing\n/* block\ncomment */ x;"
+
+	l := NewScript(script)
+	l.KeepComments()
+
+	expected := []Token{
+		{Type: Let, Source: "let", Line: 1, Column: 1},
+		{Type: Identifier, Source: "x", Line: 1, Column: 5},
+		{Type: Equal, Source: "=", Line: 1, Column: 7},
+		{Type: Integer, Source: "5", Line: 1, Column: 9},
+		{Type: Semicolon, Source: ";", Line: 1, Column: 10},
+		{Type: Comment, Source: "// trailing", Line: 1, Column: 12},
+		{Type: Comment, Source: "/* block\ncomment */", Line: 2, Column: 1},
+		{Type: Identifier, Source: "x", Line: 3, Column: 12},
+		{Type: Semicolon, Source: ";", Line: 3, Column: 13},
+		{Type: EndOfFile, Source: ""},
+	}
+
+	for i, exp := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != exp.Type || tok.Source != exp.Source {
+			t.Fatalf("[%d] want %s %q, got %s %q", i, exp.Type, exp.Source, tok.Type, tok.Source)
+		}
+		if exp.Line != 0 && (tok.Line != exp.Line || tok.Column != exp.Column) {
+			t.Fatalf("[%d] want %d:%d, got %d:%d", i, exp.Line, exp.Column, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestKeepCommentsTemplate(t *testing.T) {
+	l := NewTemplate("<p>{% /* note */ x %}</p>")
+	l.KeepComments()
+
+	expected := []TokenType{Text, ScriptStart, Comment, Identifier, ScriptEnd, Text, EndOfFile}
+	for i, exp := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != exp {
+			t.Fatalf("[%d] want %s, got %s %q", i, exp, tok.Type, tok.Source)
+		}
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	words := Keywords()
+	if len(words) != len(keywords) {
+		t.Fatalf("expected %d keywords, got %d", len(keywords), len(words))
+	}
+	for i, word := range words {
+		if i > 0 && words[i-1] >= word {
+			t.Fatalf("expected sorted keywords, got %v", words)
+		}
+		tok, err := NewScript(word).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Type == Identifier || !tok.IsKeyword() {
+			t.Fatalf("expected %q to be lexed as a keyword", word)
+		}
+	}
+}
+
+func TestContextualKeywords(t *testing.T) {
+	words := ContextualKeywords()
+	for i, word := range words {
+		if i > 0 && words[i-1] >= word {
+			t.Fatalf("expected sorted keywords, got %v", words)
+		}
+		tok, err := NewScript(word).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Type != Identifier || tok.IsKeyword() {
+			t.Fatalf("expected %q to be lexed as an identifier, got %s", word, tok.Type)
+		}
+	}
+}
+
+// --- Interpolated strings ---
+
+func TestInterpolatedString(t *testing.T) {
+	script := "let s = `a ${b + \"}\"} ${ {\"c\": `d${e}`}.c }\n\\` \\${f}`;\nlet g;"
+
+	l := NewScript(script)
+
+	expected := []Token{
+		{Type: Let, Source: "let", Line: 1, Column: 1},
+		{Type: Identifier, Source: "s", Line: 1, Column: 5},
+		{Type: Equal, Source: "=", Line: 1, Column: 7},
+		{Type: InterpolatedString, Source: "`a ${b + \"}\"} ${ {\"c\": `d${e}`}.c }\n\\` \\${f}`", Position: 8, Line: 1, Column: 9},
+		{Type: Semicolon, Source: ";", Line: 2, Column: 10},
+		{Type: Let, Source: "let", Line: 3, Column: 1},
+	}
+	for i, want := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != want.Type || tok.Source != want.Source || tok.Line != want.Line || tok.Column != want.Column {
+			t.Fatalf("[%d] want %s %q at %d:%d, got %s %q at %d:%d", i, want.Type, want.Source, want.Line, want.Column, tok.Type, tok.Source, tok.Line, tok.Column)
+		}
+		if want.Position != 0 && tok.Position != want.Position {
+			t.Fatalf("[%d] want position %d, got %d", i, want.Position, tok.Position)
+		}
+	}
+}
+
+func TestInterpolatedStringUnterminated(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		template bool
+	}{
+		{"text", "let s = `abc\n", false},
+		{"expression", "let s = `a ${b", false},
+		{"nested", "let s = `a ${ `b` ", false},
+		{"script end", "{% `a ${b %} c }` %}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewScript(tt.source)
+			if tt.template {
+				l = NewTemplate(tt.source)
+			}
+
+			var err error
+			for err == nil {
+				var tok Token
+				if tok, err = l.Read(); tok.Type == EndOfFile {
+					t.Fatal("expected error, got EOF")
+				}
+			}
+
+			var tokenErr *TokenError
+			if !errors.As(err, &tokenErr) {
+				t.Fatalf("expected TokenError, got %T", err)
+			}
+			if tokenErr.Message != "unterminated interpolated string" {
+				t.Fatalf("want 'unterminated interpolated string', got %q", tokenErr.Message)
+			}
+		})
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	source := "{% `a\n${b.c}${d(1)}\\${e}` %}"
+
+	l := NewTemplate(source)
+	var tok Token
+	for tok.Type != InterpolatedString {
+		var err error
+		if tok, err = l.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	texts, lexers, err := Interpolation(source, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTexts := []Token{
+		{Type: Text, Source: "a\n", Position: 4, Line: 1, Column: 5},
+		{Type: Text, Source: "", Position: 12, Line: 2, Column: 7},
+		{Type: Text, Source: "\\${e}", Position: 19, Line: 2, Column: 14},
+	}
+	if len(texts) != len(wantTexts) {
+		t.Fatalf("expected %d texts, got %d", len(wantTexts), len(texts))
+	}
+	for i, want := range wantTexts {
+		if texts[i] != want {
+			t.Fatalf("text %d: want %+v, got %+v", i, want, texts[i])
+		}
+	}
+
+	wantTokens := [][]Token{
+		{
+			{Type: Identifier, Source: "b", Position: 8, Line: 2, Column: 3},
+			{Type: Dot, Source: ".", Position: 9, Line: 2, Column: 4},
+			{Type: Identifier, Source: "c", Position: 10, Line: 2, Column: 5},
+			{Type: EndOfFile, Source: "", Position: 11, Line: 2, Column: 6},
+		},
+		{
+			{Type: Identifier, Source: "d", Position: 14, Line: 2, Column: 9},
+			{Type: LeftParen, Source: "(", Position: 15, Line: 2, Column: 10},
+			{Type: Integer, Source: "1", Position: 16, Line: 2, Column: 11},
+			{Type: RightParen, Source: ")", Position: 17, Line: 2, Column: 12},
+			{Type: EndOfFile, Source: "", Position: 18, Line: 2, Column: 13},
+		},
+	}
+	if len(lexers) != len(wantTokens) {
+		t.Fatalf("expected %d expressions, got %d", len(wantTokens), len(lexers))
+	}
+	for i, want := range wantTokens {
+		for j, wantTok := range want {
+			tok, err := lexers[i].Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tok != wantTok {
+				t.Fatalf("expression %d, token %d: want %+v, got %+v", i, j, wantTok, tok)
+			}
+		}
+	}
+
+	if _, _, err := Interpolation(source, Token{Type: String, Source: `"a"`}); err == nil {
+		t.Fatal("expected an error for a string token")
+	}
+}
+
+func TestRange(t *testing.T) {
+	script := "for 0..n 1.5..<x.y 1..2"
+
+	l := NewScript(script)
+
+	expected := []struct {
+		tokenType TokenType
+		source    string
+	}{
+		{Identifier, "for"}, {Integer, "0"}, {Range, ".."}, {Identifier, "n"},
+		{Float, "1.5"}, {RangeExclusive, "..<"}, {Identifier, "x"}, {Dot, "."}, {Identifier, "y"},
+		{Integer, "1"}, {Range, ".."}, {Integer, "2"}, {EndOfFile, ""},
+	}
+	for i, exp := range expected {
+		tok, err := l.Read()
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tok.Type != exp.tokenType || tok.Source != exp.source {
+			t.Fatalf("[%d] want %s %q, got %s %q", i, exp.tokenType, exp.source, tok.Type, tok.Source)
+		}
+	}
+}
@@ -56,6 +56,13 @@ const (
 	Text          TokenType = "TEXT"
 	ScriptStart   TokenType = "SCRIPT_START"
 	ScriptEnd     TokenType = "SCRIPT_END"
+	Comment       TokenType = "COMMENT"
+	InterpolatedString TokenType = "INTERPOLATED_STRING"
+	Pipe          TokenType = "PIPE"
+	OptionalDot   TokenType = "OPTIONAL_DOT"
+	OptionalBracket TokenType = "OPTIONAL_LEFT_BRACKET"
+	Range         TokenType = "RANGE"
+	RangeExclusive TokenType = "RANGE_EXCLUSIVE"
 )
 
 var TokenNone = NewToken(None, "", 0, 0, 0)
@@ -77,3 +84,9 @@ func NewToken(typ TokenType, source string, position, line, column int) Token {
 		Column:   column,
 	}
 }
+
+// IsKeyword reports whether t is a reserved word.
+func (t Token) IsKeyword() bool {
+	tokenType, ok := keywords[t.Source]
+	return ok && tokenType == t.Type
+}
@@ -0,0 +1,277 @@
+package lsp
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+	"github.com/ironfang-ltd/go-script/resolver"
+)
+
+// document is an open text document and what is known about its text.
+type document struct {
+	uri      string
+	version  int
+	text     string
+	template bool
+
+	// lines holds the byte offset at which each line starts.
+	lines []int
+	// tokens are the tokens of text, including comments, up to the first
+	// lexer error.
+	tokens []lexer.Token
+	// err is the error parsing text, if any.
+	err error
+	// analysis is that of text, or nil if it does not parse.
+	analysis *analysis
+	// lastGood is the analysis of the last text that parsed, which
+	// completion falls back on while the document is being edited.
+	lastGood *analysis
+}
+
+// analysis is a parsed and resolved program.
+type analysis struct {
+	program *parser.Program
+	res     *resolver.Resolution
+}
+
+// isScript reports whether a document holds a script rather than a
+// template, going by its language or the extension of its file.
+func isScript(languageID, uri string) bool {
+	return languageID == "goscript" || strings.HasSuffix(uri, ".gs")
+}
+
+func newDocument(uri string, version int, text string, template bool) *document {
+	d := &document{uri: uri, template: template}
+	d.update(version, text)
+	return d
+}
+
+// update replaces the text of d and analyses it.
+func (d *document) update(version int, text string) {
+	d.version = version
+	d.text = text
+
+	d.lines = append(d.lines[:0], 0)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	l := d.lexer()
+	l.KeepComments()
+	d.tokens = d.tokens[:0]
+	for {
+		tok, err := l.Read()
+		if err != nil || tok.Type == lexer.EndOfFile {
+			break
+		}
+		d.tokens = append(d.tokens, tok)
+	}
+
+	d.analysis = nil
+	program, err := parser.New(d.lexer()).Parse()
+	d.err = err
+	if err != nil {
+		return
+	}
+	d.analysis = &analysis{program: program, res: resolver.Resolve(program, resolver.Globals{})}
+	d.lastGood = d.analysis
+}
+
+func (d *document) lexer() *lexer.Lexer {
+	if d.template {
+		return lexer.NewTemplate(d.text)
+	}
+	return lexer.NewScript(d.text)
+}
+
+// diagnostics reports the syntax errors of d.
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	if d.err == nil {
+		return diagnostics
+	}
+
+	errs := []error{d.err}
+	if joined, ok := d.err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, err := range errs {
+		var parseErr *parser.ParseError
+		var tokenErr *lexer.TokenError
+		diagnostic := Diagnostic{Severity: SeverityError, Source: "goscript", Message: err.Error()}
+		switch {
+		case errors.As(err, &parseErr):
+			diagnostic.Message = parseErr.Message
+			diagnostic.Range = d.tokenRange(parseErr.Token)
+		case errors.As(err, &tokenErr):
+			diagnostic.Message = tokenErr.Message
+			start := d.position(d.lineOffset(tokenErr.Line, tokenErr.Column))
+			diagnostic.Range = Range{Start: start, End: start}
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// lineOffset returns the byte offset of a one-based line and byte column,
+// as tokens and errors locate them.
+func (d *document) lineOffset(line, column int) int {
+	if line < 1 {
+		return 0
+	}
+	if line > len(d.lines) {
+		return len(d.text)
+	}
+	return min(d.lines[line-1]+max(column-1, 0), len(d.text))
+}
+
+// offset returns the byte offset of pos, clamped to the line it is on.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+
+	offset := d.lines[pos.Line]
+	for units := 0; offset < len(d.text) && d.text[offset] != '\n' && d.text[offset] != '\r'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		n := utf16.RuneLen(r)
+		if n < 0 {
+			n = 1
+		}
+		if units+n > pos.Character {
+			break
+		}
+		units += n
+		offset += size
+	}
+	return offset
+}
+
+// position returns the position of a byte offset.
+func (d *document) position(offset int) Position {
+	offset = min(max(offset, 0), len(d.text))
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+
+	character := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		if n := utf16.RuneLen(r); n > 0 {
+			character += n
+		} else {
+			character++
+		}
+	}
+	return Position{Line: line, Character: character}
+}
+
+func (d *document) tokenRange(tok lexer.Token) Range {
+	return d.span(tok.Position, tok.Position+len(tok.Source))
+}
+
+// span returns the range between two byte offsets.
+func (d *document) span(start, end int) Range {
+	return Range{Start: d.position(start), End: d.position(end)}
+}
+
+// tokenAt returns the index of the token that offset is in or just after,
+// or -1 if there is none.
+func (d *document) tokenAt(offset int) int {
+	i := sort.Search(len(d.tokens), func(i int) bool { return d.tokens[i].Position >= offset })
+	if i < len(d.tokens) && d.tokens[i].Position == offset {
+		return i
+	}
+	if i > 0 {
+		if tok := d.tokens[i-1]; offset <= tok.Position+len(tok.Source) {
+			return i - 1
+		}
+	}
+	return -1
+}
+
+// identifierAt returns the token of the identifier at offset. Property
+// names, which follow a dot, are not variables and are not returned.
+func (d *document) identifierAt(offset int) (lexer.Token, bool) {
+	// At the boundary of two tokens, such as in "f(", prefer the
+	// identifier ending there.
+	i := d.tokenAt(offset)
+	if i < 0 {
+		return lexer.TokenNone, false
+	}
+	if d.tokens[i].Type != lexer.Identifier && i > 0 && d.tokens[i].Position == offset {
+		i--
+	}
+
+	tok := d.tokens[i]
+	if tok.Type != lexer.Identifier || offset > tok.Position+len(tok.Source) {
+		return lexer.TokenNone, false
+	}
+	if i > 0 && isDot(d.tokens[i-1]) {
+		return lexer.TokenNone, false
+	}
+	return tok, true
+}
+
+// identifier returns the identifier node of tok in the analysis of d.
+func (d *document) identifier(tok lexer.Token) *parser.Identifier {
+	if d.analysis == nil {
+		return nil
+	}
+
+	var found *parser.Identifier
+	parser.Inspect(d.analysis.program, func(n any) bool {
+		if id, ok := n.(*parser.Identifier); ok && id.Token.Position == tok.Position {
+			found = id
+		}
+		return found == nil
+	})
+	return found
+}
+
+// blockEnd returns the byte offset just past the end of b.
+func (d *document) blockEnd(b *parser.BlockStatement) int {
+	if b.End.Type == lexer.EndOfFile || b.End.Type == "" {
+		return len(d.text)
+	}
+	return b.End.Position + len(b.End.Source)
+}
+
+// statementEnd returns the byte offset just past the statement starting
+// with the token at start: its semicolon, or the last token before the
+// end of its block or tag.
+func (d *document) statementEnd(start int) int {
+	i := d.tokenAt(start)
+	if i < 0 {
+		return start
+	}
+
+	end := start
+	depth := 0
+	for ; i < len(d.tokens); i++ {
+		tok := d.tokens[i]
+		switch tok.Type {
+		case lexer.LeftParen, lexer.LeftBracket, lexer.LeftBrace:
+			depth++
+		case lexer.RightParen, lexer.RightBracket, lexer.RightBrace:
+			depth--
+		case lexer.Comment:
+			continue
+		}
+		if depth < 0 || tok.Type == lexer.ScriptEnd || tok.Type == lexer.Text {
+			break
+		}
+		end = tok.Position + len(tok.Source)
+		if depth == 0 && tok.Type == lexer.Semicolon {
+			break
+		}
+	}
+	return end
+}
@@ -0,0 +1,312 @@
+package lsp
+
+import (
+	"strings"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+	"github.com/ironfang-ltd/go-script/resolver"
+)
+
+// function returns the documentation of the built-in or registered
+// function called name.
+func (s *Server) function(name string) (evaluator.FunctionDoc, bool) {
+	for _, doc := range s.evaluator.Functions() {
+		if doc.Name == name {
+			return doc, true
+		}
+	}
+	return evaluator.FunctionDoc{}, false
+}
+
+// signature returns how a function is called, for functions registered
+// without one.
+func signature(doc evaluator.FunctionDoc) string {
+	if doc.Signature != "" {
+		return doc.Signature
+	}
+	return doc.Name + "(...)"
+}
+
+// hover describes the variable or function at a position: the declaration
+// of a variable the document declares, or the signature and description
+// of a function.
+func (s *Server) hover(params TextDocumentPositionParams) *Hover {
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	tok, ok := d.identifierAt(d.offset(params.Position))
+	if !ok {
+		return nil
+	}
+
+	var value string
+	if id := d.identifier(tok); id != nil {
+		if decl := d.analysis.res.Declaration(id); decl != nil {
+			value = codeBlock(describe(d.analysis.program, decl))
+		}
+	}
+	if value == "" {
+		doc, ok := s.function(tok.Source)
+		if !ok {
+			return nil
+		}
+		value = codeBlock(signature(doc))
+		if doc.Description != "" {
+			value += "\n\n" + doc.Description
+		}
+	}
+
+	r := d.tokenRange(tok)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}, Range: &r}
+}
+
+func codeBlock(code string) string {
+	return "```goscript\n" + code + "\n```"
+}
+
+// describe returns how decl declares its variable, such as "let total" or
+// "fn add(a, b)".
+func describe(program *parser.Program, decl *parser.Identifier) string {
+	description := decl.Value
+	parser.Inspect(program, func(n any) bool {
+		switch n := n.(type) {
+		case *parser.LetStatement:
+			if n.Name == decl {
+				description = "let " + decl.Value
+			}
+		case *parser.FunctionLiteral:
+			if n.Identifier == decl {
+				description = "fn " + decl.Value + parameters(n)
+			}
+			for _, param := range n.Parameters {
+				if param == decl {
+					description = "(parameter) " + decl.Value
+				}
+			}
+		case *parser.ForeachExpression:
+			if n.Variable == decl || n.Index == decl {
+				description = "(loop variable) " + decl.Value
+			}
+		case *parser.TryStatement:
+			if n.Parameter == decl {
+				description = "(catch parameter) " + decl.Value
+			}
+		}
+		return true
+	})
+	return description
+}
+
+// parameters returns the parameter list of fl, such as "(a, b)".
+func parameters(fl *parser.FunctionLiteral) string {
+	names := make([]string, len(fl.Parameters))
+	for i, param := range fl.Parameters {
+		names[i] = param.Value
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// definition locates the declaration of the variable or function at a
+// position. Built-in functions and variables set by the host have none.
+func (s *Server) definition(params TextDocumentPositionParams) *Location {
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	tok, ok := d.identifierAt(d.offset(params.Position))
+	if !ok {
+		return nil
+	}
+	id := d.identifier(tok)
+	if id == nil {
+		return nil
+	}
+	decl := d.analysis.res.Declaration(id)
+	if decl == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: d.tokenRange(decl.Token)}
+}
+
+// completion lists the variables in scope at a position, then the
+// functions and keywords. Nothing is completed after a dot, since the
+// properties of a value are only known at run time, nor in template text,
+// strings and comments.
+func (s *Server) completion(params TextDocumentPositionParams) CompletionList {
+	list := CompletionList{Items: []CompletionItem{}}
+
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return list
+	}
+	offset := d.offset(params.Position)
+	if !d.completes(offset) {
+		return list
+	}
+
+	seen := make(map[string]bool)
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			list.Items = append(list.Items, item)
+		}
+	}
+
+	if a := d.analysis; a != nil || d.lastGood != nil {
+		if a == nil {
+			a = d.lastGood
+		}
+		for _, name := range a.variables(offset, len(d.text)) {
+			add(CompletionItem{Label: name, Kind: CompletionVariable})
+		}
+	}
+	for _, doc := range s.evaluator.Functions() {
+		add(CompletionItem{Label: doc.Name, Kind: CompletionFunction, Detail: signature(doc), Documentation: doc.Description})
+	}
+	for _, keyword := range lexer.Keywords() {
+		add(CompletionItem{Label: keyword, Kind: CompletionKeyword})
+	}
+	for _, keyword := range lexer.ContextualKeywords() {
+		add(CompletionItem{Label: keyword, Kind: CompletionKeyword})
+	}
+	return list
+}
+
+// completes reports whether names can be completed at offset.
+func (d *document) completes(offset int) bool {
+	i := d.tokenAt(offset)
+	if i < 0 {
+		// Between tokens is whitespace in code, since template text is a
+		// token of its own.
+		return len(d.tokens) > 0 || !d.template
+	}
+
+	tok := d.tokens[i]
+	end := tok.Position + len(tok.Source)
+	switch tok.Type {
+	case lexer.Text:
+		return false
+	case lexer.ScriptStart:
+		return offset == end
+	case lexer.ScriptEnd, lexer.Dot, lexer.OptionalDot:
+		return offset == tok.Position
+	case lexer.String, lexer.InterpolatedString:
+		return offset == tok.Position || offset == end
+	case lexer.Comment:
+		// A single-line comment runs to the end of its line.
+		return offset == tok.Position || offset == end && !strings.HasPrefix(tok.Source, "//")
+	case lexer.Identifier:
+		// A property name follows a dot.
+		return i == 0 || !isDot(d.tokens[i-1])
+	}
+	return true
+}
+
+// isDot reports whether tok is the . or ?. before a property name.
+func isDot(tok lexer.Token) bool {
+	return tok.Type == lexer.Dot || tok.Type == lexer.OptionalDot
+}
+
+// variables lists the variables declared at the top level and in the
+// functions, loops and catch blocks enclosing offset, innermost first. end
+// is the length of the text.
+func (a *analysis) variables(offset, end int) []string {
+	var scopes []*resolver.Scope
+	inside := func(b *parser.BlockStatement) bool {
+		blockEnd := end
+		if b.End.Type != lexer.EndOfFile && b.End.Type != "" {
+			blockEnd = b.End.Position
+		}
+		return b.Token.Position < offset && offset <= blockEnd
+	}
+
+	parser.Inspect(a.program, func(n any) bool {
+		switch n := n.(type) {
+		case *parser.FunctionLiteral:
+			if !inside(n.Body) {
+				return false
+			}
+			scopes = append(scopes, a.res.Function(n))
+		case *parser.ForeachExpression:
+			if inside(n.Body) {
+				scopes = append(scopes, a.res.Loop(n))
+			}
+		case *parser.ForExpression:
+			if inside(n.Body) {
+				scopes = append(scopes, a.res.For(n))
+			}
+		case *parser.TryStatement:
+			if inside(n.Catch) {
+				scopes = append(scopes, a.res.Catch(n))
+			}
+		}
+		return true
+	})
+
+	var names []string
+	for i := len(scopes) - 1; i >= 0; i-- {
+		names = append(names, scopes[i].Names...)
+	}
+	return append(names, a.res.TopLevel().Names...)
+}
+
+// symbols lists the let statements, named functions and named blocks of a
+// document, with those declared in a function or block as its children.
+func (s *Server) symbols(params DocumentSymbolParams) []DocumentSymbol {
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok || d.analysis == nil {
+		return []DocumentSymbol{}
+	}
+	return d.symbols(d.analysis.program)
+}
+
+func (d *document) symbols(node any) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	parser.Inspect(node, func(n any) bool {
+		switch n := n.(type) {
+		case *parser.LetStatement:
+			symbol := DocumentSymbol{
+				Name:           n.Name.Value,
+				Kind:           SymbolVariable,
+				Range:          d.span(n.Token.Position, d.statementEnd(n.Token.Position)),
+				SelectionRange: d.tokenRange(n.Name.Token),
+			}
+			if fl, ok := n.Value.(*parser.FunctionLiteral); ok {
+				symbol.Detail = "fn" + parameters(fl)
+				symbol.Children = d.symbols(fl.Body)
+			}
+			symbols = append(symbols, symbol)
+			return false
+
+		case *parser.FunctionLiteral:
+			// Anonymous functions are not listed, nor anything in them.
+			if n.Identifier != nil {
+				symbols = append(symbols, DocumentSymbol{
+					Name:           n.Identifier.Value,
+					Detail:         "fn" + parameters(n),
+					Kind:           SymbolFunction,
+					Range:          d.span(n.Token.Position, d.blockEnd(n.Body)),
+					SelectionRange: d.tokenRange(n.Identifier.Token),
+					Children:       d.symbols(n.Body),
+				})
+			}
+			return false
+
+		case *parser.NamedBlockStatement:
+			symbols = append(symbols, DocumentSymbol{
+				Name:           n.Name.Value,
+				Detail:         "block",
+				Kind:           SymbolNamespace,
+				Range:          d.span(n.Token.Position, d.blockEnd(n.Body)),
+				SelectionRange: d.tokenRange(n.Name.Token),
+				Children:       d.symbols(n.Body),
+			})
+			return false
+		}
+		return true
+	})
+	return symbols
+}
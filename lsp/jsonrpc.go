@@ -0,0 +1,75 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ironfang-ltd/go-script/internal/wire"
+)
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	// codeServerNotInitialized is returned for requests before initialize.
+	codeServerNotInitialized = -32002
+)
+
+// message is a JSON-RPC request, response or notification. Notifications
+// have no ID.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *responseError  `json:"error,omitempty"`
+}
+
+// response is the reply to a request. Result is always present, as null
+// when there is nothing to return, unless the request failed.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *responseError  `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// readMessage reads the content of a message. It returns io.EOF at the end
+// of the input.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	content, err := wire.ReadMessage(r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("lsp: %w", err)
+	}
+	return content, err
+}
+
+func writeMessage(w io.Writer, v any) error {
+	return wire.WriteMessage(w, v)
+}
@@ -0,0 +1,222 @@
+// Package lsp implements a Language Server Protocol server for scripts and
+// templates, so that editors can check them as they are typed.
+//
+// The server reports syntax errors as diagnostics, shows the signature and
+// description of built-in and registered functions on hover, finds the
+// declarations of variables and functions, completes the variables in
+// scope, functions and keywords, and lists the let statements, named
+// functions and named blocks of a document as symbols. It communicates
+// with JSON-RPC over a pair of streams, usually standard input and output:
+//
+//	err := lsp.NewServer(evaluator.New()).Serve(os.Stdin, os.Stdout)
+//
+// Documents with the language ID "goscript", or a URI ending in ".gs", are
+// scripts. Any other document is a template.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+)
+
+// ErrNoShutdown is returned by Serve when the client asks the server to exit
+// without first shutting it down.
+var ErrNoShutdown = errors.New("lsp: exit before shutdown")
+
+// Server is a language server. It takes its functions from an evaluator,
+// so that registered functions are documented and completed alongside the
+// built-in ones.
+type Server struct {
+	evaluator   *evaluator.Evaluator
+	documents   map[string]*document
+	out         io.Writer
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer creates a server for the functions of e.
+func NewServer(e *evaluator.Evaluator) *Server {
+	return &Server{
+		evaluator: e,
+		documents: make(map[string]*document),
+	}
+}
+
+// Serve reads messages from r and writes responses and notifications to w,
+// one message at a time, until the input ends or the client sends exit.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.out = w
+	in := bufio.NewReader(r)
+
+	for {
+		content, err := readMessage(in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch {
+		case msg.Method == "exit":
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		case msg.Method == "":
+			// A response to a request the server never sends.
+		case msg.ID == nil:
+			if err := s.notify(&msg); err != nil {
+				return err
+			}
+		default:
+			result, err := s.handle(&msg)
+			if err := s.reply(msg.ID, result, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reply writes the response to the request with id.
+func (s *Server) reply(id json.RawMessage, result any, err error) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if err != nil {
+		var rpcErr *responseError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rpcErr})
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+// handle answers a request.
+func (s *Server) handle(msg *message) (any, error) {
+	if !s.initialized && msg.Method != "initialize" {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       SyncFull,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				CompletionProvider:     CompletionOptions{},
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "goscript"},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params), nil
+
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params), nil
+
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params), nil
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.symbols(params), nil
+	}
+
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// notify handles a notification. Notifications the server does not know
+// are ignored, as are those with invalid parameters, since there is no way
+// to reply to them.
+func (s *Server) notify(msg *message) error {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		item := params.TextDocument
+		d := newDocument(item.URI, item.Version, item.Text, !isScript(item.LanguageID, item.URI))
+		s.documents[item.URI] = d
+		return s.publish(d)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		d, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil
+		}
+		// With full synchronization, the last change holds the whole text.
+		d.update(params.TextDocument.Version, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		return s.publish(d)
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		uri := params.TextDocument.URI
+		if _, ok := s.documents[uri]; !ok {
+			return nil
+		}
+		delete(s.documents, uri)
+		// Clear the diagnostics of the closed document.
+		return writeMessage(s.out, notification{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params:  PublishDiagnosticsParams{URI: uri, Diagnostics: []Diagnostic{}},
+		})
+	}
+	return nil
+}
+
+// publish sends the diagnostics of d to the client.
+func (s *Server) publish(d *document) error {
+	return writeMessage(s.out, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: d.uri, Version: d.version, Diagnostics: d.diagnostics()},
+	})
+}
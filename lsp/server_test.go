@@ -0,0 +1,585 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ironfang-ltd/go-script/evaluator"
+)
+
+// client talks to a server running in the test, over a pair of pipes.
+type client struct {
+	t      *testing.T
+	w      *io.PipeWriter
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+
+	e := evaluator.New()
+	e.RegisterFunctionWithDoc(evaluator.FunctionDoc{
+		Name:        "formatPrice",
+		Signature:   "formatPrice(amount, currency?)",
+		Description: "Formats an amount of money.",
+	}, func(ctx *evaluator.ExecutionContext, scope *evaluator.Scope, args ...evaluator.Object) (evaluator.Object, error) {
+		return args[0], nil
+	})
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, w: clientOut, r: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(e).Serve(serverIn, serverOut)
+		serverOut.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() {
+		clientOut.Close()
+		clientIn.Close()
+	})
+
+	c.call("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	c.notify("initialized", map[string]any{})
+	return c
+}
+
+func (c *client) write(v any) {
+	c.t.Helper()
+	if err := writeMessage(c.w, v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+	content, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var msg message
+	if err := json.Unmarshal(content, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// call sends a request and decodes its result into result, returning the
+// error the server responds with, if any.
+func (c *client) call(method string, params, result any) *responseError {
+	c.t.Helper()
+	c.nextID++
+	id, _ := json.Marshal(c.nextID)
+	c.write(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+
+	msg := c.read()
+	if msg.Method != "" {
+		c.t.Fatalf("unexpected %s while waiting for a response", msg.Method)
+	}
+	if string(msg.ID) != string(id) {
+		c.t.Fatalf("expected a response to request %s, got one to %s", id, msg.ID)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return nil
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	c.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// diagnostics returns the next diagnostics the server publishes.
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %q", msg.Method)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params
+}
+
+// open opens a document and returns the diagnostics published for it.
+func (c *client) open(uri, languageID, text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: languageID, Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func (c *client) change(uri string, version int, text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: version},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+	})
+	return c.diagnostics()
+}
+
+// fixture opens a file from testdata, returning its URI and text.
+func (c *client) fixture(name string) (string, string) {
+	c.t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	uri := "file:///testdata/" + name
+	if d := c.open(uri, "", string(data)); len(d.Diagnostics) != 0 {
+		c.t.Fatalf("unexpected diagnostics for %s: %v", name, d.Diagnostics)
+	}
+	return uri, string(data)
+}
+
+// at returns the position of the nth occurrence of marker in text, moved
+// on by offset bytes. The texts are ASCII, so bytes are UTF-16 units.
+func at(t *testing.T, text, marker string, n, offset int) Position {
+	t.Helper()
+	index := -1
+	for i := 0; i < n; i++ {
+		next := strings.Index(text[index+1:], marker)
+		if next < 0 {
+			t.Fatalf("%q occurs fewer than %d times", marker, n)
+		}
+		index += next + 1
+	}
+	before := text[:index+offset]
+	line := strings.Count(before, "\n")
+	return Position{Line: line, Character: len(before) - strings.LastIndex(before, "\n") - 1}
+}
+
+func positionParams(uri string, pos Position) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: pos}
+}
+
+func TestInitialize(t *testing.T) {
+	c := newClient(t)
+
+	var result InitializeResult
+	if err := c.call("initialize", map[string]any{}, &result); err != nil {
+		t.Fatal(err)
+	}
+	caps := result.Capabilities
+	if caps.TextDocumentSync != SyncFull || !caps.HoverProvider || !caps.DefinitionProvider || !caps.DocumentSymbolProvider {
+		t.Fatalf("unexpected capabilities: %+v", caps)
+	}
+	if result.ServerInfo.Name != "goscript" {
+		t.Fatalf("unexpected server info: %+v", result.ServerInfo)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	c := newClient(t)
+
+	if err := c.call("textDocument/formatting", map[string]any{}, nil); err == nil || err.Code != codeMethodNotFound {
+		t.Fatalf("expected method not found, got %v", err)
+	}
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.call("textDocument/hover", positionParams("file:///a.gs", Position{}), nil); err == nil || err.Code != codeInvalidRequest {
+		t.Fatalf("expected requests after shutdown to fail, got %v", err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatalf("expected a clean exit, got %v", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newClient(t)
+	c.notify("exit", nil)
+	if err := <-c.done; !errors.Is(err, ErrNoShutdown) {
+		t.Fatalf("expected ErrNoShutdown, got %v", err)
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	defer clientIn.Close()
+	go func() {
+		_ = NewServer(evaluator.New()).Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+	c := &client{t: t, w: clientOut, r: bufio.NewReader(clientIn)}
+	defer clientOut.Close()
+
+	if err := c.call("textDocument/hover", positionParams("file:///a.gs", Position{}), nil); err == nil || err.Code != codeServerNotInitialized {
+		t.Fatalf("expected server not initialized, got %v", err)
+	}
+}
+
+func TestInvalidMessage(t *testing.T) {
+	c := newClient(t)
+
+	if _, err := io.WriteString(c.w, "Content-Length: 5\r\n\r\n{oops"); err != nil {
+		t.Fatal(err)
+	}
+	msg := c.read()
+	if msg.Error == nil || msg.Error.Code != codeParseError || string(msg.ID) != "null" {
+		t.Fatalf("expected a parse error, got %+v", msg)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	uri := "file:///scripts/check.gs"
+
+	d := c.open(uri, "goscript", "let a = 1;\nlet = 2;")
+	if d.URI != uri || d.Version != 1 || len(d.Diagnostics) == 0 {
+		t.Fatalf("expected diagnostics, got %+v", d)
+	}
+	diagnostic := d.Diagnostics[0]
+	if diagnostic.Severity != SeverityError || diagnostic.Source != "goscript" || strings.Contains(diagnostic.Message, "\n") {
+		t.Fatalf("unexpected diagnostic: %+v", diagnostic)
+	}
+	if diagnostic.Range.Start != (Position{Line: 1, Character: 4}) || diagnostic.Range.End != (Position{Line: 1, Character: 5}) {
+		t.Fatalf("expected the diagnostic at the =, got %+v", diagnostic.Range)
+	}
+
+	d = c.change(uri, 2, "let a = 1;\nlet s = \"open;")
+	if len(d.Diagnostics) != 1 || d.Diagnostics[0].Message != "unterminated string literal" {
+		t.Fatalf("expected a lexer error, got %+v", d.Diagnostics)
+	}
+	if d.Diagnostics[0].Range.Start != (Position{Line: 1, Character: 8}) {
+		t.Fatalf("expected the diagnostic at the quote, got %+v", d.Diagnostics[0].Range)
+	}
+
+	d = c.change(uri, 3, "let a = 1;")
+	if d.Version != 3 || len(d.Diagnostics) != 0 {
+		t.Fatalf("expected no diagnostics, got %+v", d)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if d := c.diagnostics(); d.URI != uri || d.Diagnostics == nil || len(d.Diagnostics) != 0 {
+		t.Fatalf("expected closing to clear the diagnostics, got %+v", d)
+	}
+}
+
+func TestDiagnosticsTemplate(t *testing.T) {
+	c := newClient(t)
+
+	// Documents other than scripts are templates.
+	d := c.open("file:///page.html", "html", "<p>{% user.name %}</p>\n{% if (a { %}")
+	if len(d.Diagnostics) == 0 || d.Diagnostics[0].Range.Start != (Position{Line: 1, Character: 9}) {
+		t.Fatalf("expected a diagnostic at the brace on line 2, got %+v", d.Diagnostics)
+	}
+	d = c.open("file:///page.gs", "", "<p>{% user.name %}</p>")
+	if len(d.Diagnostics) == 0 {
+		t.Fatal("expected a .gs file to be checked as a script")
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	script, scriptText := c.fixture("basket.gs")
+	page, pageText := c.fixture("products.html")
+
+	tests := []struct {
+		name     string
+		uri      string
+		pos      Position
+		expected []string
+	}{
+		{"built-in", script, at(t, scriptText, "toUpper", 1, 2), []string{"```goscript\ntoUpper(str)\n```", "Converts a string to uppercase."}},
+		{"end of built-in", script, at(t, scriptText, "toUpper", 1, 7), []string{"toUpper(str)"}},
+		{"registered", page, at(t, pageText, "formatPrice", 1, 0), []string{"formatPrice(amount, currency?)", "Formats an amount of money."}},
+		{"function", script, at(t, scriptText, "total(basket)", 1, 1), []string{"```goscript\nfn total(items)\n```"}},
+		{"let", script, at(t, scriptText, "taxRate)", 1, 0), []string{"let taxRate"}},
+		{"parameter", script, at(t, scriptText, "items as", 1, 0), []string{"(parameter) items"}},
+		{"loop variable", page, at(t, pageText, "product.name", 1, 3), []string{"(loop variable) product"}},
+		{"block variable", page, at(t, pageText, "year %}", 1, 0), []string{"let year"}},
+		{"property", script, at(t, scriptText, "price;", 1, 0), nil},
+		{"host variable", page, at(t, pageText, "products", 1, 0), nil},
+		{"keyword", script, at(t, scriptText, "foreach", 1, 0), nil},
+		{"template text", page, at(t, pageText, "<li>", 1, 1), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hover *Hover
+			if err := c.call("textDocument/hover", positionParams(tt.uri, tt.pos), &hover); err != nil {
+				t.Fatal(err)
+			}
+			if tt.expected == nil {
+				if hover != nil {
+					t.Fatalf("expected no hover, got %+v", hover)
+				}
+				return
+			}
+			if hover == nil {
+				t.Fatal("expected a hover")
+			}
+			if hover.Contents.Kind != "markdown" {
+				t.Fatalf("expected markdown, got %q", hover.Contents.Kind)
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(hover.Contents.Value, s) {
+					t.Fatalf("expected %q in %q", s, hover.Contents.Value)
+				}
+			}
+			if r := hover.Range; r == nil || r.Start.Line != tt.pos.Line || r.Start.Character > tt.pos.Character || r.End.Character < tt.pos.Character {
+				t.Fatalf("expected the range of the identifier at %+v, got %+v", tt.pos, r)
+			}
+		})
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	script, scriptText := c.fixture("basket.gs")
+	page, pageText := c.fixture("products.html")
+
+	tests := []struct {
+		name     string
+		uri      string
+		pos      Position
+		expected *Position
+	}{
+		{"function", script, at(t, scriptText, "total(basket)", 1, 0), ptr(at(t, scriptText, "total(items)", 1, 0))},
+		{"argument", script, at(t, scriptText, "basket))", 1, 3), ptr(at(t, scriptText, "basket =", 1, 0))},
+		{"global in function", script, at(t, scriptText, "taxRate)", 1, 0), ptr(at(t, scriptText, "taxRate =", 1, 0))},
+		{"local", script, at(t, scriptText, "sum +=", 1, 0), ptr(at(t, scriptText, "sum = 0", 1, 0))},
+		{"parameter", script, at(t, scriptText, "items as", 1, 0), ptr(at(t, scriptText, "items)", 1, 0))},
+		{"loop variable", script, at(t, scriptText, "item.price", 1, 0), ptr(at(t, scriptText, "item)", 1, 0))},
+		{"declaration", script, at(t, scriptText, "sum = 0", 1, 0), ptr(at(t, scriptText, "sum = 0", 1, 0))},
+		{"template loop variable", page, at(t, pageText, "product.price", 1, 0), ptr(at(t, pageText, "product)", 1, 0))},
+		{"template block", page, at(t, pageText, "year %}", 1, 0), ptr(at(t, pageText, "year =", 1, 0))},
+		{"built-in", script, at(t, scriptText, "log", 1, 0), nil},
+		{"host variable", page, at(t, pageText, "products", 1, 0), nil},
+		{"property", script, at(t, scriptText, "price;", 1, 0), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var location *Location
+			if err := c.call("textDocument/definition", positionParams(tt.uri, tt.pos), &location); err != nil {
+				t.Fatal(err)
+			}
+			if tt.expected == nil {
+				if location != nil {
+					t.Fatalf("expected no definition, got %+v", location)
+				}
+				return
+			}
+			if location == nil || location.URI != tt.uri || location.Range.Start != *tt.expected {
+				t.Fatalf("expected the definition at %+v, got %+v", *tt.expected, location)
+			}
+		})
+	}
+}
+
+func ptr(p Position) *Position {
+	return &p
+}
+
+// labels returns the labels of the completion items of a kind.
+func labels(list CompletionList, kind int) []string {
+	var names []string
+	for _, item := range list.Items {
+		if item.Kind == kind {
+			names = append(names, item.Label)
+		}
+	}
+	return names
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	script, scriptText := c.fixture("basket.gs")
+	page, pageText := c.fixture("products.html")
+
+	complete := func(uri string, pos Position) CompletionList {
+		t.Helper()
+		var list CompletionList
+		if err := c.call("textDocument/completion", positionParams(uri, pos), &list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	// In the loop, the loop variable comes first, then the locals of the
+	// function, then the top-level variables.
+	list := complete(script, at(t, scriptText, "sum +=", 1, 0))
+	if got := labels(list, CompletionVariable); !slices.Equal(got, []string{"item", "items", "sum", "taxRate", "total", "basket"}) {
+		t.Fatalf("unexpected variables in the loop: %v", got)
+	}
+	functions := labels(list, CompletionFunction)
+	if !slices.Contains(functions, "toUpper") || !slices.Contains(functions, "formatPrice") {
+		t.Fatalf("expected built-in and registered functions, got %v", functions)
+	}
+	if keywords := labels(list, CompletionKeyword); !slices.Contains(keywords, "foreach") {
+		t.Fatalf("expected keywords, got %v", keywords)
+	}
+	for _, item := range list.Items {
+		if item.Label == "formatPrice" && (item.Detail != "formatPrice(amount, currency?)" || item.Documentation != "Formats an amount of money.") {
+			t.Fatalf("unexpected item for formatPrice: %+v", item)
+		}
+	}
+
+	// At the top level, the variables of the function are out of scope.
+	if got := labels(complete(script, at(t, scriptText, "log(", 1, 0)), CompletionVariable); !slices.Equal(got, []string{"taxRate", "total", "basket"}) {
+		t.Fatalf("unexpected top-level variables: %v", got)
+	}
+
+	if got := labels(complete(page, at(t, pageText, "product.name", 1, 3)), CompletionVariable); !slices.Equal(got, []string{"product", "year"}) {
+		t.Fatalf("unexpected variables in the template loop: %v", got)
+	}
+
+	empty := []struct {
+		name string
+		uri  string
+		pos  Position
+	}{
+		{"property", script, at(t, scriptText, "price;", 1, 2)},
+		{"after dot", script, at(t, scriptText, ".price;", 1, 1)},
+		{"string", script, at(t, scriptText, "total: ", 1, 2)},
+		{"comment", script, at(t, scriptText, "basket of", 1, 0)},
+		{"end of comment", script, at(t, scriptText, "items.\n", 1, 6)},
+		{"template text", page, at(t, pageText, "<li>", 1, 2)},
+		{"after tag", page, at(t, pageText, "</li>", 1, 0)},
+	}
+	for _, tt := range empty {
+		if list := complete(tt.uri, tt.pos); len(list.Items) != 0 {
+			t.Fatalf("%s: expected no completions, got %d", tt.name, len(list.Items))
+		}
+	}
+
+	// While the document does not parse, the variables of the last
+	// version that did are completed.
+	edited := strings.Replace(scriptText, "log(", "let tax = taxRate +;\nlog(", 1)
+	if d := c.change(script, 2, edited); len(d.Diagnostics) == 0 {
+		t.Fatal("expected the edited script not to parse")
+	}
+	if got := labels(complete(script, at(t, edited, "taxRate +", 1, 0)), CompletionVariable); !slices.Contains(got, "basket") {
+		t.Fatalf("expected the variables of the last good version, got %v", got)
+	}
+
+	// A property name also follows a null-safe ?.
+	optionalText := "let user = {};\nlog(user?.name);"
+	optional := "file:///optional.gs"
+	c.open(optional, "", optionalText)
+	for _, offset := range []int{2, 4} {
+		if list := complete(optional, at(t, optionalText, "?.name", 1, offset)); len(list.Items) != 0 {
+			t.Fatalf("expected no completions at offset %d after ?., got %d", offset, len(list.Items))
+		}
+	}
+
+	// The variables of a for loop are in scope in its body.
+	forText := "let n = 3;\nfor (let i = 0; i < n; i += 1) {\n    let half = i / 2;\n    log(half);\n}"
+	loop := "file:///for.gs"
+	c.open(loop, "", forText)
+	if got := labels(complete(loop, at(t, forText, "log(half", 1, 4)), CompletionVariable); !slices.Equal(got, []string{"i", "half", "n"}) {
+		t.Fatalf("unexpected variables in the for loop: %v", got)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	script, scriptText := c.fixture("basket.gs")
+	page, pageText := c.fixture("products.html")
+
+	symbols := func(uri string) []DocumentSymbol {
+		t.Helper()
+		var result []DocumentSymbol
+		if err := c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	got := symbols(script)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 symbols, got %+v", got)
+	}
+	expected := []struct {
+		name  string
+		kind  int
+		start Position
+		end   Position
+	}{
+		{"taxRate", SymbolVariable, at(t, scriptText, "let taxRate", 1, 0), at(t, scriptText, "0.2;", 1, 4)},
+		{"total", SymbolFunction, at(t, scriptText, "fn total", 1, 0), at(t, scriptText, "}\n\nlet basket", 1, 1)},
+		{"basket", SymbolVariable, at(t, scriptText, "let basket", 1, 0), at(t, scriptText, "}];", 1, 3)},
+	}
+	for i, e := range expected {
+		s := got[i]
+		if s.Name != e.name || s.Kind != e.kind || s.Range.Start != e.start || s.Range.End != e.end {
+			t.Fatalf("expected %s (%d) from %+v to %+v, got %+v", e.name, e.kind, e.start, e.end, s)
+		}
+	}
+	total := got[1]
+	if total.Detail != "fn(items)" || total.SelectionRange.Start != at(t, scriptText, "total(items)", 1, 0) {
+		t.Fatalf("unexpected symbol for total: %+v", total)
+	}
+	if len(total.Children) != 1 || total.Children[0].Name != "sum" {
+		t.Fatalf("expected sum in total, got %+v", total.Children)
+	}
+
+	got = symbols(page)
+	if len(got) != 1 || got[0].Name != "footer" || got[0].Kind != SymbolNamespace {
+		t.Fatalf("expected the footer block, got %+v", got)
+	}
+	if got[0].Range.End != at(t, pageText, "} %}", 2, 1) {
+		t.Fatalf("expected the block to end at its brace, got %+v", got[0].Range)
+	}
+	if children := got[0].Children; len(children) != 1 || children[0].Name != "year" || children[0].Range.End != at(t, pageText, "2026;", 1, 5) {
+		t.Fatalf("expected year in the block, got %+v", children)
+	}
+
+	if got := symbols("file:///unknown.gs"); got == nil || len(got) != 0 {
+		t.Fatalf("expected no symbols for an unknown document, got %+v", got)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	d := newDocument("file:///a.gs", 1, "let s = \"é😀\";\r\nlet t = s;", false)
+
+	tests := []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{9, Position{0, 9}},
+		{11, Position{0, 10}},
+		{15, Position{0, 12}},
+		{19, Position{1, 0}},
+		{len(d.text), Position{1, 10}},
+	}
+	for _, tt := range tests {
+		if got := d.position(tt.offset); got != tt.pos {
+			t.Fatalf("expected offset %d at %+v, got %+v", tt.offset, tt.pos, got)
+		}
+		if got := d.offset(tt.pos); got != tt.offset {
+			t.Fatalf("expected %+v at offset %d, got %d", tt.pos, tt.offset, got)
+		}
+	}
+
+	// Positions past the end of a line or the document are clamped.
+	if got := d.offset(Position{Line: 0, Character: 100}); got != 17 {
+		t.Fatalf("expected the end of the first line, got %d", got)
+	}
+	if got := d.offset(Position{Line: 5}); got != len(d.text) {
+		t.Fatalf("expected the end of the document, got %d", got)
+	}
+}
@@ -0,0 +1,13 @@
+// Prices a basket of items.
+let taxRate = 0.2;
+
+fn total(items) {
+    let sum = 0;
+    foreach (items as item) {
+        sum += item.price;
+    }
+    return sum * (1 + taxRate);
+}
+
+let basket = [{"price": 10}, {"price": 5}];
+log(toUpper("total: ") + total(basket));
@@ -15,13 +15,19 @@ func (i *Identifier) Debug() string {
 	return i.Token.Source
 }
 
+// PropertyExpression reads Property of Left. An Optional one, written ?.,
+// is null when Left is null, along with the rest of the chain it starts.
 type PropertyExpression struct {
 	Token    lexer.Token
 	Left     Expression
 	Property Expression
+	Optional bool
 }
 
 func (pe *PropertyExpression) Debug() string {
+	if pe.Optional {
+		return pe.Left.Debug() + "?." + pe.Property.Debug()
+	}
 	return pe.Left.Debug() + "." + pe.Property.Debug()
 }
 
@@ -52,6 +58,37 @@ func (ie *InfixExpression) Debug() string {
 	return ie.Left.Debug() + " " + ie.Token.Source + " " + ie.Right.Debug()
 }
 
+// PipeExpression passes Left to Filter as its first argument: x | f calls
+// f(x), and x | f(a, b) calls f(x, a, b).
+type PipeExpression struct {
+	Token  lexer.Token
+	Left   Expression
+	Filter Expression
+}
+
+func (pe *PipeExpression) Debug() string {
+	return pe.Left.Debug() + " | " + pe.Filter.Debug()
+}
+
+// RangeExpression is the integers from Start to End, written Start..End,
+// or up to but not including End, written Start..<End. Step, written
+// Start..End step Step, is nil for the default of 1.
+type RangeExpression struct {
+	Token     lexer.Token
+	Start     Expression
+	End       Expression
+	Step      Expression
+	Exclusive bool
+}
+
+func (re *RangeExpression) Debug() string {
+	str := re.Start.Debug() + re.Token.Source + re.End.Debug()
+	if re.Step != nil {
+		str += " step " + re.Step.Debug()
+	}
+	return str
+}
+
 type PrefixExpression struct {
 	Token    lexer.Token
 	Operator string
@@ -62,13 +99,19 @@ func (pe *PrefixExpression) Debug() string {
 	return "(" + pe.Operator + ")"
 }
 
+// IndexExpression reads Left[Index]. An Optional one, written ?[ ], is
+// null when Left is null, along with the rest of the chain it starts.
 type IndexExpression struct {
-	Token lexer.Token
-	Left  Expression
-	Index Expression
+	Token    lexer.Token
+	Left     Expression
+	Index    Expression
+	Optional bool
 }
 
 func (ie *IndexExpression) Debug() string {
+	if ie.Optional {
+		return ie.Left.Debug() + "?[" + ie.Index.Debug() + "]"
+	}
 	return ie.Left.Debug() + "[" + ie.Index.Debug() + "]"
 }
 
@@ -111,3 +154,31 @@ type WhileExpression struct {
 func (we *WhileExpression) Debug() string {
 	return "while " + we.Condition.Debug() + " " + we.Body.Debug()
 }
+
+// ForExpression is a C-style for loop: for (Init; Condition; Update) Body.
+// Init is a let statement or an expression statement, and Update an
+// expression such as an assignment. Each of the three can be nil, and a
+// loop without a Condition runs until it breaks.
+type ForExpression struct {
+	Token     lexer.Token
+	Init      Statement
+	Condition Expression
+	Update    Expression
+	Body      *BlockStatement
+}
+
+func (fe *ForExpression) Debug() string {
+	str := "for ("
+	if fe.Init != nil {
+		str += fe.Init.Debug()
+	}
+	str += ";"
+	if fe.Condition != nil {
+		str += " " + fe.Condition.Debug()
+	}
+	str += ";"
+	if fe.Update != nil {
+		str += " " + fe.Update.Debug()
+	}
+	return str + ") " + fe.Body.Debug()
+}
@@ -0,0 +1,113 @@
+package parser
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f
+// for each node. If f returns false, the children of that node are skipped.
+func Inspect(node any, f func(any) bool) {
+	if !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, statement := range n.Statements {
+			Inspect(statement, f)
+		}
+	case *BlockStatement:
+		for _, statement := range n.Statements {
+			Inspect(statement, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.Value, f)
+	case *ThrowStatement:
+		Inspect(n.Value, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *ExtendsStatement:
+		Inspect(n.Template, f)
+	case *NamedBlockStatement:
+		Inspect(n.Body, f)
+	case *TryStatement:
+		Inspect(n.Body, f)
+		Inspect(n.Parameter, f)
+		Inspect(n.Catch, f)
+	case *AssignmentExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *PipeExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Filter, f)
+	case *RangeExpression:
+		Inspect(n.Start, f)
+		Inspect(n.End, f)
+		if n.Step != nil {
+			Inspect(n.Step, f)
+		}
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *WhileExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *ForExpression:
+		if n.Init != nil {
+			Inspect(n.Init, f)
+		}
+		if n.Condition != nil {
+			Inspect(n.Condition, f)
+		}
+		if n.Update != nil {
+			Inspect(n.Update, f)
+		}
+		Inspect(n.Body, f)
+	case *ForeachExpression:
+		Inspect(n.Iterable, f)
+		if n.Index != nil {
+			Inspect(n.Index, f)
+		}
+		Inspect(n.Variable, f)
+		Inspect(n.Body, f)
+	case *FunctionLiteral:
+		if n.Identifier != nil {
+			Inspect(n.Identifier, f)
+		}
+		for _, param := range n.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *InterpolatedString:
+		for _, expression := range n.Expressions {
+			Inspect(expression, f)
+		}
+	case *ArrayLiteral:
+		for _, element := range n.Elements {
+			Inspect(element, f)
+		}
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			Inspect(pair.Key, f)
+			Inspect(pair.Value, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *PropertyExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Property, f)
+	}
+}
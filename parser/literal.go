@@ -24,6 +24,34 @@ func (sl *StringLiteral) Debug() string {
 	return sl.Token.Source
 }
 
+// InterpolatedString is a backtick string, such as `Hello, ${name}!`,
+// with the values of Expressions between its Strings. There is one more
+// string than there are expressions, and the strings have their escapes
+// resolved.
+type InterpolatedString struct {
+	Token       lexer.Token
+	Strings     []string
+	Expressions []Expression
+}
+
+// interpolationEscaper escapes the text of an interpolated string.
+var interpolationEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`", "${", "\\${")
+
+func (is *InterpolatedString) Debug() string {
+	var sb strings.Builder
+	sb.WriteString("`")
+	for i, s := range is.Strings {
+		sb.WriteString(interpolationEscaper.Replace(s))
+		if i < len(is.Expressions) {
+			sb.WriteString("${")
+			sb.WriteString(is.Expressions[i].Debug())
+			sb.WriteString("}")
+		}
+	}
+	sb.WriteString("`")
+	return sb.String()
+}
+
 type BooleanLiteral struct {
 	Token lexer.Token
 	Value bool
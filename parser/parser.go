@@ -9,27 +9,35 @@ import (
 )
 
 var Precedences = map[lexer.TokenType]int{
-	lexer.NullCoalescing: 1,
-	lexer.Or:             2,
-	lexer.And:            3,
-	lexer.Equals:         4,
-	lexer.NotEqual:       4,
-	lexer.LessThan:       5,
-	lexer.GreaterThan:    5,
-	lexer.LessOrEqual:    5,
-	lexer.GreaterOrEqual: 5,
-	lexer.Plus:           6,
-	lexer.Minus:          6,
-	lexer.Slash:          7,
-	lexer.Asterisk:       7,
-	lexer.Modulo:         7,
-	lexer.Dot:            8,
-	lexer.LeftParen:      9,
-	lexer.LeftBracket:    9,
+	lexer.Pipe:            1,
+	lexer.NullCoalescing:  2,
+	lexer.Or:              3,
+	lexer.And:             4,
+	lexer.Equals:          5,
+	lexer.NotEqual:        5,
+	lexer.LessThan:        6,
+	lexer.GreaterThan:     6,
+	lexer.LessOrEqual:     6,
+	lexer.GreaterOrEqual:  6,
+	lexer.Range:           7,
+	lexer.RangeExclusive:  7,
+	lexer.Plus:            8,
+	lexer.Minus:           8,
+	lexer.Slash:           9,
+	lexer.Asterisk:        9,
+	lexer.Modulo:          9,
+	lexer.Dot:             10,
+	lexer.OptionalDot:     10,
+	lexer.LeftParen:       11,
+	lexer.LeftBracket:     11,
+	lexer.OptionalBracket: 11,
 }
 
 type Program struct {
 	Statements []Statement
+	// Comments are the comments in the source, in order, if its lexer was
+	// set to keep them.
+	Comments []lexer.Token
 }
 
 func NewProgram() *Program {
@@ -47,11 +55,12 @@ func (p *Program) Debug() string {
 }
 
 type Parser struct {
-	l       *lexer.Lexer
-	prev    lexer.Token
-	current lexer.Token
-	next    lexer.Token
-	errors  []error
+	l        *lexer.Lexer
+	prev     lexer.Token
+	current  lexer.Token
+	next     lexer.Token
+	errors   []error
+	comments []lexer.Token
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -99,6 +108,8 @@ func (p *Parser) Parse() (*Program, error) {
 		return nil, errors.Join(p.errors...)
 	}
 
+	t.Comments = p.comments
+
 	return t, nil
 }
 
@@ -119,6 +130,20 @@ func (p *Parser) parseStatement() (Statement, error) {
 		return p.parseWhileExpression()
 	case lexer.Return:
 		return p.parseReturnStatement()
+	case lexer.Identifier:
+		switch {
+		case p.l.IsTemplate() && p.atKeyword("extends"):
+			return p.parseExtendsStatement()
+		case p.l.IsTemplate() && p.atKeyword("block"):
+			return p.parseNamedBlockStatement()
+		case p.atKeyword("for"):
+			return p.parseForExpression()
+		case p.atKeyword("try") && p.next.Type == lexer.LeftBrace:
+			return p.parseTryStatement()
+		case p.atKeyword("throw"):
+			return p.parseThrowStatement()
+		}
+		return p.parseExpressionStatement()
 	case lexer.Break:
 		stmt := &BreakStatement{Token: p.current}
 		if p.next.Type == lexer.Semicolon {
@@ -140,8 +165,30 @@ func (p *Parser) parseStatement() (Statement, error) {
 	}
 }
 
+// atKeyword reports whether the current token is the contextual keyword
+// word. It is unless the token after it continues an expression using it
+// as a name, as in block = 1, block.x or block + 1, or ends the statement.
+func (p *Parser) atKeyword(word string) bool {
+	if p.current.Type != lexer.Identifier || p.current.Source != word {
+		return false
+	}
+
+	switch p.next.Type {
+	case lexer.LeftParen:
+		return true
+	case lexer.Equal, lexer.Semicolon, lexer.ScriptEnd, lexer.EndOfFile:
+		return false
+	}
+	if _, ok := compoundOperators[p.next.Type]; ok {
+		return false
+	}
+	_, ok := Precedences[p.next.Type]
+	return !ok
+}
+
 func (p *Parser) parseTextStatement() (*PrintStatement, error) {
 	statement := &PrintStatement{
+		Token: p.current,
 		Value: p.current.Source,
 	}
 
@@ -235,103 +282,268 @@ func (p *Parser) parseReturnStatement() (*ReturnStatement, error) {
 	return statement, nil
 }
 
-func (p *Parser) parseExpressionStatement() (*ExpressionStatement, error) {
-	expression, err := p.parseExpression(0)
-	if expression == nil || err != nil {
+func (p *Parser) parseExtendsStatement() (*ExtendsStatement, error) {
+	statement := &ExtendsStatement{
+		Token: p.current,
+	}
+
+	err := p.nextToken()
+	if err != nil {
 		return nil, err
 	}
 
-	statement := &ExpressionStatement{
-		Expression: expression,
+	value, err := p.parseExpression(0)
+	if value == nil || err != nil {
+		return nil, err
 	}
 
-	if p.next.Type == lexer.Equal {
+	statement.Template = value
 
-		switch expression.(type) {
-		case *IndexExpression, *PropertyExpression, *Identifier:
-			err = p.nextToken()
-			if err != nil {
-				return nil, err
-			}
+	if p.next.Type != lexer.Semicolon && p.next.Type != lexer.ScriptEnd && p.next.Type != lexer.EndOfFile {
 
-			err = p.nextToken()
-			if err != nil {
-				return nil, err
-			}
+		p.errors = append(p.errors,
+			NewParseError(
+				fmt.Sprintf("expected %s, %s or %s, got %s", lexer.Semicolon, lexer.ScriptEnd, lexer.EndOfFile, p.next.Type),
+				p.l.GetSource(), p.next))
 
-			right, err := p.parseExpression(0)
-			if right == nil || err != nil {
-				return nil, err
-			}
+		return nil, nil
+	}
 
-			// This is an assignment statement
-			assignment := &AssignmentExpression{
-				Token: p.current,
-				Left:  expression,
-				Right: right,
-			}
+	err = p.nextToken()
+	if err != nil {
+		return nil, err
+	}
 
-			statement.Expression = assignment
+	return statement, nil
+}
 
-			err = p.nextToken()
-			if err != nil {
-				return nil, err
-			}
+func (p *Parser) parseNamedBlockStatement() (*NamedBlockStatement, error) {
+	statement := &NamedBlockStatement{
+		Token: p.current,
+	}
+
+	peek, err := p.tryPeek(lexer.Identifier)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	statement.Name, err = p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	peek, err = p.tryPeek(lexer.LeftBrace)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	statement.Body, err = p.parseBlockStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+func (p *Parser) parseTryStatement() (*TryStatement, error) {
+	statement := &TryStatement{
+		Token: p.current,
+	}
+
+	peek, err := p.tryPeek(lexer.LeftBrace)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	statement.Body, err = p.parseBlockStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.next.Type != lexer.Identifier || p.next.Source != "catch" {
+		p.errors = append(p.errors,
+			NewParseError(fmt.Sprintf("expected catch, got %s", p.next.Type), p.l.GetSource(), p.next))
+		return nil, nil
+	}
+
+	err = p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	peek, err = p.tryPeek(lexer.LeftParen)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	peek, err = p.tryPeek(lexer.Identifier)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	statement.Parameter, err = p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	peek, err = p.tryPeek(lexer.RightParen)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	peek, err = p.tryPeek(lexer.LeftBrace)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	statement.Catch, err = p.parseBlockStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+func (p *Parser) parseThrowStatement() (*ThrowStatement, error) {
+	statement := &ThrowStatement{
+		Token: p.current,
+	}
+
+	err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := p.parseExpression(0)
+	if value == nil || err != nil {
+		return nil, err
+	}
+
+	statement.Value = value
+
+	if p.next.Type != lexer.Semicolon && p.next.Type != lexer.ScriptEnd && p.next.Type != lexer.EndOfFile {
+
+		p.errors = append(p.errors,
+			NewParseError(
+				fmt.Sprintf("expected %s, %s or %s, got %s", lexer.Semicolon, lexer.ScriptEnd, lexer.EndOfFile, p.next.Type),
+				p.l.GetSource(), p.next))
+
+		return nil, nil
+	}
+
+	err = p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
 
-			return statement, nil
+// checkAssignable reports an error at the assignment token when target
+// is a null-safe access, which has nothing to assign to when it is null.
+func (p *Parser) checkAssignable(target Expression, token lexer.Token) {
+	switch target := target.(type) {
+	case *PropertyExpression:
+		if !target.Optional {
+			return
 		}
+	case *IndexExpression:
+		if !target.Optional {
+			return
+		}
+	default:
+		return
+	}
+	p.errors = append(p.errors,
+		NewParseError(fmt.Sprintf("cannot assign to null-safe access %s", target.Debug()), p.l.GetSource(), token))
+}
 
+// compoundOperators are the operators of the compound assignments, such as
+// + for +=.
+var compoundOperators = map[lexer.TokenType]string{
+	lexer.PlusEqual:     "+",
+	lexer.MinusEqual:    "-",
+	lexer.AsteriskEqual: "*",
+	lexer.SlashEqual:    "/",
+	lexer.ModuloEqual:   "%",
+}
+
+// parseAssignment parses an assignment to target, if the next token is =
+// or a compound assignment operator and target can be assigned to. It
+// reports whether it did, with a nil assignment if its value could not be
+// parsed. A compound assignment is desugared: x += expr is x = x + expr.
+func (p *Parser) parseAssignment(target Expression) (*AssignmentExpression, bool, error) {
+	op, isCompound := compoundOperators[p.next.Type]
+	if p.next.Type != lexer.Equal && !isCompound {
+		return nil, false, nil
 	}
 
-	// Compound assignment operators: +=, -=, *=, /=, %=
-	compoundOps := map[lexer.TokenType]string{
-		lexer.PlusEqual:     "+",
-		lexer.MinusEqual:    "-",
-		lexer.AsteriskEqual: "*",
-		lexer.SlashEqual:    "/",
-		lexer.ModuloEqual:   "%",
+	switch target.(type) {
+	case *IndexExpression, *PropertyExpression, *Identifier:
+	default:
+		return nil, false, nil
 	}
 
-	if op, isCompound := compoundOps[p.next.Type]; isCompound {
-		switch expression.(type) {
-		case *IndexExpression, *PropertyExpression, *Identifier:
-			compoundToken := p.next
+	assignToken := p.next
+	p.checkAssignable(target, assignToken)
 
-			err = p.nextToken() // consume compound operator
-			if err != nil {
-				return nil, err
-			}
+	err := p.nextToken()
+	if err != nil {
+		return nil, true, err
+	}
 
-			err = p.nextToken()
-			if err != nil {
-				return nil, err
-			}
+	err = p.nextToken()
+	if err != nil {
+		return nil, true, err
+	}
 
-			right, err := p.parseExpression(0)
-			if right == nil || err != nil {
-				return nil, err
-			}
+	right, err := p.parseExpression(0)
+	if right == nil || err != nil {
+		return nil, true, err
+	}
 
-			// Desugar: x += expr → x = x + expr
-			assignment := &AssignmentExpression{
-				Token: compoundToken,
-				Left:  expression,
-				Right: &InfixExpression{
-					Token: lexer.NewToken(lexer.TokenType(op), op, compoundToken.Position, compoundToken.Line, compoundToken.Column),
-					Left:  expression,
-					Right: right,
-				},
-			}
+	if isCompound {
+		right = &InfixExpression{
+			Token: lexer.NewToken(lexer.TokenType(op), op, assignToken.Position, assignToken.Line, assignToken.Column),
+			Left:  target,
+			Right: right,
+		}
+	}
 
-			statement.Expression = assignment
+	return &AssignmentExpression{
+		Token: assignToken,
+		Left:  target,
+		Right: right,
+	}, true, nil
+}
 
-			err = p.nextToken()
-			if err != nil {
-				return nil, err
-			}
+func (p *Parser) parseExpressionStatement() (*ExpressionStatement, error) {
+	expression, err := p.parseExpression(0)
+	if expression == nil || err != nil {
+		return nil, err
+	}
 
-			return statement, nil
+	statement := &ExpressionStatement{
+		Expression: expression,
+	}
+
+	assignment, ok, err := p.parseAssignment(expression)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if assignment == nil {
+			return nil, nil
+		}
+
+		statement.Expression = assignment
+
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
 		}
+
+		return statement, nil
 	}
 
 	if fl, ok := expression.(*FunctionLiteral); ok && fl.Identifier != nil {
@@ -413,7 +625,27 @@ func (p *Parser) parseExpression(precedence int) (Expression, error) {
 			if err != nil {
 				return nil, err
 			}
-		case lexer.Dot:
+		case lexer.Pipe:
+			err := p.nextToken()
+			if err != nil {
+				return nil, err
+			}
+
+			leftExpression, err = p.parsePipeExpression(leftExpression)
+			if err != nil {
+				return nil, err
+			}
+		case lexer.Range, lexer.RangeExclusive:
+			err := p.nextToken()
+			if err != nil {
+				return nil, err
+			}
+
+			leftExpression, err = p.parseRangeExpression(leftExpression)
+			if err != nil {
+				return nil, err
+			}
+		case lexer.Dot, lexer.OptionalDot:
 			err := p.nextToken()
 			if err != nil {
 				return nil, err
@@ -433,7 +665,7 @@ func (p *Parser) parseExpression(precedence int) (Expression, error) {
 			if err != nil {
 				return nil, err
 			}
-		case lexer.LeftBracket:
+		case lexer.LeftBracket, lexer.OptionalBracket:
 			err := p.nextToken()
 			if err != nil {
 				return nil, err
@@ -465,6 +697,8 @@ func (p *Parser) parsePrefixExpression() (Expression, error) {
 		return p.parseFloat()
 	case lexer.String:
 		return p.parseString()
+	case lexer.InterpolatedString:
+		return p.parseInterpolatedString()
 	case lexer.True:
 		return p.parseBoolean()
 	case lexer.False:
@@ -482,7 +716,7 @@ func (p *Parser) parsePrefixExpression() (Expression, error) {
 			return nil, err
 		}
 
-		right, err := p.parseExpression(7) // Multiplicative (highest arithmetic)
+		right, err := p.parseExpression(Precedences[lexer.Asterisk]) // Multiplicative (highest arithmetic)
 		if right == nil || err != nil {
 			return nil, err
 		}
@@ -533,13 +767,94 @@ func (p *Parser) parseInfixExpression(left Expression) (Expression, error) {
 	return infix, nil
 }
 
-func (p *Parser) parseAccessExpression(left Expression) (Expression, error) {
+func (p *Parser) parsePipeExpression(left Expression) (Expression, error) {
 
-	expression := &PropertyExpression{
+	pipe := &PipeExpression{
 		Token: p.current,
 		Left:  left,
 	}
 
+	err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// The filter takes only its own properties, indexes and calls, so
+	// that x | f + 1 adds 1 to the result of the pipe.
+	start := p.current
+	filter, err := p.parseExpression(Precedences[lexer.Asterisk])
+	if filter == nil || err != nil {
+		return nil, err
+	}
+
+	// The pipe is kept when its filter can't be a function, since the
+	// rest of the expression parses as well with it.
+	switch filter.(type) {
+	case *Identifier, *PropertyExpression, *IndexExpression, *CallExpression, *FunctionLiteral:
+	default:
+		p.errors = append(p.errors,
+			NewParseError(fmt.Sprintf("expected a function after %s, got %s", pipe.Token.Source, start.Type), p.l.GetSource(), start))
+	}
+
+	pipe.Filter = filter
+
+	return pipe, nil
+}
+
+// parseRangeExpression parses a range starting at left, with its step if
+// the contextual keyword step follows its end.
+func (p *Parser) parseRangeExpression(left Expression) (Expression, error) {
+	expression := &RangeExpression{
+		Token:     p.current,
+		Start:     left,
+		Exclusive: p.current.Type == lexer.RangeExclusive,
+	}
+
+	precedence := p.currentPrecedence()
+
+	err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expression.End, err = p.parseExpression(precedence)
+	if expression.End == nil || err != nil {
+		return nil, err
+	}
+
+	if p.next.Type == lexer.Identifier && p.next.Source == "step" {
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		expression.Step, err = p.parseExpression(precedence)
+		if expression.Step == nil || err != nil {
+			return nil, err
+		}
+	}
+
+	return expression, nil
+}
+
+func (p *Parser) parseAccessExpression(left Expression) (Expression, error) {
+
+	expression := &PropertyExpression{
+		Token:    p.current,
+		Left:     left,
+		Optional: p.current.Type == lexer.OptionalDot,
+	}
+
+	// Any word can name a property, including reserved ones, as in h.if.
+	if p.next.IsKeyword() {
+		p.next.Type = lexer.Identifier
+	}
+
 	peek, err := p.tryPeek(lexer.Identifier)
 	if !peek || err != nil {
 		return nil, err
@@ -554,13 +869,15 @@ func (p *Parser) parseAccessExpression(left Expression) (Expression, error) {
 }
 
 func (p *Parser) parseCallExpression(left Expression) (Expression, error) {
+	token := p.current
+
 	args, err := p.parseExpressionList(lexer.RightParen)
 	if err != nil {
 		return nil, err
 	}
 
 	expression := &CallExpression{
-		Token:    p.current,
+		Token:    token,
 		Function: left,
 		Args:     args,
 	}
@@ -571,8 +888,9 @@ func (p *Parser) parseCallExpression(left Expression) (Expression, error) {
 func (p *Parser) parseIndexExpression(left Expression) (Expression, error) {
 
 	expression := &IndexExpression{
-		Token: p.current,
-		Left:  left,
+		Token:    p.current,
+		Left:     left,
+		Optional: p.current.Type == lexer.OptionalBracket,
 	}
 
 	err := p.nextToken()
@@ -646,8 +964,15 @@ func (p *Parser) parseString() (Expression, error) {
 
 	literal := &StringLiteral{Token: p.current}
 
-	raw := p.current.Source[1 : len(p.current.Source)-1]
+	literal.Value = unescape(p.current.Source[1:len(p.current.Source)-1], '"')
 
+	return literal, nil
+}
+
+// unescape resolves the escape sequences of the raw text of a string
+// quoted by quote. A backslash before any other character is kept, along
+// with the character.
+func unescape(raw string, quote byte) string {
 	var sb strings.Builder
 	for i := 0; i < len(raw); i++ {
 		if raw[i] == '\\' && i+1 < len(raw) {
@@ -659,24 +984,84 @@ func (p *Parser) parseString() (Expression, error) {
 				sb.WriteByte('\r')
 			case 't':
 				sb.WriteByte('\t')
-			case '\\':
-				sb.WriteByte('\\')
-			case '"':
-				sb.WriteByte('"')
+			case '\\', quote:
+				sb.WriteByte(raw[i])
 			default:
-				sb.WriteByte('\\')
+				// \$ writes a dollar sign that does not start an
+				// interpolation.
+				if quote != '`' || raw[i] != '$' {
+					sb.WriteByte('\\')
+				}
 				sb.WriteByte(raw[i])
 			}
 		} else {
 			sb.WriteByte(raw[i])
 		}
 	}
+	return sb.String()
+}
+
+func (p *Parser) parseInterpolatedString() (Expression, error) {
 
-	literal.Value = sb.String()
+	literal := &InterpolatedString{Token: p.current}
+
+	texts, lexers, err := lexer.Interpolation(p.l.GetSource(), p.current)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, text := range texts {
+		literal.Strings = append(literal.Strings, unescape(text.Source, '`'))
+	}
+
+	for _, l := range lexers {
+		expression, err := p.parseInterpolation(l)
+		if expression == nil || err != nil {
+			return nil, err
+		}
+		literal.Expressions = append(literal.Expressions, expression)
+	}
 
 	return literal, nil
 }
 
+// parseInterpolation parses the expression of an interpolated string read
+// by l, which ends at the brace closing the expression.
+func (p *Parser) parseInterpolation(l *lexer.Lexer) (Expression, error) {
+
+	sub := New(l)
+	if err := sub.nextToken(); err != nil {
+		return nil, err
+	}
+	if err := sub.nextToken(); err != nil {
+		return nil, err
+	}
+
+	expression, err := sub.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if expression != nil && sub.next.Type != lexer.EndOfFile {
+		sub.errors = append(sub.errors,
+			NewParseError(fmt.Sprintf("expected %s, got %s", lexer.RightBrace, sub.next.Type), "", sub.next))
+	}
+
+	// The errors show the whole source, not just the part up to the end
+	// of the expression.
+	for _, err := range sub.errors {
+		if parseErr, ok := err.(*ParseError); ok {
+			parseErr.Source = p.l.GetSource()
+		}
+	}
+	p.errors = append(p.errors, sub.errors...)
+
+	if len(sub.errors) > 0 {
+		return nil, nil
+	}
+	return expression, nil
+}
+
 func (p *Parser) parseBoolean() (Expression, error) {
 
 	literal := &BooleanLiteral{Token: p.current}
@@ -902,6 +1287,126 @@ func (p *Parser) parseWhileExpression() (*ExpressionStatement, error) {
 	return &ExpressionStatement{Expression: whileExpr}, nil
 }
 
+func (p *Parser) parseForExpression() (*ExpressionStatement, error) {
+
+	forExpr := &ForExpression{
+		Token: p.current,
+	}
+
+	peek, err := p.tryPeek(lexer.LeftParen)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	switch p.next.Type {
+	case lexer.Semicolon:
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+	case lexer.Let:
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		let, err := p.parseLetStatement()
+		if let == nil || err != nil {
+			return nil, err
+		}
+		if p.current.Type != lexer.Semicolon {
+			p.errors = append(p.errors,
+				NewParseError(fmt.Sprintf("expected %s, got %s", lexer.Semicolon, p.current.Type), p.l.GetSource(), p.current))
+			return nil, nil
+		}
+		forExpr.Init = let
+	default:
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		init, err := p.parseForClause()
+		if init == nil || err != nil {
+			return nil, err
+		}
+		forExpr.Init = &ExpressionStatement{Expression: init}
+
+		peek, err = p.tryPeek(lexer.Semicolon)
+		if !peek || err != nil {
+			return nil, err
+		}
+	}
+
+	if p.next.Type != lexer.Semicolon {
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		forExpr.Condition, err = p.parseExpression(0)
+		if forExpr.Condition == nil || err != nil {
+			return nil, err
+		}
+	}
+
+	peek, err = p.tryPeek(lexer.Semicolon)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	if p.next.Type != lexer.RightParen {
+		err = p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		forExpr.Update, err = p.parseForClause()
+		if forExpr.Update == nil || err != nil {
+			return nil, err
+		}
+	}
+
+	peek, err = p.tryPeek(lexer.RightParen)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	peek, err = p.tryPeek(lexer.LeftBrace)
+	if !peek || err != nil {
+		return nil, err
+	}
+
+	forExpr.Body, err = p.parseBlockStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExpressionStatement{Expression: forExpr}, nil
+}
+
+// parseForClause parses the initialization or update of a for loop that
+// is not a let statement: an expression or an assignment.
+func (p *Parser) parseForClause() (Expression, error) {
+	expression, err := p.parseExpression(0)
+	if expression == nil || err != nil {
+		return nil, err
+	}
+
+	assignment, ok, err := p.parseAssignment(expression)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if assignment == nil {
+			return nil, nil
+		}
+		return assignment, nil
+	}
+
+	return expression, nil
+}
+
 func (p *Parser) parseBlockStatement() (*BlockStatement, error) {
 
 	block := &BlockStatement{
@@ -916,6 +1421,7 @@ func (p *Parser) parseBlockStatement() (*BlockStatement, error) {
 	for {
 
 		if p.current.Type == lexer.RightBrace || p.current.Type == lexer.EndOfFile {
+			block.End = p.current
 			break
 		}
 
@@ -1075,6 +1581,7 @@ func (p *Parser) parseExpressionList(end lexer.TokenType) ([]Expression, error)
 }
 
 func (p *Parser) parseArray() (Expression, error) {
+	token := p.current
 
 	exp, err := p.parseExpressionList(lexer.RightBracket)
 	if err != nil {
@@ -1082,7 +1589,7 @@ func (p *Parser) parseArray() (Expression, error) {
 	}
 
 	literal := &ArrayLiteral{
-		Token:    p.current,
+		Token:    token,
 		Elements: exp,
 	}
 
@@ -1090,6 +1597,7 @@ func (p *Parser) parseArray() (Expression, error) {
 }
 
 func (p *Parser) parseHashLiteral() (Expression, error) {
+	token := p.current
 
 	pairs, err := p.parseHashPairs()
 	if err != nil {
@@ -1097,7 +1605,7 @@ func (p *Parser) parseHashLiteral() (Expression, error) {
 	}
 
 	literal := &HashLiteral{
-		Token: p.current,
+		Token: token,
 		Pairs: pairs,
 	}
 
@@ -1188,6 +1696,10 @@ func (p *Parser) nextToken() error {
 	p.current = p.next
 
 	next, err := p.l.Read()
+	for err == nil && next.Type == lexer.Comment {
+		p.comments = append(p.comments, next)
+		next, err = p.l.Read()
+	}
 	if err != nil {
 		return err
 	}
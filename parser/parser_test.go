@@ -3603,3 +3603,588 @@ func TestParseHashLiteralOrdered(t *testing.T) {
 		}
 	}
 }
+
+// --- Extends / Block ---
+
+func TestParseExtendsStatement(t *testing.T) {
+	input := `{% extends "layout"; %}`
+	l := lexer.NewTemplate(input)
+	p := New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ExtendsStatement)
+	if !ok {
+		t.Fatalf("expected ExtendsStatement, got %T", program.Statements[0])
+	}
+	str, ok := stmt.Template.(*StringLiteral)
+	if !ok {
+		t.Fatalf("expected StringLiteral, got %T", stmt.Template)
+	}
+	if str.Value != "layout" {
+		t.Fatalf("expected 'layout', got %q", str.Value)
+	}
+}
+
+func TestParseNamedBlockStatement(t *testing.T) {
+	input := `{% block content { %}<p>hi</p>{% } %}`
+	l := lexer.NewTemplate(input)
+	p := New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*NamedBlockStatement)
+	if !ok {
+		t.Fatalf("expected NamedBlockStatement, got %T", program.Statements[0])
+	}
+	if stmt.Name.Value != "content" {
+		t.Fatalf("expected block name 'content', got %q", stmt.Name.Value)
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("expected 1 body statement, got %d", len(stmt.Body.Statements))
+	}
+}
+
+func TestParseNamedBlockMissingName(t *testing.T) {
+	input := `{% block { } %}`
+	l := lexer.NewTemplate(input)
+	p := New(l)
+	_, err := p.Parse()
+	if err == nil {
+		t.Fatal("expected error for block without a name")
+	}
+}
+
+func TestParseContextualKeywordsAsNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		template bool
+	}{
+		{"let block = 1; block;", false},
+		{"let extends = 1; extends += 1;", false},
+		{"block = h.block ?? h?.extends;", false},
+		{"{% block %}", true},
+		{"{% extends.name %}", true},
+		{"{% block = 1; block + 1 %}", true},
+		{"{% if (x) { block; } %}", true},
+		{"let try = 1; try;", false},
+		{"throw;", false},
+		{"throw = throw - 1;", false},
+		{"catch = x.try + h?.catch;", false},
+		{"try(1);", false},
+		{"{% try %}", true},
+		{"let for = 1; for += x.for;", false},
+		{"{% for %}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.NewScript(tt.input)
+			if tt.template {
+				l = lexer.NewTemplate(tt.input)
+			}
+			program, err := New(l).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, stmt := range program.Statements {
+				switch stmt.(type) {
+				case *ExtendsStatement, *NamedBlockStatement, *TryStatement, *ThrowStatement:
+					t.Fatalf("expected %q to use a variable, got %T", tt.input, stmt)
+				}
+			}
+		})
+	}
+}
+
+func TestParseExtendsInScript(t *testing.T) {
+	// Outside of a template, extends is only a name.
+	_, err := New(lexer.NewScript(`extends "layout";`)).Parse()
+	if err == nil {
+		t.Fatal("expected an error for extends in a script")
+	}
+}
+
+func TestParseKeywordProperty(t *testing.T) {
+	words := append(lexer.Keywords(), lexer.ContextualKeywords()...)
+	for _, word := range words {
+		for _, dot := range []string{".", "?."} {
+			input := "h" + dot + word + ";"
+			t.Run(input, func(t *testing.T) {
+				program, err := New(lexer.NewScript(input)).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+				stmt := program.Statements[0].(*ExpressionStatement)
+				prop, ok := stmt.Expression.(*PropertyExpression)
+				if !ok {
+					t.Fatalf("expected PropertyExpression, got %T", stmt.Expression)
+				}
+				if prop.Property.Debug() != word {
+					t.Fatalf("expected property %q, got %s", word, prop.Property.Debug())
+				}
+			})
+		}
+	}
+}
+
+func TestParseTryStatement(t *testing.T) {
+	input := `try { let x = 1; throw "bad"; } catch (err) { return err; }`
+	l := lexer.NewScript(input)
+	p := New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*TryStatement)
+	if !ok {
+		t.Fatalf("expected TryStatement, got %T", program.Statements[0])
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("expected 2 statements in try body, got %d", len(stmt.Body.Statements))
+	}
+	throw, ok := stmt.Body.Statements[1].(*ThrowStatement)
+	if !ok {
+		t.Fatalf("expected ThrowStatement, got %T", stmt.Body.Statements[1])
+	}
+	if str, ok := throw.Value.(*StringLiteral); !ok || str.Value != "bad" {
+		t.Fatalf("expected thrown string 'bad', got %s", throw.Value.Debug())
+	}
+	if stmt.Parameter.Value != "err" {
+		t.Fatalf("expected catch parameter 'err', got %q", stmt.Parameter.Value)
+	}
+	if len(stmt.Catch.Statements) != 1 {
+		t.Fatalf("expected 1 statement in catch body, got %d", len(stmt.Catch.Statements))
+	}
+}
+
+func TestParseTryStatementTemplate(t *testing.T) {
+	input := `{% try { %}<p>{% risky() %}</p>{% } catch (e) { %}<p>{% e.message %}</p>{% } %}`
+	l := lexer.NewTemplate(input)
+	p := New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+	if _, ok := program.Statements[0].(*TryStatement); !ok {
+		t.Fatalf("expected TryStatement, got %T", program.Statements[0])
+	}
+}
+
+func TestParseTryStatementErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing catch", `try { }`},
+		{"missing parameter", `try { } catch { }`},
+		{"missing catch body", `try { } catch (e)`},
+		{"missing try body", `try catch (e) { }`},
+		{"throw without terminator", `throw 1 2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.NewScript(tt.input)
+			p := New(l)
+			if _, err := p.Parse(); err == nil {
+				t.Fatalf("expected error for %q", tt.input)
+			}
+		})
+	}
+}
+
+func TestParseKeepComments(t *testing.T) {
+	input := "// leading\nfn f(a /* inline */) {\n    return a; // trailing\n}"
+	l := lexer.NewScript(input)
+	l.KeepComments()
+	program, err := New(l).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+
+	var comments []string
+	for _, c := range program.Comments {
+		comments = append(comments, c.Source)
+	}
+	expected := []string{"// leading", "/* inline */", "// trailing"}
+	if strings.Join(comments, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected comments %q, got %q", expected, comments)
+	}
+
+	fl := program.Statements[0].(*ExpressionStatement).Expression.(*FunctionLiteral)
+	if fl.Body.End.Type != lexer.RightBrace || fl.Body.End.Line != 4 {
+		t.Fatalf("expected the block to end at the brace on line 4, got %s on line %d", fl.Body.End.Type, fl.Body.End.Line)
+	}
+}
+
+func TestParseSkipsComments(t *testing.T) {
+	program, err := New(lexer.NewScript("let a = 1; // comment")).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Comments) != 0 {
+		t.Fatalf("expected no comments, got %v", program.Comments)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	program, err := New(lexer.NewScript(`let a = [b, {"k": c}]; fn f(d) { return d + e; } foreach (a as i) { f(i); }`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	Inspect(program, func(n any) bool {
+		if id, ok := n.(*Identifier); ok {
+			names = append(names, id.Value)
+		}
+		// Skip the function declaration and everything in it.
+		_, isFunction := n.(*FunctionLiteral)
+		return !isFunction
+	})
+
+	expected := []string{"a", "b", "c", "a", "i", "f", "i"}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected identifiers %v, got %v", expected, names)
+	}
+}
+
+func TestParseInterpolatedString(t *testing.T) {
+	input := "let x = `Hello, ${user.name}!\\n\\`${n + 1}\\` \\${no} \\d\n${`in ${f(\"}\")}`}`;"
+	program, err := New(lexer.NewScript(input)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	literal, ok := program.Statements[0].(*LetStatement).Value.(*InterpolatedString)
+	if !ok {
+		t.Fatalf("expected InterpolatedString, got %T", program.Statements[0].(*LetStatement).Value)
+	}
+
+	strs := []string{"Hello, ", "!\n`", "` ${no} \\d\n", ""}
+	if strings.Join(literal.Strings, "|") != strings.Join(strs, "|") || len(literal.Strings) != len(strs) {
+		t.Fatalf("expected strings %q, got %q", strs, literal.Strings)
+	}
+
+	expressions := []string{"user.name", "n + 1", "`in ${f(\"}\")}`"}
+	if len(literal.Expressions) != len(expressions) {
+		t.Fatalf("expected %d expressions, got %d", len(expressions), len(literal.Expressions))
+	}
+	for i, want := range expressions {
+		if got := literal.Expressions[i].Debug(); got != want {
+			t.Fatalf("expression %d: expected %s, got %s", i, want, got)
+		}
+	}
+
+	// Expressions are positioned in the source, not the string.
+	call := literal.Expressions[0].(*PropertyExpression)
+	if tok := call.Left.(*Identifier).Token; tok.Position != 18 || tok.Line != 1 || tok.Column != 19 {
+		t.Fatalf("unexpected position of user: %+v", tok)
+	}
+	nested := literal.Expressions[2].(*InterpolatedString).Expressions[0].(*CallExpression)
+	if tok := nested.Function.(*Identifier).Token; tok.Line != 2 || tok.Column != 9 {
+		t.Fatalf("unexpected position of f: %+v", tok)
+	}
+
+	if got := literal.Debug(); got != "`Hello, ${user.name}!\n\\`${n + 1}\\` \\${no} \\\\d\n${`in ${f(\"}\")}`}`" {
+		t.Fatalf("unexpected debug %s", got)
+	}
+}
+
+func TestParseInterpolatedStringErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+		line    int
+		column  int
+	}{
+		{"let x = `a ${b c}`;", "expected RIGHT_BRACE, got IDENTIFIER", 1, 16},
+		{"let x = `a\n${}`;", "unexpected token EOF", 2, 3},
+		{"let x = `a ${b +}`;", "unexpected token EOF", 1, 17},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := New(lexer.NewScript(tt.input)).Parse()
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected ParseError, got %v", err)
+			}
+			if parseErr.Message != tt.message || parseErr.Token.Line != tt.line || parseErr.Token.Column != tt.column {
+				t.Fatalf("expected %q at %d:%d, got %q at %d:%d", tt.message, tt.line, tt.column,
+					parseErr.Message, parseErr.Token.Line, parseErr.Token.Column)
+			}
+			if parseErr.Source != tt.input {
+				t.Fatalf("expected the error to show the whole source, got %q", parseErr.Source)
+			}
+		})
+	}
+}
+
+func TestParsePipeExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		left   string
+		filter string
+	}{
+		{"name | upper;", "name", "upper"},
+		{"name | truncate(20);", "name", "truncate(20)"},
+		{"a + b | f;", "a + b", "f"},
+		{"x ?? y | f;", "x ?? y", "f"},
+		{"x | f | g(1, 2);", "x | f", "g(1, 2)"},
+		{"x | strings.upper;", "x", "strings.upper"},
+		{"x | filters[\"upper\"];", "x", "filters[\"upper\"]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := New(lexer.NewScript(tt.input)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pipe, ok := program.Statements[0].(*ExpressionStatement).Expression.(*PipeExpression)
+			if !ok {
+				t.Fatalf("expected PipeExpression, got %T", program.Statements[0].(*ExpressionStatement).Expression)
+			}
+			if got := pipe.Left.Debug(); got != tt.left {
+				t.Fatalf("expected left %s, got %s", tt.left, got)
+			}
+			if got := pipe.Filter.Debug(); got != tt.filter {
+				t.Fatalf("expected filter %s, got %s", tt.filter, got)
+			}
+		})
+	}
+}
+
+func TestParsePipeBindsLoosest(t *testing.T) {
+	// Only postfix operators belong to the filter, so operators after it
+	// apply to the result of the pipe.
+	program, err := New(lexer.NewScript("x | f + 1;")).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infix, ok := program.Statements[0].(*ExpressionStatement).Expression.(*InfixExpression)
+	if !ok {
+		t.Fatalf("expected InfixExpression, got %T", program.Statements[0].(*ExpressionStatement).Expression)
+	}
+	if _, ok := infix.Left.(*PipeExpression); !ok {
+		t.Fatalf("expected the pipe on the left, got %T", infix.Left)
+	}
+}
+
+func TestParsePipeErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+		column  int
+	}{
+		{"x | 5;", "expected a function after |, got INTEGER", 5},
+		{"x | 1 + 2;", "expected a function after |, got INTEGER", 5},
+		{"x | -f;", "expected a function after |, got MINUS", 5},
+		{"x | ;", "unexpected token SEMICOLON", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := New(lexer.NewScript(tt.input)).Parse()
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected ParseError, got %v", err)
+			}
+			if parseErr.Message != tt.message || parseErr.Token.Column != tt.column {
+				t.Fatalf("expected %q at column %d, got %q at column %d", tt.message, tt.column, parseErr.Message, parseErr.Token.Column)
+			}
+		})
+	}
+}
+
+func TestParseOptionalAccess(t *testing.T) {
+	program, err := New(lexer.NewScript("a?.b.c?[0](1) ?? d;")).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infix, ok := program.Statements[0].(*ExpressionStatement).Expression.(*InfixExpression)
+	if !ok {
+		t.Fatalf("expected InfixExpression, got %T", program.Statements[0].(*ExpressionStatement).Expression)
+	}
+	if got := infix.Left.Debug(); got != "a?.b.c?[0](1)" {
+		t.Fatalf("unexpected debug %s", got)
+	}
+
+	call := infix.Left.(*CallExpression)
+	index := call.Function.(*IndexExpression)
+	if !index.Optional || index.Token.Type != lexer.OptionalBracket {
+		t.Fatalf("expected an optional index, got %+v", index)
+	}
+	property := index.Left.(*PropertyExpression)
+	if property.Optional {
+		t.Fatal("expected .c not to be optional")
+	}
+	if first := property.Left.(*PropertyExpression); !first.Optional || first.Token.Type != lexer.OptionalDot {
+		t.Fatalf("expected an optional property, got %+v", first)
+	}
+}
+
+func TestParseOptionalAccessAssignment(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+		column  int
+	}{
+		{"a?.b = 1;", "cannot assign to null-safe access a?.b", 6},
+		{"a?[0] += 1;", "cannot assign to null-safe access a?[0]", 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := New(lexer.NewScript(tt.input)).Parse()
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected ParseError, got %v", err)
+			}
+			if parseErr.Message != tt.message || parseErr.Token.Column != tt.column {
+				t.Fatalf("expected %q at column %d, got %q at column %d", tt.message, tt.column, parseErr.Message, parseErr.Token.Column)
+			}
+		})
+	}
+
+	// Only the access assigned to is checked.
+	if _, err := New(lexer.NewScript("a?.b.c = 1;")).Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseRangeExpression(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		exclusive bool
+	}{
+		{"0..10;", "0..10", false},
+		{"0..<n;", "0..<n", true},
+		{"1..n - 1;", "1..n - 1", false},
+		{"a + 1..b * 2 step 2;", "a + 1..b * 2 step 2", false},
+		{"10..0 step -1;", "10..0 step (-)", false},
+		{"0..<len(items) step n + 1;", "0..<len(items) step n + 1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := New(lexer.NewScript(tt.input)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rng, ok := program.Statements[0].(*ExpressionStatement).Expression.(*RangeExpression)
+			if !ok {
+				t.Fatalf("expected RangeExpression, got %T", program.Statements[0].(*ExpressionStatement).Expression)
+			}
+			if got := rng.Debug(); got != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, got)
+			}
+			if rng.Exclusive != tt.exclusive {
+				t.Fatalf("expected exclusive %v, got %v", tt.exclusive, rng.Exclusive)
+			}
+		})
+	}
+}
+
+func TestParseRangeBindsLooserThanArithmetic(t *testing.T) {
+	// A range binds looser than arithmetic and tighter than comparisons.
+	program, err := New(lexer.NewScript("x == 0..n;")).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infix, ok := program.Statements[0].(*ExpressionStatement).Expression.(*InfixExpression)
+	if !ok {
+		t.Fatalf("expected InfixExpression, got %T", program.Statements[0].(*ExpressionStatement).Expression)
+	}
+	if _, ok := infix.Right.(*RangeExpression); !ok {
+		t.Fatalf("expected the range on the right, got %T", infix.Right)
+	}
+}
+
+func TestParseForExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < n; i += 1) { x; }", "for (let i = 0; i < n; i = i + 1) {"},
+		{"for (i = 0; i < n; i = i + 2) { x; }", "for (i = 0; i < n; i = i + 2) {"},
+		{"for (;;) { break; }", "for (;;) {"},
+		{"for (; i < n;) { x; }", "for (; i < n;) {"},
+		{"for (let i = 0; ; next(i)) { x; }", "for (let i = 0;; next(i)) {"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := New(lexer.NewScript(tt.input)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(program.Statements) != 1 {
+				t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+			}
+
+			fe, ok := program.Statements[0].(*ExpressionStatement).Expression.(*ForExpression)
+			if !ok {
+				t.Fatalf("expected ForExpression, got %T", program.Statements[0].(*ExpressionStatement).Expression)
+			}
+			if got := fe.Debug(); !strings.HasPrefix(got, tt.expected) {
+				t.Fatalf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseForErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+		column  int
+	}{
+		{"for let i = 0; i < 1; i += 1) {}", "expected LEFT_PAREN, got LET", 5},
+		{"for (let i = 0; i < 1) {}", "expected SEMICOLON, got RIGHT_PAREN", 22},
+		{"for (i = 0, i < 1; i += 1) {}", "expected SEMICOLON, got COMMA", 11},
+		{"for (let i = 0; i < 1; i += 1) x;", "expected LEFT_BRACE, got IDENTIFIER", 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := New(lexer.NewScript(tt.input)).Parse()
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected ParseError, got %v", err)
+			}
+			if parseErr.Message != tt.message || parseErr.Token.Column != tt.column {
+				t.Fatalf("expected %q at column %d, got %q at column %d", tt.message, tt.column, parseErr.Message, parseErr.Token.Column)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ func (ss *ScriptStatement) Debug() string {
 }
 
 type PrintStatement struct {
+	Token lexer.Token
 	Value string
 }
 
@@ -82,6 +83,9 @@ func (cs *ContinueStatement) Debug() string {
 type BlockStatement struct {
 	Token      lexer.Token
 	Statements []Statement
+	// End is the closing brace, or the end of the source if the block
+	// was left open.
+	End lexer.Token
 }
 
 func (bs *BlockStatement) Debug() string {
@@ -92,3 +96,48 @@ func (bs *BlockStatement) Debug() string {
 	str += "}"
 	return str
 }
+
+// ExtendsStatement declares the layout template that the current template
+// renders into.
+type ExtendsStatement struct {
+	Token    lexer.Token
+	Template Expression
+}
+
+func (es *ExtendsStatement) Debug() string {
+	return "extends " + es.Template.Debug()
+}
+
+// TryStatement runs Body and, if it fails with a runtime error, runs Catch
+// with the error bound to Parameter.
+type TryStatement struct {
+	Token     lexer.Token
+	Body      *BlockStatement
+	Parameter *Identifier
+	Catch     *BlockStatement
+}
+
+func (ts *TryStatement) Debug() string {
+	return "try " + ts.Body.Debug() + " catch (" + ts.Parameter.Value + ") " + ts.Catch.Debug()
+}
+
+// ThrowStatement raises Value as a runtime error.
+type ThrowStatement struct {
+	Token lexer.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) Debug() string {
+	return "throw " + ts.Value.Debug()
+}
+
+// NamedBlockStatement declares a block that a child template can override.
+type NamedBlockStatement struct {
+	Token lexer.Token
+	Name  *Identifier
+	Body  *BlockStatement
+}
+
+func (nb *NamedBlockStatement) Debug() string {
+	return "block " + nb.Name.Value + " " + nb.Body.Debug()
+}
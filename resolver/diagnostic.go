@@ -0,0 +1,49 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// Sentinel errors classifying diagnostics, which match them with errors.Is.
+var (
+	ErrUndefinedVariable = errors.New("undefined variable")
+	ErrInvalidAssignment = errors.New("invalid assignment")
+)
+
+// Diagnostic is a problem found in a program before it runs, located at
+// the identifier concerned.
+type Diagnostic struct {
+	Kind    error
+	Message string
+	Token   lexer.Token
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", d.Token.Line, d.Token.Column, d.Message)
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Kind
+}
+
+// report records a diagnostic for id, unless one has been recorded for it
+// already or the resolver is quiet.
+func (r *resolver) report(id *parser.Identifier, kind error, format string, args ...any) {
+	if r.quiet || r.reported[id] {
+		return
+	}
+	if r.reported == nil {
+		r.reported = make(map[*parser.Identifier]bool)
+	}
+	r.reported[id] = true
+
+	r.res.Diagnostics = append(r.res.Diagnostics, &Diagnostic{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		Token:   id.Token,
+	})
+}
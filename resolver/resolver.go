@@ -0,0 +1,432 @@
+// Package resolver binds the identifiers of a parsed program to the scopes
+// that declare them, so that local variables can live in slots rather than
+// be looked up by name, and reports undefined variables and invalid
+// assignments before the program runs.
+//
+// Function calls, foreach iterations, for loops and catch blocks each have
+// a scope of their own. A variable declared anywhere in one of them, by a
+// let statement, a named function or as a parameter, loop variable or
+// catch parameter, is given a slot in it. A for loop has one scope for all
+// of its iterations, so that the variables its initialization declares
+// carry over from one to the next. Variables declared at the top level of
+// a program are globals, which are looked up by name.
+package resolver
+
+import (
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+// Binding locates the variable an identifier refers to. Depth is the number
+// of enclosing function, loop and catch scopes between the identifier and
+// the scope holding the variable, and Slot is its index in that scope. A
+// Slot of -1 means the variable is not local to any of them, and is looked
+// up by name from the scope Depth levels up.
+type Binding struct {
+	Depth int
+	Slot  int
+}
+
+// Scope lists the variables of a function call, foreach iteration, for loop
+// or catch block, in slot order.
+type Scope struct {
+	Names []string
+}
+
+// Globals are the names a program can use without declaring them.
+type Globals struct {
+	// Variables are set by the host before the program runs.
+	Variables []string
+	// Functions are built-in or registered functions. They can be called,
+	// but not assigned to.
+	Functions []string
+}
+
+// Resolution is the result of resolving a program.
+type Resolution struct {
+	Diagnostics []*Diagnostic
+
+	bindings     map[*parser.Identifier]Binding
+	declarations map[*parser.Identifier]*parser.Identifier
+	topLevel     *Scope
+	functions    map[*parser.FunctionLiteral]*Scope
+	loops        map[*parser.ForeachExpression]*Scope
+	fors         map[*parser.ForExpression]*Scope
+	catches      map[*parser.TryStatement]*Scope
+}
+
+// Binding returns the binding of id. There is none for identifiers in a
+// named block, since a layout can evaluate a block in a scope other than
+// the one it was defined in, so they are always looked up by name.
+func (r *Resolution) Binding(id *parser.Identifier) (Binding, bool) {
+	b, ok := r.bindings[id]
+	return b, ok
+}
+
+// Declaration returns the identifier declaring the variable id refers to:
+// the name of a let statement or named function, or a parameter, loop
+// variable or catch parameter. It returns nil for globals set by the host,
+// functions and undefined variables. Unlike bindings, declarations are
+// recorded in named blocks too, as the variables visible where the block
+// is defined.
+func (r *Resolution) Declaration(id *parser.Identifier) *parser.Identifier {
+	return r.declarations[id]
+}
+
+// TopLevel returns the variables declared at the top level of the program,
+// in the order of their declarations.
+func (r *Resolution) TopLevel() *Scope {
+	return r.topLevel
+}
+
+// Function returns the scope of a call to fl.
+func (r *Resolution) Function(fl *parser.FunctionLiteral) *Scope {
+	return r.functions[fl]
+}
+
+// Loop returns the scope of an iteration of fe.
+func (r *Resolution) Loop(fe *parser.ForeachExpression) *Scope {
+	return r.loops[fe]
+}
+
+// For returns the scope of fe, shared by all of its iterations.
+func (r *Resolution) For(fe *parser.ForExpression) *Scope {
+	return r.fors[fe]
+}
+
+// Catch returns the scope of the catch block of ts.
+func (r *Resolution) Catch(ts *parser.TryStatement) *Scope {
+	return r.catches[ts]
+}
+
+// scope is a scope being resolved. Scopes without a layout hold globals.
+type scope struct {
+	parent *scope
+	layout *Scope
+	names  map[string]int
+	// decls holds the identifier that first declared each name.
+	decls map[string]*parser.Identifier
+	// declared lists the names of a scope without a layout.
+	declared *Scope
+	// detached is set for a scope opened in a named block, whose parent is
+	// only known at run time.
+	detached bool
+}
+
+func (s *scope) declare(id *parser.Identifier) {
+	name := id.Value
+	if _, ok := s.names[name]; ok {
+		return
+	}
+	if s.names == nil {
+		s.names = make(map[string]int)
+		s.decls = make(map[string]*parser.Identifier)
+	}
+	s.decls[name] = id
+	if s.layout == nil {
+		s.names[name] = -1
+		s.declared.Names = append(s.declared.Names, name)
+		return
+	}
+	s.names[name] = len(s.layout.Names)
+	s.layout.Names = append(s.layout.Names, name)
+}
+
+type resolver struct {
+	res       *Resolution
+	scope     *scope
+	globals   map[string]bool
+	functions map[string]bool
+	reported  map[*parser.Identifier]bool
+	// quiet skips reporting, for a program resolved only to be run.
+	quiet bool
+	// dynamic is set in a named block, outside any scope opened in it.
+	dynamic bool
+}
+
+// newResolver creates a resolver of programs that can use globals. Its
+// maps, and those of the scopes and resolution it creates, are only made
+// once something is added to them, so that resolving a small program
+// allocates little.
+func newResolver(globals Globals) *resolver {
+	r := &resolver{res: &Resolution{topLevel: &Scope{}}}
+	if len(globals.Variables) > 0 {
+		r.globals = make(map[string]bool, len(globals.Variables))
+		for _, name := range globals.Variables {
+			r.globals[name] = true
+		}
+	}
+	if len(globals.Functions) > 0 {
+		r.functions = make(map[string]bool, len(globals.Functions))
+		for _, name := range globals.Functions {
+			r.functions[name] = true
+		}
+	}
+	return r
+}
+
+// Resolve resolves the identifiers of program, reporting any that are
+// neither declared by the program nor listed in globals.
+func Resolve(program *parser.Program, globals Globals) *Resolution {
+	r := newResolver(globals)
+	r.program(program)
+	return r.res
+}
+
+// Bind resolves the identifiers of program without reporting anything, for
+// a program that is about to run and so only needs their bindings. Its
+// globals are set when it runs, rather than known in advance.
+func Bind(program *parser.Program) *Resolution {
+	r := newResolver(Globals{})
+	r.quiet = true
+	r.program(program)
+	return r.res
+}
+
+func (r *resolver) program(program *parser.Program) {
+	r.scope = &scope{declared: r.res.topLevel}
+	r.hoist(program)
+	r.resolve(program)
+}
+
+// ResolveFunction resolves fl on its own, for a function whose enclosing
+// program is not known. Variables from outside it are looked up by name,
+// and nothing is reported.
+func ResolveFunction(fl *parser.FunctionLiteral) *Resolution {
+	r := newResolver(Globals{})
+	r.quiet = true
+	r.dynamic = true
+
+	r.function(fl)
+
+	return r.res
+}
+
+// enter opens a scope laid out by layout.
+func (r *resolver) enter(layout *Scope) {
+	r.scope = &scope{parent: r.scope, layout: layout, detached: r.dynamic}
+	r.dynamic = false
+}
+
+func (r *resolver) leave() {
+	r.dynamic = r.scope.detached
+	r.scope = r.scope.parent
+}
+
+// hoist declares the variables node declares in the current scope, so that
+// identifiers resolve the same way before and after their declaration.
+func (r *resolver) hoist(node any) {
+	parser.Inspect(node, func(n any) bool {
+		switch n := n.(type) {
+		case *parser.LetStatement:
+			r.scope.declare(n.Name)
+		case *parser.FunctionLiteral:
+			if n.Identifier != nil {
+				r.scope.declare(n.Identifier)
+			}
+			return false
+		case *parser.ForeachExpression:
+			r.hoist(n.Iterable)
+			return false
+		case *parser.ForExpression:
+			return false
+		case *parser.TryStatement:
+			r.hoist(n.Body)
+			return false
+		}
+		return true
+	})
+}
+
+func (r *resolver) resolve(node any) {
+	parser.Inspect(node, func(n any) bool {
+		switch n := n.(type) {
+		case *parser.Identifier:
+			r.use(n)
+		case *parser.LetStatement:
+			r.resolve(n.Value)
+			r.bind(n.Name)
+			return false
+		case *parser.AssignmentExpression:
+			r.assignment(n)
+			return false
+		case *parser.PropertyExpression:
+			// The property is a name, not a variable.
+			r.resolve(n.Left)
+			return false
+		case *parser.FunctionLiteral:
+			r.function(n)
+			return false
+		case *parser.ForeachExpression:
+			r.foreach(n)
+			return false
+		case *parser.ForExpression:
+			r.forLoop(n)
+			return false
+		case *parser.TryStatement:
+			r.try(n)
+			return false
+		case *parser.NamedBlockStatement:
+			dynamic := r.dynamic
+			r.dynamic = true
+			r.resolve(n.Body)
+			r.dynamic = dynamic
+			return false
+		}
+		return true
+	})
+}
+
+func (r *resolver) function(fl *parser.FunctionLiteral) {
+	// A named function is declared in the scope defining it.
+	if fl.Identifier != nil {
+		r.bind(fl.Identifier)
+	}
+
+	layout := &Scope{}
+	if r.res.functions == nil {
+		r.res.functions = make(map[*parser.FunctionLiteral]*Scope)
+	}
+	r.res.functions[fl] = layout
+
+	r.enter(layout)
+	for _, param := range fl.Parameters {
+		r.scope.declare(param)
+		r.bind(param)
+	}
+	r.hoist(fl.Body)
+	r.resolve(fl.Body)
+	r.leave()
+}
+
+func (r *resolver) foreach(fe *parser.ForeachExpression) {
+	r.resolve(fe.Iterable)
+
+	layout := &Scope{}
+	if r.res.loops == nil {
+		r.res.loops = make(map[*parser.ForeachExpression]*Scope)
+	}
+	r.res.loops[fe] = layout
+
+	r.enter(layout)
+	r.scope.declare(fe.Variable)
+	r.bind(fe.Variable)
+	if fe.Index != nil {
+		r.scope.declare(fe.Index)
+		r.bind(fe.Index)
+	}
+	r.hoist(fe.Body)
+	r.resolve(fe.Body)
+	r.leave()
+}
+
+func (r *resolver) forLoop(fe *parser.ForExpression) {
+	layout := &Scope{}
+	if r.res.fors == nil {
+		r.res.fors = make(map[*parser.ForExpression]*Scope)
+	}
+	r.res.fors[fe] = layout
+
+	r.enter(layout)
+	r.hoist(fe.Init)
+	r.hoist(fe.Body)
+	r.resolve(fe.Init)
+	r.resolve(fe.Condition)
+	r.resolve(fe.Update)
+	r.resolve(fe.Body)
+	r.leave()
+}
+
+func (r *resolver) try(ts *parser.TryStatement) {
+	r.resolve(ts.Body)
+
+	layout := &Scope{}
+	if r.res.catches == nil {
+		r.res.catches = make(map[*parser.TryStatement]*Scope)
+	}
+	r.res.catches[ts] = layout
+
+	r.enter(layout)
+	r.scope.declare(ts.Parameter)
+	r.bind(ts.Parameter)
+	r.hoist(ts.Catch)
+	r.resolve(ts.Catch)
+	r.leave()
+}
+
+func (r *resolver) assignment(assign *parser.AssignmentExpression) {
+	switch left := assign.Left.(type) {
+	case *parser.Identifier:
+		// The target is checked first, so that a compound assignment to
+		// an undeclared variable is reported as an invalid assignment.
+		if !r.bind(left) && !r.globals[left.Value] {
+			if r.functions[left.Value] {
+				r.report(left, ErrInvalidAssignment, "cannot assign to function: %s", left.Value)
+			} else {
+				r.report(left, ErrInvalidAssignment, "assignment to undeclared variable: %s", left.Value)
+			}
+		}
+	case *parser.PropertyExpression:
+		r.resolve(left.Left)
+	default:
+		r.resolve(left)
+	}
+
+	r.resolve(assign.Right)
+}
+
+func (r *resolver) use(id *parser.Identifier) {
+	if !r.bind(id) && !r.globals[id.Value] && !r.functions[id.Value] {
+		r.report(id, ErrUndefinedVariable, "undefined variable: %s", id.Value)
+	}
+}
+
+// bind records the binding and declaration of id, reporting whether the
+// program declares the variable it refers to.
+func (r *resolver) bind(id *parser.Identifier) bool {
+	b, found := r.lookup(id.Value)
+	if !r.dynamic {
+		if r.res.bindings == nil {
+			r.res.bindings = make(map[*parser.Identifier]Binding)
+		}
+		r.res.bindings[id] = b
+	}
+	for s := r.scope; s != nil; s = s.parent {
+		if decl, ok := s.decls[id.Value]; ok {
+			if r.res.declarations == nil {
+				r.res.declarations = make(map[*parser.Identifier]*parser.Identifier)
+			}
+			r.res.declarations[id] = decl
+			break
+		}
+	}
+	return found
+}
+
+// lookup finds the variable name refers to in the current scope. Past a
+// detached scope, the search only establishes whether the variable is
+// declared; the binding ends there.
+func (r *resolver) lookup(name string) (Binding, bool) {
+	var b Binding
+	bound := false
+	depth := 0
+
+	for s := r.scope; s != nil; s = s.parent {
+		if slot, ok := s.names[name]; ok {
+			if !bound {
+				b = Binding{Depth: depth, Slot: slot}
+			}
+			return b, true
+		}
+		if s.layout != nil {
+			depth++
+		}
+		if s.detached && !bound {
+			b, bound = Binding{Depth: depth, Slot: -1}, true
+		}
+	}
+
+	if !bound {
+		b = Binding{Depth: depth, Slot: -1}
+	}
+	return b, false
+}
@@ -0,0 +1,300 @@
+package resolver
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ironfang-ltd/go-script/lexer"
+	"github.com/ironfang-ltd/go-script/parser"
+)
+
+func parse(t *testing.T, input string) *parser.Program {
+	t.Helper()
+	program, err := parser.New(lexer.NewScript(input)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// identifiers returns every identifier named name in program, in source
+// order.
+func identifiers(program *parser.Program, name string) []*parser.Identifier {
+	var ids []*parser.Identifier
+	parser.Inspect(program, func(n any) bool {
+		if id, ok := n.(*parser.Identifier); ok && id.Value == name {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	return ids
+}
+
+func firstFunction(program *parser.Program) *parser.FunctionLiteral {
+	var fl *parser.FunctionLiteral
+	parser.Inspect(program, func(n any) bool {
+		if f, ok := n.(*parser.FunctionLiteral); ok && fl == nil {
+			fl = f
+		}
+		return fl == nil
+	})
+	return fl
+}
+
+func TestResolveBindings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		variable string
+		expected []Binding
+	}{
+		{
+			"global",
+			`let x = 1; fn f() { return x; }`,
+			"x",
+			[]Binding{{0, -1}, {1, -1}},
+		},
+		{
+			"parameter",
+			`fn f(a, b) { return b + a; }`,
+			"b",
+			[]Binding{{0, 1}, {0, 1}},
+		},
+		{
+			"hoisted local",
+			`fn f() { let g = fn() { return y; }; let y = 2; return g(); }`,
+			"y",
+			[]Binding{{1, 1}, {0, 1}},
+		},
+		{
+			"closure",
+			`fn f(n) { return fn() { return n; }; }`,
+			"n",
+			[]Binding{{0, 0}, {1, 0}},
+		},
+		{
+			"foreach",
+			`fn f(items) { foreach (items as i, v) { let w = v; } }`,
+			"v",
+			[]Binding{{0, 0}, {0, 0}},
+		},
+		{
+			"for",
+			`fn f(n) { for (let i = 0; i < n; i += 1) { let j = i; } }`,
+			"i",
+			[]Binding{{0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}},
+		},
+		{
+			"catch",
+			`fn f() { try { } catch (e) { return e; } }`,
+			"e",
+			[]Binding{{0, 0}, {0, 0}},
+		},
+		{
+			"named function",
+			`fn f() { fn g() { return g; } }`,
+			"g",
+			[]Binding{{0, 0}, {1, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parse(t, tt.input)
+			res := Resolve(program, Globals{})
+
+			var got []Binding
+			for _, id := range identifiers(program, tt.variable) {
+				b, ok := res.Binding(id)
+				if !ok {
+					t.Fatalf("expected a binding for %s at column %d", id.Value, id.Token.Column)
+				}
+				got = append(got, b)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestResolveScopes(t *testing.T) {
+	program := parse(t, `fn f(a) { let b = 1; if (a) { let c = 2; } foreach ([] as d) { let e = 3; } let b = 4; }`)
+	res := Resolve(program, Globals{})
+
+	fl := firstFunction(program)
+	if got := res.Function(fl).Names; !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected function scope: %v", got)
+	}
+
+	var fe *parser.ForeachExpression
+	parser.Inspect(program, func(n any) bool {
+		if f, ok := n.(*parser.ForeachExpression); ok {
+			fe = f
+		}
+		return true
+	})
+	if got := res.Loop(fe).Names; !reflect.DeepEqual(got, []string{"d", "e"}) {
+		t.Fatalf("unexpected loop scope: %v", got)
+	}
+
+	program = parse(t, `fn f(n) { for (let i = 0; i < n; i += 1) { let j = i; } }`)
+	res = Resolve(program, Globals{})
+
+	var loop *parser.ForExpression
+	parser.Inspect(program, func(n any) bool {
+		if f, ok := n.(*parser.ForExpression); ok {
+			loop = f
+		}
+		return true
+	})
+	if got := res.Function(firstFunction(program)).Names; !reflect.DeepEqual(got, []string{"n"}) {
+		t.Fatalf("unexpected function scope: %v", got)
+	}
+	if got := res.For(loop).Names; !reflect.DeepEqual(got, []string{"i", "j"}) {
+		t.Fatalf("unexpected for scope: %v", got)
+	}
+}
+
+func TestResolveDeclarations(t *testing.T) {
+	program := parse(t, `let x = 1; fn f(x) { return x + y; } x = f(x); foreach ([] as i) { let x = i; } try { } catch (e) { log(e); }`)
+	res := Resolve(program, Globals{Variables: []string{"y"}, Functions: []string{"log"}})
+
+	xs := identifiers(program, "x")
+	expected := []*parser.Identifier{xs[0], xs[1], xs[1], xs[0], xs[0], xs[5]}
+	for i, x := range xs {
+		if got := res.Declaration(x); got != expected[i] {
+			t.Fatalf("expected x %d to be declared at column %d, got %v", i, expected[i].Token.Column, got)
+		}
+	}
+
+	f := identifiers(program, "f")
+	if res.Declaration(f[1]) != f[0] {
+		t.Fatal("expected the call to f to refer to its declaration")
+	}
+	i := identifiers(program, "i")
+	if res.Declaration(i[1]) != i[0] {
+		t.Fatal("expected i to refer to the loop variable")
+	}
+	e := identifiers(program, "e")
+	if res.Declaration(e[1]) != e[0] {
+		t.Fatal("expected e to refer to the catch parameter")
+	}
+	for _, name := range []string{"y", "log"} {
+		if decl := res.Declaration(identifiers(program, name)[0]); decl != nil {
+			t.Fatalf("expected no declaration for %s, got %v", name, decl)
+		}
+	}
+
+	if got := res.TopLevel().Names; !reflect.DeepEqual(got, []string{"x", "f"}) {
+		t.Fatalf("unexpected top-level names: %v", got)
+	}
+}
+
+func TestResolveNamedBlock(t *testing.T) {
+	program, err := parser.New(lexer.NewTemplate(`{% fn f() { block b { let x = y; fn g() { return x; } } } %}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := Resolve(program, Globals{Variables: []string{"y"}})
+
+	for _, name := range []string{"x", "y"} {
+		if b, ok := res.Binding(identifiers(program, name)[0]); ok {
+			t.Fatalf("expected %s in a named block to be unbound, got %v", name, b)
+		}
+	}
+
+	// Inside a function defined in the block, x is looked up by name from
+	// the scope the block runs in.
+	x := identifiers(program, "x")[1]
+	if b, _ := res.Binding(x); b != (Binding{Depth: 1, Slot: -1}) {
+		t.Fatalf("unexpected binding for x: %v", b)
+	}
+	if len(res.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", res.Diagnostics)
+	}
+}
+
+func TestResolveDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		kind     error
+		message  string
+		line     int
+		column   int
+		globals  Globals
+		expected int
+	}{
+		{"undefined", "let a = 1;\nreturn a + b;", ErrUndefinedVariable, "undefined variable: b", 2, 12, Globals{}, 1},
+		{"undefined in function", "fn f() {\n  return missing;\n}", ErrUndefinedVariable, "undefined variable: missing", 2, 10, Globals{}, 1},
+		{"undefined in interpolation", "let a = 1;\nreturn `${a}\n  ${b}`;", ErrUndefinedVariable, "undefined variable: b", 3, 5, Globals{}, 1},
+		{"undefined filter", "let a = \"x\";\nreturn a | upper;", ErrUndefinedVariable, "undefined variable: upper", 2, 12, Globals{}, 1},
+		{"local out of scope", "fn f() { let x = 1; }\nreturn x;", ErrUndefinedVariable, "undefined variable: x", 2, 8, Globals{}, 1},
+		{"for variable out of scope", "for (let i = 0; i < 3; i += 1) { }\nreturn i;", ErrUndefinedVariable, "undefined variable: i", 2, 8, Globals{}, 1},
+		{"assign undeclared", "x = 1;", ErrInvalidAssignment, "assignment to undeclared variable: x", 1, 1, Globals{}, 1},
+		{"compound assign undeclared", "total += 1;", ErrInvalidAssignment, "assignment to undeclared variable: total", 1, 1, Globals{}, 1},
+		{"assign function", "len = 1;", ErrInvalidAssignment, "cannot assign to function: len", 1, 1, Globals{Functions: []string{"len"}}, 1},
+		{"assign global", "user = 1; return len(user);", nil, "", 0, 0, Globals{Variables: []string{"user"}, Functions: []string{"len"}}, 0},
+		{"declared later", "fn f() { return g(); }\nfn g() { return 1; }", nil, "", 0, 0, Globals{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := Resolve(parse(t, tt.input), tt.globals).Diagnostics
+			if len(diagnostics) != tt.expected {
+				t.Fatalf("expected %d diagnostics, got %v", tt.expected, diagnostics)
+			}
+			if tt.expected == 0 {
+				return
+			}
+
+			d := diagnostics[0]
+			if !errors.Is(d, tt.kind) || d.Message != tt.message {
+				t.Fatalf("unexpected diagnostic: %v", d)
+			}
+			if d.Token.Line != tt.line || d.Token.Column != tt.column {
+				t.Fatalf("expected line %d, column %d, got %d, %d", tt.line, tt.column, d.Token.Line, d.Token.Column)
+			}
+		})
+	}
+}
+
+func TestResolveFunction(t *testing.T) {
+	fl := firstFunction(parse(t, `fn f(a) { let b = a; return c; }`))
+	res := ResolveFunction(fl)
+
+	if got := res.Function(fl).Names; !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("unexpected function scope: %v", got)
+	}
+	c := identifiers(&parser.Program{Statements: fl.Body.Statements}, "c")[0]
+	if b, _ := res.Binding(c); b != (Binding{Depth: 1, Slot: -1}) {
+		t.Fatalf("unexpected binding for c: %v", b)
+	}
+	if len(res.Diagnostics) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", res.Diagnostics)
+	}
+}
+
+func TestBind(t *testing.T) {
+	program := parse(t, "let a = 1;\nfn f(b) { return a + b + c; }\nx = f(2);")
+	res := Bind(program)
+
+	if len(res.Diagnostics) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", res.Diagnostics)
+	}
+	resolved := Resolve(program, Globals{})
+	if len(resolved.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", resolved.Diagnostics)
+	}
+	for _, name := range []string{"a", "b", "c", "f", "x"} {
+		for _, id := range identifiers(program, name) {
+			b, ok := res.Binding(id)
+			expected, expectedOK := resolved.Binding(id)
+			if b != expected || ok != expectedOK {
+				t.Fatalf("expected %s bound to %v, got %v", name, expected, b)
+			}
+		}
+	}
+}